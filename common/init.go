@@ -119,6 +119,29 @@ func initConstantEnv() {
 	constant.GenerateDefaultToken = GetEnvOrDefaultBool("GENERATE_DEFAULT_TOKEN", false)
 	// 是否启用错误日志
 	constant.ErrorLogEnabled = GetEnvOrDefaultBool("ERROR_LOG_ENABLED", false)
+	// 文件存储
+	constant.FileStoragePath = GetEnvOrDefaultString("FILE_STORAGE_PATH", "./data/files")
+	constant.FileMaxUploadMB = GetEnvOrDefault("FILE_MAX_UPLOAD_MB", 512)
+	constant.FileForwardTimeout = GetEnvOrDefault("FILE_FORWARD_TIMEOUT", 300)
+	// 异步任务生成结果存储
+	constant.ArtifactStorage = GetEnvOrDefaultString("ARTIFACT_STORAGE", "local")
+	constant.ArtifactStoragePath = GetEnvOrDefaultString("ARTIFACT_STORAGE_PATH", "./data/artifacts")
+	constant.ArtifactS3Endpoint = GetEnvOrDefaultString("ARTIFACT_S3_ENDPOINT", "")
+	constant.ArtifactS3Region = GetEnvOrDefaultString("ARTIFACT_S3_REGION", "us-east-1")
+	constant.ArtifactS3Bucket = GetEnvOrDefaultString("ARTIFACT_S3_BUCKET", "")
+	constant.ArtifactS3AccessKeyId = GetEnvOrDefaultString("ARTIFACT_S3_ACCESS_KEY_ID", "")
+	constant.ArtifactS3SecretAccessKey = GetEnvOrDefaultString("ARTIFACT_S3_SECRET_ACCESS_KEY", "")
+	constant.ArtifactS3PathStyle = GetEnvOrDefaultBool("ARTIFACT_S3_PATH_STYLE", true)
+	// OpenTelemetry 链路追踪，导出配置使用 OTEL_EXPORTER_OTLP_* 标准环境变量
+	constant.TracingEnabled = GetEnvOrDefaultBool("OTEL_TRACING_ENABLED", false)
+	// Prometheus 指标
+	constant.MetricsEnabled = GetEnvOrDefaultBool("METRICS_ENABLED", false)
+	constant.MetricsToken = GetEnvOrDefaultString("METRICS_TOKEN", "")
+	for _, ip := range strings.Split(GetEnvOrDefaultString("METRICS_ALLOWED_IPS", ""), ",") {
+		if trimmedIP := strings.TrimSpace(ip); trimmedIP != "" {
+			constant.MetricsAllowedIPs = append(constant.MetricsAllowedIPs, trimmedIP)
+		}
+	}
 
 	soraPatchStr := GetEnvOrDefaultString("TASK_PRICE_PATCH", "")
 	if soraPatchStr != "" {
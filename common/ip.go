@@ -20,3 +20,12 @@ func IsPrivateIP(ip net.IP) bool {
 	}
 	return false
 }
+
+// IsIpInList 检查 IP 是否在列表中，列表项可以是单个 IP 或 CIDR
+func IsIpInList(ip string, list []string) bool {
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil {
+		return false
+	}
+	return isIPListed(parsedIP, list)
+}
@@ -5,6 +5,7 @@ import (
 	_ "embed"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/go-redis/redis/v8"
@@ -13,9 +14,17 @@ import (
 //go:embed lua/rate_limit.lua
 var rateLimitScript string
 
+//go:embed lua/adjust.lua
+var adjustScript string
+
+//go:embed lua/concurrency.lua
+var concurrencyScript string
+
 type RedisLimiter struct {
-	client         *redis.Client
-	limitScriptSHA string
+	client               *redis.Client
+	limitScriptSHA       string
+	adjustScriptSHA      string
+	concurrencyScriptSHA string
 }
 
 var (
@@ -30,9 +39,19 @@ func New(ctx context.Context, r *redis.Client) *RedisLimiter {
 		if err != nil {
 			common.SysLog(fmt.Sprintf("Failed to load rate limit script: %v", err))
 		}
+		adjustSHA, err := r.ScriptLoad(ctx, adjustScript).Result()
+		if err != nil {
+			common.SysLog(fmt.Sprintf("Failed to load rate limit adjust script: %v", err))
+		}
+		concurrencySHA, err := r.ScriptLoad(ctx, concurrencyScript).Result()
+		if err != nil {
+			common.SysLog(fmt.Sprintf("Failed to load concurrency limit script: %v", err))
+		}
 		instance = &RedisLimiter{
-			client:         r,
-			limitScriptSHA: limitSHA,
+			client:               r,
+			limitScriptSHA:       limitSHA,
+			adjustScriptSHA:      adjustSHA,
+			concurrencyScriptSHA: concurrencySHA,
 		}
 	})
 
@@ -68,6 +87,48 @@ func (rl *RedisLimiter) Allow(ctx context.Context, key string, opts ...Option) (
 	return result == 1, nil
 }
 
+// Adjust 按实际用量修正令牌桶，Requested 为负数时返还令牌，令牌不足时允许欠账
+func (rl *RedisLimiter) Adjust(ctx context.Context, key string, opts ...Option) error {
+	config := &Config{
+		Capacity:  10,
+		Rate:      1,
+		Requested: 0,
+	}
+	for _, opt := range opts {
+		opt(config)
+	}
+	err := rl.client.EvalSha(
+		ctx,
+		rl.adjustScriptSHA,
+		[]string{key},
+		config.Requested,
+		config.Rate,
+		config.Capacity,
+	).Err()
+	if err != nil {
+		return fmt.Errorf("rate limit adjust failed: %w", err)
+	}
+	return nil
+}
+
+// AcquireConcurrency 占用一个并发名额，达到 limit 时返回 false；返回的租约 id 用于释放，未释放的租约 ttl 秒后过期
+func (rl *RedisLimiter) AcquireConcurrency(ctx context.Context, key string, limit int64, ttl int64) (string, bool, error) {
+	leaseId := common.GetUUID()
+	result, err := rl.client.EvalSha(ctx, rl.concurrencyScriptSHA, []string{key}, 1, leaseId, time.Now().UnixMilli(), limit, ttl).Int()
+	if err != nil {
+		return "", false, fmt.Errorf("concurrency limit failed: %w", err)
+	}
+	return leaseId, result == 1, nil
+}
+
+func (rl *RedisLimiter) ReleaseConcurrency(ctx context.Context, key string, leaseId string) error {
+	err := rl.client.EvalSha(ctx, rl.concurrencyScriptSHA, []string{key}, -1, leaseId, 0, 0, 0).Err()
+	if err != nil {
+		return fmt.Errorf("concurrency release failed: %w", err)
+	}
+	return nil
+}
+
 // Config 配置选项模式
 type Config struct {
 	Capacity  int64
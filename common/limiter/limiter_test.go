@@ -0,0 +1,133 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func newTestRedisLimiter(t *testing.T) (*RedisLimiter, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	// New 为单例，每个测试替换为连接到独立 miniredis 的实例
+	ctx := context.Background()
+	rl := New(ctx, client)
+	*rl = RedisLimiter{client: client}
+	var err error
+	if rl.limitScriptSHA, err = client.ScriptLoad(ctx, rateLimitScript).Result(); err != nil {
+		t.Fatal(err)
+	}
+	if rl.adjustScriptSHA, err = client.ScriptLoad(ctx, adjustScript).Result(); err != nil {
+		t.Fatal(err)
+	}
+	if rl.concurrencyScriptSHA, err = client.ScriptLoad(ctx, concurrencyScript).Result(); err != nil {
+		t.Fatal(err)
+	}
+	return rl, server
+}
+
+func TestRedisAllowAndAdjust(t *testing.T) {
+	rl, _ := newTestRedisLimiter(t)
+	ctx := context.Background()
+	opts := []Option{WithCapacity(10), WithRate(1), WithRequested(6)}
+	if ok, err := rl.Allow(ctx, "tpm", opts...); err != nil || !ok {
+		t.Fatalf("first request: ok=%v err=%v, want allowed", ok, err)
+	}
+	if ok, err := rl.Allow(ctx, "tpm", opts...); err != nil || ok {
+		t.Fatalf("second request: ok=%v err=%v, want rejected", ok, err)
+	}
+	// 返还 5 个令牌后剩余 9 个
+	if err := rl.Adjust(ctx, "tpm", WithCapacity(10), WithRate(1), WithRequested(-5)); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := rl.Allow(ctx, "tpm", opts...); err != nil || !ok {
+		t.Fatalf("after refund: ok=%v err=%v, want allowed", ok, err)
+	}
+}
+
+func TestRedisConcurrencyLimit(t *testing.T) {
+	rl, _ := newTestRedisLimiter(t)
+	ctx := context.Background()
+	var leases []string
+	for i := 0; i < 2; i++ {
+		leaseId, ok, err := rl.AcquireConcurrency(ctx, "concurrency", 2, 60)
+		if err != nil || !ok {
+			t.Fatalf("acquire %d: ok=%v err=%v", i, ok, err)
+		}
+		leases = append(leases, leaseId)
+	}
+	if _, ok, err := rl.AcquireConcurrency(ctx, "concurrency", 2, 60); err != nil || ok {
+		t.Fatalf("acquire past limit: ok=%v err=%v, want rejected", ok, err)
+	}
+	if err := rl.ReleaseConcurrency(ctx, "concurrency", leases[0]); err != nil {
+		t.Fatal(err)
+	}
+	// 重复释放同一租约不会多释放名额
+	if err := rl.ReleaseConcurrency(ctx, "concurrency", leases[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := rl.AcquireConcurrency(ctx, "concurrency", 2, 60); err != nil || !ok {
+		t.Fatalf("acquire after release: ok=%v err=%v, want allowed", ok, err)
+	}
+	if _, ok, err := rl.AcquireConcurrency(ctx, "concurrency", 2, 60); err != nil || ok {
+		t.Fatalf("double release freed an extra slot: ok=%v err=%v", ok, err)
+	}
+}
+
+func TestRedisConcurrencyLeaseExpiry(t *testing.T) {
+	rl, server := newTestRedisLimiter(t)
+	ctx := context.Background()
+	// 模拟进程退出后没有释放的租约
+	if _, ok, err := rl.AcquireConcurrency(ctx, "leaked", 1, 1); err != nil || !ok {
+		t.Fatalf("acquire: ok=%v err=%v", ok, err)
+	}
+	// 持续有请求时 key 不会过期，但过期的租约仍会被清理
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		_, ok, err := rl.AcquireConcurrency(ctx, "leaked", 1, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("leaked lease never expired while traffic continued")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if !server.Exists("leaked") {
+		t.Fatal("expected the new lease to be stored")
+	}
+}
+
+func TestMemoryConcurrencyLeaseExpiry(t *testing.T) {
+	ml := &MemoryLimiter{
+		buckets:     make(map[string]*memoryBucket),
+		concurrency: make(map[string]map[string]time.Time),
+	}
+	leaseId, ok := ml.AcquireConcurrency("memory", 1, 60)
+	if !ok {
+		t.Fatal("first acquire rejected")
+	}
+	if _, ok := ml.AcquireConcurrency("memory", 1, 60); ok {
+		t.Fatal("acquire past limit allowed")
+	}
+	ml.ReleaseConcurrency("memory", leaseId)
+	if _, ok := ml.AcquireConcurrency("memory", 1, 60); !ok {
+		t.Fatal("acquire after release rejected")
+	}
+
+	// 让租约立即过期
+	for id := range ml.concurrency["memory"] {
+		ml.concurrency["memory"][id] = time.Now().Add(-time.Second)
+	}
+	if _, ok := ml.AcquireConcurrency("memory", 1, 60); !ok {
+		t.Fatal("expired lease still holds the slot")
+	}
+}
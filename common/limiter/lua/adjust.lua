@@ -0,0 +1,32 @@
+-- 修正令牌桶中的令牌数，不检查是否足够，允许为负数
+-- KEYS[1]: 限流器唯一标识
+-- ARGV[1]: 扣减的令牌数，负数表示返还
+-- ARGV[2]: 令牌生成速率 (每秒)
+-- ARGV[3]: 桶容量
+
+local key = KEYS[1]
+local delta = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local capacity = tonumber(ARGV[3])
+
+local now = redis.call('TIME')
+local nowInSeconds = tonumber(now[1])
+
+local bucket = redis.call('HMGET', key, 'tokens', 'last_time')
+local tokens = tonumber(bucket[1])
+local last_time = tonumber(bucket[2])
+
+if not tokens or not last_time then
+    tokens = capacity
+else
+    local elapsed = nowInSeconds - last_time
+    tokens = math.min(capacity, tokens + elapsed * rate)
+end
+
+tokens = math.min(capacity, tokens - delta)
+
+redis.call('HMSET', key, 'tokens', tokens, 'last_time', nowInSeconds)
+-- 令牌数为负数时需要更长时间补满
+redis.call('EXPIRE', key, math.ceil((capacity - math.min(tokens, 0)) / rate) + 60)
+
+return tokens
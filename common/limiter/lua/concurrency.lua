@@ -0,0 +1,28 @@
+-- 并发名额
+-- KEYS[1]: 有序集合唯一标识，成员为租约 id，分数为租约过期时间（毫秒）
+-- ARGV[1]: 1 表示占用，-1 表示释放
+-- ARGV[2]: 租约 id
+-- ARGV[3]: 当前时间（毫秒）
+-- ARGV[4]: 最大并发数（仅占用时使用）
+-- ARGV[5]: 租约有效期（秒），进程退出后未释放的租约到期后不再占用名额
+
+local key = KEYS[1]
+local delta = tonumber(ARGV[1])
+local leaseId = ARGV[2]
+
+if delta < 0 then
+    redis.call('ZREM', key, leaseId)
+    return 1
+end
+
+local now = tonumber(ARGV[3])
+local limit = tonumber(ARGV[4])
+local ttl = tonumber(ARGV[5]) * 1000
+-- 清理已过期的租约，持续有请求时也不会因为泄漏的名额被永久占满
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now)
+if redis.call('ZCARD', key) >= limit then
+    return 0
+end
+redis.call('ZADD', key, now + ttl, leaseId)
+redis.call('PEXPIRE', key, ttl)
+return 1
@@ -39,6 +39,6 @@ end
 
 ---- 更新桶状态并设置过期时间
 redis.call('HMSET', key, 'tokens', tokens, 'last_time', last_time)
---redis.call('EXPIRE', key, math.ceil(capacity / rate) + 60) -- 适当延长过期时间
+redis.call('EXPIRE', key, math.ceil(capacity / rate) + 60) -- 桶补满后状态与新建相同，可以过期
 
 return allowed and 1 or 0
\ No newline at end of file
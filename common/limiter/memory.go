@@ -0,0 +1,130 @@
+package limiter
+
+import (
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+// MemoryLimiter 未启用 Redis 时使用的单节点令牌桶和并发计数器，行为与 RedisLimiter 一致
+type MemoryLimiter struct {
+	mutex       sync.Mutex
+	buckets     map[string]*memoryBucket
+	concurrency map[string]map[string]time.Time // key -> 租约 id -> 过期时间
+	lastCleanup time.Time
+}
+
+type memoryBucket struct {
+	tokens   float64
+	lastTime time.Time
+	rate     float64
+	capacity float64
+}
+
+var memoryInstance = &MemoryLimiter{
+	buckets:     make(map[string]*memoryBucket),
+	concurrency: make(map[string]map[string]time.Time),
+}
+
+func NewMemory() *MemoryLimiter {
+	return memoryInstance
+}
+
+func newConfig(requested int64, opts []Option) *Config {
+	config := &Config{
+		Capacity:  10,
+		Rate:      1,
+		Requested: requested,
+	}
+	for _, opt := range opts {
+		opt(config)
+	}
+	return config
+}
+
+// bucket 返回补充令牌后的桶，调用方需持有锁
+func (ml *MemoryLimiter) bucket(key string, config *Config) *memoryBucket {
+	now := time.Now()
+	ml.cleanup(now)
+	b, ok := ml.buckets[key]
+	if !ok {
+		b = &memoryBucket{tokens: float64(config.Capacity), lastTime: now}
+		ml.buckets[key] = b
+	} else {
+		b.tokens = min(float64(config.Capacity), b.tokens+now.Sub(b.lastTime).Seconds()*float64(config.Rate))
+		b.lastTime = now
+	}
+	b.rate = float64(config.Rate)
+	b.capacity = float64(config.Capacity)
+	return b
+}
+
+// cleanup 定期删除已补满的桶，补满后的状态与新建相同
+func (ml *MemoryLimiter) cleanup(now time.Time) {
+	if now.Sub(ml.lastCleanup) < time.Minute {
+		return
+	}
+	ml.lastCleanup = now
+	for key, b := range ml.buckets {
+		if b.rate > 0 && b.tokens+now.Sub(b.lastTime).Seconds()*b.rate >= b.capacity {
+			delete(ml.buckets, key)
+		}
+	}
+}
+
+func (ml *MemoryLimiter) Allow(key string, opts ...Option) bool {
+	config := newConfig(1, opts)
+	ml.mutex.Lock()
+	defer ml.mutex.Unlock()
+	b := ml.bucket(key, config)
+	if b.tokens < float64(config.Requested) {
+		return false
+	}
+	b.tokens -= float64(config.Requested)
+	return true
+}
+
+func (ml *MemoryLimiter) Adjust(key string, opts ...Option) {
+	config := newConfig(0, opts)
+	ml.mutex.Lock()
+	defer ml.mutex.Unlock()
+	b := ml.bucket(key, config)
+	b.tokens = min(b.capacity, b.tokens-float64(config.Requested))
+}
+
+// AcquireConcurrency 占用一个并发名额，达到 limit 时返回 false；返回的租约 id 用于释放，未释放的租约 ttl 秒后过期
+func (ml *MemoryLimiter) AcquireConcurrency(key string, limit int64, ttl int64) (string, bool) {
+	ml.mutex.Lock()
+	defer ml.mutex.Unlock()
+	now := time.Now()
+	leases, ok := ml.concurrency[key]
+	if !ok {
+		leases = make(map[string]time.Time)
+		ml.concurrency[key] = leases
+	}
+	for leaseId, deadline := range leases {
+		if !deadline.After(now) {
+			delete(leases, leaseId)
+		}
+	}
+	if int64(len(leases)) >= limit {
+		return "", false
+	}
+	leaseId := common.GetUUID()
+	leases[leaseId] = now.Add(time.Duration(ttl) * time.Second)
+	return leaseId, true
+}
+
+func (ml *MemoryLimiter) ReleaseConcurrency(key string, leaseId string) {
+	ml.mutex.Lock()
+	defer ml.mutex.Unlock()
+	leases, ok := ml.concurrency[key]
+	if !ok {
+		return
+	}
+	delete(leases, leaseId)
+	if len(leases) == 0 {
+		delete(ml.concurrency, key)
+	}
+}
@@ -0,0 +1,124 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/QuantumNous/new-api/constant"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Prometheus 指标，由 /metrics 暴露（需设置 METRICS_ENABLED=true）。
+// 指标只统计当前节点，多节点部署时由 Prometheus 按实例分别抓取后汇总。
+
+const namespace = "newapi"
+
+var relayLabels = []string{"model", "group", "channel_id", "channel_type"}
+
+var (
+	relayRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "relay_requests_total",
+		Help:      "Relay attempts sent to upstream channels, error_code is empty on success.",
+	}, append(relayLabels, "status_code", "error_code"))
+
+	relayDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "relay_request_duration_seconds",
+		Help:      "Duration of relay attempts, including streaming time.",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 60, 120, 300},
+	}, relayLabels)
+
+	relayTTFT = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "relay_first_token_seconds",
+		Help:      "Time to first response byte of successful relay attempts.",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2, 3, 5, 10, 20, 30, 60},
+	}, relayLabels)
+
+	relayTokens = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "relay_tokens_total",
+		Help:      "Billed tokens, type is prompt or completion.",
+	}, append(relayLabels, "type"))
+
+	quotaConsumed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "quota_consumed_total",
+		Help:      "Quota consumed by relay requests.",
+	}, relayLabels)
+
+	preConsumedQuota = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pre_consumed_quota_outstanding",
+		Help:      "Quota pre-consumed by in-flight requests and not yet settled.",
+	})
+)
+
+func relayLabelValues(model string, group string, channelId int, channelType int) []string {
+	return []string{model, group, strconv.Itoa(channelId), strconv.Itoa(channelType)}
+}
+
+// RecordRelayRequest 记录一次渠道请求，ttft 为 0 时不记录首字延迟
+func RecordRelayRequest(model string, group string, channelId int, channelType int, statusCode int, errorCode string, duration time.Duration, ttft time.Duration) {
+	if !constant.MetricsEnabled {
+		return
+	}
+	labels := relayLabelValues(model, group, channelId, channelType)
+	relayRequests.WithLabelValues(append(labels, strconv.Itoa(statusCode), errorCode)...).Inc()
+	if channelId == 0 {
+		// 没有选到渠道，只计数
+		return
+	}
+	relayDuration.WithLabelValues(labels...).Observe(duration.Seconds())
+	if ttft > 0 {
+		relayTTFT.WithLabelValues(labels...).Observe(ttft.Seconds())
+	}
+}
+
+// RecordConsume 记录一次计费的 token 数和额度
+func RecordConsume(model string, group string, channelId int, channelType int, promptTokens int, completionTokens int, quota int) {
+	if !constant.MetricsEnabled {
+		return
+	}
+	labels := relayLabelValues(model, group, channelId, channelType)
+	if promptTokens > 0 {
+		relayTokens.WithLabelValues(append(labels, "prompt")...).Add(float64(promptTokens))
+	}
+	if completionTokens > 0 {
+		relayTokens.WithLabelValues(append(labels, "completion")...).Add(float64(completionTokens))
+	}
+	if quota > 0 {
+		quotaConsumed.WithLabelValues(labels...).Add(float64(quota))
+	}
+}
+
+// AddPreConsumedQuota 预扣费时增加，请求结束时减去相同的值
+func AddPreConsumedQuota(quota int) {
+	if !constant.MetricsEnabled {
+		return
+	}
+	preConsumedQuota.Add(float64(quota))
+}
+
+// RegisterCollector 注册按需采集的指标，如渠道状态
+func RegisterCollector(collector prometheus.Collector) {
+	prometheus.MustRegister(collector)
+}
+
+// RegisterGaugeFunc 注册抓取时计算的单值指标
+func RegisterGaugeFunc(name string, help string, function func() float64) {
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name,
+		Help:      help,
+	}, function))
+}
+
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,147 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// OpenTelemetry 链路追踪，通过 OTLP/HTTP 导出（需设置 OTEL_TRACING_ENABLED=true）。
+// 导出地址、请求头、采样率等使用 OpenTelemetry 标准环境变量，如 OTEL_EXPORTER_OTLP_ENDPOINT、OTEL_TRACES_SAMPLER。
+
+const tracerName = "github.com/QuantumNous/new-api"
+
+var tracer = otel.Tracer(tracerName)
+
+func Init() {
+	if !constant.TracingEnabled {
+		return
+	}
+	ctx := context.Background()
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		common.SysError("failed to create OTLP trace exporter: " + err.Error())
+		return
+	}
+	// 后面的 detector 优先，OTEL_SERVICE_NAME 等环境变量可以覆盖默认值
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName("new-api"), semconv.ServiceVersion(common.Version)),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		common.SysError("failed to create trace resource: " + err.Error())
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	common.SysLog("OpenTelemetry tracing enabled")
+}
+
+// Span 绑定到 gin.Context 的 span，开始时将新的上下文写入 c.Request，结束时恢复，使后续的 span 成为兄弟节点
+type Span struct {
+	c      *gin.Context
+	parent context.Context
+	span   trace.Span
+	ended  bool
+}
+
+func start(c *gin.Context, name string, kind trace.SpanKind, attrs []attribute.KeyValue) *Span {
+	if !constant.TracingEnabled || c == nil || c.Request == nil {
+		return &Span{}
+	}
+	parent := c.Request.Context()
+	ctx, span := tracer.Start(parent, name, trace.WithSpanKind(kind), trace.WithAttributes(attrs...))
+	c.Request = c.Request.WithContext(ctx)
+	return &Span{c: c, parent: parent, span: span}
+}
+
+// Start 开始一个内部 span，必须调用 End
+func Start(c *gin.Context, name string, attrs ...attribute.KeyValue) *Span {
+	return start(c, name, trace.SpanKindInternal, attrs)
+}
+
+// StartServer 开始请求的根 span，会继承请求头中的 traceparent
+func StartServer(c *gin.Context, name string, attrs ...attribute.KeyValue) *Span {
+	if constant.TracingEnabled && c != nil && c.Request != nil {
+		ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+		c.Request = c.Request.WithContext(ctx)
+	}
+	return start(c, name, trace.SpanKindServer, attrs)
+}
+
+// StartClient 开始请求上游的 span
+func StartClient(c *gin.Context, name string, attrs ...attribute.KeyValue) *Span {
+	return start(c, name, trace.SpanKindClient, attrs)
+}
+
+func (s *Span) SetAttributes(attrs ...attribute.KeyValue) {
+	if s.span == nil {
+		return
+	}
+	s.span.SetAttributes(attrs...)
+}
+
+// End 结束 span，err 不为空时记录错误，重复调用无效
+func (s *Span) End(err error) {
+	if s.span == nil || s.ended {
+		return
+	}
+	s.ended = true
+	if !isNilError(err) {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+		if apiErr, ok := err.(*types.NewAPIError); ok {
+			s.span.SetAttributes(
+				attribute.String("newapi.error_code", string(apiErr.GetErrorCode())),
+				attribute.Int("newapi.status_code", apiErr.StatusCode),
+			)
+		}
+	}
+	s.span.End()
+	s.c.Request = s.c.Request.WithContext(s.parent)
+}
+
+// isNilError 避免 (*types.NewAPIError)(nil) 作为 error 传入时被当作错误
+func isNilError(err error) bool {
+	if err == nil {
+		return true
+	}
+	if apiErr, ok := err.(*types.NewAPIError); ok {
+		return apiErr == nil
+	}
+	return false
+}
+
+// Inject 将当前 trace 上下文以 W3C traceparent 请求头传递给上游
+func Inject(ctx context.Context, header http.Header) {
+	if !constant.TracingEnabled {
+		return
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+func ChannelAttributes(channelId int, channelType int) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.Int("newapi.channel.id", channelId),
+		attribute.Int("newapi.channel.type", channelType),
+	}
+}
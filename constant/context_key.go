@@ -6,8 +6,12 @@ const (
 	ContextKeyTokenCountMeta ContextKey = "token_count_meta"
 	ContextKeyPromptTokens   ContextKey = "prompt_tokens"
 
-	ContextKeyOriginalModel    ContextKey = "original_model"
-	ContextKeyRequestStartTime ContextKey = "request_start_time"
+	ContextKeyOriginalModel     ContextKey = "original_model"
+	ContextKeyRequestStartTime  ContextKey = "request_start_time"
+	ContextKeyModelFallbackFrom ContextKey = "model_fallback_from"
+
+	ContextKeyResponseCacheKey   ContextKey = "response_cache_key"
+	ContextKeyResponseCacheEntry ContextKey = "response_cache_entry"
 
 	/* token related keys */
 	ContextKeyTokenUnlimited         ContextKey = "token_unlimited_quota"
@@ -17,6 +21,11 @@ const (
 	ContextKeyTokenSpecificChannelId ContextKey = "specific_channel_id"
 	ContextKeyTokenModelLimitEnabled ContextKey = "token_model_limit_enabled"
 	ContextKeyTokenModelLimit        ContextKey = "token_model_limit"
+	ContextKeyTokenResponseCache     ContextKey = "token_response_cache"
+	ContextKeyTokenQuotaSpendLimited ContextKey = "token_quota_spend_limited"
+	ContextKeyTokenTPMLimit          ContextKey = "token_tpm_limit"
+	ContextKeyTokenConcurrencyLimit  ContextKey = "token_concurrency_limit"
+	ContextKeyTokenCallbackUrl       ContextKey = "token_callback_url"
 
 	/* channel related keys */
 	ContextKeyChannelId                ContextKey = "channel_id"
@@ -35,6 +44,12 @@ const (
 	ContextKeyChannelIsMultiKey        ContextKey = "channel_is_multi_key"
 	ContextKeyChannelMultiKeyIndex     ContextKey = "channel_multi_key_index"
 	ContextKeyChannelKey               ContextKey = "channel_key"
+	ContextKeyChannelAttemptStartTime  ContextKey = "channel_attempt_start_time"
+	ContextKeyChannelRateLimitLease    ContextKey = "channel_rate_limit_lease"
+	ContextKeyStickySessionKey         ContextKey = "sticky_session_key"
+	ContextKeyStickyChannelId          ContextKey = "sticky_channel_id"
+	ContextKeyStickyKeyIndex           ContextKey = "sticky_key_index"
+	ContextKeyHedgeAttempt             ContextKey = "hedge_attempt"
 
 	/* user related keys */
 	ContextKeyUserId      ContextKey = "id"
@@ -46,5 +61,17 @@ const (
 	ContextKeyUsingGroup  ContextKey = "group"
 	ContextKeyUserName    ContextKey = "username"
 
+	ContextKeyUserQuotaSpendLimited ContextKey = "user_quota_spend_limited"
+
 	ContextKeySystemPromptOverride ContextKey = "system_prompt_override"
+
+	/* batch related keys */
+	ContextKeyBatchId            ContextKey = "batch_id"
+	ContextKeyBatchDiscountRatio ContextKey = "batch_discount_ratio"
+
+	ContextKeyConsumedQuota  ContextKey = "consumed_quota"
+	ContextKeyConsumedTokens ContextKey = "consumed_tokens"
+
+	// ContextKeyMediaSignedAccess 通过签名地址访问任务结果，跳过归属校验
+	ContextKeyMediaSignedAccess ContextKey = "media_signed_access"
 )
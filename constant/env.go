@@ -16,3 +16,40 @@ var ErrorLogEnabled bool
 
 // temporary variable for sora patch, will be removed in future
 var TaskPricePatches []string
+
+// FileStoragePath 文件上传（/v1/files）的本地存储目录
+var FileStoragePath string
+
+// FileMaxUploadMB 单个上传文件的最大体积
+var FileMaxUploadMB int
+
+// FileForwardTimeout 引用的文件首次上传到上游渠道的超时时间（秒）
+var FileForwardTimeout int
+
+// ArtifactStorage 异步任务生成结果（视频、图片）的存储方式，local 或 s3
+var ArtifactStorage string
+
+// ArtifactStoragePath 生成结果使用本地存储时的目录
+var ArtifactStoragePath string
+
+// ArtifactS3Endpoint 等为 S3 兼容对象存储的配置，ArtifactS3PathStyle 为 true 时使用 endpoint/bucket/key 形式的地址
+var (
+	ArtifactS3Endpoint        string
+	ArtifactS3Region          string
+	ArtifactS3Bucket          string
+	ArtifactS3AccessKeyId     string
+	ArtifactS3SecretAccessKey string
+	ArtifactS3PathStyle       bool
+)
+
+// MetricsEnabled 是否开启 Prometheus /metrics
+var MetricsEnabled bool
+
+// MetricsToken 访问 /metrics 的 Bearer Token，为空时只能使用管理员 access token 或白名单 IP 访问
+var MetricsToken string
+
+// MetricsAllowedIPs 无需鉴权即可访问 /metrics 的 IP 或 CIDR
+var MetricsAllowedIPs []string
+
+// TracingEnabled 是否开启 OpenTelemetry 链路追踪
+var TracingEnabled bool
@@ -3,6 +3,10 @@ package constant
 type MultiKeyMode string
 
 const (
-	MultiKeyModeRandom  MultiKeyMode = "random"  // 随机
-	MultiKeyModePolling MultiKeyMode = "polling" // 轮询
+	MultiKeyModeRandom            MultiKeyMode = "random"            // 随机
+	MultiKeyModePolling           MultiKeyMode = "polling"           // 轮询
+	MultiKeyModeWeighted          MultiKeyMode = "weighted"          // 按 Key 权重随机
+	MultiKeyModeLeastRecentlyUsed MultiKeyMode = "lru"               // 最久未使用
+	MultiKeyModeLeastOutstanding  MultiKeyMode = "least_outstanding" // 进行中请求最少
+	MultiKeyModeSticky            MultiKeyMode = "sticky"            // 同一用户固定使用同一个 Key，提高上游提示词缓存命中率
 )
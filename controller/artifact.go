@@ -0,0 +1,36 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetArtifactContent 返回网关保存的任务生成结果，支持 Range 请求
+func GetArtifactContent(c *gin.Context) {
+	artifact, err := model.GetTaskArtifactByArtifactId(c.Param("artifact_id"))
+	// 非结果所有者按不存在处理，避免泄露结果是否存在
+	if err != nil || !service.CanAccessArtifact(c, artifact) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": gin.H{
+				"message": "Artifact not found or expired",
+				"type":    "invalid_request_error",
+			},
+		})
+		return
+	}
+	if err := service.ServeArtifact(c, artifact); err != nil {
+		logger.LogError(c.Request.Context(), fmt.Sprintf("Failed to serve artifact %s: %s", artifact.ArtifactId, err.Error()))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": gin.H{
+				"message": "Failed to read artifact content",
+				"type":    "server_error",
+			},
+		})
+	}
+}
@@ -0,0 +1,531 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/middleware"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// CreateBatch POST /v1/batches
+func CreateBatch(c *gin.Context) {
+	if !operation_setting.GetBatchSetting().Enabled {
+		openAIInvalidRequestError(c, http.StatusForbidden, "batch api is disabled", "batch_disabled")
+		return
+	}
+	var req dto.BatchRequest
+	if err := common.UnmarshalBodyReusable(c, &req); err != nil {
+		openAIInvalidRequestError(c, http.StatusBadRequest, "invalid request body", "invalid_request")
+		return
+	}
+	batch, err := service.CreateBatch(c.GetInt("id"), c.GetInt("token_id"), req)
+	if err != nil {
+		openAIInvalidRequestError(c, http.StatusBadRequest, err.Error(), "invalid_request")
+		return
+	}
+	c.JSON(http.StatusOK, service.BatchToOpenAIBatch(batch))
+}
+
+// ListBatches GET /v1/batches
+func ListBatches(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	batches, err := model.GetUserBatches(c.GetInt("id"), c.Query("after"), limit+1)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			openAIInvalidRequestError(c, http.StatusNotFound, "after batch not found", "batch_not_found")
+			return
+		}
+		openAIInvalidRequestError(c, http.StatusInternalServerError, err.Error(), "batch_list_failed")
+		return
+	}
+	hasMore := len(batches) > limit
+	if hasMore {
+		batches = batches[:limit]
+	}
+	list := dto.BatchList{
+		Object:  "list",
+		Data:    make([]dto.Batch, 0, len(batches)),
+		HasMore: hasMore,
+	}
+	for _, batch := range batches {
+		list.Data = append(list.Data, service.BatchToOpenAIBatch(batch))
+	}
+	if len(list.Data) > 0 {
+		list.FirstId = list.Data[0].Id
+		list.LastId = list.Data[len(list.Data)-1].Id
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+func getUserBatch(c *gin.Context) (*model.Batch, bool) {
+	batchId := c.Param("id")
+	batch, err := model.GetUserBatchByBatchId(c.GetInt("id"), batchId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			openAIInvalidRequestError(c, http.StatusNotFound, fmt.Sprintf("No batch found with id '%s'.", batchId), "batch_not_found")
+			return nil, false
+		}
+		openAIInvalidRequestError(c, http.StatusInternalServerError, err.Error(), "batch_query_failed")
+		return nil, false
+	}
+	return batch, true
+}
+
+// RetrieveBatch GET /v1/batches/:id
+func RetrieveBatch(c *gin.Context) {
+	batch, ok := getUserBatch(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, service.BatchToOpenAIBatch(batch))
+}
+
+// CancelBatch POST /v1/batches/:id/cancel
+// 未开始执行的任务直接取消；执行中的任务置为 cancelling，由执行节点停止后写入已完成部分的结果
+func CancelBatch(c *gin.Context) {
+	batch, ok := getUserBatch(c)
+	if !ok {
+		return
+	}
+	now := common.GetTimestamp()
+	var (
+		updated bool
+		err     error
+	)
+	switch batch.Status {
+	case model.BatchStatusValidating:
+		updated, err = batch.UpdateStatusFrom(model.BatchStatusValidating, map[string]any{
+			"status":        model.BatchStatusCancelled,
+			"cancelling_at": now,
+			"cancelled_at":  now,
+		})
+	case model.BatchStatusInProgress:
+		updated, err = batch.UpdateStatusFrom(model.BatchStatusInProgress, map[string]any{
+			"status":        model.BatchStatusCancelling,
+			"cancelling_at": now,
+		})
+	}
+	if err != nil {
+		openAIInvalidRequestError(c, http.StatusInternalServerError, err.Error(), "batch_cancel_failed")
+		return
+	}
+	if !updated {
+		openAIInvalidRequestError(c, http.StatusConflict, fmt.Sprintf("Cannot cancel a batch with status '%s'.", batch.Status), "batch_cannot_be_cancelled")
+		return
+	}
+	batch, ok = getUserBatch(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, service.BatchToOpenAIBatch(batch))
+}
+
+var runningBatches atomic.Int32
+
+// RunBatchScheduler 轮询待执行的批处理任务，仅在主节点运行
+func RunBatchScheduler() {
+	failInterruptedBatches()
+	for {
+		time.Sleep(5 * time.Second)
+		batchSetting := operation_setting.GetBatchSetting()
+		if !batchSetting.Enabled {
+			continue
+		}
+		free := batchSetting.MaxRunningBatches - int(runningBatches.Load())
+		if free <= 0 {
+			continue
+		}
+		batches, err := model.GetPendingBatches(free)
+		if err != nil {
+			common.SysError("failed to get pending batches: " + err.Error())
+			continue
+		}
+		for _, batch := range batches {
+			now := common.GetTimestamp()
+			claimed, err := batch.UpdateStatusFrom(model.BatchStatusValidating, map[string]any{
+				"status":         model.BatchStatusInProgress,
+				"in_progress_at": now,
+			})
+			if err != nil || !claimed {
+				continue
+			}
+			batch.Status = model.BatchStatusInProgress
+			batch.InProgressAt = now
+			runningBatches.Add(1)
+			gopool.Go(func() {
+				defer runningBatches.Add(-1)
+				runBatch(batch)
+			})
+		}
+	}
+}
+
+// failInterruptedBatches 服务重启后，上次未执行完的任务无法恢复进度，直接标记失败，已执行部分已正常计费
+func failInterruptedBatches() {
+	batches, err := model.GetUnfinishedBatches()
+	if err != nil {
+		common.SysError("failed to get unfinished batches: " + err.Error())
+		return
+	}
+	now := common.GetTimestamp()
+	for _, batch := range batches {
+		if batch.Status == model.BatchStatusCancelling {
+			batch.Status = model.BatchStatusCancelled
+			batch.CancelledAt = now
+		} else {
+			batch.Status = model.BatchStatusFailed
+			batch.FailedAt = now
+			service.SetBatchErrors(batch, []dto.BatchError{{Code: "batch_interrupted", Message: "The batch was interrupted by a server restart."}})
+		}
+		if err := batch.Update(); err != nil {
+			common.SysError(fmt.Sprintf("failed to update interrupted batch %s: %s", batch.BatchId, err.Error()))
+		}
+	}
+}
+
+func failBatch(batch *model.Batch, batchErrors []dto.BatchError) {
+	batch.Status = model.BatchStatusFailed
+	batch.FailedAt = common.GetTimestamp()
+	service.SetBatchErrors(batch, batchErrors)
+	if err := batch.Update(); err != nil {
+		common.SysError(fmt.Sprintf("failed to update batch %s: %s", batch.BatchId, err.Error()))
+	}
+}
+
+// batchResultWriter 结果先写入临时文件，全部执行完成后再保存为用户文件
+type batchResultWriter struct {
+	mu     sync.Mutex
+	file   *os.File
+	lines  int
+	prefix string
+}
+
+func newBatchResultWriter(prefix string) (*batchResultWriter, error) {
+	file, err := os.CreateTemp("", prefix+"_*.jsonl")
+	if err != nil {
+		return nil, err
+	}
+	return &batchResultWriter{file: file, prefix: prefix}, nil
+}
+
+func (w *batchResultWriter) Write(line dto.BatchOutputLine) {
+	data, err := common.Marshal(line)
+	if err != nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := w.file.Write(append(data, '\n')); err != nil {
+		common.SysError(fmt.Sprintf("failed to write batch result %s: %s", w.prefix, err.Error()))
+		return
+	}
+	w.lines++
+}
+
+// Save 保存为用户文件，没有内容时返回空 file id
+func (w *batchResultWriter) Save(batch *model.Batch, name string) (string, error) {
+	if w.lines == 0 {
+		return "", nil
+	}
+	if _, err := w.file.Seek(0, 0); err != nil {
+		return "", err
+	}
+	file, err := service.SaveBatchResultFile(batch, name, w.file)
+	if err != nil {
+		return "", err
+	}
+	return file.FileId, nil
+}
+
+func (w *batchResultWriter) Close() {
+	_ = w.file.Close()
+	_ = os.Remove(w.file.Name())
+}
+
+func runBatch(batch *model.Batch) {
+	lines, batchErrors := service.ParseBatchInput(batch)
+	if len(batchErrors) > 0 {
+		failBatch(batch, batchErrors)
+		return
+	}
+	token, err := model.GetTokenById(batch.TokenId)
+	if err != nil {
+		failBatch(batch, []dto.BatchError{{Code: "invalid_token", Message: "The token used to create the batch is no longer available."}})
+		return
+	}
+	batch.RequestTotal = len(lines)
+	if err := model.DB.Model(batch).Update("request_total", batch.RequestTotal).Error; err != nil {
+		common.SysError(fmt.Sprintf("failed to update batch %s: %s", batch.BatchId, err.Error()))
+	}
+
+	outputWriter, err := newBatchResultWriter(batch.BatchId + "_output")
+	if err != nil {
+		failBatch(batch, []dto.BatchError{{Code: "server_error", Message: "failed to create output file"}})
+		return
+	}
+	defer outputWriter.Close()
+	errorWriter, err := newBatchResultWriter(batch.BatchId + "_error")
+	if err != nil {
+		failBatch(batch, []dto.BatchError{{Code: "server_error", Message: "failed to create error file"}})
+		return
+	}
+	defer errorWriter.Close()
+
+	common.SysLog(fmt.Sprintf("batch %s started, %d requests", batch.BatchId, len(lines)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// 定期检查取消与过期
+	var stopStatus atomic.Value
+	gopool.Go(func() {
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if common.GetTimestamp() > batch.ExpiresAt {
+				stopStatus.Store(model.BatchStatusExpired)
+				cancel()
+				return
+			}
+			status, err := model.GetBatchStatus(batch.Id)
+			if err == nil && status == model.BatchStatusCancelling {
+				stopStatus.Store(model.BatchStatusCancelled)
+				cancel()
+				return
+			}
+		}
+	})
+
+	concurrency := operation_setting.GetBatchSetting().Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		processed int
+	)
+	sem := make(chan struct{}, concurrency)
+	executed := make([]bool, len(lines))
+dispatch:
+	for i := range lines {
+		select {
+		case <-ctx.Done():
+			break dispatch
+		case sem <- struct{}{}:
+		}
+		executed[i] = true
+		line := lines[i]
+		wg.Add(1)
+		gopool.Go(func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			result, quota := executeBatchLine(batch, token.Key, line)
+			mu.Lock()
+			batch.Quota += quota
+			if result.Error == nil {
+				batch.RequestCompleted++
+			} else {
+				batch.RequestFailed++
+			}
+			processed++
+			if processed%20 == 0 {
+				if err := batch.UpdateProgress(); err != nil {
+					common.SysError(fmt.Sprintf("failed to update batch %s progress: %s", batch.BatchId, err.Error()))
+				}
+			}
+			mu.Unlock()
+			if result.Error == nil {
+				outputWriter.Write(result)
+			} else {
+				errorWriter.Write(result)
+			}
+		})
+	}
+	wg.Wait()
+
+	finalStatus := model.BatchStatusCompleted
+	if status, ok := stopStatus.Load().(string); ok {
+		finalStatus = status
+	}
+	// 未执行的请求写入错误文件
+	if finalStatus != model.BatchStatusCompleted {
+		code := "batch_" + finalStatus
+		for i, line := range lines {
+			if executed[i] {
+				continue
+			}
+			errorWriter.Write(dto.BatchOutputLine{
+				Id:       "batch_req_" + common.GetUUID()[:24],
+				CustomId: line.CustomId,
+				Error: &dto.BatchOutputError{
+					Code:    code,
+					Message: fmt.Sprintf("This request could not be executed before the batch was %s.", finalStatus),
+				},
+			})
+		}
+	}
+
+	batch.FinalizingAt = common.GetTimestamp()
+	if finalStatus == model.BatchStatusCompleted {
+		if _, err := batch.UpdateStatusFrom(model.BatchStatusInProgress, map[string]any{
+			"status":        model.BatchStatusFinalizing,
+			"finalizing_at": batch.FinalizingAt,
+		}); err != nil {
+			common.SysError(fmt.Sprintf("failed to update batch %s: %s", batch.BatchId, err.Error()))
+		}
+	}
+	batch.OutputFileId, err = outputWriter.Save(batch, "output")
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to save batch %s output: %s", batch.BatchId, err.Error()))
+	}
+	batch.ErrorFileId, err = errorWriter.Save(batch, "error")
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to save batch %s errors: %s", batch.BatchId, err.Error()))
+	}
+
+	now := common.GetTimestamp()
+	batch.Status = finalStatus
+	switch finalStatus {
+	case model.BatchStatusCompleted:
+		batch.CompletedAt = now
+	case model.BatchStatusCancelled:
+		batch.CancelledAt = now
+		if cancellingBatch, err := model.GetUserBatchByBatchId(batch.UserId, batch.BatchId); err == nil {
+			batch.CancellingAt = cancellingBatch.CancellingAt
+		}
+	case model.BatchStatusExpired:
+		batch.ExpiredAt = now
+	}
+	if err := batch.Update(); err != nil {
+		common.SysError(fmt.Sprintf("failed to update batch %s: %s", batch.BatchId, err.Error()))
+	}
+	common.SysLog(fmt.Sprintf("batch %s %s, completed %d, failed %d, quota %d", batch.BatchId, finalStatus, batch.RequestCompleted, batch.RequestFailed, batch.Quota))
+}
+
+// executeBatchLine 构造内部请求，按正常的 relay 流程（分发、预扣费、重试、结算）执行单个请求
+func executeBatchLine(batch *model.Batch, tokenKey string, line dto.BatchInputLine) (dto.BatchOutputLine, int) {
+	result := dto.BatchOutputLine{
+		Id:       "batch_req_" + common.GetUUID()[:24],
+		CustomId: line.CustomId,
+	}
+
+	body, err := normalizeBatchBody(line.Body)
+	if err != nil {
+		result.Error = &dto.BatchOutputError{Code: "invalid_request", Message: err.Error()}
+		return result, 0
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	requestId := common.GetTimeString() + common.GetRandomString(8)
+	req, _ := http.NewRequestWithContext(context.WithValue(context.Background(), common.RequestIdKey, requestId), http.MethodPost, batch.Endpoint, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	c.Request = req
+	c.Set(common.RequestIdKey, requestId)
+
+	if err := setupBatchTokenContext(c, tokenKey); err != nil {
+		result.Error = &dto.BatchOutputError{Code: "invalid_token", Message: err.Error()}
+		return result, 0
+	}
+	common.SetContextKey(c, constant.ContextKeyBatchId, batch.BatchId)
+	common.SetContextKey(c, constant.ContextKeyBatchDiscountRatio, operation_setting.GetBatchSetting().DiscountRatio)
+
+	// 不经过中间件链，每一行单独检查模型请求限流，渠道的速率限制占用需要在 Relay 完成后按实际消耗释放
+	if recordModelRequest, ok := middleware.AcquireModelRequestRateLimit(c); !ok {
+		// 内存限流只设置了状态码，需要写出后才会记录到响应中
+		c.Writer.WriteHeaderNow()
+	} else {
+		if middleware.DistributeChannel(c) {
+			relayFormat := types.RelayFormatOpenAI
+			if batch.Endpoint == "/v1/embeddings" {
+				relayFormat = types.RelayFormatEmbedding
+			}
+			Relay(c, relayFormat)
+			middleware.ReleaseChannelRateLimit(c)
+		}
+		recordModelRequest()
+	}
+
+	respBody := w.Body.Bytes()
+	if len(respBody) == 0 || !common.IsJsonObject(string(respBody)) {
+		respBody = []byte("{}")
+	}
+	result.Response = &dto.BatchOutputResponse{
+		StatusCode: w.Code,
+		RequestId:  requestId,
+		Body:       respBody,
+	}
+	if w.Code != http.StatusOK {
+		var errResp struct {
+			Error dto.OpenAIError `json:"error"`
+		}
+		_ = common.Unmarshal(respBody, &errResp)
+		code, _ := errResp.Error.Code.(string)
+		if code == "" {
+			code = "request_failed"
+		}
+		result.Error = &dto.BatchOutputError{Code: code, Message: errResp.Error.Message}
+	}
+	return result, common.GetContextKeyInt(c, constant.ContextKeyConsumedQuota)
+}
+
+// normalizeBatchBody 批处理不支持流式输出
+func normalizeBatchBody(body []byte) ([]byte, error) {
+	var request map[string]any
+	if err := common.Unmarshal(body, &request); err != nil {
+		return nil, errors.New("body must be a JSON object")
+	}
+	delete(request, "stream")
+	delete(request, "stream_options")
+	return common.Marshal(request)
+}
+
+// setupBatchTokenContext 与 TokenAuth 相同地写入令牌与用户上下文，令牌状态与余额在每个请求执行前重新校验
+func setupBatchTokenContext(c *gin.Context, tokenKey string) error {
+	token, err := model.ValidateUserToken(tokenKey)
+	if err != nil {
+		return err
+	}
+	userCache, err := model.GetUserCache(token.UserId)
+	if err != nil {
+		return err
+	}
+	if userCache.Status != common.UserStatusEnabled {
+		return errors.New("用户已被封禁")
+	}
+	userCache.WriteContext(c)
+	usingGroup, err := middleware.GetTokenUsingGroup(userCache.Group, token.Group)
+	if err != nil {
+		return err
+	}
+	common.SetContextKey(c, constant.ContextKeyUsingGroup, usingGroup)
+	return middleware.SetupContextForToken(c, token)
+}
@@ -0,0 +1,97 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+)
+
+const batchTestResponse = `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-test","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}],"usage":{"prompt_tokens":3,"completion_tokens":1,"total_tokens":4}}`
+
+func newBatchTestLine(customId string) dto.BatchInputLine {
+	return dto.BatchInputLine{
+		CustomId: customId,
+		Method:   http.MethodPost,
+		Url:      "/v1/chat/completions",
+		Body:     []byte(`{"model":"gpt-test","messages":[{"role":"user","content":"hi"}]}`),
+	}
+}
+
+func TestExecuteBatchLineHoldsChannelRateLimit(t *testing.T) {
+	setupRelayTestDB(t)
+	operation_setting.SelfUseModeEnabled = true
+	defer func() { operation_setting.SelfUseModeEnabled = false }()
+
+	var channel *model.Channel
+	// 上游收到请求时，批处理仍占用着渠道唯一的并发额度
+	var acquiredWhileRelaying atomic.Bool
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lease, ok := model.AcquireChannelRateLimit(channel, 0)
+		if ok {
+			lease.Release(0)
+		}
+		acquiredWhileRelaying.Store(ok)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(batchTestResponse))
+	}))
+	defer upstream.Close()
+	channel = createRelayTestChannel(t, 1, "gpt-test", upstream.URL, `{"concurrency_limit":1}`)
+	token := createRelayTestToken(t)
+
+	batch := &model.Batch{BatchId: "batch_test", UserId: token.UserId, Endpoint: "/v1/chat/completions"}
+	result, _ := executeBatchLine(batch, token.Key, newBatchTestLine("req-1"))
+	if result.Error != nil {
+		t.Fatalf("batch line failed: %s %s", result.Error.Code, result.Error.Message)
+	}
+	if acquiredWhileRelaying.Load() {
+		t.Fatal("channel concurrency slot was free while the batch line was relaying")
+	}
+	lease, ok := model.AcquireChannelRateLimit(channel, 0)
+	if !ok {
+		t.Fatal("channel concurrency slot was not released after the batch line finished")
+	}
+	lease.Release(0)
+}
+
+func TestExecuteBatchLineAppliesModelRequestRateLimit(t *testing.T) {
+	setupRelayTestDB(t)
+	operation_setting.SelfUseModeEnabled = true
+	oldEnabled, oldCount, oldSuccessCount := setting.ModelRequestRateLimitEnabled, setting.ModelRequestRateLimitCount, setting.ModelRequestRateLimitSuccessCount
+	defer func() {
+		operation_setting.SelfUseModeEnabled = false
+		setting.ModelRequestRateLimitEnabled, setting.ModelRequestRateLimitCount, setting.ModelRequestRateLimitSuccessCount = oldEnabled, oldCount, oldSuccessCount
+	}()
+	setting.ModelRequestRateLimitEnabled = true
+	setting.ModelRequestRateLimitCount = 0
+	setting.ModelRequestRateLimitSuccessCount = 1
+
+	var upstreamCalls atomic.Int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(batchTestResponse))
+	}))
+	defer upstream.Close()
+	createRelayTestChannel(t, 3, "gpt-test", upstream.URL, "")
+	token := createRelayTestToken(t)
+
+	batch := &model.Batch{BatchId: "batch_rate_limit_test", UserId: token.UserId, Endpoint: "/v1/chat/completions"}
+	result, _ := executeBatchLine(batch, token.Key, newBatchTestLine("req-1"))
+	if result.Error != nil {
+		t.Fatalf("first batch line failed: %s %s", result.Error.Code, result.Error.Message)
+	}
+	// 每分钟只允许一次成功请求，第二行被限流且不请求上游
+	result, _ = executeBatchLine(batch, token.Key, newBatchTestLine("req-2"))
+	if result.Response == nil || result.Response.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("second batch line response = %+v, want status 429", result.Response)
+	}
+	if got := upstreamCalls.Load(); got != 1 {
+		t.Fatalf("upstream calls = %d, want 1", got)
+	}
+}
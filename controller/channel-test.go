@@ -637,3 +637,65 @@ func AutomaticallyTestChannels() {
 		}
 	})
 }
+
+var circuitBreakerProbeOnce sync.Once
+
+const circuitBreakerProbeLeaderTTL = time.Minute
+
+// AutomaticallyProbeCircuitBreakers 主动测试熔断器处于半开状态的渠道和 Key，成功后自动启用。
+// 每个节点定期从 Redis 同步熔断记录，多节点部署时只由选举出的节点建立熔断记录和主动测试
+func AutomaticallyProbeCircuitBreakers() {
+	circuitBreakerProbeOnce.Do(func() {
+		elector := service.NewLeaderElector("circuit_breaker_probe", circuitBreakerProbeLeaderTTL)
+		initialized := false
+		for {
+			time.Sleep(10 * time.Second)
+			setting := operation_setting.GetCircuitBreakerSetting()
+			if !setting.Enabled {
+				initialized = false
+				continue
+			}
+			model.SyncChannelCircuitBreakers()
+			if !elector.IsLeader() {
+				initialized = false
+				continue
+			}
+			if !initialized {
+				model.InitChannelCircuitBreakers()
+				initialized = true
+			}
+			if !setting.ProbeEnabled {
+				continue
+			}
+			for _, probe := range model.GetChannelCircuitBreakerProbes() {
+				probeCircuitBreaker(probe)
+				time.Sleep(common.RequestInterval)
+			}
+		}
+	})
+}
+
+func probeCircuitBreaker(probe model.ChannelCircuitBreakerProbe) {
+	channel := probe.Channel
+	testTarget := channel
+	if probe.KeyIndex != model.CircuitBreakerChannelLevel {
+		// 只测试指定的 Key
+		keyChannel := *channel
+		keyChannel.Key = probe.Key
+		keyChannel.Keys = nil
+		keyChannel.ChannelInfo = model.ChannelInfo{}
+		testTarget = &keyChannel
+	}
+	result := testChannel(testTarget, "", "")
+	switch {
+	case result.newAPIError != nil:
+		model.RecordChannelCircuitBreakerResult(channel.Id, probe.Key, false, result.newAPIError.Error())
+	case result.localErr != nil:
+		// 渠道类型不支持测试等本地错误，只能依靠真实请求恢复
+		model.ReleaseChannelCircuitBreakerTrial(channel.Id, probe.Key)
+	default:
+		if model.RecordChannelCircuitBreakerResult(channel.Id, probe.Key, true, "") {
+			service.EnableChannel(channel.Id, probe.Key, channel.Name)
+		}
+	}
+}
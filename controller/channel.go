@@ -386,6 +386,32 @@ func GetChannel(c *gin.Context) {
 	return
 }
 
+// GetChannelHealth 获取渠道健康统计（滑动窗口内的成功率、延迟以及剔除状态）
+func GetChannelHealth(c *gin.Context) {
+	common.ApiSuccess(c, model.GetAllChannelHealthStats())
+}
+
+// GetChannelCircuitBreakers 获取渠道熔断器状态
+func GetChannelCircuitBreakers(c *gin.Context) {
+	common.ApiSuccess(c, model.GetChannelCircuitBreakers())
+}
+
+// GetChannelCooldowns 获取因上游限流而冷却中的渠道和 Key
+func GetChannelCooldowns(c *gin.Context) {
+	common.ApiSuccess(c, model.GetChannelCooldowns())
+}
+
+// ClearChannelCooldown 手动解除渠道及其所有 Key 的冷却
+func ClearChannelCooldown(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	model.ClearChannelCooldown(id)
+	common.ApiSuccess(c, nil)
+}
+
 // GetChannelKey 获取渠道密钥（需要通过安全验证中间件）
 // 此函数依赖 SecureVerificationRequired 中间件，确保用户已通过安全验证
 func GetChannelKey(c *gin.Context) {
@@ -1081,8 +1107,9 @@ func CopyChannel(c *gin.Context) {
 // MultiKeyManageRequest represents the request for multi-key management operations
 type MultiKeyManageRequest struct {
 	ChannelId int    `json:"channel_id"`
-	Action    string `json:"action"`              // "disable_key", "enable_key", "delete_key", "delete_disabled_keys", "get_key_status"
-	KeyIndex  *int   `json:"key_index,omitempty"` // for disable_key, enable_key, and delete_key actions
+	Action    string `json:"action"`              // "disable_key", "enable_key", "delete_key", "delete_disabled_keys", "get_key_status", "set_key_weight"
+	KeyIndex  *int   `json:"key_index,omitempty"` // for disable_key, enable_key, delete_key and set_key_weight actions
+	Weight    *int   `json:"weight,omitempty"`    // for set_key_weight action
 	Page      int    `json:"page,omitempty"`      // for get_key_status pagination
 	PageSize  int    `json:"page_size,omitempty"` // for get_key_status pagination
 	Status    *int   `json:"status,omitempty"`    // for get_key_status filtering: 1=enabled, 2=manual_disabled, 3=auto_disabled, nil=all
@@ -1107,6 +1134,14 @@ type KeyStatus struct {
 	DisabledTime int64  `json:"disabled_time,omitempty"`
 	Reason       string `json:"reason,omitempty"`
 	KeyPreview   string `json:"key_preview"` // first 10 chars of key for identification
+	Weight       int    `json:"weight"`      // weight for weighted mode
+	// 当前节点内存中的使用统计
+	Requests     int64 `json:"requests"`
+	Tokens       int64 `json:"tokens"`
+	Outstanding  int   `json:"outstanding"`
+	LastUsedTime int64 `json:"last_used_time,omitempty"`
+	// 持久化的累计用量（请求数、token、额度、最近错误）
+	Stat *model.ChannelKeyStat `json:"stat,omitempty"`
 }
 
 // ManageMultiKeys handles multi-key management operations
@@ -1156,6 +1191,13 @@ func ManageMultiKeys(c *gin.Context) {
 		// Statistics for all keys (unchanged by filtering)
 		var enabledCount, manualDisabledCount, autoDisabledCount int
 
+		usages := model.GetChannelKeyUsages(channel.Id, len(keys))
+		stats, err := model.GetChannelKeyStats(channel.Id)
+		if err != nil {
+			common.ApiError(c, err)
+			return
+		}
+
 		// Build all key status data first
 		var allKeyStatusList []KeyStatus
 		for i, key := range keys {
@@ -1194,12 +1236,23 @@ func ManageMultiKeys(c *gin.Context) {
 				keyPreview = key[:10] + "..."
 			}
 
+			weight := 1
+			if w, exists := channel.ChannelInfo.MultiKeyWeights[i]; exists {
+				weight = w
+			}
+
 			allKeyStatusList = append(allKeyStatusList, KeyStatus{
 				Index:        i,
 				Status:       status,
 				DisabledTime: disabledTime,
 				Reason:       reason,
 				KeyPreview:   keyPreview,
+				Weight:       weight,
+				Requests:     usages[i].Requests,
+				Tokens:       usages[i].Tokens,
+				Outstanding:  usages[i].Outstanding,
+				LastUsedTime: usages[i].LastUsedTime,
+				Stat:         stats[model.ChannelKeyHash(key)],
 			})
 		}
 
@@ -1410,6 +1463,49 @@ func ManageMultiKeys(c *gin.Context) {
 		})
 		return
 
+	case "set_key_weight":
+		if request.KeyIndex == nil || request.Weight == nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "未指定密钥索引或权重",
+			})
+			return
+		}
+
+		keyIndex := *request.KeyIndex
+		if keyIndex < 0 || keyIndex >= channel.ChannelInfo.MultiKeySize {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "密钥索引超出范围",
+			})
+			return
+		}
+		if *request.Weight < 0 {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "权重不能为负数",
+			})
+			return
+		}
+
+		if channel.ChannelInfo.MultiKeyWeights == nil {
+			channel.ChannelInfo.MultiKeyWeights = make(map[int]int)
+		}
+		channel.ChannelInfo.MultiKeyWeights[keyIndex] = *request.Weight
+
+		err = channel.Update()
+		if err != nil {
+			common.ApiError(c, err)
+			return
+		}
+
+		model.InitChannelCache()
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "密钥权重已更新",
+		})
+		return
+
 	case "delete_key":
 		if request.KeyIndex == nil {
 			c.JSON(http.StatusOK, gin.H{
@@ -1433,6 +1529,7 @@ func ManageMultiKeys(c *gin.Context) {
 		var newStatusList = make(map[int]int)
 		var newDisabledTime = make(map[int]int64)
 		var newDisabledReason = make(map[int]string)
+		var newWeights = make(map[int]int)
 
 		newIndex := 0
 		for i, key := range keys {
@@ -1459,6 +1556,9 @@ func ManageMultiKeys(c *gin.Context) {
 					newDisabledReason[newIndex] = r
 				}
 			}
+			if w, exists := channel.ChannelInfo.MultiKeyWeights[i]; exists {
+				newWeights[newIndex] = w
+			}
 			newIndex++
 		}
 
@@ -1476,6 +1576,7 @@ func ManageMultiKeys(c *gin.Context) {
 		channel.ChannelInfo.MultiKeyStatusList = newStatusList
 		channel.ChannelInfo.MultiKeyDisabledTime = newDisabledTime
 		channel.ChannelInfo.MultiKeyDisabledReason = newDisabledReason
+		channel.ChannelInfo.MultiKeyWeights = newWeights
 
 		err = channel.Update()
 		if err != nil {
@@ -1483,6 +1584,9 @@ func ManageMultiKeys(c *gin.Context) {
 			return
 		}
 
+		// 密钥索引已变化，清空按索引记录的使用统计和冷却状态
+		model.ResetChannelKeyUsages(channel.Id)
+		model.ClearChannelCooldown(channel.Id)
 		model.InitChannelCache()
 		c.JSON(http.StatusOK, gin.H{
 			"success": true,
@@ -1497,6 +1601,7 @@ func ManageMultiKeys(c *gin.Context) {
 		var newStatusList = make(map[int]int)
 		var newDisabledTime = make(map[int]int64)
 		var newDisabledReason = make(map[int]string)
+		var newWeights = make(map[int]int)
 
 		newIndex := 0
 		for i, key := range keys {
@@ -1512,6 +1617,9 @@ func ManageMultiKeys(c *gin.Context) {
 				deletedCount++
 			} else {
 				remainingKeys = append(remainingKeys, key)
+				if w, exists := channel.ChannelInfo.MultiKeyWeights[i]; exists {
+					newWeights[newIndex] = w
+				}
 				// 保留非自动禁用密钥的状态信息，重新索引
 				if status != 1 {
 					newStatusList[newIndex] = status
@@ -1544,6 +1652,7 @@ func ManageMultiKeys(c *gin.Context) {
 		channel.ChannelInfo.MultiKeyStatusList = newStatusList
 		channel.ChannelInfo.MultiKeyDisabledTime = newDisabledTime
 		channel.ChannelInfo.MultiKeyDisabledReason = newDisabledReason
+		channel.ChannelInfo.MultiKeyWeights = newWeights
 
 		err = channel.Update()
 		if err != nil {
@@ -1551,6 +1660,8 @@ func ManageMultiKeys(c *gin.Context) {
 			return
 		}
 
+		model.ResetChannelKeyUsages(channel.Id)
+		model.ClearChannelCooldown(channel.Id)
 		model.InitChannelCache()
 		c.JSON(http.StatusOK, gin.H{
 			"success": true,
@@ -0,0 +1,193 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func openAIInvalidRequestError(c *gin.Context, statusCode int, message string, code string) {
+	c.JSON(statusCode, gin.H{
+		"error": dto.OpenAIError{
+			Message: message,
+			Type:    "invalid_request_error",
+			Code:    code,
+		},
+	})
+}
+
+func isValidFilePurpose(purpose string) bool {
+	switch purpose {
+	case model.FilePurposeAssistants, model.FilePurposeBatch, model.FilePurposeFineTune,
+		model.FilePurposeVision, model.FilePurposeUserData, model.FilePurposeEvals:
+		return true
+	}
+	return false
+}
+
+// UploadFile POST /v1/files
+func UploadFile(c *gin.Context) {
+	maxBytes := int64(constant.FileMaxUploadMB) << 20
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes+(1<<20))
+
+	purpose := c.PostForm("purpose")
+	if !isValidFilePurpose(purpose) {
+		openAIInvalidRequestError(c, http.StatusBadRequest, fmt.Sprintf("invalid purpose: %s", purpose), "invalid_purpose")
+		return
+	}
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		openAIInvalidRequestError(c, http.StatusBadRequest, "file is required", "invalid_file")
+		return
+	}
+	if fileHeader.Size > maxBytes {
+		openAIInvalidRequestError(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("file is too large, max size is %d MB", constant.FileMaxUploadMB), "file_too_large")
+		return
+	}
+	var expiresAfter int64
+	if seconds := c.PostForm("expires_after[seconds]"); seconds != "" {
+		expiresAfter, err = strconv.ParseInt(seconds, 10, 64)
+		if err != nil || expiresAfter <= 0 {
+			openAIInvalidRequestError(c, http.StatusBadRequest, "invalid expires_after[seconds]", "invalid_expires_after")
+			return
+		}
+	}
+
+	src, err := fileHeader.Open()
+	if err != nil {
+		openAIInvalidRequestError(c, http.StatusBadRequest, "failed to read file", "invalid_file")
+		return
+	}
+	defer src.Close()
+
+	contentType := fileHeader.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	file, err := service.CreateFile(c.GetInt("id"), c.GetInt("token_id"), fileHeader.Filename, purpose, contentType, src, expiresAfter)
+	if err != nil {
+		logger.LogError(c, "failed to create file: "+err.Error())
+		openAIInvalidRequestError(c, http.StatusInternalServerError, err.Error(), "file_upload_failed")
+		return
+	}
+	c.JSON(http.StatusOK, service.FileToOpenAIFile(file))
+}
+
+// ListFiles GET /v1/files
+func ListFiles(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if limit <= 0 || limit > 10000 {
+		limit = 100
+	}
+	// 多取一条用于判断 has_more
+	files, err := model.GetUserFiles(c.GetInt("id"), c.Query("purpose"), c.Query("after"), limit+1, c.Query("order"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			openAIInvalidRequestError(c, http.StatusNotFound, "after file not found", "file_not_found")
+			return
+		}
+		openAIInvalidRequestError(c, http.StatusInternalServerError, err.Error(), "file_list_failed")
+		return
+	}
+	hasMore := len(files) > limit
+	if hasMore {
+		files = files[:limit]
+	}
+	list := dto.OpenAIFileList{
+		Object:  "list",
+		Data:    make([]dto.OpenAIFile, 0, len(files)),
+		HasMore: hasMore,
+	}
+	for _, file := range files {
+		list.Data = append(list.Data, service.FileToOpenAIFile(file))
+	}
+	if len(list.Data) > 0 {
+		list.FirstId = list.Data[0].Id
+		list.LastId = list.Data[len(list.Data)-1].Id
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+func getUserFile(c *gin.Context) (*model.File, bool) {
+	fileId := c.Param("id")
+	file, err := model.GetUserFileByFileId(c.GetInt("id"), fileId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			openAIInvalidRequestError(c, http.StatusNotFound, fmt.Sprintf("No such File object: %s", fileId), "file_not_found")
+			return nil, false
+		}
+		openAIInvalidRequestError(c, http.StatusInternalServerError, err.Error(), "file_query_failed")
+		return nil, false
+	}
+	if file.IsExpired() {
+		openAIInvalidRequestError(c, http.StatusNotFound, fmt.Sprintf("No such File object: %s", fileId), "file_not_found")
+		return nil, false
+	}
+	return file, true
+}
+
+// RetrieveFile GET /v1/files/:id
+func RetrieveFile(c *gin.Context) {
+	file, ok := getUserFile(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, service.FileToOpenAIFile(file))
+}
+
+// DeleteFile DELETE /v1/files/:id
+func DeleteFile(c *gin.Context) {
+	file, ok := getUserFile(c)
+	if !ok {
+		return
+	}
+	if err := service.DeleteFile(file); err != nil {
+		openAIInvalidRequestError(c, http.StatusInternalServerError, err.Error(), "file_delete_failed")
+		return
+	}
+	c.JSON(http.StatusOK, dto.OpenAIFileDeleted{
+		Id:      file.FileId,
+		Object:  "file",
+		Deleted: true,
+	})
+}
+
+// RetrieveFileContent GET /v1/files/:id/content
+func RetrieveFileContent(c *gin.Context) {
+	file, ok := getUserFile(c)
+	if !ok {
+		return
+	}
+	content, err := service.OpenFileContent(file)
+	if err != nil {
+		logger.LogError(c, fmt.Sprintf("failed to open file %s: %s", file.FileId, err.Error()))
+		openAIInvalidRequestError(c, http.StatusInternalServerError, "failed to read file content", "file_read_failed")
+		return
+	}
+	defer content.Close()
+
+	contentType := file.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Length", strconv.FormatInt(file.Bytes, 10))
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": file.Filename}))
+	c.Status(http.StatusOK)
+	if _, err := io.Copy(c.Writer, content); err != nil {
+		common.SysError(fmt.Sprintf("failed to write file content %s: %s", file.FileId, err.Error()))
+	}
+}
@@ -0,0 +1,94 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/setting/system_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+type mediaSignedUrlRequest struct {
+	// ExpiresIn 有效期（秒），为空时使用默认有效期
+	ExpiresIn int `json:"expires_in"`
+}
+
+// signMediaUrl 为当前用户自己的任务结果生成限时签名地址
+func signMediaUrl(c *gin.Context, resource string, id string, path string, userId int) {
+	if userId != c.GetInt("id") {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": gin.H{
+				"message": "Task not found",
+				"type":    "invalid_request_error",
+			},
+		})
+		return
+	}
+	var req mediaSignedUrlRequest
+	if c.Request.ContentLength > 0 {
+		if err := common.UnmarshalBodyReusable(c, &req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": gin.H{
+					"message": "invalid request body",
+					"type":    "invalid_request_error",
+				},
+			})
+			return
+		}
+	}
+	setting := operation_setting.GetMediaAccessSetting()
+	ttl := req.ExpiresIn
+	if ttl <= 0 {
+		ttl = setting.SignedUrlTTL
+	}
+	if ttl > setting.MaxSignedUrlTTL {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"message": "expires_in exceeds the maximum allowed value",
+				"type":    "invalid_request_error",
+			},
+		})
+		return
+	}
+	query, expiresAt := service.SignMediaResource(resource, id, ttl)
+	c.JSON(http.StatusOK, gin.H{
+		"url":        system_setting.ServerAddress + path + "?" + query.Encode(),
+		"expires_at": expiresAt,
+	})
+}
+
+// CreateVideoContentSignedUrl 生成视频内容的限时签名地址
+func CreateVideoContentSignedUrl(c *gin.Context) {
+	taskId := c.Param("task_id")
+	task, exists, err := model.GetByOnlyTaskId(taskId)
+	if err != nil || !exists || task == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": gin.H{
+				"message": "Task not found",
+				"type":    "invalid_request_error",
+			},
+		})
+		return
+	}
+	signMediaUrl(c, service.MediaResourceVideo, task.TaskID, "/v1/videos/"+task.TaskID+"/content", task.UserId)
+}
+
+// CreateMidjourneyImageSignedUrl 生成 Midjourney 图片的限时签名地址
+func CreateMidjourneyImageSignedUrl(c *gin.Context) {
+	mjId := c.Param("id")
+	task := model.GetByOnlyMJId(mjId)
+	if task == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": gin.H{
+				"message": "Task not found",
+				"type":    "invalid_request_error",
+			},
+		})
+		return
+	}
+	signMediaUrl(c, service.MediaResourceMidjourneyImage, task.MjId, "/mj/image/"+task.MjId, task.UserId)
+}
@@ -10,186 +10,231 @@ import (
 	"time"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/dto"
 	"github.com/QuantumNous/new-api/logger"
 	"github.com/QuantumNous/new-api/model"
 	"github.com/QuantumNous/new-api/service"
 	"github.com/QuantumNous/new-api/setting"
-	"github.com/QuantumNous/new-api/setting/system_setting"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
 
 	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/errgroup"
 )
 
-func UpdateMidjourneyTaskBulk() {
+// updateDueMidjourneyTasks 按渠道批量查询到达查询时间的 Midjourney 任务，查询在 g 中并发执行
+func updateDueMidjourneyTasks(g *errgroup.Group) {
 	//imageModel := "midjourney"
 	ctx := context.TODO()
-	for {
-		time.Sleep(time.Duration(15) * time.Second)
+	now := common.GetTimestamp()
+	tasks := model.GetDueUnFinishTasks(now, operation_setting.GetTaskPollSetting().BatchSize)
+	if len(tasks) == 0 {
+		return
+	}
 
-		tasks := model.GetAllUnFinishTasks()
-		if len(tasks) == 0 {
+	logger.LogInfo(ctx, fmt.Sprintf("检测到未完成的任务数有: %v", len(tasks)))
+	taskChannelM := make(map[int][]string)
+	taskM := make(map[string]*model.Midjourney)
+	nullTaskIds := make([]int, 0)
+	maxDuration := operation_setting.GetTaskPollSetting().GetMaxDuration(string(constant.TaskPlatformMidjourney))
+	for _, task := range tasks {
+		if task.MjId == "" {
+			// 统计失败的未完成任务
+			nullTaskIds = append(nullTaskIds, task.Id)
 			continue
 		}
-
-		logger.LogInfo(ctx, fmt.Sprintf("检测到未完成的任务数有: %v", len(tasks)))
-		taskChannelM := make(map[int][]string)
-		taskM := make(map[string]*model.Midjourney)
-		nullTaskIds := make([]int, 0)
-		for _, task := range tasks {
-			if task.MjId == "" {
-				// 统计失败的未完成任务
-				nullTaskIds = append(nullTaskIds, task.Id)
-				continue
-			}
-			taskM[task.MjId] = task
-			taskChannelM[task.ChannelId] = append(taskChannelM[task.ChannelId], task.MjId)
+		// 超过最长时间仍未完成的任务不再查询上游，上游查询失败或未返回该任务时也能结束并退还额度
+		if maxDuration > 0 && task.SubmitTime > 0 && now*1000-task.SubmitTime > maxDuration*1000 && task.Progress != "100%" {
+			failTimedOutMidjourneyTask(ctx, task, maxDuration)
+			continue
 		}
-		if len(nullTaskIds) > 0 {
-			err := model.MjBulkUpdateByTaskIds(nullTaskIds, map[string]any{
-				"status":   "FAILURE",
-				"progress": "100%",
-			})
-			if err != nil {
-				logger.LogError(ctx, fmt.Sprintf("Fix null mj_id task error: %v", err))
-			} else {
-				logger.LogInfo(ctx, fmt.Sprintf("Fix null mj_id task success: %v", nullTaskIds))
+		claimMidjourneyPoll(task, now)
+		taskM[task.MjId] = task
+		taskChannelM[task.ChannelId] = append(taskChannelM[task.ChannelId], task.MjId)
+	}
+	if len(nullTaskIds) > 0 {
+		err := model.MjBulkUpdateByTaskIds(nullTaskIds, map[string]any{
+			"status":   "FAILURE",
+			"progress": "100%",
+		})
+		if err != nil {
+			logger.LogError(ctx, fmt.Sprintf("Fix null mj_id task error: %v", err))
+		} else {
+			logger.LogInfo(ctx, fmt.Sprintf("Fix null mj_id task success: %v", nullTaskIds))
+		}
+	}
+
+	for channelId, taskIds := range taskChannelM {
+		g.Go(func() error {
+			updateMidjourneyChannelTasks(ctx, channelId, taskIds, taskM)
+			return nil
+		})
+	}
+}
+
+func updateMidjourneyChannelTasks(ctx context.Context, channelId int, taskIds []string, taskM map[string]*model.Midjourney) {
+	logger.LogInfo(ctx, fmt.Sprintf("渠道 #%d 未完成的任务有: %d", channelId, len(taskIds)))
+	if len(taskIds) == 0 {
+		return
+	}
+	midjourneyChannel, err := model.CacheGetChannel(channelId)
+	if err != nil {
+		logger.LogError(ctx, fmt.Sprintf("CacheGetChannel: %v", err))
+		err := model.MjBulkUpdate(taskIds, map[string]any{
+			"fail_reason": fmt.Sprintf("获取渠道信息失败，请联系管理员，渠道ID：%d", channelId),
+			"status":      "FAILURE",
+			"progress":    "100%",
+		})
+		if err != nil {
+			logger.LogInfo(ctx, fmt.Sprintf("UpdateMidjourneyTask error: %v", err))
+		} else {
+			for _, taskId := range taskIds {
+				task := taskM[taskId]
+				preStatus := task.Status
+				task.Status = "FAILURE"
+				task.Progress = "100%"
+				task.FailReason = fmt.Sprintf("获取渠道信息失败，请联系管理员，渠道ID：%d", channelId)
+				service.NotifyMidjourneyCallback(task, preStatus)
 			}
 		}
-		if len(taskChannelM) == 0 {
+		return
+	}
+	requestUrl := fmt.Sprintf("%s/mj/task/list-by-condition", *midjourneyChannel.BaseURL)
+
+	body, _ := json.Marshal(map[string]any{
+		"ids": taskIds,
+	})
+	req, err := http.NewRequest("POST", requestUrl, bytes.NewBuffer(body))
+	if err != nil {
+		logger.LogError(ctx, fmt.Sprintf("Get Task error: %v", err))
+		return
+	}
+	// 设置超时时间
+	timeout := time.Second * 15
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	// 使用带有超时的 context 创建新的请求
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("mj-api-secret", midjourneyChannel.Key)
+	resp, err := service.GetHttpClient().Do(req)
+	if err != nil {
+		logger.LogError(ctx, fmt.Sprintf("Get Task Do req error: %v", err))
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		logger.LogError(ctx, fmt.Sprintf("Get Task status code: %d", resp.StatusCode))
+		return
+	}
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		logger.LogError(ctx, fmt.Sprintf("Get Task parse body error: %v", err))
+		return
+	}
+	var responseItems []dto.MidjourneyDto
+	err = json.Unmarshal(responseBody, &responseItems)
+	if err != nil {
+		logger.LogError(ctx, fmt.Sprintf("Get Task parse body error2: %v, body: %s", err, string(responseBody)))
+		return
+	}
+	for _, responseItem := range responseItems {
+		task := taskM[responseItem.MjId]
+		if !checkMjTaskNeedUpdate(task, responseItem) {
 			continue
 		}
+		task.Code = 1
+		task.Progress = responseItem.Progress
+		task.PromptEn = responseItem.PromptEn
+		task.State = responseItem.State
+		task.SubmitTime = responseItem.SubmitTime
+		task.StartTime = responseItem.StartTime
+		task.FinishTime = responseItem.FinishTime
+		task.ImageUrl = responseItem.ImageUrl
+		preStatus := task.Status
+		task.Status = responseItem.Status
+		if task.Status != preStatus {
+			resetMidjourneyPoll(task, common.GetTimestamp())
+		}
+		task.FailReason = responseItem.FailReason
+		if responseItem.Properties != nil {
+			propertiesStr, _ := json.Marshal(responseItem.Properties)
+			task.Properties = string(propertiesStr)
+		}
+		if responseItem.Buttons != nil {
+			buttonStr, _ := json.Marshal(responseItem.Buttons)
+			task.Buttons = string(buttonStr)
+		}
+		// 映射 VideoUrl
+		task.VideoUrl = responseItem.VideoUrl
 
-		for channelId, taskIds := range taskChannelM {
-			logger.LogInfo(ctx, fmt.Sprintf("渠道 #%d 未完成的任务有: %d", channelId, len(taskIds)))
-			if len(taskIds) == 0 {
-				continue
-			}
-			midjourneyChannel, err := model.CacheGetChannel(channelId)
+		// 映射 VideoUrls - 将数组序列化为 JSON 字符串
+		if responseItem.VideoUrls != nil && len(responseItem.VideoUrls) > 0 {
+			videoUrlsStr, err := json.Marshal(responseItem.VideoUrls)
 			if err != nil {
-				logger.LogError(ctx, fmt.Sprintf("CacheGetChannel: %v", err))
-				err := model.MjBulkUpdate(taskIds, map[string]any{
-					"fail_reason": fmt.Sprintf("获取渠道信息失败，请联系管理员，渠道ID：%d", channelId),
-					"status":      "FAILURE",
-					"progress":    "100%",
-				})
-				if err != nil {
-					logger.LogInfo(ctx, fmt.Sprintf("UpdateMidjourneyTask error: %v", err))
-				}
-				continue
+				logger.LogError(ctx, fmt.Sprintf("序列化 VideoUrls 失败: %v", err))
+				task.VideoUrls = "[]" // 失败时设置为空数组
+			} else {
+				task.VideoUrls = string(videoUrlsStr)
 			}
-			requestUrl := fmt.Sprintf("%s/mj/task/list-by-condition", *midjourneyChannel.BaseURL)
+		} else {
+			task.VideoUrls = "" // 空值时清空字段
+		}
 
-			body, _ := json.Marshal(map[string]any{
-				"ids": taskIds,
-			})
-			req, err := http.NewRequest("POST", requestUrl, bytes.NewBuffer(body))
-			if err != nil {
-				logger.LogError(ctx, fmt.Sprintf("Get Task error: %v", err))
-				continue
-			}
-			// 设置超时时间
-			timeout := time.Second * 15
-			ctx, cancel := context.WithTimeout(context.Background(), timeout)
-			// 使用带有超时的 context 创建新的请求
-			req = req.WithContext(ctx)
-			req.Header.Set("Content-Type", "application/json")
-			req.Header.Set("mj-api-secret", midjourneyChannel.Key)
-			resp, err := service.GetHttpClient().Do(req)
-			if err != nil {
-				logger.LogError(ctx, fmt.Sprintf("Get Task Do req error: %v", err))
-				continue
-			}
-			if resp.StatusCode != http.StatusOK {
-				logger.LogError(ctx, fmt.Sprintf("Get Task status code: %d", resp.StatusCode))
-				continue
-			}
-			responseBody, err := io.ReadAll(resp.Body)
-			if err != nil {
-				logger.LogError(ctx, fmt.Sprintf("Get Task parse body error: %v", err))
-				continue
+		shouldReturnQuota := false
+		if (task.Progress != "100%" && responseItem.FailReason != "") || (task.Progress == "100%" && task.Status == "FAILURE") {
+			logger.LogInfo(ctx, task.MjId+" 构建失败，"+task.FailReason)
+			task.Progress = "100%"
+			if task.Quota != 0 {
+				shouldReturnQuota = true
 			}
-			var responseItems []dto.MidjourneyDto
-			err = json.Unmarshal(responseBody, &responseItems)
-			if err != nil {
-				logger.LogError(ctx, fmt.Sprintf("Get Task parse body error2: %v, body: %s", err, string(responseBody)))
-				continue
-			}
-			resp.Body.Close()
-			req.Body.Close()
-			cancel()
-
-			for _, responseItem := range responseItems {
-				task := taskM[responseItem.MjId]
-
-				useTime := (time.Now().UnixNano() / int64(time.Millisecond)) - task.SubmitTime
-				// 如果时间超过一小时，且进度不是100%，则认为任务失败
-				if useTime > 3600000 && task.Progress != "100%" {
-					responseItem.FailReason = "上游任务超时（超过1小时）"
-					responseItem.Status = "FAILURE"
-				}
-				if !checkMjTaskNeedUpdate(task, responseItem) {
-					continue
-				}
-				task.Code = 1
-				task.Progress = responseItem.Progress
-				task.PromptEn = responseItem.PromptEn
-				task.State = responseItem.State
-				task.SubmitTime = responseItem.SubmitTime
-				task.StartTime = responseItem.StartTime
-				task.FinishTime = responseItem.FinishTime
-				task.ImageUrl = responseItem.ImageUrl
-				task.Status = responseItem.Status
-				task.FailReason = responseItem.FailReason
-				if responseItem.Properties != nil {
-					propertiesStr, _ := json.Marshal(responseItem.Properties)
-					task.Properties = string(propertiesStr)
-				}
-				if responseItem.Buttons != nil {
-					buttonStr, _ := json.Marshal(responseItem.Buttons)
-					task.Buttons = string(buttonStr)
-				}
-				// 映射 VideoUrl
-				task.VideoUrl = responseItem.VideoUrl
-
-				// 映射 VideoUrls - 将数组序列化为 JSON 字符串
-				if responseItem.VideoUrls != nil && len(responseItem.VideoUrls) > 0 {
-					videoUrlsStr, err := json.Marshal(responseItem.VideoUrls)
-					if err != nil {
-						logger.LogError(ctx, fmt.Sprintf("序列化 VideoUrls 失败: %v", err))
-						task.VideoUrls = "[]" // 失败时设置为空数组
-					} else {
-						task.VideoUrls = string(videoUrlsStr)
-					}
-				} else {
-					task.VideoUrls = "" // 空值时清空字段
-				}
-
-				shouldReturnQuota := false
-				if (task.Progress != "100%" && responseItem.FailReason != "") || (task.Progress == "100%" && task.Status == "FAILURE") {
-					logger.LogInfo(ctx, task.MjId+" 构建失败，"+task.FailReason)
-					task.Progress = "100%"
-					if task.Quota != 0 {
-						shouldReturnQuota = true
-					}
-				}
-				err = task.Update()
+		}
+		err = task.Update()
+		if err != nil {
+			logger.LogError(ctx, "UpdateMidjourneyTask task error: "+err.Error())
+		} else {
+			service.AfterMidjourneyTaskUpdate(task, preStatus)
+			if shouldReturnQuota {
+				err = service.RefundTaskQuota(task.UserId, task.TokenId, task.Quota)
 				if err != nil {
-					logger.LogError(ctx, "UpdateMidjourneyTask task error: "+err.Error())
-				} else {
-					if shouldReturnQuota {
-						err = model.IncreaseUserQuota(task.UserId, task.Quota, false)
-						if err != nil {
-							logger.LogError(ctx, "fail to increase user quota: "+err.Error())
-						}
-						logContent := fmt.Sprintf("构图失败 %s，补偿 %s", task.MjId, logger.LogQuota(task.Quota))
-						model.RecordLog(task.UserId, model.LogTypeSystem, logContent)
-					}
+					logger.LogError(ctx, "fail to increase user quota: "+err.Error())
 				}
+				logContent := fmt.Sprintf("构图失败 %s，补偿 %s", task.MjId, logger.LogQuota(task.Quota))
+				model.RecordLog(task.UserId, model.LogTypeSystem, logContent)
 			}
 		}
 	}
 }
 
+// failTimedOutMidjourneyTask 任务超过最长时间仍未完成时标记失败并退还额度，数据库中的状态已被其他更新改变时不做处理
+func failTimedOutMidjourneyTask(ctx context.Context, task *model.Midjourney, maxDuration int64) {
+	preStatus := task.Status
+	task.Status = "FAILURE"
+	task.Progress = "100%"
+	task.FailReason = fmt.Sprintf("上游任务超时（超过%d分钟）", maxDuration/60)
+	if task.FinishTime == 0 {
+		task.FinishTime = time.Now().UnixMilli()
+	}
+	updated, err := task.UpdateWithStatus(preStatus)
+	if err != nil {
+		logger.LogError(ctx, fmt.Sprintf("Failed to mark midjourney task %s as timed out: %s", task.MjId, err.Error()))
+		return
+	}
+	if !updated {
+		return
+	}
+	logger.LogInfo(ctx, fmt.Sprintf("Midjourney task %s timed out after %d seconds", task.MjId, maxDuration))
+	service.AfterMidjourneyTaskUpdate(task, preStatus)
+	// 之前已是失败状态的任务不重复退还
+	if task.Quota != 0 && preStatus != "FAILURE" {
+		if err := service.RefundTaskQuota(task.UserId, task.TokenId, task.Quota); err != nil {
+			logger.LogError(ctx, "fail to increase user quota: "+err.Error())
+		}
+		logContent := fmt.Sprintf("构图失败 %s，补偿 %s", task.MjId, logger.LogQuota(task.Quota))
+		model.RecordLog(task.UserId, model.LogTypeSystem, logContent)
+	}
+}
+
 func checkMjTaskNeedUpdate(oldTask *model.Midjourney, newTask dto.MidjourneyDto) bool {
 	if oldTask.Code != 1 {
 		return true
@@ -259,11 +304,14 @@ func GetAllMidjourney(c *gin.Context) {
 	items := model.GetAllTasks(pageInfo.GetStartIdx(), pageInfo.GetPageSize(), queryParams)
 	total := model.CountAllTasks(queryParams)
 
-	if setting.MjForwardUrlEnabled {
-		for i, midjourney := range items {
-			midjourney.ImageUrl = system_setting.ServerAddress + "/mj/image/" + midjourney.MjId
-			items[i] = midjourney
+	for i, midjourney := range items {
+		if setting.MjForwardUrlEnabled {
+			midjourney.ImageUrl = service.MidjourneyImageUrl(midjourney.MjId)
+		} else {
+			midjourney.ImageUrl = service.SignMediaUrl(midjourney.ImageUrl)
 		}
+		midjourney.VideoUrl = service.SignMediaUrl(midjourney.VideoUrl)
+		items[i] = midjourney
 	}
 	pageInfo.SetTotal(int(total))
 	pageInfo.SetItems(items)
@@ -284,11 +332,14 @@ func GetUserMidjourney(c *gin.Context) {
 	items := model.GetAllUserTask(userId, pageInfo.GetStartIdx(), pageInfo.GetPageSize(), queryParams)
 	total := model.CountAllUserTask(userId, queryParams)
 
-	if setting.MjForwardUrlEnabled {
-		for i, midjourney := range items {
-			midjourney.ImageUrl = system_setting.ServerAddress + "/mj/image/" + midjourney.MjId
-			items[i] = midjourney
+	for i, midjourney := range items {
+		if setting.MjForwardUrlEnabled {
+			midjourney.ImageUrl = service.MidjourneyImageUrl(midjourney.MjId)
+		} else {
+			midjourney.ImageUrl = service.SignMediaUrl(midjourney.ImageUrl)
 		}
+		midjourney.VideoUrl = service.SignMediaUrl(midjourney.VideoUrl)
+		items[i] = midjourney
 	}
 	pageInfo.SetTotal(int(total))
 	pageInfo.SetItems(items)
@@ -7,8 +7,11 @@ import (
 	"log"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/metrics"
+	"github.com/QuantumNous/new-api/common/tracing"
 	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/dto"
 	"github.com/QuantumNous/new-api/logger"
@@ -20,12 +23,14 @@ import (
 	"github.com/QuantumNous/new-api/relay/helper"
 	"github.com/QuantumNous/new-api/service"
 	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
 	"github.com/QuantumNous/new-api/types"
 
 	"github.com/bytedance/gopkg/util/gopool"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 func relayHandler(c *gin.Context, info *relaycommon.RelayInfo) *types.NewAPIError {
@@ -61,6 +66,32 @@ func geminiRelayHandler(c *gin.Context, info *relaycommon.RelayInfo) *types.NewA
 	return err
 }
 
+func relayAttempt(c *gin.Context, relayInfo *relaycommon.RelayInfo, relayFormat types.RelayFormat) (newAPIError *types.NewAPIError) {
+	if writer := newSensitiveWriter(c, relayInfo, relayFormat); writer != nil {
+		defer func() {
+			writer.finish(newAPIError == nil)
+		}()
+	}
+	switch relayFormat {
+	case types.RelayFormatOpenAIRealtime:
+		return relay.WssHelper(c, relayInfo)
+	case types.RelayFormatClaude:
+		return relay.ClaudeHelper(c, relayInfo)
+	case types.RelayFormatGemini:
+		return geminiRelayHandler(c, relayInfo)
+	default:
+		return relayHandler(c, relayInfo)
+	}
+}
+
+// relayResponseCache 输出分发阶段命中的缓存响应，与请求上游时一样经过敏感词过滤
+func relayResponseCache(c *gin.Context, relayInfo *relaycommon.RelayInfo, relayFormat types.RelayFormat, entry *service.ResponseCacheEntry) {
+	if writer := newSensitiveWriter(c, relayInfo, relayFormat); writer != nil {
+		defer writer.finish(true)
+	}
+	relay.ServeResponseCache(c, relayInfo, entry)
+}
+
 func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 
 	requestId := c.GetString(common.RequestIdKey)
@@ -68,9 +99,18 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 	originalModel := common.GetContextKeyString(c, constant.ContextKeyOriginalModel)
 
 	var (
-		newAPIError *types.NewAPIError
-		ws          *websocket.Conn
+		newAPIError  *types.NewAPIError
+		ws           *websocket.Conn
+		streamResume *streamResumer
+	)
+
+	span := tracing.Start(c, "controller.Relay",
+		attribute.String("newapi.model", originalModel),
+		attribute.String("newapi.group", group),
 	)
+	defer func() {
+		span.End(newAPIError)
+	}()
 
 	if relayFormat == types.RelayFormatOpenAIRealtime {
 		var err error
@@ -85,6 +125,11 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 	defer func() {
 		if newAPIError != nil {
 			newAPIError.SetMessage(common.MessageWithRequestId(newAPIError.Error(), requestId))
+			if streamResume.started() {
+				// 已经向客户端输出了流式内容，错误只能在流中发送
+				streamResume.fail(c, newAPIError)
+				return
+			}
 			switch relayFormat {
 			case types.RelayFormatOpenAIRealtime:
 				helper.WssError(c, ws, newAPIError.ToOpenAIError())
@@ -132,6 +177,12 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 
 	relayInfo.SetPromptTokens(tokens)
 
+	relayLimitLease, newAPIError := service.AcquireRelayLimits(c, relayInfo, tokens)
+	if newAPIError != nil {
+		return
+	}
+	defer relayLimitLease.Release(c)
+
 	priceData, err := helper.ModelPriceHelper(c, relayInfo, tokens, meta)
 	if err != nil {
 		newAPIError = types.NewError(err, types.ErrorCodeModelPriceError)
@@ -147,6 +198,10 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 		if newAPIError != nil {
 			return
 		}
+		if preConsumedQuota := relayInfo.FinalPreConsumedQuota; preConsumedQuota > 0 {
+			metrics.AddPreConsumedQuota(preConsumedQuota)
+			defer metrics.AddPreConsumedQuota(-preConsumedQuota)
+		}
 	}
 
 	defer func() {
@@ -156,36 +211,110 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 		}
 	}()
 
-	for i := 0; i <= common.RetryTimes; i++ {
-		channel, err := getChannel(c, group, originalModel, i)
-		if err != nil {
-			logger.LogError(c, err.Error())
-			newAPIError = err
-			break
-		}
+	if entry := service.GetResponseCacheHit(c); entry != nil {
+		relayResponseCache(c, relayInfo, relayFormat, entry)
+		metrics.RecordRelayRequest(originalModel, group, 0, 0, http.StatusOK, "", time.Since(relayInfo.StartTime), 0)
+		return
+	}
 
-		addUsedChannel(c, channel.Id)
-		requestBody, _ := common.GetRequestBody(c)
-		c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+	streamResume = newStreamResumer(c, relayFormat, relayInfo)
 
-		switch relayFormat {
-		case types.RelayFormatOpenAIRealtime:
-			newAPIError = relay.WssHelper(c, relayInfo)
-		case types.RelayFormatClaude:
-			newAPIError = relay.ClaudeHelper(c, relayInfo)
-		case types.RelayFormatGemini:
-			newAPIError = geminiRelayHandler(c, relayInfo)
-		default:
-			newAPIError = relayHandler(c, relayInfo)
-		}
+	// 当前模型的渠道都失败后依次切换到备用模型，按实际使用的模型计费
+	fallbackModels := service.GetModelFallbacks(c, relayInfo.UsingGroup, originalModel)
+	for fallbackIndex := 0; ; fallbackIndex++ {
+		for i := 0; i <= common.RetryTimes; i++ {
+			var channel *model.Channel
+			var err *types.NewAPIError
+			if fallbackIndex == 0 {
+				channel, err = getChannel(c, group, originalModel, i)
+			} else {
+				// 备用模型没有分发阶段选好的渠道，第一次也需要选择
+				channel, err = selectChannel(c, group, originalModel, i)
+			}
+			if err != nil {
+				logger.LogError(c, err.Error())
+				metrics.RecordRelayRequest(originalModel, group, 0, 0, err.StatusCode, string(err.GetErrorCode()), 0, 0)
+				newAPIError = err
+				break
+			}
 
-		if newAPIError == nil {
-			return
-		}
+			addUsedChannel(c, channel.Id)
+			requestBody, _ := common.GetRequestBody(c)
+			c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+			attemptStartTime := time.Now()
+			common.SetContextKey(c, constant.ContextKeyChannelAttemptStartTime, attemptStartTime)
+			attemptSpan := tracing.Start(c, "relay.attempt", append(tracing.ChannelAttributes(channel.Id, channel.Type), attribute.Int("newapi.retry", i))...)
+
+			if i == 0 && shouldHedgeRequest(c, relayFormat, relayInfo) {
+				channel, attemptStartTime, newAPIError = relayHedged(c, relayInfo, relayFormat, group, originalModel, channel, attemptStartTime)
+			} else {
+				newAPIError = relayAttempt(c, relayInfo, relayFormat)
+			}
 
-		processChannelError(c, *types.NewChannelError(channel.Id, channel.Type, channel.Name, channel.ChannelInfo.IsMultiKey, common.GetContextKeyString(c, constant.ContextKeyChannelKey), channel.GetAutoBan()), newAPIError)
+			if newAPIError == nil && streamResume.interrupted() {
+				// 中断前的部分输出已经按实际用量结算，续写的请求重新计费
+				relayInfo.FinalPreConsumedQuota = 0
+				newAPIError = types.NewOpenAIError(fmt.Errorf("upstream stream interrupted: %s", relayInfo.StreamInterruption), types.ErrorCodeStreamInterrupted, http.StatusBadGateway)
+			}
 
-		if !shouldRetry(c, newAPIError, common.RetryTimes-i) {
+			attemptSpan.End(newAPIError)
+
+			channelError := *types.NewChannelError(channel.Id, channel.Type, channel.Name, channel.ChannelInfo.IsMultiKey, common.GetContextKeyString(c, constant.ContextKeyChannelKey), channel.GetAutoBan())
+			if newAPIError == nil {
+				var ttft time.Duration
+				if relayInfo.FirstResponseTime.After(attemptStartTime) {
+					ttft = relayInfo.FirstResponseTime.Sub(attemptStartTime)
+				}
+				metrics.RecordRelayRequest(originalModel, group, channel.Id, channel.Type, http.StatusOK, "", time.Since(attemptStartTime), ttft)
+				// 流式响应中途中断的请求已经向客户端发送了错误事件，计入渠道失败
+				model.RecordChannelHealth(channel.Id, relayInfo.StreamInterruption == "", time.Since(attemptStartTime), ttft)
+				service.RecordChannelCircuitBreakerResult(channelError, nil)
+				service.SaveStickySession(c, relayInfo.UsingGroup, channel.Id)
+				return
+			}
+
+			metrics.RecordRelayRequest(originalModel, group, channel.Id, channel.Type, newAPIError.StatusCode, string(newAPIError.GetErrorCode()), time.Since(attemptStartTime), 0)
+			processChannelError(c, channelError, newAPIError)
+
+			if fallbackIndex < len(fallbackModels) && !streamResume.started() && operation_setting.IsModelFallbackStatusCode(newAPIError.StatusCode) {
+				break
+			}
+			if !shouldRetry(c, newAPIError, common.RetryTimes-i) {
+				break
+			}
+			if streamResume.interrupted() {
+				if !streamResume.resume(c) {
+					break
+				}
+				// 续写的请求附带了已输出的内容，按新的提示重新预扣费，余额不足时不再续写
+				resumePriceData, priceErr := helper.ModelPriceHelper(c, relayInfo, relayInfo.PromptTokens, meta)
+				if priceErr != nil {
+					newAPIError = types.NewError(priceErr, types.ErrorCodeModelPriceError)
+					break
+				}
+				if !resumePriceData.FreeModel {
+					if newAPIError = service.PreConsumeQuota(c, resumePriceData.QuotaToPreConsume, relayInfo); newAPIError != nil {
+						break
+					}
+					if preConsumedQuota := relayInfo.FinalPreConsumedQuota; preConsumedQuota > 0 {
+						metrics.AddPreConsumedQuota(preConsumedQuota)
+						defer metrics.AddPreConsumedQuota(-preConsumedQuota)
+					}
+				}
+			}
+		}
+
+		// 已经开始输出的流不切换模型
+		if fallbackIndex >= len(fallbackModels) || streamResume.started() || !service.ShouldFallbackModel(c, newAPIError) {
+			break
+		}
+		fallbackModel := fallbackModels[fallbackIndex]
+		logger.LogWarn(c, fmt.Sprintf("model %s failed, falling back to model %s", originalModel, fallbackModel))
+		service.SetModelFallback(c, originalModel, fallbackModel)
+		originalModel = fallbackModel
+		relayInfo.OriginModelName = fallbackModel
+		if _, err := helper.ModelPriceHelper(c, relayInfo, tokens, meta); err != nil {
+			newAPIError = types.NewError(err, types.ErrorCodeModelPriceError)
 			break
 		}
 	}
@@ -197,6 +326,33 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 	}
 }
 
+// RelayClaudeCountTokens POST /v1/messages/count_tokens，只计算 token，不预扣费也不记录消费日志
+func RelayClaudeCountTokens(c *gin.Context) {
+	requestId := c.GetString(common.RequestIdKey)
+	var newAPIError *types.NewAPIError
+	defer func() {
+		if newAPIError != nil {
+			newAPIError.SetMessage(common.MessageWithRequestId(newAPIError.Error(), requestId))
+			c.JSON(newAPIError.StatusCode, gin.H{
+				"type":  "error",
+				"error": newAPIError.ToClaudeError(),
+			})
+		}
+	}()
+
+	request := &dto.ClaudeRequest{}
+	if err := common.UnmarshalBodyReusable(c, request); err != nil {
+		newAPIError = types.NewErrorWithStatusCode(err, types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+		return
+	}
+	if request.Model == "" {
+		newAPIError = types.NewErrorWithStatusCode(fmt.Errorf("model is required"), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+		return
+	}
+	relayInfo := relaycommon.GenRelayInfoClaude(c, request)
+	newAPIError = relay.ClaudeCountTokensHelper(c, relayInfo)
+}
+
 var upgrader = websocket.Upgrader{
 	Subprotocols: []string{"realtime"}, // WS 握手支持的协议，如果有使用 Sec-WebSocket-Protocol，则必须在此声明对应的 Protocol TODO add other protocol
 	CheckOrigin: func(r *http.Request) bool {
@@ -224,16 +380,24 @@ func getChannel(c *gin.Context, group, originalModel string, retryCount int) (*m
 			AutoBan: &autoBanInt,
 		}, nil
 	}
-	channel, selectGroup, err := model.CacheGetRandomSatisfiedChannel(c, group, originalModel, retryCount)
+	return selectChannel(c, group, originalModel, retryCount)
+}
+
+// selectChannel 按重试次数对应的优先级选择渠道，设置请求上下文并占用渠道的速率限制额度
+func selectChannel(c *gin.Context, group, originalModel string, retryCount int) (*model.Channel, *types.NewAPIError) {
+	var setupErr *types.NewAPIError
+	channel, selectGroup, err := model.CacheGetRandomSatisfiedChannel(c, group, originalModel, retryCount, func(channel *model.Channel) bool {
+		setupErr = middleware.SetupContextForSelectedChannel(c, channel, originalModel)
+		return setupErr != nil || middleware.AcquireChannelRateLimit(c, channel)
+	})
 	if err != nil {
 		return nil, types.NewError(fmt.Errorf("获取分组 %s 下模型 %s 的可用渠道失败（retry）: %s", selectGroup, originalModel, err.Error()), types.ErrorCodeGetChannelFailed, types.ErrOptionWithSkipRetry())
 	}
 	if channel == nil {
 		return nil, types.NewError(fmt.Errorf("分组 %s 下模型 %s 的可用渠道不存在（retry）", selectGroup, originalModel), types.ErrorCodeGetChannelFailed, types.ErrOptionWithSkipRetry())
 	}
-	newAPIError := middleware.SetupContextForSelectedChannel(c, channel, originalModel)
-	if newAPIError != nil {
-		return nil, newAPIError
+	if setupErr != nil {
+		return nil, setupErr
 	}
 	return channel, nil
 }
@@ -284,6 +448,17 @@ func processChannelError(c *gin.Context, channelError types.ChannelError, err *t
 	logger.LogError(c, fmt.Sprintf("relay error (channel #%d, status code: %d): %s", channelError.ChannelId, err.StatusCode, err.Error()))
 	// 不要使用context获取渠道信息，异步处理时可能会出现渠道信息不一致的情况
 	// do not use context to get channel info, there may be inconsistent channel info when processing asynchronously
+	if service.IsChannelHealthFailure(err) {
+		var latency time.Duration
+		if attemptStartTime := common.GetContextKeyTime(c, constant.ContextKeyChannelAttemptStartTime); !attemptStartTime.IsZero() {
+			latency = time.Since(attemptStartTime)
+		}
+		model.RecordChannelHealth(channelError.ChannelId, false, latency, 0)
+		if channelError.IsMultiKey {
+			model.RecordChannelKeyError(channelError.ChannelId, common.GetContextKeyInt(c, constant.ContextKeyChannelMultiKeyIndex), channelError.UsingKey, err.MaskSensitiveError())
+		}
+	}
+	service.RecordChannelCircuitBreakerResult(channelError, err)
 	if service.ShouldDisableChannel(channelError.ChannelId, err) && channelError.AutoBan {
 		gopool.Go(func() {
 			service.DisableChannel(channelError, err.Error())
@@ -0,0 +1,291 @@
+package controller
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/metrics"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/middleware"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errHedgeAttemptLost = errors.New("hedged attempt lost")
+
+// hedgeWriter 对冲请求中每次尝试使用的 ResponseWriter，胜出前响应头和状态码只保存在本地，
+// 第一次写出响应体时参与竞争，胜出后直接写到客户端，落败的尝试写出的内容全部丢弃
+type hedgeWriter struct {
+	gin.ResponseWriter
+	attempt *service.HedgeAttempt
+	header  http.Header
+	status  int
+	won     bool
+}
+
+func newHedgeWriter(w gin.ResponseWriter, attempt *service.HedgeAttempt) *hedgeWriter {
+	return &hedgeWriter{
+		ResponseWriter: w,
+		attempt:        attempt,
+		header:         w.Header().Clone(),
+		status:         http.StatusOK,
+	}
+}
+
+// claim 参与竞争，胜出后把保存的响应头和状态码写到客户端
+func (w *hedgeWriter) claim() bool {
+	if w.won {
+		return true
+	}
+	if !w.attempt.Claim() {
+		return false
+	}
+	w.won = true
+	header := w.ResponseWriter.Header()
+	for key := range header {
+		delete(header, key)
+	}
+	for key, values := range w.header {
+		header[key] = values
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	return true
+}
+
+func (w *hedgeWriter) Header() http.Header {
+	if w.won {
+		return w.ResponseWriter.Header()
+	}
+	return w.header
+}
+
+func (w *hedgeWriter) WriteHeader(code int) {
+	if w.won {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.status = code
+}
+
+func (w *hedgeWriter) WriteHeaderNow() {
+	if w.won {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *hedgeWriter) Write(data []byte) (int, error) {
+	if !w.claim() {
+		return 0, errHedgeAttemptLost
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *hedgeWriter) WriteString(s string) (int, error) {
+	if !w.claim() {
+		return 0, errHedgeAttemptLost
+	}
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *hedgeWriter) Status() int {
+	if w.won {
+		return w.ResponseWriter.Status()
+	}
+	return w.status
+}
+
+func (w *hedgeWriter) Size() int {
+	if w.won {
+		return w.ResponseWriter.Size()
+	}
+	return -1
+}
+
+func (w *hedgeWriter) Written() bool {
+	if w.won {
+		return w.ResponseWriter.Written()
+	}
+	return false
+}
+
+func (w *hedgeWriter) Flush() {
+	if w.won {
+		w.ResponseWriter.Flush()
+	}
+}
+
+type hedgeAttemptResult struct {
+	ctx       *gin.Context
+	info      *relaycommon.RelayInfo
+	channel   *model.Channel
+	startTime time.Time
+	err       *types.NewAPIError
+	won       bool
+	lost      bool
+}
+
+// shouldHedgeRequest 只对文本生成类请求发起对冲，图片、音频、向量等请求成本高或没有首字延迟的概念
+func shouldHedgeRequest(c *gin.Context, relayFormat types.RelayFormat, relayInfo *relaycommon.RelayInfo) bool {
+	if !operation_setting.IsHedgeRequestEnabled(relayInfo.OriginModelName) {
+		return false
+	}
+	if _, ok := c.Get("specific_channel_id"); ok {
+		return false
+	}
+	switch relayFormat {
+	case types.RelayFormatOpenAIRealtime:
+		return false
+	case types.RelayFormatClaude:
+		return true
+	case types.RelayFormatGemini:
+		return !strings.Contains(c.Request.URL.Path, "embed")
+	}
+	switch relayInfo.RelayMode {
+	case relayconstant.RelayModeImagesGenerations, relayconstant.RelayModeImagesEdits,
+		relayconstant.RelayModeAudioSpeech, relayconstant.RelayModeAudioTranslation, relayconstant.RelayModeAudioTranscription,
+		relayconstant.RelayModeRerank, relayconstant.RelayModeEmbeddings:
+		return false
+	}
+	return true
+}
+
+// newHedgeContext 为一次尝试复制请求上下文，各尝试使用独立的 Keys、请求体和 ResponseWriter
+func newHedgeContext(c *gin.Context, attempt *service.HedgeAttempt) (*gin.Context, *hedgeWriter) {
+	ctx := c.Copy()
+	writer := newHedgeWriter(c.Writer, attempt)
+	ctx.Writer = writer
+	requestBody, _ := common.GetRequestBody(c)
+	ctx.Request = c.Request.Clone(c.Request.Context())
+	ctx.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+	common.SetContextKey(ctx, constant.ContextKeyHedgeAttempt, attempt)
+	return ctx, writer
+}
+
+func runHedgeAttempt(ctx *gin.Context, writer *hedgeWriter, info *relaycommon.RelayInfo, relayFormat types.RelayFormat,
+	channel *model.Channel, startTime time.Time, results chan<- *hedgeAttemptResult) {
+	attempt := writer.attempt
+	// 自定义 Ping 会在收到上游数据前写出响应体，导致提前决出胜者
+	info.DisablePing = true
+	go func() {
+		defer attempt.Cancel()
+		result := &hedgeAttemptResult{ctx: ctx, info: info, channel: channel, startTime: startTime}
+		result.err = relayAttempt(ctx, info, relayFormat)
+		if result.err == nil {
+			// 没有写出响应体也没有计费的成功请求在结束时参与竞争
+			result.won = writer.claim()
+		} else {
+			result.won = attempt.Won()
+		}
+		result.lost = !result.won && attempt.Lost()
+		if result.lost {
+			// 被取消的尝试不计入渠道健康状态，只释放可能占用的熔断试探
+			model.ReleaseChannelCircuitBreakerTrial(channel.Id, common.GetContextKeyString(ctx, constant.ContextKeyChannelKey))
+		}
+		middleware.ReleaseChannelRateLimit(ctx)
+		results <- result
+	}()
+}
+
+// relayHedged 首次请求在设定时间内没有写出首个字节时，向同一优先级的另一个渠道再发起一次请求，
+// 先写出数据的一方胜出，另一方被取消。胜出（或最后失败）的尝试的上下文会合并回 c 和 relayInfo，
+// 由调用方按普通请求的方式记录结果、计费和重试。各尝试的上下文派生自客户端请求，客户端断开时一起取消
+func relayHedged(c *gin.Context, relayInfo *relaycommon.RelayInfo, relayFormat types.RelayFormat, group string, originalModel string,
+	channel *model.Channel, startTime time.Time) (*model.Channel, time.Time, *types.NewAPIError) {
+	race := service.NewHedgeRace()
+	results := make(chan *hedgeAttemptResult, 2)
+
+	primary := race.NewAttempt(c.Request.Context())
+	primaryCtx, primaryWriter := newHedgeContext(c, primary)
+	runHedgeAttempt(primaryCtx, primaryWriter, relayInfo.Clone(), relayFormat, channel, startTime, results)
+
+	launchHedge := func() bool {
+		selectGroup := group
+		if group == "auto" {
+			selectGroup = c.GetString("auto_group")
+		}
+		attempt := race.NewAttempt(c.Request.Context())
+		if attempt == nil {
+			return false
+		}
+		hedgeCtx, hedgeWriter := newHedgeContext(c, attempt)
+		// 首次请求的占用由首次请求自己释放
+		delete(hedgeCtx.Keys, string(constant.ContextKeyChannelRateLimitLease))
+		var setupErr *types.NewAPIError
+		hedgeChannel := model.CacheGetHedgeChannel(selectGroup, originalModel, channel.Id, func(hedgeChannel *model.Channel) bool {
+			setupErr = middleware.SetupContextForSelectedChannel(hedgeCtx, hedgeChannel, originalModel)
+			return setupErr != nil || middleware.AcquireChannelRateLimit(hedgeCtx, hedgeChannel)
+		})
+		if hedgeChannel == nil {
+			attempt.Cancel()
+			return false
+		}
+		if setupErr != nil {
+			attempt.Cancel()
+			logger.LogError(c, fmt.Sprintf("hedge request setup failed (channel #%d): %s", hedgeChannel.Id, setupErr.Error()))
+			return false
+		}
+		hedgeStartTime := time.Now()
+		common.SetContextKey(hedgeCtx, constant.ContextKeyChannelAttemptStartTime, hedgeStartTime)
+
+		addUsedChannel(c, hedgeChannel.Id)
+		useChannel := c.GetStringSlice("use_channel")
+		hedgeCtx.Set("use_channel", useChannel)
+		primaryCtx.Set("use_channel", useChannel)
+		logger.LogInfo(c, fmt.Sprintf("no first byte from channel #%d in %dms, hedging to channel #%d", channel.Id, time.Since(startTime).Milliseconds(), hedgeChannel.Id))
+		runHedgeAttempt(hedgeCtx, hedgeWriter, relayInfo.Clone(), relayFormat, hedgeChannel, hedgeStartTime, results)
+		return true
+	}
+
+	timer := time.NewTimer(time.Duration(operation_setting.GetHedgeRequestSetting().DelayMs) * time.Millisecond)
+	defer timer.Stop()
+	timerC := timer.C
+	claimed := race.Claimed()
+	pending := 1
+	var result *hedgeAttemptResult
+wait:
+	for pending > 0 {
+		select {
+		case <-timerC:
+			timerC = nil
+			if launchHedge() {
+				pending++
+			}
+		case <-claimed:
+			// 已经写出首个字节，不再需要对冲
+			claimed = nil
+			timerC = nil
+		case r := <-results:
+			pending--
+			if r.lost {
+				continue
+			}
+			result = r
+			if r.won || pending == 0 {
+				break wait
+			}
+			// 另一次尝试仍在进行，先记录这次失败
+			channelError := *types.NewChannelError(r.channel.Id, r.channel.Type, r.channel.Name, common.GetContextKeyBool(r.ctx, constant.ContextKeyChannelIsMultiKey), common.GetContextKeyString(r.ctx, constant.ContextKeyChannelKey), r.channel.GetAutoBan())
+			metrics.RecordRelayRequest(originalModel, group, r.channel.Id, r.channel.Type, r.err.StatusCode, string(r.err.GetErrorCode()), time.Since(r.startTime), 0)
+			processChannelError(r.ctx, channelError, r.err)
+		}
+	}
+
+	for key, value := range result.ctx.Keys {
+		c.Set(key, value)
+	}
+	*relayInfo = *result.info
+	return result.channel, result.startTime, result.err
+}
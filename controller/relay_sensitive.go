@@ -0,0 +1,398 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// sensitiveWriter 检查渠道输出中的敏感词，按分组配置替换、停止生成或只记录日志。
+// 流式响应逐个事件处理，文本末尾可能与后续输出组成敏感词的部分暂不发出；非流式响应在请求结束后统一处理
+type sensitiveWriter struct {
+	gin.ResponseWriter
+	c       *gin.Context
+	format  types.RelayFormat
+	policy  string
+	request *http.Request
+	cancel  context.CancelFunc
+
+	mu        sync.Mutex
+	streaming bool
+	decided   bool
+	// 流式时保存未处理完的行，非流式时保存完整响应体
+	buffer bytes.Buffer
+	// 当前事件已读取的行
+	lines  []string
+	filter *service.SensitiveStreamFilter
+	// 最近一个文本事件，保留的文本以它为模板发出
+	lastEvent string
+	lastData  string
+	words     []string
+	stopped   bool
+}
+
+// newSensitiveWriter 未开启输出敏感词检查或请求没有文本输出时返回 nil
+func newSensitiveWriter(c *gin.Context, relayInfo *relaycommon.RelayInfo, relayFormat types.RelayFormat) *sensitiveWriter {
+	if !setting.ShouldCheckCompletionSensitive() || len(setting.SensitiveWords) == 0 {
+		return nil
+	}
+	switch relayFormat {
+	case types.RelayFormatOpenAI:
+		if relayInfo.RelayMode != relayconstant.RelayModeChatCompletions && relayInfo.RelayMode != relayconstant.RelayModeCompletions {
+			return nil
+		}
+	case types.RelayFormatClaude:
+	case types.RelayFormatGemini:
+		if strings.Contains(c.Request.URL.Path, "embed") {
+			return nil
+		}
+	default:
+		return nil
+	}
+	// 停止生成时取消请求的 context，流式处理随之结束并关闭上游连接
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	w := &sensitiveWriter{
+		ResponseWriter: c.Writer,
+		c:              c,
+		format:         relayFormat,
+		policy:         setting.GetCompletionSensitivePolicy(relayInfo.UsingGroup),
+		request:        c.Request,
+		cancel:         cancel,
+		filter:         service.NewSensitiveStreamFilter(),
+	}
+	c.Writer = w
+	c.Request = c.Request.WithContext(ctx)
+	return w
+}
+
+func (w *sensitiveWriter) decide() {
+	if w.decided {
+		return
+	}
+	w.decided = true
+	w.streaming = strings.HasPrefix(w.ResponseWriter.Header().Get("Content-Type"), "text/event-stream")
+}
+
+func (w *sensitiveWriter) Write(data []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.decide()
+	w.buffer.Write(data)
+	if !w.streaming {
+		return len(data), nil
+	}
+	for {
+		line, err := w.buffer.ReadString('\n')
+		if err != nil {
+			// 不完整的行放回缓冲区等待后续数据
+			rest := line
+			w.buffer.Reset()
+			w.buffer.WriteString(rest)
+			break
+		}
+		w.handleStreamLine(strings.TrimRight(line, "\r\n"))
+	}
+	return len(data), nil
+}
+
+func (w *sensitiveWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *sensitiveWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.decide()
+	// 非流式响应在 finish 中统一写出
+	if w.streaming && !w.stopped {
+		w.ResponseWriter.Flush()
+	}
+}
+
+// handleStreamLine 按空行切分事件，事件读取完整后处理
+func (w *sensitiveWriter) handleStreamLine(line string) {
+	if line != "" {
+		w.lines = append(w.lines, line)
+		return
+	}
+	if len(w.lines) == 0 {
+		return
+	}
+	lines := w.lines
+	w.lines = nil
+	if w.stopped {
+		return
+	}
+	var event, data string
+	hasData := false
+	for _, l := range lines {
+		if value, ok := strings.CutPrefix(l, "event:"); ok {
+			event = strings.TrimSpace(value)
+		} else if value, ok := strings.CutPrefix(l, "data:"); ok {
+			data = strings.TrimSpace(value)
+			hasData = true
+		}
+	}
+	if !hasData || !gjson.Valid(data) {
+		// ping 等注释行和 [DONE] 先发出保留的文本再原样透传
+		if hasData {
+			w.flushPending()
+		}
+		if !w.stopped {
+			w.writeEvent(lines)
+		}
+		return
+	}
+	paths, final := w.streamTextPaths(data)
+	if len(paths) == 0 {
+		w.flushPending()
+		if !w.stopped {
+			w.writeEvent(lines)
+		}
+		return
+	}
+
+	var words []string
+	for i, path := range paths {
+		text, found := w.filter.Push(gjson.Get(data, path).String())
+		if final && i == len(paths)-1 {
+			rest, restFound := w.filter.Flush()
+			text += rest
+			found = append(found, restFound...)
+		}
+		words = append(words, found...)
+		if w.policy != setting.SensitivePolicyLog {
+			data, _ = sjson.Set(data, path, text)
+		}
+	}
+	w.lastEvent, w.lastData = event, data
+	if w.detected(words) && w.policy == setting.SensitivePolicyStop {
+		w.stop()
+		return
+	}
+	w.writeData(event, data)
+}
+
+// streamTextPaths 返回流式事件中文本内容的路径，以及事件是否带有结束原因（此时保留的文本需要一并发出）
+func (w *sensitiveWriter) streamTextPaths(data string) ([]string, bool) {
+	switch w.format {
+	case types.RelayFormatClaude:
+		if gjson.Get(data, "type").String() == "content_block_delta" && gjson.Get(data, "delta.type").String() == "text_delta" {
+			return []string{"delta.text"}, false
+		}
+		return nil, false
+	case types.RelayFormatGemini:
+		var paths []string
+		gjson.Get(data, "candidates.0.content.parts").ForEach(func(key, part gjson.Result) bool {
+			if part.Get("text").Exists() && !part.Get("thought").Bool() {
+				paths = append(paths, "candidates.0.content.parts."+key.String()+".text")
+			}
+			return true
+		})
+		return paths, gjson.Get(data, "candidates.0.finishReason").String() != ""
+	default:
+		final := gjson.Get(data, "choices.0.finish_reason").String() != ""
+		if gjson.Get(data, "choices.0.delta.content").Type == gjson.String {
+			return []string{"choices.0.delta.content"}, final
+		}
+		if gjson.Get(data, "choices.0.text").Type == gjson.String {
+			return []string{"choices.0.text"}, final
+		}
+		return nil, false
+	}
+}
+
+// flushPending 非文本事件（结束原因、用量、[DONE] 等）之前发出保留的文本
+func (w *sensitiveWriter) flushPending() {
+	if !w.filter.Pending() || w.lastData == "" {
+		return
+	}
+	text, words := w.filter.Flush()
+	if w.detected(words) && w.policy == setting.SensitivePolicyStop {
+		w.stop()
+		return
+	}
+	if w.policy == setting.SensitivePolicyLog {
+		// 只记录日志时文本已经原样发出
+		return
+	}
+	data := w.lastData
+	switch w.format {
+	case types.RelayFormatClaude:
+		data, _ = sjson.Set(data, "delta.text", text)
+	case types.RelayFormatGemini:
+		data, _ = sjson.Set(data, "candidates.0.content.parts", []gin.H{{"text": text}})
+		data, _ = sjson.Delete(data, "candidates.0.finishReason")
+	default:
+		path := "choices.0.delta.content"
+		if !gjson.Get(data, path).Exists() {
+			path = "choices.0.text"
+		}
+		data, _ = sjson.Set(data, path, text)
+		data, _ = sjson.Delete(data, "choices.0.finish_reason")
+		data, _ = sjson.Delete(data, "usage")
+	}
+	w.writeData(w.lastEvent, data)
+}
+
+func (w *sensitiveWriter) detected(words []string) bool {
+	if len(words) == 0 {
+		return false
+	}
+	w.words = append(w.words, words...)
+	return true
+}
+
+// stop 检测到敏感词时按客户端请求的格式结束输出，之后渠道写出的内容全部丢弃
+func (w *sensitiveWriter) stop() {
+	w.stopped = true
+	data := w.lastData
+	switch w.format {
+	case types.RelayFormatClaude:
+		index := gjson.Get(data, "index").Int()
+		w.writeData("content_block_stop", fmt.Sprintf(`{"type":"content_block_stop","index":%d}`, index))
+		w.writeData("message_delta", `{"type":"message_delta","delta":{"stop_reason":"refusal","stop_sequence":null},"usage":{"output_tokens":0}}`)
+		w.writeData("message_stop", `{"type":"message_stop"}`)
+	case types.RelayFormatGemini:
+		data, _ = sjson.Set(data, "candidates.0.content.parts", []any{})
+		data, _ = sjson.Set(data, "candidates.0.finishReason", "SAFETY")
+		w.writeData("", data)
+	default:
+		if gjson.Get(data, "choices.0.delta").Exists() {
+			data, _ = sjson.Set(data, "choices.0.delta", gin.H{})
+		} else {
+			data, _ = sjson.Set(data, "choices.0.text", "")
+		}
+		data, _ = sjson.Set(data, "choices.0.finish_reason", "content_filter")
+		data, _ = sjson.Delete(data, "usage")
+		w.writeData("", data)
+		w.writeData("", "[DONE]")
+	}
+	w.cancel()
+}
+
+func (w *sensitiveWriter) writeData(event string, data string) {
+	if event != "" {
+		_, _ = w.ResponseWriter.WriteString("event: " + event + "\n")
+	}
+	_, _ = w.ResponseWriter.WriteString("data: " + data + "\n\n")
+	w.ResponseWriter.Flush()
+}
+
+func (w *sensitiveWriter) writeEvent(lines []string) {
+	_, _ = w.ResponseWriter.WriteString(strings.Join(lines, "\n") + "\n\n")
+	w.ResponseWriter.Flush()
+}
+
+// finish 请求结束后恢复 ResponseWriter，流式响应发出剩余的文本，成功的非流式响应检查后写出
+func (w *sensitiveWriter) finish(success bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.c.Writer = w.ResponseWriter
+	w.c.Request = w.request
+	defer w.cancel()
+	w.decide()
+	if w.streaming {
+		if w.buffer.Len() > 0 {
+			w.handleStreamLine(strings.TrimRight(w.buffer.String(), "\r\n"))
+		}
+		w.handleStreamLine("")
+		if !w.stopped {
+			w.flushPending()
+		}
+	} else if success && w.buffer.Len() > 0 {
+		body := w.buffer.Bytes()
+		if gjson.ValidBytes(body) {
+			body = w.filterBody(body)
+		}
+		w.ResponseWriter.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		_, _ = w.ResponseWriter.Write(body)
+	}
+	if len(w.words) > 0 {
+		logger.LogWarn(w.c, fmt.Sprintf("completion sensitive words detected (policy: %s): %s", w.policy, strings.Join(w.words, ", ")))
+	}
+}
+
+// filterBody 检查非流式响应中的文本，停止生成时清空文本并把结束原因改为内容过滤
+func (w *sensitiveWriter) filterBody(body []byte) []byte {
+	type textPath struct {
+		path   string
+		finish string
+	}
+	var paths []textPath
+	response := gjson.ParseBytes(body)
+	switch w.format {
+	case types.RelayFormatClaude:
+		response.Get("content").ForEach(func(key, block gjson.Result) bool {
+			if block.Get("type").String() == "text" {
+				paths = append(paths, textPath{path: "content." + key.String() + ".text", finish: "stop_reason"})
+			}
+			return true
+		})
+	case types.RelayFormatGemini:
+		response.Get("candidates").ForEach(func(i, candidate gjson.Result) bool {
+			candidate.Get("content.parts").ForEach(func(j, part gjson.Result) bool {
+				if part.Get("text").Exists() && !part.Get("thought").Bool() {
+					paths = append(paths, textPath{
+						path:   "candidates." + i.String() + ".content.parts." + j.String() + ".text",
+						finish: "candidates." + i.String() + ".finishReason",
+					})
+				}
+				return true
+			})
+			return true
+		})
+	default:
+		response.Get("choices").ForEach(func(i, choice gjson.Result) bool {
+			path := "choices." + i.String() + ".message.content"
+			if choice.Get("message.content").Type != gjson.String {
+				path = "choices." + i.String() + ".text"
+			}
+			paths = append(paths, textPath{path: path, finish: "choices." + i.String() + ".finish_reason"})
+			return true
+		})
+	}
+
+	result := body
+	for _, p := range paths {
+		contains, words, text := service.SensitiveWordReplace(gjson.GetBytes(body, p.path).String(), false)
+		if !contains {
+			continue
+		}
+		w.words = append(w.words, words...)
+		switch w.policy {
+		case setting.SensitivePolicyReplace:
+			result, _ = sjson.SetBytes(result, p.path, text)
+		case setting.SensitivePolicyStop:
+			result, _ = sjson.SetBytes(result, p.path, "")
+			result, _ = sjson.SetBytes(result, p.finish, w.stopReason())
+		}
+	}
+	return result
+}
+
+func (w *sensitiveWriter) stopReason() string {
+	switch w.format {
+	case types.RelayFormatClaude:
+		return "refusal"
+	case types.RelayFormatGemini:
+		return "SAFETY"
+	default:
+		return "content_filter"
+	}
+}
@@ -0,0 +1,195 @@
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"slices"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/relay/helper"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// streamResumeWriter 记录已经发给客户端的流式内容，上游中断等待续写期间丢弃当前尝试后续写出的内容，
+// 避免客户端在续写前收到结束标志或部分用量
+type streamResumeWriter struct {
+	gin.ResponseWriter
+	state    *relaycommon.StreamResumeState
+	body     bytes.Buffer
+	maxSize  int
+	written  bool
+	overflow bool // 已输出的内容超过 maxSize，不再保留，也不能续写
+}
+
+// keep 判断是否继续保留即将写出的内容，超过上限时释放已保留的内容
+func (w *streamResumeWriter) keep(size int) bool {
+	w.written = true
+	if !w.overflow && w.body.Len()+size > w.maxSize {
+		w.overflow = true
+		w.body = bytes.Buffer{}
+	}
+	return !w.overflow
+}
+
+func (w *streamResumeWriter) Write(data []byte) (int, error) {
+	if w.state.Pending() {
+		return len(data), nil
+	}
+	if w.keep(len(data)) {
+		w.body.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *streamResumeWriter) WriteString(s string) (int, error) {
+	if w.state.Pending() {
+		return len(s), nil
+	}
+	if w.keep(len(s)) {
+		w.body.WriteString(s)
+	}
+	return w.ResponseWriter.WriteString(s)
+}
+
+// streamResumer 流式响应中断后，把已输出的内容作为 assistant 消息追加到请求中，在其他渠道上继续输出。
+// 只支持 OpenAI Chat Completions 格式，nil 上调用各方法无效
+type streamResumer struct {
+	writer       *streamResumeWriter
+	info         *relaycommon.RelayInfo
+	resumes      int
+	messages     []dto.Message
+	body         []byte
+	promptTokens int
+}
+
+func newStreamResumer(c *gin.Context, relayFormat types.RelayFormat, relayInfo *relaycommon.RelayInfo) *streamResumer {
+	if !operation_setting.IsStreamResumeEnabled() || relayFormat != types.RelayFormatOpenAI ||
+		relayInfo.RelayMode != relayconstant.RelayModeChatCompletions || !relayInfo.IsStream {
+		return nil
+	}
+	request, ok := relayInfo.Request.(*dto.GeneralOpenAIRequest)
+	if !ok || request.N > 1 {
+		return nil
+	}
+	if _, ok := c.Get("specific_channel_id"); ok {
+		return nil
+	}
+	state := &relaycommon.StreamResumeState{}
+	relayInfo.StreamResume = state
+	writer := &streamResumeWriter{ResponseWriter: c.Writer, state: state, maxSize: operation_setting.GetStreamFailoverSetting().MaxBufferKB << 10}
+	c.Writer = writer
+	return &streamResumer{writer: writer, info: relayInfo}
+}
+
+// interrupted 上游流式响应中断，等待续写
+func (r *streamResumer) interrupted() bool {
+	return r != nil && r.writer.state.Pending()
+}
+
+// started 已经向客户端输出了流式内容，之后的错误只能在流中发送
+func (r *streamResumer) started() bool {
+	return r != nil && r.writer.written
+}
+
+// resume 准备续写的请求，返回 false 表示不能续写
+func (r *streamResumer) resume(c *gin.Context) bool {
+	if !r.interrupted() || r.writer.overflow || r.resumes >= operation_setting.GetStreamFailoverSetting().MaxResumes {
+		return false
+	}
+	partial, ok := parseStreamContent(r.writer.body.Bytes())
+	if !ok {
+		return false
+	}
+	request, ok := r.info.Request.(*dto.GeneralOpenAIRequest)
+	if !ok {
+		return false
+	}
+	if r.resumes == 0 {
+		body, err := common.GetRequestBody(c)
+		if err != nil {
+			return false
+		}
+		r.messages = request.Messages
+		r.body = body
+		r.promptTokens = r.info.PromptTokens
+	}
+	if partial != "" {
+		body, err := appendAssistantMessage(r.body, partial)
+		if err != nil {
+			return false
+		}
+		// 透传请求体时使用缓存的请求体，其他情况由 info.Request 转换
+		c.Set(common.KeyRequestBody, body)
+		request.Messages = append(slices.Clone(r.messages), dto.Message{Role: "assistant", Content: partial})
+		r.info.SetPromptTokens(r.promptTokens + service.CountTextToken(partial, r.info.OriginModelName))
+	}
+	r.resumes++
+	r.info.StreamInterruption = ""
+	r.writer.state.Reset()
+	return true
+}
+
+// fail 放弃续写，在流中发送错误事件并结束
+func (r *streamResumer) fail(c *gin.Context, newAPIError *types.NewAPIError) {
+	r.writer.state.Reset()
+	helper.StreamErrorData(c, r.info, newAPIError)
+	helper.Done(c)
+}
+
+// parseStreamContent 从已输出的 OpenAI 流式数据中拼接文本内容，已经输出了工具调用时无法续写
+func parseStreamContent(body []byte) (string, bool) {
+	var content strings.Builder
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, helper.InitialScannerBufferSize), helper.MaxScannerBufferSize)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "" || data == "[DONE]" {
+			continue
+		}
+		var streamResponse dto.ChatCompletionsStreamResponse
+		if err := common.UnmarshalJsonStr(data, &streamResponse); err != nil {
+			continue
+		}
+		for _, choice := range streamResponse.Choices {
+			if len(choice.Delta.ToolCalls) > 0 {
+				return "", false
+			}
+			content.WriteString(choice.Delta.GetContentString())
+		}
+	}
+	return content.String(), scanner.Err() == nil
+}
+
+// appendAssistantMessage 在原始请求体的 messages 末尾追加 assistant 消息，其余字段保持原样
+func appendAssistantMessage(body []byte, content string) ([]byte, error) {
+	var request map[string]json.RawMessage
+	if err := common.Unmarshal(body, &request); err != nil {
+		return nil, err
+	}
+	var messages []json.RawMessage
+	if err := common.Unmarshal(request["messages"], &messages); err != nil {
+		return nil, err
+	}
+	message, err := common.Marshal(dto.Message{Role: "assistant", Content: content})
+	if err != nil {
+		return nil, err
+	}
+	messages = append(messages, message)
+	if request["messages"], err = common.Marshal(messages); err != nil {
+		return nil, err
+	}
+	return common.Marshal(request)
+}
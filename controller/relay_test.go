@@ -0,0 +1,414 @@
+package controller
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/middleware"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/setting/ratio_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+func setupRelayTestDB(t *testing.T) {
+	t.Helper()
+	t.Setenv("SQL_DSN", "")
+	t.Setenv("LOG_SQL_DSN", "")
+	common.SQLitePath = "file:relay_test?mode=memory&cache=shared"
+	common.RedisEnabled = false
+	common.MemoryCacheEnabled = false
+	common.IsMasterNode = true
+	if err := model.InitDB(); err != nil {
+		t.Fatal(err)
+	}
+	if err := model.InitLogDB(); err != nil {
+		t.Fatal(err)
+	}
+	service.InitHttpClient()
+	gin.SetMode(gin.TestMode)
+}
+
+// createRelayTestChannel 创建转发到上游测试服务器的渠道，测试结束后删除，避免其他测试选到该渠道
+func createRelayTestChannel(t *testing.T, id int, modelName string, upstreamURL string, setting string) *model.Channel {
+	t.Helper()
+	deleteChannel := func() {
+		model.DB.Where("channel_id = ?", id).Delete(&model.Ability{})
+		model.DB.Unscoped().Delete(&model.Channel{}, id)
+	}
+	deleteChannel()
+	t.Cleanup(deleteChannel)
+	autoBan := 0
+	channel := &model.Channel{
+		Id:      id,
+		Type:    1,
+		Key:     "sk-test",
+		Name:    "relay-test",
+		Status:  common.ChannelStatusEnabled,
+		Group:   "default",
+		Models:  modelName,
+		BaseURL: &upstreamURL,
+		AutoBan: &autoBan,
+	}
+	if setting != "" {
+		channel.Setting = &setting
+	}
+	if err := model.DB.Create(channel).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := channel.AddAbilities(nil); err != nil {
+		t.Fatal(err)
+	}
+	return channel
+}
+
+// createRelayTestToken 每次创建新的用户和令牌，避免用户级别的限流计数影响重复运行
+func createRelayTestToken(t *testing.T) *model.Token {
+	t.Helper()
+	user := &model.User{Username: "relay_" + common.GetRandomString(8), Status: common.UserStatusEnabled, Group: "default", Quota: 1000000, AffCode: common.GetRandomString(8)}
+	if err := model.DB.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	token := &model.Token{UserId: user.Id, Key: common.GetRandomString(48), Status: common.TokenStatusEnabled, ExpiredTime: -1, UnlimitedQuota: true}
+	if err := model.DB.Create(token).Error; err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// relayTestRequest 按中间件链的顺序分发并转发请求，要求请求成功
+func relayTestRequest(t *testing.T, tokenKey string, req *http.Request) (*httptest.ResponseRecorder, *gin.Context) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	req.Header.Set("Content-Type", "application/json")
+	c.Request = req
+	c.Set(common.RequestIdKey, "relay-test")
+	if err := setupBatchTokenContext(c, tokenKey); err != nil {
+		t.Fatal(err)
+	}
+	if middleware.DistributeChannel(c) {
+		Relay(c, types.RelayFormatOpenAI)
+		middleware.ReleaseChannelRateLimit(c)
+	}
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	return w, c
+}
+
+func newChatTestRequest(modelName string, stream bool) *http.Request {
+	body := `{"model":"` + modelName + `","messages":[{"role":"user","content":"hi"}]}`
+	if stream {
+		body = `{"model":"` + modelName + `","stream":true,"messages":[{"role":"user","content":"hi"}]}`
+	}
+	return httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+}
+
+func chatTestResponse(modelName string, content string) string {
+	return `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"` + modelName + `","choices":[{"index":0,"message":{"role":"assistant","content":"` + content + `"},"finish_reason":"stop"}],"usage":{"prompt_tokens":3,"completion_tokens":1,"total_tokens":4}}`
+}
+
+func countConsumeLogs(t *testing.T, userId int) int64 {
+	t.Helper()
+	var count int64
+	if err := model.LOG_DB.Model(&model.Log{}).Where("user_id = ? and type = ?", userId, model.LogTypeConsume).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestRelayHedgedRequest(t *testing.T) {
+	setupRelayTestDB(t)
+	hedgeSetting := operation_setting.GetHedgeRequestSetting()
+	oldHedgeSetting := *hedgeSetting
+	operation_setting.SelfUseModeEnabled = true
+	t.Cleanup(func() {
+		*hedgeSetting = oldHedgeSetting
+		operation_setting.SelfUseModeEnabled = false
+	})
+	hedgeSetting.Enabled = true
+	hedgeSetting.Models = []string{}
+	hedgeSetting.DelayMs = 50
+
+	// 先到的请求一直不返回，直到被取消；对冲请求立即返回
+	var calls atomic.Int64
+	slowCancelled := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 读完请求体后服务端才能发现连接被关闭
+		_, _ = io.Copy(io.Discard, r.Body)
+		if calls.Add(1) == 1 {
+			select {
+			case <-r.Context().Done():
+				close(slowCancelled)
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(chatTestResponse("gpt-hedge-test", "fast")))
+	}))
+	defer upstream.Close()
+	createRelayTestChannel(t, 11, "gpt-hedge-test", upstream.URL, "")
+	createRelayTestChannel(t, 12, "gpt-hedge-test", upstream.URL, "")
+	token := createRelayTestToken(t)
+
+	start := time.Now()
+	w, _ := relayTestRequest(t, token.Key, newChatTestRequest("gpt-hedge-test", false))
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("hedged request took %s, want the fast attempt to win", elapsed)
+	}
+	if !strings.Contains(w.Body.String(), `"content":"fast"`) {
+		t.Fatalf("body = %s, want the hedged response", w.Body.String())
+	}
+	if got := calls.Load(); got != 2 {
+		t.Fatalf("upstream calls = %d, want 2", got)
+	}
+	select {
+	case <-slowCancelled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("losing attempt was not cancelled")
+	}
+	// 落败的尝试不计费
+	if got := countConsumeLogs(t, token.UserId); got != 1 {
+		t.Fatalf("consume logs = %d, want 1", got)
+	}
+}
+
+func TestRelayWithoutHedgeWhenFirstByteIsFast(t *testing.T) {
+	setupRelayTestDB(t)
+	hedgeSetting := operation_setting.GetHedgeRequestSetting()
+	oldHedgeSetting := *hedgeSetting
+	operation_setting.SelfUseModeEnabled = true
+	t.Cleanup(func() {
+		*hedgeSetting = oldHedgeSetting
+		operation_setting.SelfUseModeEnabled = false
+	})
+	hedgeSetting.Enabled = true
+	hedgeSetting.Models = []string{}
+	hedgeSetting.DelayMs = 1000
+
+	var calls atomic.Int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(chatTestResponse("gpt-hedge-test", "ok")))
+	}))
+	defer upstream.Close()
+	createRelayTestChannel(t, 11, "gpt-hedge-test", upstream.URL, "")
+	createRelayTestChannel(t, 12, "gpt-hedge-test", upstream.URL, "")
+	token := createRelayTestToken(t)
+
+	_, c := relayTestRequest(t, token.Key, newChatTestRequest("gpt-hedge-test", false))
+	if got := calls.Load(); got != 1 {
+		t.Fatalf("upstream calls = %d, want no hedged request", got)
+	}
+	if used := c.GetStringSlice("use_channel"); len(used) != 1 {
+		t.Fatalf("used channels = %v, want one", used)
+	}
+	if got := common.GetContextKeyInt(c, constant.ContextKeyConsumedQuota); got <= 0 {
+		t.Fatalf("consumed quota = %d, want the request to be billed", got)
+	}
+}
+
+// setupModelFallbackTest 配置 gpt-primary 的备用模型 gpt-backup，两个模型按次计费的价格不同
+func setupModelFallbackTest(t *testing.T) {
+	t.Helper()
+	setupRelayTestDB(t)
+	fallbackSetting := operation_setting.GetModelFallbackSetting()
+	oldFallbackSetting := *fallbackSetting
+	oldModelPrice := ratio_setting.ModelPrice2JSONString()
+	t.Cleanup(func() {
+		*fallbackSetting = oldFallbackSetting
+		_ = ratio_setting.UpdateModelPriceByJSONString(oldModelPrice)
+	})
+	fallbackSetting.Enabled = true
+	fallbackSetting.Rules = []operation_setting.ModelFallbackRule{
+		{Model: "gpt-primary", Fallbacks: []string{"gpt-backup"}},
+		{Model: "gpt-missing", Fallbacks: []string{"gpt-backup"}},
+	}
+	fallbackSetting.StatusCodes = []int{}
+	if err := ratio_setting.UpdateModelPriceByJSONString(`{"gpt-primary":0.01,"gpt-missing":0.01,"gpt-backup":0.002}`); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRelayModelFallbackAfterChannelFailure(t *testing.T) {
+	setupModelFallbackTest(t)
+
+	var primaryCalls, backupCalls atomic.Int64
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		primaryCalls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":{"message":"overloaded","type":"server_error"}}`))
+	}))
+	defer primary.Close()
+	backup := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		backupCalls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(chatTestResponse("gpt-backup", "from backup")))
+	}))
+	defer backup.Close()
+	createRelayTestChannel(t, 21, "gpt-primary", primary.URL, "")
+	createRelayTestChannel(t, 22, "gpt-backup", backup.URL, "")
+	token := createRelayTestToken(t)
+
+	w, c := relayTestRequest(t, token.Key, newChatTestRequest("gpt-primary", false))
+	if primaryCalls.Load() != 1 || backupCalls.Load() != 1 {
+		t.Fatalf("primary calls = %d, backup calls = %d, want 1 and 1", primaryCalls.Load(), backupCalls.Load())
+	}
+	if got := w.Header().Get("X-Model-Fallback"); got != "gpt-primary -> gpt-backup" {
+		t.Fatalf("fallback header = %q", got)
+	}
+	if !strings.Contains(w.Body.String(), "from backup") {
+		t.Fatalf("body = %s, want the backup response", w.Body.String())
+	}
+	// 按实际使用的备用模型计费
+	if got := common.GetContextKeyInt(c, constant.ContextKeyConsumedQuota); got != 1000 {
+		t.Fatalf("consumed quota = %d, want 1000 at the backup model price", got)
+	}
+}
+
+func TestRelayModelFallbackWithoutChannel(t *testing.T) {
+	setupModelFallbackTest(t)
+
+	backup := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(chatTestResponse("gpt-backup", "from backup")))
+	}))
+	defer backup.Close()
+	createRelayTestChannel(t, 22, "gpt-backup", backup.URL, "")
+	token := createRelayTestToken(t)
+
+	// 请求的模型没有渠道时分发阶段直接选择备用模型的渠道
+	w, c := relayTestRequest(t, token.Key, newChatTestRequest("gpt-missing", false))
+	if got := w.Header().Get("X-Model-Fallback"); got != "gpt-missing -> gpt-backup" {
+		t.Fatalf("fallback header = %q", got)
+	}
+	if got := common.GetContextKeyInt(c, constant.ContextKeyConsumedQuota); got != 1000 {
+		t.Fatalf("consumed quota = %d, want 1000 at the backup model price", got)
+	}
+}
+
+func TestRelayModelFallbackDisabled(t *testing.T) {
+	setupModelFallbackTest(t)
+	operation_setting.GetModelFallbackSetting().Enabled = false
+	token := createRelayTestToken(t)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = newChatTestRequest("gpt-missing", false)
+	c.Request.Header.Set("Content-Type", "application/json")
+	if err := setupBatchTokenContext(c, token.Key); err != nil {
+		t.Fatal(err)
+	}
+	if middleware.DistributeChannel(c) {
+		t.Fatal("request for a model without channels should fail when fallback is disabled")
+	}
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503", w.Code)
+	}
+}
+
+const (
+	resumeTestFirstChunk  = `data: {"id":"chatcmpl-1","object":"chat.completion.chunk","created":1,"model":"gpt-resume-test","choices":[{"index":0,"delta":{"role":"assistant","content":"Hel"},"finish_reason":null}]}` + "\n\n"
+	resumeTestSecondChunk = `data: {"id":"chatcmpl-2","object":"chat.completion.chunk","created":1,"model":"gpt-resume-test","choices":[{"index":0,"delta":{"content":"lo"},"finish_reason":null}]}` + "\n\n" +
+		`data: {"id":"chatcmpl-2","object":"chat.completion.chunk","created":1,"model":"gpt-resume-test","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}` + "\n\n" +
+		"data: [DONE]\n\n"
+)
+
+// setupStreamFailoverTest 第一次请求输出部分内容后断开，之后的请求输出剩余内容，返回上游收到的请求体
+func setupStreamFailoverTest(t *testing.T, mode string, retryTimes int) (*model.Token, *[]string) {
+	t.Helper()
+	setupRelayTestDB(t)
+	failoverSetting := operation_setting.GetStreamFailoverSetting()
+	oldFailoverSetting := *failoverSetting
+	oldRetryTimes, oldStreamingTimeout := common.RetryTimes, constant.StreamingTimeout
+	oldSelfUseMode := operation_setting.SelfUseModeEnabled
+	t.Cleanup(func() {
+		*failoverSetting = oldFailoverSetting
+		common.RetryTimes, constant.StreamingTimeout = oldRetryTimes, oldStreamingTimeout
+		operation_setting.SelfUseModeEnabled = oldSelfUseMode
+	})
+	operation_setting.SelfUseModeEnabled = true
+	failoverSetting.Enabled = true
+	failoverSetting.Mode = mode
+	failoverSetting.MaxResumes = 1
+	failoverSetting.MaxBufferKB = 1024
+	common.RetryTimes = retryTimes
+	constant.StreamingTimeout = 60
+	// 上游没有返回用量，按输出内容计算 token
+	service.InitTokenEncoders()
+
+	var mu sync.Mutex
+	var bodies []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(body))
+		first := len(bodies) == 1
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/event-stream")
+		if first {
+			_, _ = io.WriteString(w, resumeTestFirstChunk)
+			return
+		}
+		_, _ = io.WriteString(w, resumeTestSecondChunk)
+	}))
+	t.Cleanup(upstream.Close)
+	createRelayTestChannel(t, 31, "gpt-resume-test", upstream.URL, "")
+	createRelayTestChannel(t, 32, "gpt-resume-test", upstream.URL, "")
+	return createRelayTestToken(t), &bodies
+}
+
+func TestRelayStreamResume(t *testing.T) {
+	token, bodies := setupStreamFailoverTest(t, operation_setting.StreamFailoverModeResume, 1)
+
+	w, _ := relayTestRequest(t, token.Key, newChatTestRequest("gpt-resume-test", true))
+	if len(*bodies) != 2 {
+		t.Fatalf("upstream calls = %d, want 2", len(*bodies))
+	}
+	// 续写的请求附带已输出的内容
+	var resumed dto.GeneralOpenAIRequest
+	if err := common.UnmarshalJsonStr((*bodies)[1], &resumed); err != nil {
+		t.Fatal(err)
+	}
+	last := resumed.Messages[len(resumed.Messages)-1]
+	if last.Role != "assistant" || last.StringContent() != "Hel" {
+		t.Fatalf("last message of the resumed request = %+v, want the partial assistant output", last)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, `"content":"Hel"`) || !strings.Contains(body, `"content":"lo"`) {
+		t.Fatalf("stream = %s, want both parts of the output", body)
+	}
+	if strings.Count(body, "[DONE]") != 1 || strings.Contains(body, `"error"`) {
+		t.Fatalf("stream = %s, want a single clean end", body)
+	}
+}
+
+func TestRelayStreamInterruptedError(t *testing.T) {
+	token, bodies := setupStreamFailoverTest(t, operation_setting.StreamFailoverModeError, 1)
+
+	w, _ := relayTestRequest(t, token.Key, newChatTestRequest("gpt-resume-test", true))
+	// 已经输出了内容，error 模式下不重试，在流中发送错误事件
+	if len(*bodies) != 1 {
+		t.Fatalf("upstream calls = %d, want 1", len(*bodies))
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, `"content":"Hel"`) || !strings.Contains(body, `"error"`) {
+		t.Fatalf("stream = %s, want the partial output followed by an error event", body)
+	}
+}
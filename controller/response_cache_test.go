@@ -0,0 +1,160 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/setting/ratio_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+const responseCacheTestModel = "gpt-cache-test"
+
+// setupResponseCacheTest 创建开启响应缓存的令牌和按次计费的渠道，返回令牌 Key 和上游请求计数
+func setupResponseCacheTest(t *testing.T) (string, *atomic.Int64) {
+	t.Helper()
+	setupRelayTestDB(t)
+	var upstreamCalls atomic.Int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-cache-test","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}],"usage":{"prompt_tokens":3,"completion_tokens":1,"total_tokens":4}}`))
+	}))
+	t.Cleanup(upstream.Close)
+
+	cacheSetting := operation_setting.GetResponseCacheSetting()
+	oldCacheSetting := *cacheSetting
+	oldModelPrice := ratio_setting.ModelPrice2JSONString()
+	t.Cleanup(func() {
+		*cacheSetting = oldCacheSetting
+		_ = ratio_setting.UpdateModelPriceByJSONString(oldModelPrice)
+	})
+	cacheSetting.Enabled = true
+	cacheSetting.TTLSeconds = 60
+	cacheSetting.Scope = operation_setting.ResponseCacheScopeUser
+	// 按次计费 0.002 美元，完整计费为 1000 额度
+	if err := ratio_setting.UpdateModelPriceByJSONString(`{"` + responseCacheTestModel + `":0.002}`); err != nil {
+		t.Fatal(err)
+	}
+
+	const id = 2
+	model.DB.Where("channel_id = ?", id).Delete(&model.Ability{})
+	model.DB.Unscoped().Delete(&model.Channel{}, id)
+	model.DB.Unscoped().Delete(&model.User{}, id)
+	model.DB.Unscoped().Delete(&model.Token{}, id)
+	baseURL := upstream.URL
+	channel := &model.Channel{
+		Id:      id,
+		Type:    1,
+		Key:     "sk-test",
+		Name:    "response-cache-test",
+		Status:  common.ChannelStatusEnabled,
+		Group:   "default",
+		Models:  responseCacheTestModel,
+		BaseURL: &baseURL,
+	}
+	if err := model.DB.Create(channel).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := channel.AddAbilities(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := model.DB.Create(&model.User{Id: id, Username: "response_cache", Status: common.UserStatusEnabled, Group: "default", Quota: 1000000, AffCode: "responsecache"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	token := &model.Token{Id: id, UserId: id, Key: "responsecachetestkey", Status: common.TokenStatusEnabled, ExpiredTime: -1, UnlimitedQuota: true, ResponseCache: true}
+	if err := model.DB.Create(token).Error; err != nil {
+		t.Fatal(err)
+	}
+	return token.Key, &upstreamCalls
+}
+
+// relayCachedRequest 按中间件链的顺序分发并转发一个 chat completions 请求
+func relayCachedRequest(t *testing.T, tokenKey string, body string) (*httptest.ResponseRecorder, *gin.Context) {
+	t.Helper()
+	return relayTestRequest(t, tokenKey, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+}
+
+func TestResponseCacheBilling(t *testing.T) {
+	tokenKey, upstreamCalls := setupResponseCacheTest(t)
+	cacheSetting := operation_setting.GetResponseCacheSetting()
+
+	tests := []struct {
+		name          string
+		billingMode   string
+		discountRatio float64
+		wantQuota     int
+	}{
+		{name: "free", billingMode: operation_setting.ResponseCacheBillingFree, wantQuota: 0},
+		{name: "discount", billingMode: operation_setting.ResponseCacheBillingDiscount, discountRatio: 0.5, wantQuota: 500},
+		{name: "full", billingMode: operation_setting.ResponseCacheBillingFull, wantQuota: 1000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cacheSetting.BillingMode = tt.billingMode
+			cacheSetting.DiscountRatio = tt.discountRatio
+			// 每个用例使用不同的内容，避免命中其他用例写入的缓存
+			content := common.GetUUID()
+			calls := upstreamCalls.Load()
+
+			w, c := relayCachedRequest(t, tokenKey, `{"model":"gpt-cache-test","messages":[{"role":"user","content":"`+content+`"}],"user":"alice"}`)
+			if got := w.Header().Get("X-Response-Cache"); got != "MISS" {
+				t.Fatalf("first request cache header = %q, want MISS", got)
+			}
+			if got := upstreamCalls.Load() - calls; got != 1 {
+				t.Fatalf("upstream calls after miss = %d, want 1", got)
+			}
+			if got := common.GetContextKeyInt(c, constant.ContextKeyConsumedQuota); got != 1000 {
+				t.Fatalf("quota on miss = %d, want 1000", got)
+			}
+
+			// user、stream_options 和字段顺序不影响缓存键
+			w, c = relayCachedRequest(t, tokenKey, `{"messages":[{"role":"user","content":"`+content+`"}],"model":"gpt-cache-test","user":"bob","stream_options":{"include_usage":true}}`)
+			if got := w.Header().Get("X-Response-Cache"); got != "HIT" {
+				t.Fatalf("second request cache header = %q, want HIT", got)
+			}
+			if got := upstreamCalls.Load() - calls; got != 1 {
+				t.Fatalf("upstream calls after hit = %d, want 1", got)
+			}
+			if !strings.Contains(w.Body.String(), `"content":"ok"`) {
+				t.Fatalf("cached body = %s", w.Body.String())
+			}
+			if channelId := common.GetContextKeyInt(c, constant.ContextKeyChannelId); channelId != 0 {
+				t.Fatalf("cache hit selected channel #%d, want no channel", channelId)
+			}
+			if got := common.GetContextKeyInt(c, constant.ContextKeyConsumedQuota); got != tt.wantQuota {
+				t.Fatalf("quota on hit = %d, want %d", got, tt.wantQuota)
+			}
+		})
+	}
+}
+
+func TestResponseCacheKeySeparatesRequests(t *testing.T) {
+	tokenKey, upstreamCalls := setupResponseCacheTest(t)
+	content := common.GetUUID()
+	calls := upstreamCalls.Load()
+
+	relayCachedRequest(t, tokenKey, `{"model":"gpt-cache-test","messages":[{"role":"user","content":"`+content+`"}]}`)
+	w, _ := relayCachedRequest(t, tokenKey, `{"model":"gpt-cache-test","messages":[{"role":"user","content":"`+content+`"}],"temperature":0.5}`)
+	if got := w.Header().Get("X-Response-Cache"); got != "MISS" {
+		t.Fatalf("request with different parameters cache header = %q, want MISS", got)
+	}
+	// Cache-Control: no-cache 不读取缓存
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-cache-test","messages":[{"role":"user","content":"`+content+`"}]}`))
+	req.Header.Set("Cache-Control", "no-cache")
+	w, _ = relayTestRequest(t, tokenKey, req)
+	if got := w.Header().Get("X-Response-Cache"); got != "MISS" {
+		t.Fatalf("no-cache request cache header = %q, want MISS", got)
+	}
+	if got := upstreamCalls.Load() - calls; got != 3 {
+		t.Fatalf("upstream calls = %d, want 3", got)
+	}
+}
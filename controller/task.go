@@ -9,7 +9,6 @@ import (
 	"net/http"
 	"sort"
 	"strconv"
-	"time"
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/constant"
@@ -17,79 +16,88 @@ import (
 	"github.com/QuantumNous/new-api/logger"
 	"github.com/QuantumNous/new-api/model"
 	"github.com/QuantumNous/new-api/relay"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
 
 	"github.com/gin-gonic/gin"
 	"github.com/samber/lo"
+	"golang.org/x/sync/errgroup"
 )
 
-func UpdateTaskBulk() {
-	//revocer
-	//imageModel := "midjourney"
-	for {
-		time.Sleep(time.Duration(15) * time.Second)
-		common.SysLog("任务进度轮询开始")
-		ctx := context.TODO()
-		allTasks := model.GetAllUnFinishSyncTasks(500)
-		platformTask := make(map[constant.TaskPlatform][]*model.Task)
-		for _, t := range allTasks {
-			platformTask[t.Platform] = append(platformTask[t.Platform], t)
+// updateDueTasks 查询到达查询时间的未完成任务，查询在 g 中并发执行
+func updateDueTasks(g *errgroup.Group) {
+	ctx := context.TODO()
+	now := common.GetTimestamp()
+	allTasks := model.GetDueUnFinishSyncTasks(now, operation_setting.GetTaskPollSetting().BatchSize)
+	if len(allTasks) == 0 {
+		return
+	}
+	platformTask := make(map[constant.TaskPlatform][]*model.Task)
+	for _, t := range allTasks {
+		platformTask[t.Platform] = append(platformTask[t.Platform], t)
+	}
+	for platform, tasks := range platformTask {
+		if len(tasks) == 0 {
+			continue
 		}
-		for platform, tasks := range platformTask {
-			if len(tasks) == 0 {
+		taskChannelM := make(map[int][]string)
+		taskM := make(map[string]*model.Task)
+		nullTaskIds := make([]int64, 0)
+		for _, task := range tasks {
+			if task.TaskID == "" {
+				// 统计失败的未完成任务
+				nullTaskIds = append(nullTaskIds, task.ID)
 				continue
 			}
-			taskChannelM := make(map[int][]string)
-			taskM := make(map[string]*model.Task)
-			nullTaskIds := make([]int64, 0)
-			for _, task := range tasks {
-				if task.TaskID == "" {
-					// 统计失败的未完成任务
-					nullTaskIds = append(nullTaskIds, task.ID)
-					continue
-				}
-				taskM[task.TaskID] = task
-				taskChannelM[task.ChannelId] = append(taskChannelM[task.ChannelId], task.TaskID)
-			}
-			if len(nullTaskIds) > 0 {
-				err := model.TaskBulkUpdateByID(nullTaskIds, map[string]any{
-					"status":   "FAILURE",
-					"progress": "100%",
-				})
-				if err != nil {
-					logger.LogError(ctx, fmt.Sprintf("Fix null task_id task error: %v", err))
-				} else {
-					logger.LogInfo(ctx, fmt.Sprintf("Fix null task_id task success: %v", nullTaskIds))
-				}
-			}
-			if len(taskChannelM) == 0 {
+			if maxDuration := operation_setting.GetTaskPollSetting().GetMaxDuration(string(platform)); maxDuration > 0 && task.SubmitTime > 0 && now-task.SubmitTime > maxDuration {
+				failTimedOutTask(ctx, task, maxDuration)
 				continue
 			}
-
-			UpdateTaskByPlatform(platform, taskChannelM, taskM)
+			claimTaskPoll(task, now)
+			taskM[task.TaskID] = task
+			taskChannelM[task.ChannelId] = append(taskChannelM[task.ChannelId], task.TaskID)
+		}
+		if len(nullTaskIds) > 0 {
+			err := model.TaskBulkUpdateByID(nullTaskIds, map[string]any{
+				"status":   "FAILURE",
+				"progress": "100%",
+			})
+			if err != nil {
+				logger.LogError(ctx, fmt.Sprintf("Fix null task_id task error: %v", err))
+			} else {
+				logger.LogInfo(ctx, fmt.Sprintf("Fix null task_id task success: %v", nullTaskIds))
+			}
+		}
+		if len(taskChannelM) == 0 {
+			continue
 		}
-		common.SysLog("任务进度轮询完成")
+
+		UpdateTaskByPlatform(g, platform, taskChannelM, taskM)
 	}
 }
 
-func UpdateTaskByPlatform(platform constant.TaskPlatform, taskChannelM map[int][]string, taskM map[string]*model.Task) {
+func UpdateTaskByPlatform(g *errgroup.Group, platform constant.TaskPlatform, taskChannelM map[int][]string, taskM map[string]*model.Task) {
 	switch platform {
 	case constant.TaskPlatformMidjourney:
 		//_ = UpdateMidjourneyTaskAll(context.Background(), tasks)
 	case constant.TaskPlatformSuno:
-		_ = UpdateSunoTaskAll(context.Background(), taskChannelM, taskM)
+		_ = UpdateSunoTaskAll(context.Background(), g, taskChannelM, taskM)
 	default:
-		if err := UpdateVideoTaskAll(context.Background(), platform, taskChannelM, taskM); err != nil {
+		if err := UpdateVideoTaskAll(context.Background(), g, platform, taskChannelM, taskM); err != nil {
 			common.SysLog(fmt.Sprintf("UpdateVideoTaskAll fail: %s", err))
 		}
 	}
 }
 
-func UpdateSunoTaskAll(ctx context.Context, taskChannelM map[int][]string, taskM map[string]*model.Task) error {
+func UpdateSunoTaskAll(ctx context.Context, g *errgroup.Group, taskChannelM map[int][]string, taskM map[string]*model.Task) error {
 	for channelId, taskIds := range taskChannelM {
-		err := updateSunoTaskAll(ctx, channelId, taskIds, taskM)
-		if err != nil {
-			logger.LogError(ctx, fmt.Sprintf("渠道 #%d 更新异步任务失败: %d", channelId, err.Error()))
-		}
+		g.Go(func() error {
+			err := updateSunoTaskAll(ctx, channelId, taskIds, taskM)
+			if err != nil {
+				logger.LogError(ctx, fmt.Sprintf("渠道 #%d 更新异步任务失败: %d", channelId, err.Error()))
+			}
+			return nil
+		})
 	}
 	return nil
 }
@@ -109,6 +117,8 @@ func updateSunoTaskAll(ctx context.Context, channelId int, taskIds []string, tas
 		})
 		if err != nil {
 			common.SysLog(fmt.Sprintf("UpdateMidjourneyTask error2: %v", err))
+		} else {
+			notifyTaskBulkFailure(taskIds, taskM, fmt.Sprintf("获取渠道信息失败，请联系管理员，渠道ID：%d", channelId))
 		}
 		return err
 	}
@@ -150,7 +160,11 @@ func updateSunoTaskAll(ctx context.Context, channelId int, taskIds []string, tas
 			continue
 		}
 
+		preStatus := task.Status
 		task.Status = lo.If(model.TaskStatus(responseItem.Status) != "", model.TaskStatus(responseItem.Status)).Else(task.Status)
+		if task.Status != preStatus {
+			resetTaskPoll(task, common.GetTimestamp())
+		}
 		task.FailReason = lo.If(responseItem.FailReason != "", responseItem.FailReason).Else(task.FailReason)
 		task.SubmitTime = lo.If(responseItem.SubmitTime != 0, responseItem.SubmitTime).Else(task.SubmitTime)
 		task.StartTime = lo.If(responseItem.StartTime != 0, responseItem.StartTime).Else(task.StartTime)
@@ -164,7 +178,7 @@ func updateSunoTaskAll(ctx context.Context, channelId int, taskIds []string, tas
 			} else {
 				quota := task.Quota
 				if quota != 0 {
-					err = model.IncreaseUserQuota(task.UserId, quota, false)
+					err = service.RefundTaskQuota(task.UserId, task.TokenId, quota)
 					if err != nil {
 						logger.LogError(ctx, "fail to increase user quota: "+err.Error())
 					}
@@ -181,11 +195,28 @@ func updateSunoTaskAll(ctx context.Context, channelId int, taskIds []string, tas
 		err = task.Update()
 		if err != nil {
 			common.SysLog("UpdateMidjourneyTask task error: " + err.Error())
+		} else {
+			service.NotifyTaskCallback(task, preStatus)
 		}
 	}
 	return nil
 }
 
+// notifyTaskBulkFailure 批量标记任务失败后推送回调
+func notifyTaskBulkFailure(taskIds []string, taskM map[string]*model.Task, failReason string) {
+	for _, taskId := range taskIds {
+		task, ok := taskM[taskId]
+		if !ok {
+			continue
+		}
+		preStatus := task.Status
+		task.Status = model.TaskStatusFailure
+		task.Progress = "100%"
+		task.FailReason = failReason
+		service.NotifyTaskCallback(task, preStatus)
+	}
+}
+
 func checkTaskNeedUpdate(oldTask *model.Task, newTask dto.SunoDataResponse) bool {
 
 	if oldTask.SubmitTime != newTask.SubmitTime {
@@ -245,6 +276,9 @@ func GetAllTask(c *gin.Context) {
 
 	items := model.TaskGetAllTasks(pageInfo.GetStartIdx(), pageInfo.GetPageSize(), queryParams)
 	total := model.TaskCountAllTasks(queryParams)
+	for _, task := range items {
+		task.FailReason = service.SignMediaUrl(task.FailReason)
+	}
 	pageInfo.SetTotal(int(total))
 	pageInfo.SetItems(items)
 	common.ApiSuccess(c, pageInfo)
@@ -269,6 +303,22 @@ func GetUserTask(c *gin.Context) {
 
 	items := model.TaskGetAllUserTask(userId, pageInfo.GetStartIdx(), pageInfo.GetPageSize(), queryParams)
 	total := model.TaskCountAllUserTask(userId, queryParams)
+	for _, task := range items {
+		task.FailReason = service.SignMediaUrl(task.FailReason)
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(items)
+	common.ApiSuccess(c, pageInfo)
+}
+
+// GetUserTaskCallbacks 查询当前用户的任务回调投递记录，可按 task_id 过滤
+func GetUserTaskCallbacks(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	items, total, err := model.GetUserTaskCallbacks(c.GetInt("id"), c.Query("task_id"), pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
 	pageInfo.SetTotal(int(total))
 	pageInfo.SetItems(items)
 	common.ApiSuccess(c, pageInfo)
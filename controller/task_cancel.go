@@ -0,0 +1,167 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/relay"
+	"github.com/QuantumNous/new-api/relay/channel"
+	"github.com/QuantumNous/new-api/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+const taskCancelledReason = "Task cancelled by user"
+
+// failTask 把未完成的任务标记为失败并退还额度，数据库中的状态已被其他更新改变时不做处理，返回是否标记成功
+func failTask(ctx context.Context, task *model.Task, reason string) (bool, error) {
+	preStatus := task.Status
+	quota := task.Quota
+	task.Status = model.TaskStatusFailure
+	task.Progress = "100%"
+	task.FailReason = reason
+	if task.FinishTime == 0 {
+		task.FinishTime = time.Now().Unix()
+	}
+	updated, err := task.UpdateWithStatus(preStatus)
+	if err != nil || !updated {
+		return false, err
+	}
+	service.AfterTaskUpdate(task, preStatus, "", nil)
+	// 与查询结果为失败时相同，之前已是失败状态的任务不重复退还
+	if quota != 0 && preStatus != model.TaskStatusFailure {
+		refundTaskQuota(ctx, task, quota, fmt.Sprintf("Async task %s failed: %s, refund %s", task.TaskID, reason, logger.LogQuota(quota)))
+	}
+	return true, nil
+}
+
+// failTimedOutTask 任务超过平台的最长时间仍未完成时标记失败并退还额度
+func failTimedOutTask(ctx context.Context, task *model.Task, maxDuration int64) {
+	reason := fmt.Sprintf("Task timed out after %d minutes", maxDuration/60)
+	if _, err := failTask(ctx, task, reason); err != nil {
+		logger.LogError(ctx, fmt.Sprintf("Failed to mark task %s as timed out: %s", task.TaskID, err.Error()))
+		return
+	}
+	logger.LogInfo(ctx, fmt.Sprintf("Task %s timed out after %d seconds", task.TaskID, maxDuration))
+}
+
+// CancelVideoTask 取消未完成的视频任务，上游取消成功后标记失败并退还额度
+func CancelVideoTask(c *gin.Context) {
+	taskId := c.Param("task_id")
+	task, exists, err := model.GetByTaskId(c.GetInt("id"), taskId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": gin.H{
+				"message": "Failed to query task",
+				"type":    "server_error",
+			},
+		})
+		return
+	}
+	if !exists || task == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": gin.H{
+				"message": "Task not found",
+				"type":    "invalid_request_error",
+			},
+		})
+		return
+	}
+	if task.Status == model.TaskStatusSuccess || task.Status == model.TaskStatusFailure {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"message": fmt.Sprintf("Task is already finished, current status: %s", task.Status),
+				"type":    "invalid_request_error",
+			},
+		})
+		return
+	}
+	canceler, ok := relay.GetTaskAdaptor(task.Platform).(channel.TaskCanceler)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"message": "Cancelling tasks is not supported for this platform",
+				"type":    "invalid_request_error",
+			},
+		})
+		return
+	}
+	taskChannel, err := model.CacheGetChannel(task.ChannelId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": gin.H{
+				"message": "Failed to retrieve channel information",
+				"type":    "server_error",
+			},
+		})
+		return
+	}
+	baseURL := constant.ChannelBaseURLs[taskChannel.Type]
+	if taskChannel.GetBaseURL() != "" {
+		baseURL = taskChannel.GetBaseURL()
+	}
+	resp, err := canceler.CancelTask(baseURL, taskChannel.Key, map[string]any{
+		"task_id": task.TaskID,
+	})
+	if err != nil {
+		logger.LogError(c.Request.Context(), fmt.Sprintf("Failed to cancel task %s: %s", task.TaskID, err.Error()))
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error": gin.H{
+				"message": "Failed to cancel task upstream",
+				"type":    "server_error",
+			},
+		})
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		responseBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		c.JSON(resp.StatusCode, gin.H{
+			"error": gin.H{
+				"message": fmt.Sprintf("Upstream refused to cancel task: %s", string(responseBody)),
+				"type":    "upstream_error",
+			},
+		})
+		return
+	}
+
+	updated, err := failTask(c.Request.Context(), task, taskCancelledReason)
+	if err != nil {
+		logger.LogError(c.Request.Context(), fmt.Sprintf("Failed to mark task %s as cancelled: %s", task.TaskID, err.Error()))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": gin.H{
+				"message": "Failed to update task",
+				"type":    "server_error",
+			},
+		})
+		return
+	}
+	if !updated {
+		// 任务在取消期间被查询或推送更新，返回最新状态
+		if latest, exists, err := model.GetByTaskId(task.UserId, task.TaskID); err == nil && exists {
+			task = latest
+		}
+	}
+
+	video := dto.NewOpenAIVideo()
+	video.ID = task.TaskID
+	video.TaskID = task.TaskID
+	video.Status = task.Status.ToVideoStatus()
+	video.SetProgressStr(task.Progress)
+	video.CreatedAt = task.CreatedAt
+	video.CompletedAt = task.FinishTime
+	if task.Status == model.TaskStatusFailure {
+		video.Error = &dto.OpenAIVideoError{
+			Message: task.FailReason,
+			Code:    "cancelled",
+		}
+	}
+	c.JSON(http.StatusOK, video)
+}
@@ -0,0 +1,189 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/errgroup"
+)
+
+const taskTestPlatform = constant.TaskPlatform("55")
+
+// createTestTask 创建属于用户的未完成任务
+func createTestTask(t *testing.T, taskId string, userId int, channelId int, submitTime int64, quota int) *model.Task {
+	t.Helper()
+	task := &model.Task{
+		TaskID:     taskId,
+		Platform:   taskTestPlatform,
+		UserId:     userId,
+		ChannelId:  channelId,
+		Quota:      quota,
+		Action:     constant.TaskActionGenerate,
+		Status:     model.TaskStatusInProgress,
+		Progress:   "30%",
+		SubmitTime: submitTime,
+	}
+	if err := model.DB.Create(task).Error; err != nil {
+		t.Fatal(err)
+	}
+	return task
+}
+
+func getTestTask(t *testing.T, task *model.Task) *model.Task {
+	t.Helper()
+	latest, exists, err := model.GetByTaskId(task.UserId, task.TaskID)
+	if err != nil || !exists {
+		t.Fatalf("task %s not found: %v", task.TaskID, err)
+	}
+	return latest
+}
+
+func getTestUserQuota(t *testing.T, userId int) int {
+	t.Helper()
+	quota, err := model.GetUserQuota(userId, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return quota
+}
+
+func TestUpdateDueTasksFailsTimedOutTask(t *testing.T) {
+	setupRelayTestDB(t)
+	// 其他测试留下的未完成任务会被一起查询
+	if err := model.DB.Exec("DELETE FROM tasks").Error; err != nil {
+		t.Fatal(err)
+	}
+	pollSetting := operation_setting.GetTaskPollSetting()
+	oldMaxDuration, oldPlatformMaxDurations := pollSetting.MaxDuration, pollSetting.PlatformMaxDurations
+	t.Cleanup(func() {
+		pollSetting.MaxDuration, pollSetting.PlatformMaxDurations = oldMaxDuration, oldPlatformMaxDurations
+	})
+	pollSetting.MaxDuration = 0
+	pollSetting.PlatformMaxDurations = map[string]int{string(taskTestPlatform): 3600}
+
+	token := createRelayTestToken(t)
+	quota := getTestUserQuota(t, token.UserId)
+	task := createTestTask(t, "video_"+common.GetRandomString(12), token.UserId, 0, common.GetTimestamp()-7200, 500)
+
+	var g errgroup.Group
+	updateDueTasks(&g)
+	_ = g.Wait()
+	timedOut := getTestTask(t, task)
+	if timedOut.Status != model.TaskStatusFailure || timedOut.Progress != "100%" || !strings.Contains(timedOut.FailReason, "timed out") {
+		t.Fatalf("task = %s %s %q, want a timed out failure", timedOut.Status, timedOut.Progress, timedOut.FailReason)
+	}
+	if got := getTestUserQuota(t, token.UserId); got != quota+500 {
+		t.Fatalf("user quota = %d, want %d after the refund", got, quota+500)
+	}
+
+	// 已经失败的任务不重复退还
+	failTimedOutTask(context.Background(), timedOut, 3600)
+	if got := getTestUserQuota(t, token.UserId); got != quota+500 {
+		t.Fatalf("user quota = %d after failing the task again, want %d", got, quota+500)
+	}
+}
+
+func TestFailTaskSkipsTaskUpdatedConcurrently(t *testing.T) {
+	setupRelayTestDB(t)
+	token := createRelayTestToken(t)
+	quota := getTestUserQuota(t, token.UserId)
+	task := createTestTask(t, "video_"+common.GetRandomString(12), token.UserId, 0, common.GetTimestamp(), 500)
+
+	// 查询结果先把任务更新为成功，超时处理持有的是旧状态
+	stale := *task
+	if err := model.DB.Model(&model.Task{}).Where("id = ?", task.ID).Updates(map[string]any{"status": model.TaskStatusSuccess, "progress": "100%"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	updated, err := failTask(context.Background(), &stale, "Task timed out after 60 minutes")
+	if err != nil || updated {
+		t.Fatalf("failTask = %v, %v, want no update", updated, err)
+	}
+	if latest := getTestTask(t, task); latest.Status != model.TaskStatusSuccess {
+		t.Fatalf("task status = %s, want %s", latest.Status, model.TaskStatusSuccess)
+	}
+	if got := getTestUserQuota(t, token.UserId); got != quota {
+		t.Fatalf("user quota = %d, want %d without a refund", got, quota)
+	}
+}
+
+func TestCancelVideoTask(t *testing.T) {
+	setupRelayTestDB(t)
+	var cancelled []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		taskId := strings.TrimPrefix(r.URL.Path, "/v1/videos/")
+		if strings.HasSuffix(taskId, "_refused") {
+			w.WriteHeader(http.StatusConflict)
+			_, _ = w.Write([]byte(`{"error":{"message":"task is already running"}}`))
+			return
+		}
+		cancelled = append(cancelled, taskId)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"` + taskId + `","status":"cancelled"}`))
+	}))
+	t.Cleanup(upstream.Close)
+	channel := createRelayTestChannel(t, 41, "sora-2", upstream.URL, "")
+	token := createRelayTestToken(t)
+	quota := getTestUserQuota(t, token.UserId)
+
+	cancel := func(userId int, taskId string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodDelete, "/v1/videos/"+taskId, nil)
+		c.Params = gin.Params{{Key: "task_id", Value: taskId}}
+		c.Set("id", userId)
+		CancelVideoTask(c)
+		return w
+	}
+
+	task := createTestTask(t, "video_"+common.GetRandomString(12), token.UserId, channel.Id, common.GetTimestamp(), 300)
+	if w := cancel(token.UserId+1, task.TaskID); w.Code != http.StatusNotFound {
+		t.Fatalf("cancel another user's task status = %d, want 404", w.Code)
+	}
+	w := cancel(token.UserId, task.TaskID)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"cancelled"`) {
+		t.Fatalf("cancel = %d %s, want a cancelled video", w.Code, w.Body.String())
+	}
+	if len(cancelled) != 1 || cancelled[0] != task.TaskID {
+		t.Fatalf("upstream cancelled %v, want [%s]", cancelled, task.TaskID)
+	}
+	if latest := getTestTask(t, task); latest.Status != model.TaskStatusFailure || latest.FailReason != taskCancelledReason {
+		t.Fatalf("task = %s %q, want a cancelled failure", latest.Status, latest.FailReason)
+	}
+	if got := getTestUserQuota(t, token.UserId); got != quota+300 {
+		t.Fatalf("user quota = %d, want %d after the refund", got, quota+300)
+	}
+	// 已结束的任务不能再次取消，也不重复退还
+	if w := cancel(token.UserId, task.TaskID); w.Code != http.StatusBadRequest {
+		t.Fatalf("cancel finished task status = %d, want 400", w.Code)
+	}
+	if got := getTestUserQuota(t, token.UserId); got != quota+300 {
+		t.Fatalf("user quota = %d after cancelling again, want %d", got, quota+300)
+	}
+
+	// 上游拒绝取消时任务保持原状态
+	refused := createTestTask(t, "video_"+common.GetRandomString(12)+"_refused", token.UserId, channel.Id, common.GetTimestamp(), 300)
+	if w := cancel(token.UserId, refused.TaskID); w.Code != http.StatusConflict {
+		t.Fatalf("refused cancel status = %d, want 409", w.Code)
+	}
+	if latest := getTestTask(t, refused); latest.Status != model.TaskStatusInProgress {
+		t.Fatalf("refused task status = %s, want %s", latest.Status, model.TaskStatusInProgress)
+	}
+	if got := getTestUserQuota(t, token.UserId); got != quota+300 {
+		t.Fatalf("user quota = %d after a refused cancel, want %d", got, quota+300)
+	}
+	// 结束测试时不留下未完成的任务
+	model.DB.Model(&model.Task{}).Where("id = ?", refused.ID).Update("status", model.TaskStatusFailure)
+}
@@ -0,0 +1,99 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/relay"
+	"github.com/QuantumNous/new-api/relay/channel"
+	"github.com/QuantumNous/new-api/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TaskPush 接收上游主动推送的任务状态，地址签名校验通过后立即向上游查询该任务并更新，无需等待下一次查询。
+// 推送内容只用于取得任务 ID，不作为任务结果，避免推送地址泄露后被伪造任务状态
+func TaskPush(c *gin.Context) {
+	platform := constant.TaskPlatform(c.Param("platform"))
+	channelId, err := strconv.Atoi(c.Param("channel_id"))
+	if err != nil || !service.VerifyTaskPushSignature(string(platform), channelId, c.Query("signature")) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "invalid signature",
+		})
+		return
+	}
+	pushParser, ok := relay.GetTaskAdaptor(platform).(channel.TaskPushParser)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "platform does not support push",
+		})
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "failed to read body",
+		})
+		return
+	}
+	taskId, err := pushParser.ParseTaskPush(body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	task, exists, err := model.GetByOnlyTaskId(taskId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "failed to query task",
+		})
+		return
+	}
+	// 推送地址绑定了平台和渠道，不属于该渠道的任务不接受
+	if !exists || task.Platform != platform || task.ChannelId != channelId {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "task not found",
+		})
+		return
+	}
+	if task.Status == model.TaskStatusSuccess || task.Status == model.TaskStatusFailure {
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+		})
+		return
+	}
+	taskChannel, err := model.CacheGetChannel(channelId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "failed to get channel",
+		})
+		return
+	}
+	ctx := context.Background()
+	adaptor := newVideoTaskAdaptor(platform, taskChannel)
+	if err := updateVideoSingleTask(ctx, adaptor, taskChannel, taskId, map[string]*model.Task{taskId: task}); err != nil {
+		common.SysError(fmt.Sprintf("failed to update pushed task %s: %s", taskId, err.Error()))
+		c.JSON(http.StatusBadGateway, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+	})
+}
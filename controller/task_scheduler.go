@@ -0,0 +1,90 @@
+package controller
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"golang.org/x/sync/errgroup"
+)
+
+// 异步任务状态调度。
+// 每个任务记录下次查询上游的时间，调度器定期取出到期的任务并发查询；状态变化后按基础间隔再次查询，
+// 状态未变化时间隔指数退避。多节点部署时通过选举只由一个节点执行，上游推送的状态由 TaskPush 直接更新。
+
+const taskSchedulerLeaderTTL = 30 * time.Second
+
+// RunTaskScheduler 运行异步任务状态调度，取代固定间隔的全量轮询
+func RunTaskScheduler() {
+	elector := service.NewLeaderElector("task_scheduler", taskSchedulerLeaderTTL)
+	var isLeader atomic.Bool
+	isLeader.Store(elector.IsLeader())
+	// 单独续期，避免单轮查询耗时过长导致锁过期
+	gopool.Go(func() {
+		for {
+			time.Sleep(taskSchedulerLeaderTTL / 3)
+			isLeader.Store(elector.IsLeader())
+		}
+	})
+	for {
+		setting := operation_setting.GetTaskPollSetting()
+		time.Sleep(time.Duration(max(setting.TickInterval, 1)) * time.Second)
+		if !isLeader.Load() {
+			continue
+		}
+		g := new(errgroup.Group)
+		g.SetLimit(max(setting.Concurrency, 1))
+		updateDueTasks(g)
+		updateDueMidjourneyTasks(g)
+		_ = g.Wait()
+	}
+}
+
+func isTaskQueued(status string) bool {
+	switch status {
+	case "", string(model.TaskStatusNotStart), model.TaskStatusSubmitted, model.TaskStatusQueued:
+		return true
+	}
+	return false
+}
+
+// claimTaskPoll 查询前先按退避间隔推后下次查询时间，查询失败时也不会在下一轮重复查询
+func claimTaskPoll(task *model.Task, now int64) {
+	interval := operation_setting.GetTaskPollSetting().GetPollInterval(string(task.Platform), isTaskQueued(string(task.Status)),
+		task.PollAttempts, task.Properties.PushEnabled)
+	task.NextPollTime = now + interval
+	task.PollAttempts++
+	if err := model.TaskSetNextPoll(task.ID, task.NextPollTime, task.PollAttempts); err != nil {
+		common.SysError(fmt.Sprintf("failed to schedule task %s: %s", task.TaskID, err.Error()))
+	}
+}
+
+// resetTaskPoll 任务状态变化后重置退避，随任务一起保存
+func resetTaskPoll(task *model.Task, now int64) {
+	task.PollAttempts = 0
+	task.NextPollTime = now + operation_setting.GetTaskPollSetting().GetPollInterval(string(task.Platform), isTaskQueued(string(task.Status)),
+		0, task.Properties.PushEnabled)
+}
+
+func claimMidjourneyPoll(task *model.Midjourney, now int64) {
+	interval := operation_setting.GetTaskPollSetting().GetPollInterval(string(constant.TaskPlatformMidjourney), isTaskQueued(task.Status),
+		task.PollAttempts, false)
+	task.NextPollTime = now + interval
+	task.PollAttempts++
+	if err := model.MjSetNextPoll(task.Id, task.NextPollTime, task.PollAttempts); err != nil {
+		common.SysError(fmt.Sprintf("failed to schedule midjourney task %s: %s", task.MjId, err.Error()))
+	}
+}
+
+func resetMidjourneyPoll(task *model.Midjourney, now int64) {
+	task.PollAttempts = 0
+	task.NextPollTime = now + operation_setting.GetTaskPollSetting().GetPollInterval(string(constant.TaskPlatformMidjourney), isTaskQueued(task.Status),
+		0, false)
+}
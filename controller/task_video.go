@@ -15,19 +15,22 @@ import (
 	"github.com/QuantumNous/new-api/relay"
 	"github.com/QuantumNous/new-api/relay/channel"
 	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
 	"github.com/QuantumNous/new-api/setting/ratio_setting"
+
+	"golang.org/x/sync/errgroup"
 )
 
-func UpdateVideoTaskAll(ctx context.Context, platform constant.TaskPlatform, taskChannelM map[int][]string, taskM map[string]*model.Task) error {
+func UpdateVideoTaskAll(ctx context.Context, g *errgroup.Group, platform constant.TaskPlatform, taskChannelM map[int][]string, taskM map[string]*model.Task) error {
 	for channelId, taskIds := range taskChannelM {
-		if err := updateVideoTaskAll(ctx, platform, channelId, taskIds, taskM); err != nil {
+		if err := updateVideoTaskAll(ctx, g, platform, channelId, taskIds, taskM); err != nil {
 			logger.LogError(ctx, fmt.Sprintf("Channel #%d failed to update video async tasks: %s", channelId, err.Error()))
 		}
 	}
 	return nil
 }
 
-func updateVideoTaskAll(ctx context.Context, platform constant.TaskPlatform, channelId int, taskIds []string, taskM map[string]*model.Task) error {
+func updateVideoTaskAll(ctx context.Context, g *errgroup.Group, platform constant.TaskPlatform, channelId int, taskIds []string, taskM map[string]*model.Task) error {
 	logger.LogInfo(ctx, fmt.Sprintf("Channel #%d pending video tasks: %d", channelId, len(taskIds)))
 	if len(taskIds) == 0 {
 		return nil
@@ -41,24 +44,38 @@ func updateVideoTaskAll(ctx context.Context, platform constant.TaskPlatform, cha
 		})
 		if errUpdate != nil {
 			common.SysLog(fmt.Sprintf("UpdateVideoTask error: %v", errUpdate))
+		} else {
+			notifyTaskBulkFailure(taskIds, taskM, fmt.Sprintf("Failed to get channel info, channel ID: %d", channelId))
 		}
 		return fmt.Errorf("CacheGetChannel failed: %w", err)
 	}
+	if relay.GetTaskAdaptor(platform) == nil {
+		return fmt.Errorf("video adaptor not found")
+	}
+	for _, taskId := range taskIds {
+		g.Go(func() error {
+			// 每个任务使用独立的适配器实例，避免并发查询时共享状态
+			adaptor := newVideoTaskAdaptor(platform, cacheGetChannel)
+			if err := updateVideoSingleTask(ctx, adaptor, cacheGetChannel, taskId, taskM); err != nil {
+				logger.LogError(ctx, fmt.Sprintf("Failed to update video task %s: %s", taskId, err.Error()))
+			}
+			return nil
+		})
+	}
+	return nil
+}
+
+func newVideoTaskAdaptor(platform constant.TaskPlatform, channel *model.Channel) channel.TaskAdaptor {
 	adaptor := relay.GetTaskAdaptor(platform)
 	if adaptor == nil {
-		return fmt.Errorf("video adaptor not found")
+		return nil
 	}
 	info := &relaycommon.RelayInfo{}
 	info.ChannelMeta = &relaycommon.ChannelMeta{
-		ChannelBaseUrl: cacheGetChannel.GetBaseURL(),
+		ChannelBaseUrl: channel.GetBaseURL(),
 	}
 	adaptor.Init(info)
-	for _, taskId := range taskIds {
-		if err := updateVideoSingleTask(ctx, adaptor, cacheGetChannel, taskId, taskM); err != nil {
-			logger.LogError(ctx, fmt.Sprintf("Failed to update video task %s: %s", taskId, err.Error()))
-		}
-	}
-	return nil
+	return adaptor
 }
 
 func updateVideoSingleTask(ctx context.Context, adaptor channel.TaskAdaptor, channel *model.Channel, taskId string, taskM map[string]*model.Task) error {
@@ -72,7 +89,7 @@ func updateVideoSingleTask(ctx context.Context, adaptor channel.TaskAdaptor, cha
 		logger.LogError(ctx, fmt.Sprintf("Task %s not found in taskM", taskId))
 		return fmt.Errorf("task %s not found", taskId)
 	}
-	resp, err := adaptor.FetchTask(baseURL, channel.Key, map[string]any{
+	resp, err := adaptor.FetchTask(baseURL, task.ChannelKey(channel), map[string]any{
 		"task_id": taskId,
 		"action":  task.Action,
 	})
@@ -89,6 +106,13 @@ func updateVideoSingleTask(ctx context.Context, adaptor channel.TaskAdaptor, cha
 	}
 
 	logger.LogDebug(ctx, fmt.Sprintf("UpdateVideoSingleTask response: %s", string(responseBody)))
+	return applyVideoTaskResponse(ctx, adaptor, channel, task, responseBody)
+}
+
+// applyVideoTaskResponse 按上游查询或推送的结果更新视频任务，并处理计费和退款
+func applyVideoTaskResponse(ctx context.Context, adaptor channel.TaskAdaptor, channel *model.Channel, task *model.Task, responseBody []byte) error {
+	taskId := task.TaskID
+	var err error
 
 	taskResult := &relaycommon.TaskInfo{}
 	// try parse as New API response format
@@ -122,6 +146,9 @@ func updateVideoSingleTask(ctx context.Context, adaptor channel.TaskAdaptor, cha
 	preStatus := task.Status
 
 	task.Status = model.TaskStatus(taskResult.Status)
+	if task.Status != preStatus {
+		resetTaskPoll(task, now)
+	}
 	switch taskResult.Status {
 	case model.TaskStatusSubmitted:
 		task.Progress = "10%"
@@ -209,7 +236,7 @@ func updateVideoSingleTask(ctx context.Context, adaptor channel.TaskAdaptor, cha
 									logger.LogQuota(preConsumedQuota),
 									taskResult.TotalTokens,
 								))
-								if err := model.IncreaseUserQuota(task.UserId, refundQuota, false); err != nil {
+								if err := service.RefundTaskQuota(task.UserId, task.TokenId, refundQuota); err != nil {
 									logger.LogError(ctx, fmt.Sprintf("退还预扣费失败: %s", err.Error()))
 								} else {
 									task.Quota = actualQuota // 更新任务记录的实际扣费额度
@@ -253,23 +280,33 @@ func updateVideoSingleTask(ctx context.Context, adaptor channel.TaskAdaptor, cha
 	if taskResult.Progress != "" {
 		task.Progress = taskResult.Progress
 	}
-	if err := task.Update(); err != nil {
-		common.SysLog("UpdateVideoTask task error: " + err.Error())
+	// 只有数据库中的状态仍为 preStatus 时才更新，推送和查询同时到达时只结算一次
+	if updated, err := task.UpdateWithStatus(preStatus); err != nil || !updated {
+		if err != nil {
+			common.SysLog("UpdateVideoTask task error: " + err.Error())
+		} else {
+			logger.LogWarn(ctx, fmt.Sprintf("Task %s was updated concurrently, skip", task.TaskID))
+		}
 		shouldRefund = false
+	} else {
+		service.AfterTaskUpdate(task, preStatus, taskResult.Url, channel)
 	}
 
 	if shouldRefund {
 		// 任务失败且之前状态不是失败才退还额度，防止重复退还
-		if err := model.IncreaseUserQuota(task.UserId, quota, false); err != nil {
-			logger.LogWarn(ctx, "Failed to increase user quota: "+err.Error())
-		}
-		logContent := fmt.Sprintf("Video async task failed %s, refund %s", task.TaskID, logger.LogQuota(quota))
-		model.RecordLog(task.UserId, model.LogTypeSystem, logContent)
+		refundTaskQuota(ctx, task, quota, fmt.Sprintf("Video async task failed %s, refund %s", task.TaskID, logger.LogQuota(quota)))
 	}
 
 	return nil
 }
 
+func refundTaskQuota(ctx context.Context, task *model.Task, quota int, logContent string) {
+	if err := service.RefundTaskQuota(task.UserId, task.TokenId, quota); err != nil {
+		logger.LogWarn(ctx, "Failed to increase user quota: "+err.Error())
+	}
+	model.RecordLog(task.UserId, model.LogTypeSystem, logContent)
+}
+
 func redactVideoResponseBody(body []byte) []byte {
 	var m map[string]any
 	if err := json.Unmarshal(body, &m); err != nil {
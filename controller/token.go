@@ -7,10 +7,40 @@ import (
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
 
 	"github.com/gin-gonic/gin"
 )
 
+// tokenWithQuotaSpend 令牌及其当前天、周、月窗口内已消费的额度
+type tokenWithQuotaSpend struct {
+	*model.Token
+	QuotaSpend *model.QuotaSpendUsage `json:"quota_spend,omitempty"`
+}
+
+func withQuotaSpend(tokens []*model.Token) ([]tokenWithQuotaSpend, error) {
+	var limitedIds []int
+	for _, token := range tokens {
+		if token.GetQuotaSpendLimits().Enabled() {
+			limitedIds = append(limitedIds, token.Id)
+		}
+	}
+	usages, err := model.GetQuotaSpendUsages(model.QuotaSpendSubjectToken, limitedIds)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]tokenWithQuotaSpend, 0, len(tokens))
+	for _, token := range tokens {
+		items = append(items, tokenWithQuotaSpend{Token: token, QuotaSpend: usages[token.Id]})
+	}
+	return items, nil
+}
+
+func validateTokenLimits(token *model.Token) bool {
+	return token.DailyQuotaLimit >= 0 && token.WeeklyQuotaLimit >= 0 && token.MonthlyQuotaLimit >= 0 &&
+		token.TPMLimit >= 0 && token.ConcurrencyLimit >= 0
+}
+
 func GetAllTokens(c *gin.Context) {
 	userId := c.GetInt("id")
 	pageInfo := common.GetPageQuery(c)
@@ -19,9 +49,14 @@ func GetAllTokens(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
+	items, err := withQuotaSpend(tokens)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
 	total, _ := model.CountUserTokens(userId)
 	pageInfo.SetTotal(int(total))
-	pageInfo.SetItems(tokens)
+	pageInfo.SetItems(items)
 	common.ApiSuccess(c, pageInfo)
 	return
 }
@@ -55,10 +90,15 @@ func GetToken(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
+	items, err := withQuotaSpend([]*model.Token{token})
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
-		"data":    token,
+		"data":    items[0],
 	})
 	return
 }
@@ -149,6 +189,17 @@ func AddToken(c *gin.Context) {
 		})
 		return
 	}
+	if !validateTokenLimits(&token) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "令牌限制不能为负数",
+		})
+		return
+	}
+	if err := service.ValidateTaskCallbackUrl(token.CallbackUrl); err != nil {
+		common.ApiError(c, err)
+		return
+	}
 	key, err := common.GenerateKey()
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -171,6 +222,13 @@ func AddToken(c *gin.Context) {
 		ModelLimits:        token.ModelLimits,
 		AllowIps:           token.AllowIps,
 		Group:              token.Group,
+		ResponseCache:      token.ResponseCache,
+		DailyQuotaLimit:    token.DailyQuotaLimit,
+		WeeklyQuotaLimit:   token.WeeklyQuotaLimit,
+		MonthlyQuotaLimit:  token.MonthlyQuotaLimit,
+		TPMLimit:           token.TPMLimit,
+		ConcurrencyLimit:   token.ConcurrencyLimit,
+		CallbackUrl:        token.CallbackUrl,
 	}
 	err = cleanToken.Insert()
 	if err != nil {
@@ -215,6 +273,17 @@ func UpdateToken(c *gin.Context) {
 		})
 		return
 	}
+	if !validateTokenLimits(&token) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "令牌限制不能为负数",
+		})
+		return
+	}
+	if err := service.ValidateTaskCallbackUrl(token.CallbackUrl); err != nil {
+		common.ApiError(c, err)
+		return
+	}
 	cleanToken, err := model.GetTokenByIds(token.Id, userId)
 	if err != nil {
 		common.ApiError(c, err)
@@ -248,6 +317,13 @@ func UpdateToken(c *gin.Context) {
 		cleanToken.ModelLimits = token.ModelLimits
 		cleanToken.AllowIps = token.AllowIps
 		cleanToken.Group = token.Group
+		cleanToken.ResponseCache = token.ResponseCache
+		cleanToken.DailyQuotaLimit = token.DailyQuotaLimit
+		cleanToken.WeeklyQuotaLimit = token.WeeklyQuotaLimit
+		cleanToken.MonthlyQuotaLimit = token.MonthlyQuotaLimit
+		cleanToken.TPMLimit = token.TPMLimit
+		cleanToken.ConcurrencyLimit = token.ConcurrencyLimit
+		cleanToken.CallbackUrl = token.CallbackUrl
 	}
 	err = cleanToken.Update()
 	if err != nil {
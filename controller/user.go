@@ -1243,6 +1243,8 @@ func UpdateUserSetting(c *gin.Context) {
 		QuotaWarningThreshold: req.QuotaWarningThreshold,
 		AcceptUnsetRatioModel: req.AcceptUnsetModelRatioModel,
 		RecordIpLog:           req.RecordIpLog,
+		// webhook 密钥同时用于任务回调签名，切换通知类型时保留
+		WebhookSecret: user.GetSetting().WebhookSecret,
 	}
 
 	// 如果是webhook类型,添加webhook相关设置
@@ -8,6 +8,7 @@ import (
 
 	"github.com/QuantumNous/new-api/logger"
 	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
 
 	"github.com/gin-gonic/gin"
 )
@@ -35,8 +36,9 @@ func VideoProxy(c *gin.Context) {
 		})
 		return
 	}
-	if !exists || task == nil {
-		logger.LogError(c.Request.Context(), fmt.Sprintf("Failed to get task %s: %s", taskID, err.Error()))
+	// 非任务所有者按不存在处理，避免泄露任务是否存在
+	if !exists || task == nil || !service.CanAccessMedia(c, task.UserId) {
+		logger.LogError(c.Request.Context(), fmt.Sprintf("Failed to get task %s", taskID))
 		c.JSON(http.StatusNotFound, gin.H{
 			"error": gin.H{
 				"message": "Task not found",
@@ -56,6 +58,23 @@ func VideoProxy(c *gin.Context) {
 		return
 	}
 
+	// 已保存结果副本时直接从网关存储返回，不再请求上游；副本过期或不属于当前用户时与 /v1/artifacts 一样按不存在处理
+	if artifact, err := model.GetTaskArtifact(model.TaskArtifactTypeTask, task.TaskID); err == nil && artifact != nil {
+		if !service.CanAccessArtifact(c, artifact) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": gin.H{
+					"message": "Artifact not found or expired",
+					"type":    "invalid_request_error",
+				},
+			})
+			return
+		}
+		if err := service.ServeArtifact(c, artifact); err == nil {
+			return
+		}
+		logger.LogError(c.Request.Context(), fmt.Sprintf("Failed to serve artifact %s: %s", artifact.ArtifactId, err.Error()))
+	}
+
 	channel, err := model.CacheGetChannel(task.ChannelId)
 	if err != nil {
 		logger.LogError(c.Request.Context(), fmt.Sprintf("Failed to get channel %d: %s", task.ChannelId, err.Error()))
@@ -89,7 +108,7 @@ func VideoProxy(c *gin.Context) {
 		return
 	}
 
-	req.Header.Set("Authorization", "Bearer "+channel.Key)
+	req.Header.Set("Authorization", "Bearer "+task.ChannelKey(channel))
 
 	resp, err := client.Do(req)
 	if err != nil {
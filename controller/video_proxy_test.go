@@ -0,0 +1,68 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestVideoProxyRejectsExpiredOrForeignArtifact(t *testing.T) {
+	t.Setenv("SQL_DSN", "")
+	common.SQLitePath = "file:video_proxy_test?mode=memory&cache=shared"
+	common.RedisEnabled = false
+	common.MemoryCacheEnabled = false
+	common.IsMasterNode = true
+	if err := model.InitDB(); err != nil {
+		t.Fatal(err)
+	}
+	gin.SetMode(gin.TestMode)
+	for _, table := range []string{"tasks", "task_artifacts"} {
+		if err := model.DB.Exec("DELETE FROM " + table).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	setting := operation_setting.GetMediaAccessSetting()
+	oldVerify := setting.VerifyOwnership
+	defer func() { setting.VerifyOwnership = oldVerify }()
+
+	now := common.GetTimestamp()
+	for _, taskId := range []string{"task_expired", "task_valid"} {
+		if err := model.DB.Create(&model.Task{TaskID: taskId, UserId: 1, Status: model.TaskStatusSuccess}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	artifacts := []*model.TaskArtifact{
+		{ArtifactId: "art_expired", UserId: 1, TaskType: model.TaskArtifactTypeTask, TaskId: "task_expired", ExpiresAt: now - 1},
+		{ArtifactId: "art_valid", UserId: 1, TaskType: model.TaskArtifactTypeTask, TaskId: "task_valid", ExpiresAt: now + 3600},
+	}
+	for _, artifact := range artifacts {
+		if err := artifact.Insert(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	serve := func(taskId string, userId int) int {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/v1/videos/"+taskId+"/content", nil)
+		c.Params = gin.Params{{Key: "task_id", Value: taskId}}
+		c.Set("id", userId)
+		VideoProxy(c)
+		return w.Code
+	}
+
+	setting.VerifyOwnership = false
+	if code := serve("task_expired", 1); code != http.StatusNotFound {
+		t.Fatalf("expired artifact: status %d, want 404", code)
+	}
+	setting.VerifyOwnership = true
+	if code := serve("task_valid", 2); code != http.StatusNotFound {
+		t.Fatalf("another user's artifact: status %d, want 404", code)
+	}
+}
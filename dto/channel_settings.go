@@ -7,6 +7,14 @@ type ChannelSettings struct {
 	PassThroughBodyEnabled bool   `json:"pass_through_body_enabled,omitempty"`
 	SystemPrompt           string `json:"system_prompt,omitempty"`
 	SystemPromptOverride   bool   `json:"system_prompt_override,omitempty"`
+	// 渠道级别的每分钟请求数、每分钟 token 数和并发限制，0 表示不限制
+	RPMLimit         int `json:"rpm_limit,omitempty"`
+	TPMLimit         int `json:"tpm_limit,omitempty"`
+	ConcurrencyLimit int `json:"concurrency_limit,omitempty"`
+	// 多 Key 渠道中每个 Key 的限制
+	KeyRPMLimit         int `json:"key_rpm_limit,omitempty"`
+	KeyTPMLimit         int `json:"key_tpm_limit,omitempty"`
+	KeyConcurrencyLimit int `json:"key_concurrency_limit,omitempty"`
 }
 
 type VertexKeyType string
@@ -211,6 +211,21 @@ type ClaudeRequest struct {
 	ServiceTier string `json:"service_tier,omitempty"`
 }
 
+// ClaudeCountTokensRequest https://docs.anthropic.com/en/api/messages-count-tokens
+type ClaudeCountTokensRequest struct {
+	Model      string          `json:"model"`
+	System     any             `json:"system,omitempty"`
+	Messages   []ClaudeMessage `json:"messages"`
+	Tools      any             `json:"tools,omitempty"`
+	ToolChoice any             `json:"tool_choice,omitempty"`
+	Thinking   *Thinking       `json:"thinking,omitempty"`
+	McpServers json.RawMessage `json:"mcp_servers,omitempty"`
+}
+
+type ClaudeCountTokensResponse struct {
+	InputTokens int `json:"input_tokens"`
+}
+
 func (c *ClaudeRequest) GetTokenCountMeta() *types.TokenCountMeta {
 	var tokenCountMeta = types.TokenCountMeta{
 		TokenType: types.TokenTypeTokenizer,
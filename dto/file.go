@@ -0,0 +1,28 @@
+package dto
+
+// OpenAIFile https://platform.openai.com/docs/api-reference/files/object
+type OpenAIFile struct {
+	Id            string `json:"id"`
+	Object        string `json:"object"`
+	Bytes         int64  `json:"bytes"`
+	CreatedAt     int64  `json:"created_at"`
+	ExpiresAt     *int64 `json:"expires_at,omitempty"`
+	Filename      string `json:"filename"`
+	Purpose       string `json:"purpose"`
+	Status        string `json:"status,omitempty"`
+	StatusDetails string `json:"status_details,omitempty"`
+}
+
+type OpenAIFileList struct {
+	Object  string       `json:"object"`
+	Data    []OpenAIFile `json:"data"`
+	FirstId string       `json:"first_id,omitempty"`
+	LastId  string       `json:"last_id,omitempty"`
+	HasMore bool         `json:"has_more"`
+}
+
+type OpenAIFileDeleted struct {
+	Id      string `json:"id"`
+	Object  string `json:"object"`
+	Deleted bool   `json:"deleted"`
+}
@@ -325,17 +325,24 @@ func (o *OpenAIResponsesResponse) GetSize() string {
 }
 
 type IncompleteDetails struct {
-	Reasoning string `json:"reasoning"`
+	Reasoning string `json:"reasoning,omitempty"`
+	Reason    string `json:"reason,omitempty"`
 }
 
 type ResponsesOutput struct {
 	Type    string                   `json:"type"`
 	ID      string                   `json:"id"`
-	Status  string                   `json:"status"`
-	Role    string                   `json:"role"`
-	Content []ResponsesOutputContent `json:"content"`
-	Quality string                   `json:"quality"`
-	Size    string                   `json:"size"`
+	Status  string                   `json:"status,omitempty"`
+	Role    string                   `json:"role,omitempty"`
+	Content []ResponsesOutputContent `json:"content,omitempty"`
+	Quality string                   `json:"quality,omitempty"`
+	Size    string                   `json:"size,omitempty"`
+	// function_call
+	CallId    string `json:"call_id,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments,omitempty"`
+	// reasoning
+	Summary []ResponsesOutputContent `json:"summary,omitempty"`
 }
 
 type ResponsesOutputContent struct {
@@ -360,10 +367,18 @@ const (
 
 // ResponsesStreamResponse 用于处理 /v1/responses 流式响应
 type ResponsesStreamResponse struct {
-	Type     string                   `json:"type"`
-	Response *OpenAIResponsesResponse `json:"response,omitempty"`
-	Delta    string                   `json:"delta,omitempty"`
-	Item     *ResponsesOutput         `json:"item,omitempty"`
+	Type           string                   `json:"type"`
+	SequenceNumber int                      `json:"sequence_number"`
+	Response       *OpenAIResponsesResponse `json:"response,omitempty"`
+	Delta          string                   `json:"delta,omitempty"`
+	Item           *ResponsesOutput         `json:"item,omitempty"`
+	ItemId         string                   `json:"item_id,omitempty"`
+	OutputIndex    *int                     `json:"output_index,omitempty"`
+	ContentIndex   *int                     `json:"content_index,omitempty"`
+	SummaryIndex   *int                     `json:"summary_index,omitempty"`
+	Part           *ResponsesOutputContent  `json:"part,omitempty"`
+	Text           string                   `json:"text,omitempty"`
+	Arguments      string                   `json:"arguments,omitempty"`
 }
 
 // GetOpenAIError 从动态错误类型中提取OpenAIError结构
@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/tracing"
 	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/controller"
 	"github.com/QuantumNous/new-api/logger"
@@ -101,13 +102,29 @@ func main() {
 	}
 
 	go controller.AutomaticallyTestChannels()
+	go controller.AutomaticallyProbeCircuitBreakers()
 
-	if common.IsMasterNode && constant.UpdateTask {
+	// 启用 Redis 时各节点通过选举由一个节点查询任务状态，否则只在主节点查询
+	if constant.UpdateTask && (common.IsMasterNode || common.RedisEnabled) {
 		gopool.Go(func() {
-			controller.UpdateMidjourneyTaskBulk()
+			controller.RunTaskScheduler()
+		})
+	}
+	if common.IsMasterNode {
+		gopool.Go(func() {
+			service.AutomaticallyCleanupExpiredFiles()
+		})
+		gopool.Go(func() {
+			controller.RunBatchScheduler()
 		})
 		gopool.Go(func() {
-			controller.UpdateTaskBulk()
+			model.CleanupQuotaSpends()
+		})
+		gopool.Go(func() {
+			service.AutomaticallyRetryTaskCallbacks()
+		})
+		gopool.Go(func() {
+			service.AutomaticallyCleanupExpiredArtifacts()
 		})
 	}
 	if os.Getenv("BATCH_UPDATE_ENABLED") == "true" {
@@ -124,6 +141,8 @@ func main() {
 		common.SysLog("pprof enabled")
 	}
 
+	tracing.Init()
+
 	// Initialize HTTP server
 	server := gin.New()
 	server.Use(gin.CustomRecovery(func(c *gin.Context, err any) {
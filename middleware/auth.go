@@ -9,7 +9,9 @@ import (
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
 	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
 	"github.com/QuantumNous/new-api/setting/ratio_setting"
 
 	"github.com/gin-contrib/sessions"
@@ -262,22 +264,10 @@ func TokenAuth() func(c *gin.Context) {
 
 		userCache.WriteContext(c)
 
-		userGroup := userCache.Group
-		tokenGroup := token.Group
-		if tokenGroup != "" {
-			// check common.UserUsableGroups[userGroup]
-			if _, ok := setting.GetUserUsableGroups(userGroup)[tokenGroup]; !ok {
-				abortWithOpenAiMessage(c, http.StatusForbidden, fmt.Sprintf("令牌分组 %s 已被禁用", tokenGroup))
-				return
-			}
-			// check group in common.GroupRatio
-			if !ratio_setting.ContainsGroupRatio(tokenGroup) {
-				if tokenGroup != "auto" {
-					abortWithOpenAiMessage(c, http.StatusForbidden, fmt.Sprintf("分组 %s 已被弃用", tokenGroup))
-					return
-				}
-			}
-			userGroup = tokenGroup
+		userGroup, err := GetTokenUsingGroup(userCache.Group, token.Group)
+		if err != nil {
+			abortWithOpenAiMessage(c, http.StatusForbidden, err.Error())
+			return
 		}
 		common.SetContextKey(c, constant.ContextKeyUsingGroup, userGroup)
 
@@ -289,6 +279,24 @@ func TokenAuth() func(c *gin.Context) {
 	}
 }
 
+// GetTokenUsingGroup 校验令牌分组是否可用，返回本次请求实际使用的分组
+func GetTokenUsingGroup(userGroup string, tokenGroup string) (string, error) {
+	if tokenGroup == "" {
+		return userGroup, nil
+	}
+	// check common.UserUsableGroups[userGroup]
+	if _, ok := setting.GetUserUsableGroups(userGroup)[tokenGroup]; !ok {
+		return "", fmt.Errorf("令牌分组 %s 已被禁用", tokenGroup)
+	}
+	// check group in common.GroupRatio
+	if !ratio_setting.ContainsGroupRatio(tokenGroup) {
+		if tokenGroup != "auto" {
+			return "", fmt.Errorf("分组 %s 已被弃用", tokenGroup)
+		}
+	}
+	return tokenGroup, nil
+}
+
 func SetupContextForToken(c *gin.Context, token *model.Token, parts ...string) error {
 	if token == nil {
 		return fmt.Errorf("token is nil")
@@ -308,6 +316,11 @@ func SetupContextForToken(c *gin.Context, token *model.Token, parts ...string) e
 		c.Set("token_model_limit_enabled", false)
 	}
 	c.Set("token_group", token.Group)
+	common.SetContextKey(c, constant.ContextKeyTokenResponseCache, token.ResponseCache)
+	common.SetContextKey(c, constant.ContextKeyTokenQuotaSpendLimited, token.GetQuotaSpendLimits().Enabled())
+	common.SetContextKey(c, constant.ContextKeyTokenTPMLimit, token.TPMLimit)
+	common.SetContextKey(c, constant.ContextKeyTokenConcurrencyLimit, token.ConcurrencyLimit)
+	common.SetContextKey(c, constant.ContextKeyTokenCallbackUrl, token.CallbackUrl)
 	if len(parts) > 1 {
 		if model.IsAdmin(token.UserId) {
 			c.Set("specific_channel_id", parts[1])
@@ -318,3 +331,20 @@ func SetupContextForToken(c *gin.Context, token *model.Token, parts ...string) e
 	}
 	return nil
 }
+
+// MediaAuth 任务结果地址的鉴权，param 为路由中的任务 ID 参数，带有效签名时直接放行，否则按令牌鉴权
+func MediaAuth(resource string, param string) func(c *gin.Context) {
+	tokenAuth := TokenAuth()
+	return func(c *gin.Context) {
+		if !operation_setting.GetMediaAccessSetting().VerifyOwnership {
+			c.Next()
+			return
+		}
+		if service.VerifyMediaSignature(resource, c.Param(param), c.Query("expires"), c.Query("signature")) {
+			common.SetContextKey(c, constant.ContextKeyMediaSignedAccess, true)
+			c.Next()
+			return
+		}
+		tokenAuth(c)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/tracing"
 	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/dto"
 	"github.com/QuantumNous/new-api/model"
@@ -20,6 +21,7 @@ import (
 	"github.com/QuantumNous/new-api/types"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type ModelRequest struct {
@@ -29,99 +31,182 @@ type ModelRequest struct {
 
 func Distribute() func(c *gin.Context) {
 	return func(c *gin.Context) {
-		var channel *model.Channel
-		channelId, ok := common.GetContextKey(c, constant.ContextKeyTokenSpecificChannelId)
-		modelRequest, shouldSelectChannel, err := getModelRequest(c)
-		if err != nil {
-			abortWithOpenAiMessage(c, http.StatusBadRequest, "Invalid request, "+err.Error())
+		if !DistributeChannel(c) {
 			return
 		}
-		if ok {
-			id, err := strconv.Atoi(channelId.(string))
-			if err != nil {
-				abortWithOpenAiMessage(c, http.StatusBadRequest, "无效的渠道 Id")
-				return
-			}
-			channel, err = model.GetChannelById(id, true)
-			if err != nil {
-				abortWithOpenAiMessage(c, http.StatusBadRequest, "无效的渠道 Id")
-				return
+		defer ReleaseChannelRateLimit(c)
+		c.Next()
+	}
+}
+
+// DistributeWithoutRateLimit 只选择渠道，不占用渠道的 RPM 与并发额度，用于 count_tokens 等不产生上游生成请求的路由
+func DistributeWithoutRateLimit() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		if !distributeChannel(c, false) {
+			return
+		}
+		c.Next()
+	}
+}
+
+// DistributeChannel 为请求选择渠道并占用渠道的速率限制额度，选择失败时写入错误响应并返回 false。
+// 不经过中间件链调用时（如批处理），需要在请求处理完成后调用 ReleaseChannelRateLimit 释放占用
+func DistributeChannel(c *gin.Context) bool {
+	return distributeChannel(c, true)
+}
+
+func distributeChannel(c *gin.Context, acquireRateLimit bool) bool {
+	span := tracing.Start(c, "middleware.Distribute")
+	defer span.End(nil)
+	var channel *model.Channel
+	// 选择渠道时已设置上下文并占用额度
+	selected := false
+	channelId, ok := common.GetContextKey(c, constant.ContextKeyTokenSpecificChannelId)
+	modelRequest, shouldSelectChannel, err := getModelRequest(c)
+	if err != nil {
+		abortWithOpenAiMessage(c, http.StatusBadRequest, "Invalid request, "+err.Error())
+		return false
+	}
+	if ok {
+		id, err := strconv.Atoi(channelId.(string))
+		if err != nil {
+			abortWithOpenAiMessage(c, http.StatusBadRequest, "无效的渠道 Id")
+			return false
+		}
+		channel, err = model.GetChannelById(id, true)
+		if err != nil {
+			abortWithOpenAiMessage(c, http.StatusBadRequest, "无效的渠道 Id")
+			return false
+		}
+		if channel.Status != common.ChannelStatusEnabled {
+			abortWithOpenAiMessage(c, http.StatusForbidden, "该渠道已被禁用")
+			return false
+		}
+	} else {
+		// Select a channel for the user
+		// check token model mapping
+		modelLimitEnable := common.GetContextKeyBool(c, constant.ContextKeyTokenModelLimitEnabled)
+		if modelLimitEnable {
+			s, ok := common.GetContextKey(c, constant.ContextKeyTokenModelLimit)
+			if !ok {
+				// token model limit is empty, all models are not allowed
+				abortWithOpenAiMessage(c, http.StatusForbidden, "该令牌无权访问任何模型")
+				return false
 			}
-			if channel.Status != common.ChannelStatusEnabled {
-				abortWithOpenAiMessage(c, http.StatusForbidden, "该渠道已被禁用")
-				return
+			var tokenModelLimit map[string]bool
+			tokenModelLimit, ok = s.(map[string]bool)
+			if !ok {
+				tokenModelLimit = map[string]bool{}
 			}
-		} else {
-			// Select a channel for the user
-			// check token model mapping
-			modelLimitEnable := common.GetContextKeyBool(c, constant.ContextKeyTokenModelLimitEnabled)
-			if modelLimitEnable {
-				s, ok := common.GetContextKey(c, constant.ContextKeyTokenModelLimit)
-				if !ok {
-					// token model limit is empty, all models are not allowed
-					abortWithOpenAiMessage(c, http.StatusForbidden, "该令牌无权访问任何模型")
-					return
-				}
-				var tokenModelLimit map[string]bool
-				tokenModelLimit, ok = s.(map[string]bool)
-				if !ok {
-					tokenModelLimit = map[string]bool{}
-				}
-				matchName := ratio_setting.FormatMatchingModelName(modelRequest.Model) // match gpts & thinking-*
-				if _, ok := tokenModelLimit[matchName]; !ok {
-					abortWithOpenAiMessage(c, http.StatusForbidden, "该令牌无权访问模型 "+modelRequest.Model)
-					return
-				}
+			matchName := ratio_setting.FormatMatchingModelName(modelRequest.Model) // match gpts & thinking-*
+			if _, ok := tokenModelLimit[matchName]; !ok {
+				abortWithOpenAiMessage(c, http.StatusForbidden, "该令牌无权访问模型 "+modelRequest.Model)
+				return false
 			}
+		}
 
-			if shouldSelectChannel {
-				if modelRequest.Model == "" {
-					abortWithOpenAiMessage(c, http.StatusBadRequest, "未指定模型名称，模型名称不能为空")
-					return
+		if shouldSelectChannel {
+			if modelRequest.Model == "" {
+				abortWithOpenAiMessage(c, http.StatusBadRequest, "未指定模型名称，模型名称不能为空")
+				return false
+			}
+			var selectGroup string
+			userGroup := common.GetContextKeyString(c, constant.ContextKeyUsingGroup)
+			// check path is /pg/chat/completions
+			if strings.HasPrefix(c.Request.URL.Path, "/pg/chat/completions") {
+				playgroundRequest := &dto.PlayGroundRequest{}
+				err = common.UnmarshalBodyReusable(c, playgroundRequest)
+				if err != nil {
+					abortWithOpenAiMessage(c, http.StatusBadRequest, "无效的请求, "+err.Error())
+					return false
 				}
-				var selectGroup string
-				userGroup := common.GetContextKeyString(c, constant.ContextKeyUsingGroup)
-				// check path is /pg/chat/completions
-				if strings.HasPrefix(c.Request.URL.Path, "/pg/chat/completions") {
-					playgroundRequest := &dto.PlayGroundRequest{}
-					err = common.UnmarshalBodyReusable(c, playgroundRequest)
-					if err != nil {
-						abortWithOpenAiMessage(c, http.StatusBadRequest, "无效的请求, "+err.Error())
-						return
+				if playgroundRequest.Group != "" {
+					if !setting.GroupInUserUsableGroups(playgroundRequest.Group) && playgroundRequest.Group != userGroup {
+						abortWithOpenAiMessage(c, http.StatusForbidden, "无权访问该分组")
+						return false
 					}
-					if playgroundRequest.Group != "" {
-						if !setting.GroupInUserUsableGroups(playgroundRequest.Group) && playgroundRequest.Group != userGroup {
-							abortWithOpenAiMessage(c, http.StatusForbidden, "无权访问该分组")
-							return
+					userGroup = playgroundRequest.Group
+				}
+			}
+			// 命中响应缓存时不请求上游，不选择渠道也不占用渠道额度
+			if service.LookupResponseCache(c, userGroup) {
+				common.SetContextKey(c, constant.ContextKeyOriginalModel, modelRequest.Model)
+				common.SetContextKey(c, constant.ContextKeyRequestStartTime, time.Now())
+				span.SetAttributes(attribute.String("newapi.model", modelRequest.Model))
+				return true
+			}
+			// 会话粘性：绑定的渠道仍可用时直接使用，否则按正常方式选择，请求成功后重新绑定
+			if stickyKey := service.GetStickySessionKey(c, userGroup, modelRequest.Model); stickyKey != "" {
+				common.SetContextKey(c, constant.ContextKeyStickySessionKey, stickyKey)
+				if session := service.GetStickySession(stickyKey); session != nil {
+					channel = model.CacheGetStickyChannel(session.Group, modelRequest.Model, session.ChannelId)
+					if channel != nil {
+						common.SetContextKey(c, constant.ContextKeyStickyChannelId, session.ChannelId)
+						common.SetContextKey(c, constant.ContextKeyStickyKeyIndex, session.KeyIndex)
+						if channelAcquirer(c, modelRequest.Model, acquireRateLimit)(channel) {
+							selected = true
+							selectGroup = session.Group
+							if userGroup == "auto" {
+								c.Set("auto_group", session.Group)
+							}
+						} else {
+							channel = nil
 						}
-						userGroup = playgroundRequest.Group
 					}
 				}
-				channel, selectGroup, err = model.CacheGetRandomSatisfiedChannel(c, userGroup, modelRequest.Model, 0)
-				if err != nil {
-					showGroup := userGroup
-					if userGroup == "auto" {
-						showGroup = fmt.Sprintf("auto(%s)", selectGroup)
+			}
+			if channel == nil {
+				channel, selectGroup, err = model.CacheGetRandomSatisfiedChannel(c, userGroup, modelRequest.Model, 0, channelAcquirer(c, modelRequest.Model, acquireRateLimit))
+				selected = channel != nil
+			}
+			// 请求的模型没有可用渠道时依次尝试备用模型
+			if channel == nil {
+				for _, fallbackModel := range service.GetModelFallbacks(c, userGroup, modelRequest.Model) {
+					fallbackChannel, fallbackGroup, fallbackErr := model.CacheGetRandomSatisfiedChannel(c, userGroup, fallbackModel, 0, channelAcquirer(c, fallbackModel, acquireRateLimit))
+					if fallbackErr != nil || fallbackChannel == nil {
+						continue
 					}
-					message := fmt.Sprintf("获取分组 %s 下模型 %s 的可用渠道失败（distributor）: %s", showGroup, modelRequest.Model, err.Error())
-					// 如果错误，但是渠道不为空，说明是数据库一致性问题
-					//if channel != nil {
-					//	common.SysError(fmt.Sprintf("渠道不存在：%d", channel.Id))
-					//	message = "数据库一致性已被破坏，请联系管理员"
-					//}
-					abortWithOpenAiMessage(c, http.StatusServiceUnavailable, message, string(types.ErrorCodeModelNotFound))
-					return
+					selected = true
+					service.SetModelFallback(c, modelRequest.Model, fallbackModel)
+					modelRequest.Model = fallbackModel
+					channel, selectGroup, err = fallbackChannel, fallbackGroup, nil
+					break
 				}
-				if channel == nil {
-					abortWithOpenAiMessage(c, http.StatusServiceUnavailable, fmt.Sprintf("分组 %s 下模型 %s 无可用渠道（distributor）", userGroup, modelRequest.Model), string(types.ErrorCodeModelNotFound))
-					return
+			}
+			if err != nil {
+				showGroup := userGroup
+				if userGroup == "auto" {
+					showGroup = fmt.Sprintf("auto(%s)", selectGroup)
 				}
+				message := fmt.Sprintf("获取分组 %s 下模型 %s 的可用渠道失败（distributor）: %s", showGroup, modelRequest.Model, err.Error())
+				// 如果错误，但是渠道不为空，说明是数据库一致性问题
+				//if channel != nil {
+				//	common.SysError(fmt.Sprintf("渠道不存在：%d", channel.Id))
+				//	message = "数据库一致性已被破坏，请联系管理员"
+				//}
+				abortWithOpenAiMessage(c, http.StatusServiceUnavailable, message, string(types.ErrorCodeModelNotFound))
+				return false
+			}
+			if channel == nil {
+				abortWithOpenAiMessage(c, http.StatusServiceUnavailable, fmt.Sprintf("分组 %s 下模型 %s 无可用渠道（distributor）", userGroup, modelRequest.Model), string(types.ErrorCodeModelNotFound))
+				return false
 			}
 		}
-		common.SetContextKey(c, constant.ContextKeyRequestStartTime, time.Now())
+	}
+	common.SetContextKey(c, constant.ContextKeyRequestStartTime, time.Now())
+	if !selected {
+		// 令牌指定的渠道或不需要选择渠道的请求，选择渠道时已在占用额度前设置过上下文
 		SetupContextForSelectedChannel(c, channel, modelRequest.Model)
-		c.Next()
+		if channel != nil && acquireRateLimit && !AcquireChannelRateLimit(c, channel) {
+			abortWithOpenAiMessage(c, http.StatusTooManyRequests, fmt.Sprintf("渠道 #%d 已达到速率限制，请稍后再试", channel.Id), string(types.ErrorCodeRateLimitExceeded))
+			return false
+		}
+	}
+	if channel != nil {
+		span.SetAttributes(tracing.ChannelAttributes(channel.Id, channel.Type)...)
 	}
+	span.SetAttributes(attribute.String("newapi.model", modelRequest.Model))
+	return true
 }
 
 func getModelRequest(c *gin.Context) (*ModelRequest, bool, error) {
@@ -297,7 +382,7 @@ func SetupContextForSelectedChannel(c *gin.Context, channel *model.Channel, mode
 	common.SetContextKey(c, constant.ContextKeyChannelModelMapping, channel.GetModelMapping())
 	common.SetContextKey(c, constant.ContextKeyChannelStatusCodeMapping, channel.GetStatusCodeMapping())
 
-	key, index, newAPIError := channel.GetNextEnabledKey()
+	key, index, newAPIError := getSelectedChannelKey(c, channel)
 	if newAPIError != nil {
 		return newAPIError
 	}
@@ -336,6 +421,49 @@ func SetupContextForSelectedChannel(c *gin.Context, channel *model.Channel, mode
 	return nil
 }
 
+// getSelectedChannelKey 会话粘性绑定了该渠道的 Key 且仍可用时优先使用
+func getSelectedChannelKey(c *gin.Context, channel *model.Channel) (string, int, *types.NewAPIError) {
+	if channel.ChannelInfo.IsMultiKey && common.GetContextKeyInt(c, constant.ContextKeyStickyChannelId) == channel.Id {
+		index := common.GetContextKeyInt(c, constant.ContextKeyStickyKeyIndex)
+		if key, ok := channel.GetEnabledKeyByIndex(index); ok {
+			return key, index, nil
+		}
+	}
+	return channel.GetNextEnabledKeyWithAffinity(strconv.Itoa(common.GetContextKeyInt(c, constant.ContextKeyUserId)))
+}
+
+// AcquireChannelRateLimit 占用所选渠道和 Key 的 RPM/TPM/并发额度，已达到限制时返回 false。
+// 重试切换渠道时先释放上一次的占用
+func AcquireChannelRateLimit(c *gin.Context, channel *model.Channel) bool {
+	ReleaseChannelRateLimit(c)
+	lease, ok := model.AcquireChannelRateLimit(channel, common.GetContextKeyInt(c, constant.ContextKeyChannelMultiKeyIndex))
+	if lease != nil {
+		common.SetContextKey(c, constant.ContextKeyChannelRateLimitLease, lease)
+	}
+	return ok
+}
+
+// channelAcquirer 为选中的渠道设置上下文（包括选择 Key）并占用其额度，设置失败时不占用，由调用方重新设置上下文时返回错误
+func channelAcquirer(c *gin.Context, modelName string, acquireRateLimit bool) model.ChannelAcquireFunc {
+	return func(channel *model.Channel) bool {
+		if SetupContextForSelectedChannel(c, channel, modelName) != nil {
+			return true
+		}
+		return !acquireRateLimit || AcquireChannelRateLimit(c, channel)
+	}
+}
+
+// ReleaseChannelRateLimit 请求结束时释放占用，成功的请求按实际消耗的 token 计入 TPM
+func ReleaseChannelRateLimit(c *gin.Context) {
+	value, ok := common.GetContextKey(c, constant.ContextKeyChannelRateLimitLease)
+	if !ok {
+		return
+	}
+	if lease, ok := value.(*model.ChannelRateLimitLease); ok {
+		lease.Release(common.GetContextKeyInt(c, constant.ContextKeyConsumedTokens))
+	}
+}
+
 // extractModelNameFromGeminiPath 从 Gemini API URL 路径中提取模型名
 // 输入格式: /v1beta/models/gemini-2.0-flash:generateContent
 // 输出: gemini-2.0-flash
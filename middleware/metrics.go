@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MetricsAuth /metrics 鉴权：白名单 IP、METRICS_TOKEN 或管理员 access token
+func MetricsAuth() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		if common.IsIpInList(c.ClientIP(), constant.MetricsAllowedIPs) {
+			c.Next()
+			return
+		}
+		if constant.MetricsToken != "" {
+			token := strings.TrimPrefix(c.Request.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(constant.MetricsToken)) == 1 {
+				c.Next()
+				return
+			}
+		}
+		authHelper(c, common.RoleAdminUser)
+	}
+}
@@ -74,127 +74,131 @@ func recordRedisRequest(ctx context.Context, rdb *redis.Client, key string, maxC
 	rdb.Expire(ctx, key, time.Duration(setting.ModelRequestRateLimitDurationMinutes)*time.Minute)
 }
 
-// Redis限流处理器
-func redisRateLimitHandler(duration int64, totalMaxCount, successMaxCount int) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		userId := strconv.Itoa(c.GetInt("id"))
-		ctx := context.Background()
-		rdb := common.RDB
+// Redis限流检查，通过时返回请求处理完成后记录成功请求的函数
+func checkRedisModelRateLimit(c *gin.Context, duration int64, totalMaxCount, successMaxCount int) (func(), bool) {
+	userId := strconv.Itoa(c.GetInt("id"))
+	ctx := context.Background()
+	rdb := common.RDB
+
+	// 1. 检查成功请求数限制
+	successKey := fmt.Sprintf("rateLimit:%s:%s", ModelRequestRateLimitSuccessCountMark, userId)
+	allowed, err := checkRedisRateLimit(ctx, rdb, successKey, successMaxCount, duration)
+	if err != nil {
+		fmt.Println("检查成功请求数限制失败:", err.Error())
+		abortWithOpenAiMessage(c, http.StatusInternalServerError, "rate_limit_check_failed")
+		return nil, false
+	}
+	if !allowed {
+		abortWithOpenAiMessage(c, http.StatusTooManyRequests, fmt.Sprintf("您已达到请求数限制：%d分钟内最多请求%d次", setting.ModelRequestRateLimitDurationMinutes, successMaxCount))
+		return nil, false
+	}
+
+	//2.检查总请求数限制并记录总请求（当totalMaxCount为0时会自动跳过，使用令牌桶限流器
+	if totalMaxCount > 0 {
+		totalKey := fmt.Sprintf("rateLimit:%s", userId)
+		// 初始化
+		tb := limiter.New(ctx, rdb)
+		allowed, err = tb.Allow(
+			ctx,
+			totalKey,
+			limiter.WithCapacity(int64(totalMaxCount)*duration),
+			limiter.WithRate(int64(totalMaxCount)),
+			limiter.WithRequested(duration),
+		)
 
-		// 1. 检查成功请求数限制
-		successKey := fmt.Sprintf("rateLimit:%s:%s", ModelRequestRateLimitSuccessCountMark, userId)
-		allowed, err := checkRedisRateLimit(ctx, rdb, successKey, successMaxCount, duration)
 		if err != nil {
-			fmt.Println("检查成功请求数限制失败:", err.Error())
+			fmt.Println("检查总请求数限制失败:", err.Error())
 			abortWithOpenAiMessage(c, http.StatusInternalServerError, "rate_limit_check_failed")
-			return
-		}
-		if !allowed {
-			abortWithOpenAiMessage(c, http.StatusTooManyRequests, fmt.Sprintf("您已达到请求数限制：%d分钟内最多请求%d次", setting.ModelRequestRateLimitDurationMinutes, successMaxCount))
-			return
+			return nil, false
 		}
 
-		//2.检查总请求数限制并记录总请求（当totalMaxCount为0时会自动跳过，使用令牌桶限流器
-		if totalMaxCount > 0 {
-			totalKey := fmt.Sprintf("rateLimit:%s", userId)
-			// 初始化
-			tb := limiter.New(ctx, rdb)
-			allowed, err = tb.Allow(
-				ctx,
-				totalKey,
-				limiter.WithCapacity(int64(totalMaxCount)*duration),
-				limiter.WithRate(int64(totalMaxCount)),
-				limiter.WithRequested(duration),
-			)
-
-			if err != nil {
-				fmt.Println("检查总请求数限制失败:", err.Error())
-				abortWithOpenAiMessage(c, http.StatusInternalServerError, "rate_limit_check_failed")
-				return
-			}
-
-			if !allowed {
-				abortWithOpenAiMessage(c, http.StatusTooManyRequests, fmt.Sprintf("您已达到总请求数限制：%d分钟内最多请求%d次，包括失败次数，请检查您的请求是否正确", setting.ModelRequestRateLimitDurationMinutes, totalMaxCount))
-			}
+		if !allowed {
+			abortWithOpenAiMessage(c, http.StatusTooManyRequests, fmt.Sprintf("您已达到总请求数限制：%d分钟内最多请求%d次，包括失败次数，请检查您的请求是否正确", setting.ModelRequestRateLimitDurationMinutes, totalMaxCount))
+			return nil, false
 		}
+	}
 
-		// 4. 处理请求
-		c.Next()
-
-		// 5. 如果请求成功，记录成功请求
+	// 3. 如果请求成功，记录成功请求
+	return func() {
 		if c.Writer.Status() < 400 {
 			recordRedisRequest(ctx, rdb, successKey, successMaxCount)
 		}
-	}
+	}, true
 }
 
-// 内存限流处理器
-func memoryRateLimitHandler(duration int64, totalMaxCount, successMaxCount int) gin.HandlerFunc {
+// 内存限流检查，通过时返回请求处理完成后记录成功请求的函数
+func checkMemoryModelRateLimit(c *gin.Context, duration int64, totalMaxCount, successMaxCount int) (func(), bool) {
 	inMemoryRateLimiter.Init(time.Duration(setting.ModelRequestRateLimitDurationMinutes) * time.Minute)
 
-	return func(c *gin.Context) {
-		userId := strconv.Itoa(c.GetInt("id"))
-		totalKey := ModelRequestRateLimitCountMark + userId
-		successKey := ModelRequestRateLimitSuccessCountMark + userId
-
-		// 1. 检查总请求数限制（当totalMaxCount为0时跳过）
-		if totalMaxCount > 0 && !inMemoryRateLimiter.Request(totalKey, totalMaxCount, duration) {
-			c.Status(http.StatusTooManyRequests)
-			c.Abort()
-			return
-		}
+	userId := strconv.Itoa(c.GetInt("id"))
+	totalKey := ModelRequestRateLimitCountMark + userId
+	successKey := ModelRequestRateLimitSuccessCountMark + userId
 
-		// 2. 检查成功请求数限制
-		// 使用一个临时key来检查限制，这样可以避免实际记录
-		checkKey := successKey + "_check"
-		if !inMemoryRateLimiter.Request(checkKey, successMaxCount, duration) {
-			c.Status(http.StatusTooManyRequests)
-			c.Abort()
-			return
-		}
+	// 1. 检查总请求数限制（当totalMaxCount为0时跳过）
+	if totalMaxCount > 0 && !inMemoryRateLimiter.Request(totalKey, totalMaxCount, duration) {
+		c.Status(http.StatusTooManyRequests)
+		c.Abort()
+		return nil, false
+	}
 
-		// 3. 处理请求
-		c.Next()
+	// 2. 检查成功请求数限制
+	// 使用一个临时key来检查限制，这样可以避免实际记录
+	checkKey := successKey + "_check"
+	if !inMemoryRateLimiter.Request(checkKey, successMaxCount, duration) {
+		c.Status(http.StatusTooManyRequests)
+		c.Abort()
+		return nil, false
+	}
 
-		// 4. 如果请求成功，记录到实际的成功请求计数中
+	// 3. 如果请求成功，记录到实际的成功请求计数中
+	return func() {
 		if c.Writer.Status() < 400 {
 			inMemoryRateLimiter.Request(successKey, successMaxCount, duration)
 		}
+	}, true
+}
+
+// AcquireModelRequestRateLimit 检查用户的模型请求限流，未通过时写入错误响应并返回 false。
+// 通过时返回的函数需要在请求处理完成后调用，用于记录成功请求；不经过中间件链调用时（如批处理）使用
+func AcquireModelRequestRateLimit(c *gin.Context) (func(), bool) {
+	// 在每个请求时检查是否启用限流
+	if !setting.ModelRequestRateLimitEnabled {
+		return func() {}, true
+	}
+
+	// 计算限流参数
+	duration := int64(setting.ModelRequestRateLimitDurationMinutes * 60)
+	totalMaxCount := setting.ModelRequestRateLimitCount
+	successMaxCount := setting.ModelRequestRateLimitSuccessCount
+
+	// 获取分组
+	group := common.GetContextKeyString(c, constant.ContextKeyTokenGroup)
+	if group == "" {
+		group = common.GetContextKeyString(c, constant.ContextKeyUserGroup)
 	}
+
+	//获取分组的限流配置
+	groupTotalCount, groupSuccessCount, found := setting.GetGroupRateLimit(group)
+	if found {
+		totalMaxCount = groupTotalCount
+		successMaxCount = groupSuccessCount
+	}
+
+	// 根据存储类型选择并执行限流检查
+	if common.RedisEnabled {
+		return checkRedisModelRateLimit(c, duration, totalMaxCount, successMaxCount)
+	}
+	return checkMemoryModelRateLimit(c, duration, totalMaxCount, successMaxCount)
 }
 
 // ModelRequestRateLimit 模型请求限流中间件
 func ModelRequestRateLimit() func(c *gin.Context) {
 	return func(c *gin.Context) {
-		// 在每个请求时检查是否启用限流
-		if !setting.ModelRequestRateLimitEnabled {
-			c.Next()
+		recordSuccess, ok := AcquireModelRequestRateLimit(c)
+		if !ok {
 			return
 		}
-
-		// 计算限流参数
-		duration := int64(setting.ModelRequestRateLimitDurationMinutes * 60)
-		totalMaxCount := setting.ModelRequestRateLimitCount
-		successMaxCount := setting.ModelRequestRateLimitSuccessCount
-
-		// 获取分组
-		group := common.GetContextKeyString(c, constant.ContextKeyTokenGroup)
-		if group == "" {
-			group = common.GetContextKeyString(c, constant.ContextKeyUserGroup)
-		}
-
-		//获取分组的限流配置
-		groupTotalCount, groupSuccessCount, found := setting.GetGroupRateLimit(group)
-		if found {
-			totalMaxCount = groupTotalCount
-			successMaxCount = groupSuccessCount
-		}
-
-		// 根据存储类型选择并执行限流处理器
-		if common.RedisEnabled {
-			redisRateLimitHandler(duration, totalMaxCount, successMaxCount)(c)
-		} else {
-			memoryRateLimitHandler(duration, totalMaxCount, successMaxCount)(c)
-		}
+		c.Next()
+		recordSuccess()
 	}
 }
@@ -3,6 +3,8 @@ package middleware
 import (
 	"sync/atomic"
 
+	"github.com/QuantumNous/new-api/common/metrics"
+
 	"github.com/gin-gonic/gin"
 )
 
@@ -13,6 +15,12 @@ type HTTPStats struct {
 
 var globalStats = &HTTPStats{}
 
+func init() {
+	metrics.RegisterGaugeFunc("active_connections", "Relay requests in progress.", func() float64 {
+		return float64(atomic.LoadInt64(&globalStats.activeConnections))
+	})
+}
+
 // StatsMiddleware 统计中间件
 func StatsMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
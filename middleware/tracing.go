@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"fmt"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/tracing"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Tracing 为每个请求创建根 span，并继承调用方传入的 traceparent
+func Tracing() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		span := tracing.StartServer(c, c.Request.Method+" "+c.FullPath(),
+			attribute.String("http.request.method", c.Request.Method),
+			attribute.String("url.path", c.Request.URL.Path),
+			attribute.String("newapi.request_id", c.GetString(common.RequestIdKey)),
+		)
+		c.Next()
+		status := c.Writer.Status()
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= 500 {
+			span.End(fmt.Errorf("http status %d", status))
+			return
+		}
+		span.End(nil)
+	}
+}
@@ -0,0 +1,146 @@
+package model
+
+import (
+	"database/sql/driver"
+	"errors"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+const (
+	BatchStatusValidating = "validating"
+	BatchStatusFailed     = "failed"
+	BatchStatusInProgress = "in_progress"
+	BatchStatusFinalizing = "finalizing"
+	BatchStatusCompleted  = "completed"
+	BatchStatusExpired    = "expired"
+	BatchStatusCancelling = "cancelling"
+	BatchStatusCancelled  = "cancelled"
+)
+
+// BatchMetadata 用户提交的 metadata，原样返回
+type BatchMetadata map[string]string
+
+func (m BatchMetadata) Value() (driver.Value, error) {
+	return common.Marshal(m)
+}
+
+func (m *BatchMetadata) Scan(value interface{}) error {
+	var bytesValue []byte
+	switch v := value.(type) {
+	case []byte:
+		bytesValue = v
+	case string:
+		bytesValue = []byte(v)
+	}
+	if len(bytesValue) == 0 {
+		*m = nil
+		return nil
+	}
+	return common.Unmarshal(bytesValue, m)
+}
+
+// Batch 通过 /v1/batches 提交的批处理任务，输入输出均为 File
+type Batch struct {
+	Id               int64         `json:"id"`
+	BatchId          string        `json:"batch_id" gorm:"type:varchar(64);uniqueIndex"`
+	UserId           int           `json:"user_id" gorm:"index"`
+	TokenId          int           `json:"token_id" gorm:"index"`
+	Endpoint         string        `json:"endpoint" gorm:"type:varchar(64)"`
+	InputFileId      string        `json:"input_file_id" gorm:"type:varchar(64)"`
+	OutputFileId     string        `json:"output_file_id" gorm:"type:varchar(64)"`
+	ErrorFileId      string        `json:"error_file_id" gorm:"type:varchar(64)"`
+	CompletionWindow string        `json:"completion_window" gorm:"type:varchar(16)"`
+	Status           string        `json:"status" gorm:"type:varchar(20);index"`
+	Errors           string        `json:"errors" gorm:"type:text"` // 校验/执行失败原因，dto.BatchError 列表的 JSON
+	RequestTotal     int           `json:"request_total"`
+	RequestCompleted int           `json:"request_completed"`
+	RequestFailed    int           `json:"request_failed"`
+	Quota            int           `json:"quota"`
+	Metadata         BatchMetadata `json:"metadata" gorm:"type:text"`
+	CreatedAt        int64         `json:"created_at" gorm:"bigint;index"`
+	InProgressAt     int64         `json:"in_progress_at" gorm:"bigint"`
+	ExpiresAt        int64         `json:"expires_at" gorm:"bigint"`
+	FinalizingAt     int64         `json:"finalizing_at" gorm:"bigint"`
+	CompletedAt      int64         `json:"completed_at" gorm:"bigint"`
+	FailedAt         int64         `json:"failed_at" gorm:"bigint"`
+	ExpiredAt        int64         `json:"expired_at" gorm:"bigint"`
+	CancellingAt     int64         `json:"cancelling_at" gorm:"bigint"`
+	CancelledAt      int64         `json:"cancelled_at" gorm:"bigint"`
+}
+
+func (batch *Batch) Insert() error {
+	if batch.CreatedAt == 0 {
+		batch.CreatedAt = common.GetTimestamp()
+	}
+	return DB.Create(batch).Error
+}
+
+func (batch *Batch) Update() error {
+	return DB.Save(batch).Error
+}
+
+// UpdateStatusFrom 仅当当前状态为 from 时才更新，返回是否更新成功，用于多节点下抢占任务
+func (batch *Batch) UpdateStatusFrom(from string, updates map[string]any) (bool, error) {
+	result := DB.Model(&Batch{}).Where("id = ? and status = ?", batch.Id, from).Updates(updates)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// UpdateProgress 更新执行进度，不覆盖状态字段
+func (batch *Batch) UpdateProgress() error {
+	return DB.Model(&Batch{}).Where("id = ?", batch.Id).Updates(map[string]any{
+		"request_completed": batch.RequestCompleted,
+		"request_failed":    batch.RequestFailed,
+		"quota":             batch.Quota,
+	}).Error
+}
+
+func GetBatchStatus(id int64) (string, error) {
+	var batch Batch
+	err := DB.Select("status").Where("id = ?", id).First(&batch).Error
+	return batch.Status, err
+}
+
+func GetUserBatchByBatchId(userId int, batchId string) (*Batch, error) {
+	if batchId == "" {
+		return nil, errors.New("batch id is empty")
+	}
+	var batch Batch
+	err := DB.Where("user_id = ? and batch_id = ?", userId, batchId).First(&batch).Error
+	if err != nil {
+		return nil, err
+	}
+	return &batch, nil
+}
+
+// GetUserBatches 按 OpenAI 列表接口语义分页查询，after 为上一页最后一个 batch id
+func GetUserBatches(userId int, after string, limit int) ([]*Batch, error) {
+	var batches []*Batch
+	query := DB.Where("user_id = ?", userId)
+	if after != "" {
+		afterBatch, err := GetUserBatchByBatchId(userId, after)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("id < ?", afterBatch.Id)
+	}
+	err := query.Order("id desc").Limit(limit).Find(&batches).Error
+	return batches, err
+}
+
+// GetPendingBatches 获取等待执行的批处理任务，按提交顺序
+func GetPendingBatches(limit int) ([]*Batch, error) {
+	var batches []*Batch
+	err := DB.Where("status = ?", BatchStatusValidating).Order("id asc").Limit(limit).Find(&batches).Error
+	return batches, err
+}
+
+// GetUnfinishedBatches 获取执行中断（如服务重启）的批处理任务
+func GetUnfinishedBatches() ([]*Batch, error) {
+	var batches []*Batch
+	err := DB.Where("status in (?)", []string{BatchStatusInProgress, BatchStatusFinalizing, BatchStatusCancelling}).Find(&batches).Error
+	return batches, err
+}
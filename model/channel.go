@@ -54,7 +54,8 @@ type Channel struct {
 	OtherSettings string `json:"settings" gorm:"column:settings"` // 其他设置，存储azure版本等不需要检索的信息，详见dto.ChannelOtherSettings
 
 	// cache info
-	Keys []string `json:"-" gorm:"-"`
+	Keys       []string           `json:"-" gorm:"-"`
+	rateLimits *channelRateLimits // 解析后的速率限制设置，仅缓存中的渠道有值
 }
 
 type ChannelInfo struct {
@@ -64,6 +65,7 @@ type ChannelInfo struct {
 	MultiKeyDisabledReason map[int]string        `json:"multi_key_disabled_reason,omitempty"` // key禁用原因列表，key index -> reason
 	MultiKeyDisabledTime   map[int]int64         `json:"multi_key_disabled_time,omitempty"`   // key禁用时间列表，key index -> time
 	MultiKeyPollingIndex   int                   `json:"multi_key_polling_index"`             // 多Key模式下轮询的key索引
+	MultiKeyWeights        map[int]int           `json:"multi_key_weights,omitempty"`         // key权重列表，key index -> weight，未设置时为1
 	MultiKeyMode           constant.MultiKeyMode `json:"multi_key_mode"`
 }
 
@@ -103,6 +105,11 @@ func (channel *Channel) GetKeys() []string {
 }
 
 func (channel *Channel) GetNextEnabledKey() (string, int, *types.NewAPIError) {
+	return channel.GetNextEnabledKeyWithAffinity("")
+}
+
+// GetNextEnabledKeyWithAffinity affinity 用于 sticky 模式，相同的 affinity（如用户 ID）尽量选择同一个 Key
+func (channel *Channel) GetNextEnabledKeyWithAffinity(affinity string) (string, int, *types.NewAPIError) {
 	// If not in multi-key mode, return the original key string directly.
 	if !channel.ChannelInfo.IsMultiKey {
 		return channel.Key, 0, nil
@@ -138,15 +145,40 @@ func (channel *Channel) GetNextEnabledKey() (string, int, *types.NewAPIError) {
 			enabledIdx = append(enabledIdx, i)
 		}
 	}
+	// 熔断器半开状态的 Key 按比例放行真实请求
+	if idx, ok := pickHalfOpenKey(channel.Id, len(keys), getStatus); ok {
+		return keys[idx], idx, nil
+	}
+
 	// If no specific status list or none enabled, fall back to first key
 	if len(enabledIdx) == 0 {
 		return keys[0], 0, nil
 	}
 
+	// 跳过已达到 RPM/TPM/并发限制的 Key，全部达到限制时不再区分
+	saturatedKeys := saturatedChannelKeys(channel, len(keys))
+	availableIdx := enabledIdx
+	if len(saturatedKeys) > 0 {
+		availableIdx = make([]int, 0, len(enabledIdx))
+		for _, idx := range enabledIdx {
+			if !saturatedKeys[idx] {
+				availableIdx = append(availableIdx, idx)
+			}
+		}
+		if len(availableIdx) == 0 {
+			availableIdx = enabledIdx
+			saturatedKeys = nil
+		}
+	}
+
+	if selectedIdx, ok := channel.pickKeyByMode(availableIdx, affinity); ok {
+		return keys[selectedIdx], selectedIdx, nil
+	}
+
 	switch channel.ChannelInfo.MultiKeyMode {
 	case constant.MultiKeyModeRandom:
 		// Randomly pick one enabled key
-		selectedIdx := enabledIdx[rand.Intn(len(enabledIdx))]
+		selectedIdx := availableIdx[rand.Intn(len(availableIdx))]
 		return keys[selectedIdx], selectedIdx, nil
 	case constant.MultiKeyModePolling:
 		// Use channel-specific lock to ensure thread-safe polling
@@ -173,18 +205,33 @@ func (channel *Channel) GetNextEnabledKey() (string, int, *types.NewAPIError) {
 		}
 		for i := 0; i < len(keys); i++ {
 			idx := (start + i) % len(keys)
-			if getStatus(idx) == common.ChannelStatusEnabled {
+			if getStatus(idx) == common.ChannelStatusEnabled && !saturatedKeys[idx] {
 				// update polling index for next call (point to the next position)
 				channel.ChannelInfo.MultiKeyPollingIndex = (idx + 1) % len(keys)
 				return keys[idx], idx, nil
 			}
 		}
 		// Fallback – should not happen, but return first enabled key
-		return keys[enabledIdx[0]], enabledIdx[0], nil
+		return keys[availableIdx[0]], availableIdx[0], nil
 	default:
 		// Unknown mode, default to first enabled key (or original key string)
-		return keys[enabledIdx[0]], enabledIdx[0], nil
+		return keys[availableIdx[0]], availableIdx[0], nil
+	}
+}
+
+// GetEnabledKeyByIndex 会话粘性指定的 Key 仍启用且未达到限制或冷却时返回该 Key
+func (channel *Channel) GetEnabledKeyByIndex(index int) (string, bool) {
+	if !channel.ChannelInfo.IsMultiKey {
+		return "", false
+	}
+	keys := channel.GetKeys()
+	if index < 0 || index >= len(keys) || getKeyStatus(channel, index) != common.ChannelStatusEnabled {
+		return "", false
 	}
+	if saturatedChannelKeys(channel, len(keys))[index] {
+		return "", false
+	}
+	return keys[index], true
 }
 
 func (channel *Channel) SaveChannelInfo() error {
@@ -578,6 +625,10 @@ func handlerMultiKeyUpdate(channel *Channel, usingKey string, status int, reason
 		}
 		if status == common.ChannelStatusEnabled {
 			delete(channel.ChannelInfo.MultiKeyStatusList, keyIndex)
+			// 所有 Key 被禁用导致渠道自动禁用时，Key 恢复后重新启用渠道
+			if channel.Status == common.ChannelStatusAutoDisabled {
+				channel.Status = common.ChannelStatusEnabled
+			}
 		} else {
 			channel.ChannelInfo.MultiKeyStatusList[keyIndex] = status
 			if channel.ChannelInfo.MultiKeyDisabledReason == nil {
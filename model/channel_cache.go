@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
@@ -12,6 +13,7 @@ import (
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
 	"github.com/QuantumNous/new-api/setting/ratio_setting"
 
 	"github.com/gin-gonic/gin"
@@ -29,6 +31,7 @@ func InitChannelCache() {
 	var channels []*Channel
 	DB.Find(&channels)
 	for _, channel := range channels {
+		channel.rateLimits = parseChannelRateLimits(channel)
 		newChannelId2channel[channel.Id] = channel
 	}
 	var abilities []*Ability
@@ -96,7 +99,16 @@ func SyncChannelCache(frequency int) {
 	}
 }
 
-func CacheGetRandomSatisfiedChannel(c *gin.Context, group string, model string, retry int) (*Channel, string, error) {
+// CacheGetRandomSatisfiedChannel 选择渠道并通过 acquire 占用其速率限制额度，占用失败时重新选择，所有渠道均达到限制时按设置排队等待
+func CacheGetRandomSatisfiedChannel(c *gin.Context, group string, model string, retry int, acquire ChannelAcquireFunc) (*Channel, string, error) {
+	return waitForSaturatedChannel(c, func() (*Channel, string, error) {
+		return acquireSelectedChannel(acquire, func() (*Channel, string, error) {
+			return cacheGetRandomSatisfiedChannel(c, group, model, retry)
+		})
+	})
+}
+
+func cacheGetRandomSatisfiedChannel(c *gin.Context, group string, model string, retry int) (*Channel, string, error) {
 	var channel *Channel
 	var err error
 	selectGroup := group
@@ -104,12 +116,16 @@ func CacheGetRandomSatisfiedChannel(c *gin.Context, group string, model string,
 		if len(setting.AutoGroups) == 0 {
 			return nil, selectGroup, errors.New("auto groups is not enabled")
 		}
+		saturated := false
 		for _, autoGroup := range setting.AutoGroups {
 			if common.DebugEnabled {
 				println("autoGroup:", autoGroup)
 			}
-			channel, _ = getRandomSatisfiedChannel(autoGroup, model, retry)
+			channel, err = getRandomSatisfiedChannel(autoGroup, model, retry)
 			if channel == nil {
+				if errors.Is(err, ErrChannelsSaturated) {
+					saturated = true
+				}
 				continue
 			} else {
 				c.Set("auto_group", autoGroup)
@@ -120,6 +136,9 @@ func CacheGetRandomSatisfiedChannel(c *gin.Context, group string, model string,
 				break
 			}
 		}
+		if channel == nil && saturated {
+			return nil, selectGroup, ErrChannelsSaturated
+		}
 	} else {
 		channel, err = getRandomSatisfiedChannel(group, model, retry)
 		if err != nil {
@@ -132,7 +151,29 @@ func CacheGetRandomSatisfiedChannel(c *gin.Context, group string, model string,
 func getRandomSatisfiedChannel(group string, model string, retry int) (*Channel, error) {
 	// if memory cache is disabled, get channel directly from database
 	if !common.MemoryCacheEnabled {
-		return GetRandomSatisfiedChannel(group, model, retry)
+		// 熔断器半开状态的渠道按比例放行首次请求，重试时不再选择
+		if retry == 0 {
+			if channel := pickHalfOpenChannel(group, model, getChannelFromDB); channel != nil {
+				return channel, nil
+			}
+		}
+		channels, channelM, err := getSatisfiedChannelsFromDB(group, model)
+		if err != nil {
+			return nil, err
+		}
+		if len(channels) == 0 {
+			return nil, nil
+		}
+		getChannel := func(id int) (*Channel, bool) {
+			channel, ok := channelM[id]
+			return channel, ok
+		}
+		// 跳过已达到 RPM/TPM/并发限制的渠道
+		channels, err = filterSaturatedChannels(channels, getChannel)
+		if err != nil {
+			return nil, err
+		}
+		return selectSatisfiedChannel(group, model, retry, channels, getChannel)
 	}
 
 	channelSyncLock.RLock()
@@ -147,12 +188,80 @@ func getRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 		channels = group2model2channels[group][normalizedModel]
 	}
 
+	// 熔断器半开状态的渠道按比例放行首次请求，重试时不再选择
+	if retry == 0 {
+		if channel := pickHalfOpenChannel(group, model, getCachedChannel); channel != nil {
+			return channel, nil
+		}
+	}
+
 	if len(channels) == 0 {
 		return nil, nil
 	}
 
+	// 跳过已达到 RPM/TPM/并发限制的渠道
+	channels, err := filterSaturatedChannels(channels, getCachedChannel)
+	if err != nil {
+		return nil, err
+	}
+
+	return selectSatisfiedChannel(group, model, retry, channels, getCachedChannel)
+}
+
+// getCachedChannel 调用方需持有 channelSyncLock
+func getCachedChannel(id int) (*Channel, bool) {
+	channel, ok := channelsIDM[id]
+	return channel, ok
+}
+
+func getChannelFromDB(id int) (*Channel, bool) {
+	channel, err := GetChannelById(id, true)
+	return channel, err == nil
+}
+
+// getSatisfiedChannelsFromDB 未启用内存缓存时从数据库读取分组和模型下启用的渠道，按优先级从高到低排序
+func getSatisfiedChannelsFromDB(group string, model string) ([]int, map[int]*Channel, error) {
+	var channelIds []int
+	err := DB.Model(&Ability{}).Where(commonGroupCol+" = ? and model = ? and enabled = ?", group, model, true).Pluck("channel_id", &channelIds).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(channelIds) == 0 {
+		normalizedModel := ratio_setting.FormatMatchingModelName(model)
+		if normalizedModel != model {
+			err = DB.Model(&Ability{}).Where(commonGroupCol+" = ? and model = ? and enabled = ?", group, normalizedModel, true).Pluck("channel_id", &channelIds).Error
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	if len(channelIds) == 0 {
+		return nil, nil, nil
+	}
+	var channels []*Channel
+	if err := DB.Where("id in ? and status = ?", channelIds, common.ChannelStatusEnabled).Find(&channels).Error; err != nil {
+		return nil, nil, err
+	}
+	sort.Slice(channels, func(i, j int) bool {
+		return channels[i].GetPriority() > channels[j].GetPriority()
+	})
+	ids := make([]int, 0, len(channels))
+	channelM := make(map[int]*Channel, len(channels))
+	for _, channel := range channels {
+		// 熔断中的渠道只通过半开试探放行
+		if isChannelCircuitBroken(channel.Id) {
+			continue
+		}
+		ids = append(ids, channel.Id)
+		channelM[channel.Id] = channel
+	}
+	return ids, channelM, nil
+}
+
+// selectSatisfiedChannel 按重试次数选择优先级，在该优先级的渠道中按权重（启用健康感知选择时按健康权重）随机选择
+func selectSatisfiedChannel(group string, model string, retry int, channels []int, getChannel func(id int) (*Channel, bool)) (*Channel, error) {
 	if len(channels) == 1 {
-		if channel, ok := channelsIDM[channels[0]]; ok {
+		if channel, ok := getChannel(channels[0]); ok {
 			return channel, nil
 		}
 		return nil, fmt.Errorf("数据库一致性错误，渠道# %d 不存在，请联系管理员修复", channels[0])
@@ -160,7 +269,7 @@ func getRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 
 	uniquePriorities := make(map[int]bool)
 	for _, channelId := range channels {
-		if channel, ok := channelsIDM[channelId]; ok {
+		if channel, ok := getChannel(channelId); ok {
 			uniquePriorities[int(channel.GetPriority())] = true
 		} else {
 			return nil, fmt.Errorf("数据库一致性错误，渠道# %d 不存在，请联系管理员修复", channelId)
@@ -180,7 +289,7 @@ func getRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 	// get the priority for the given retry number
 	var targetChannels []*Channel
 	for _, channelId := range channels {
-		if channel, ok := channelsIDM[channelId]; ok {
+		if channel, ok := getChannel(channelId); ok {
 			if channel.GetPriority() == targetPriority {
 				targetChannels = append(targetChannels, channel)
 			}
@@ -191,6 +300,14 @@ func getRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 
 	// 平滑系数
 	smoothingFactor := 10
+
+	// 健康感知选择：根据近期成功率和延迟调整权重
+	if operation_setting.IsAdaptiveSelectionEnabled(group, model) {
+		if channel := pickAdaptiveChannel(targetChannels, smoothingFactor); channel != nil {
+			return channel, nil
+		}
+	}
+
 	// Calculate the total weight of all channels up to endIdx
 	totalWeight := 0
 	for _, channel := range targetChannels {
@@ -280,6 +397,108 @@ func CacheUpdateChannel(channel *Channel) {
 	println("CacheUpdateChannel:", channel.Id, channel.Name, channel.Status, channel.ChannelInfo.MultiKeyPollingIndex)
 
 	println("before:", channelsIDM[channel.Id].ChannelInfo.MultiKeyPollingIndex)
+	channel.rateLimits = parseChannelRateLimits(channel)
 	channelsIDM[channel.Id] = channel
 	println("after :", channelsIDM[channel.Id].ChannelInfo.MultiKeyPollingIndex)
 }
+
+// isChannelAvailable 渠道未处于熔断中，也没有因健康统计异常被临时剔除，会话粘性和对冲请求不选择不可用的渠道
+func isChannelAvailable(channelId int) bool {
+	return !isChannelCircuitBroken(channelId) && !isChannelEjected(channelId)
+}
+
+// CacheGetStickyChannel 会话粘性绑定的渠道仍启用、可用于该分组和模型、未熔断且未达到限制时返回该渠道，否则返回 nil
+func CacheGetStickyChannel(group string, model string, channelId int) *Channel {
+	if !common.MemoryCacheEnabled {
+		var count int64
+		DB.Model(&Ability{}).Where(commonGroupCol+" = ? and model = ? and channel_id = ? and enabled = ?", group, model, channelId, true).Count(&count)
+		if count == 0 {
+			return nil
+		}
+		if !isChannelAvailable(channelId) {
+			return nil
+		}
+		channel, err := GetChannelById(channelId, true)
+		if err != nil || channel.Status != common.ChannelStatusEnabled || isChannelSaturated(channel) {
+			return nil
+		}
+		return channel
+	}
+
+	channelSyncLock.RLock()
+	defer channelSyncLock.RUnlock()
+	channels := group2model2channels[group][model]
+	if len(channels) == 0 {
+		channels = group2model2channels[group][ratio_setting.FormatMatchingModelName(model)]
+	}
+	if !slices.Contains(channels, channelId) {
+		return nil
+	}
+	channel, ok := channelsIDM[channelId]
+	if !ok || !isChannelAvailable(channelId) || isChannelSaturated(channel) {
+		return nil
+	}
+	return channel
+}
+
+// CacheGetHedgeChannel 为对冲请求选择与指定渠道同一优先级的另一个未熔断且未达到限制的渠道，并通过 acquire 占用其额度，没有可用渠道时返回 nil
+func CacheGetHedgeChannel(group string, model string, channelId int, acquire ChannelAcquireFunc) *Channel {
+	var candidates []*Channel
+	if !common.MemoryCacheEnabled {
+		channel, err := GetChannelById(channelId, true)
+		if err != nil {
+			return nil
+		}
+		channels, channelM, err := getSatisfiedChannelsFromDB(group, model)
+		if err != nil {
+			return nil
+		}
+		for _, id := range channels {
+			candidate := channelM[id]
+			if id == channelId || candidate.GetPriority() != channel.GetPriority() || !isChannelAvailable(id) || isChannelSaturated(candidate) {
+				continue
+			}
+			candidates = append(candidates, candidate)
+		}
+	} else {
+		channelSyncLock.RLock()
+		channel, ok := channelsIDM[channelId]
+		if !ok {
+			channelSyncLock.RUnlock()
+			return nil
+		}
+		channels := group2model2channels[group][model]
+		if len(channels) == 0 {
+			channels = group2model2channels[group][ratio_setting.FormatMatchingModelName(model)]
+		}
+		for _, id := range channels {
+			candidate, ok := channelsIDM[id]
+			if !ok || id == channelId || candidate.GetPriority() != channel.GetPriority() || !isChannelAvailable(id) || isChannelSaturated(candidate) {
+				continue
+			}
+			candidates = append(candidates, candidate)
+		}
+		channelSyncLock.RUnlock()
+	}
+	for len(candidates) > 0 {
+		totalWeight := 0
+		for _, candidate := range candidates {
+			totalWeight += candidate.GetWeight() + 10
+		}
+		randomWeight := rand.Intn(totalWeight)
+		picked := len(candidates) - 1
+		for i, candidate := range candidates {
+			randomWeight -= candidate.GetWeight() + 10
+			if randomWeight < 0 {
+				picked = i
+				break
+			}
+		}
+		if acquire == nil || acquire(candidates[picked]) {
+			return candidates[picked]
+		}
+		// 额度已被并发请求占满，换下一个候选渠道
+		candidates = append(candidates[:picked], candidates[picked+1:]...)
+	}
+	return nil
+}
@@ -0,0 +1,619 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/setting/ratio_setting"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 渠道熔断器：渠道（或多 Key 渠道中的 Key）被自动禁用时进入 open 状态，熔断时间结束后进入 half-open 状态，
+// 放行少量真实请求或主动测试，成功后关闭熔断并自动启用，失败则以指数退避重新熔断。
+// closed 状态不单独保存，没有熔断记录即为 closed。
+// 启用 Redis 时熔断记录保存在 Redis 中，各节点的状态变更在 Redis 事务中完成，试探名额在所有节点间只有一个；
+// 内存中的记录作为本节点的副本用于请求路径上的快速判断，定期从 Redis 同步。
+
+const (
+	CircuitBreakerStateOpen     = "open"
+	CircuitBreakerStateHalfOpen = "half_open"
+
+	// CircuitBreakerChannelLevel 表示整个渠道的熔断器，多 Key 渠道按 Key 索引分别熔断
+	CircuitBreakerChannelLevel = -1
+)
+
+// 半开状态下的试探请求超过该时间没有结果时，允许发起新的试探
+const circuitBreakerTrialTimeout = 5 * 60
+
+type circuitBreakerKey struct {
+	channelId int
+	keyIndex  int
+}
+
+type channelCircuitBreaker struct {
+	openCount int
+	openUntil int64
+	successes int
+	trialAt   int64 // 正在进行的试探请求开始时间，0 表示没有
+	reason    string
+}
+
+type ChannelCircuitBreakerStatus struct {
+	ChannelId int    `json:"channel_id"`
+	KeyIndex  int    `json:"key_index"`
+	State     string `json:"state"`
+	OpenCount int    `json:"open_count"`
+	OpenUntil int64  `json:"open_until"`
+	Successes int    `json:"successes"`
+	Reason    string `json:"reason"`
+}
+
+type ChannelCircuitBreakerProbe struct {
+	Channel  *Channel
+	KeyIndex int
+	Key      string
+}
+
+var circuitBreakers = make(map[circuitBreakerKey]*channelCircuitBreaker)
+var circuitBreakerLock sync.Mutex
+
+const (
+	circuitBreakerRedisPrefix   = "channel_circuit_breaker:"
+	circuitBreakerRedisIndexKey = "channel_circuit_breakers"
+	// Redis 事务因并发修改失败时的最大重试次数
+	circuitBreakerRedisRetries = 10
+)
+
+type circuitBreakerAction int
+
+const (
+	circuitBreakerKeep circuitBreakerAction = iota
+	circuitBreakerSave
+	circuitBreakerDelete
+)
+
+func (k circuitBreakerKey) member() string {
+	return fmt.Sprintf("%d:%d", k.channelId, k.keyIndex)
+}
+
+func (k circuitBreakerKey) redisKey() string {
+	return circuitBreakerRedisPrefix + k.member()
+}
+
+func parseCircuitBreakerMember(member string) (circuitBreakerKey, bool) {
+	channelPart, keyPart, ok := strings.Cut(member, ":")
+	if !ok {
+		return circuitBreakerKey{}, false
+	}
+	channelId, err1 := strconv.Atoi(channelPart)
+	keyIndex, err2 := strconv.Atoi(keyPart)
+	if err1 != nil || err2 != nil {
+		return circuitBreakerKey{}, false
+	}
+	return circuitBreakerKey{channelId: channelId, keyIndex: keyIndex}, true
+}
+
+func (b *channelCircuitBreaker) toRedisFields() map[string]interface{} {
+	return map[string]interface{}{
+		"open_count": b.openCount,
+		"open_until": b.openUntil,
+		"successes":  b.successes,
+		"trial_at":   b.trialAt,
+		"reason":     b.reason,
+	}
+}
+
+func circuitBreakerFromRedisFields(fields map[string]string) *channelCircuitBreaker {
+	b := &channelCircuitBreaker{reason: fields["reason"]}
+	b.openCount, _ = strconv.Atoi(fields["open_count"])
+	b.openUntil, _ = strconv.ParseInt(fields["open_until"], 10, 64)
+	b.successes, _ = strconv.Atoi(fields["successes"])
+	b.trialAt, _ = strconv.ParseInt(fields["trial_at"], 10, 64)
+	return b
+}
+
+// updateCircuitBreaker 对单个熔断器做一次原子的读改写，update 收到当前记录（不存在时为新建的空记录），
+// 返回是否保存或删除。启用 Redis 时在事务中执行，冲突时重试，update 可能被调用多次，不能有副作用
+func updateCircuitBreaker(key circuitBreakerKey, update func(b *channelCircuitBreaker, exists bool) circuitBreakerAction) error {
+	if !common.RedisEnabled {
+		circuitBreakerLock.Lock()
+		defer circuitBreakerLock.Unlock()
+		breaker, exists := circuitBreakers[key]
+		if !exists {
+			breaker = &channelCircuitBreaker{}
+		}
+		switch update(breaker, exists) {
+		case circuitBreakerSave:
+			circuitBreakers[key] = breaker
+		case circuitBreakerDelete:
+			delete(circuitBreakers, key)
+		}
+		return nil
+	}
+
+	ctx := context.Background()
+	for i := 0; i < circuitBreakerRedisRetries; i++ {
+		var breaker *channelCircuitBreaker
+		var action circuitBreakerAction
+		err := common.RDB.Watch(ctx, func(tx *redis.Tx) error {
+			fields, err := tx.HGetAll(ctx, key.redisKey()).Result()
+			if err != nil {
+				return err
+			}
+			exists := len(fields) > 0
+			breaker = &channelCircuitBreaker{}
+			if exists {
+				breaker = circuitBreakerFromRedisFields(fields)
+			}
+			action = update(breaker, exists)
+			if action == circuitBreakerKeep {
+				return nil
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				if action == circuitBreakerSave {
+					pipe.HSet(ctx, key.redisKey(), breaker.toRedisFields())
+					pipe.SAdd(ctx, circuitBreakerRedisIndexKey, key.member())
+				} else {
+					pipe.Del(ctx, key.redisKey())
+					pipe.SRem(ctx, circuitBreakerRedisIndexKey, key.member())
+				}
+				return nil
+			})
+			return err
+		}, key.redisKey())
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		circuitBreakerLock.Lock()
+		switch action {
+		case circuitBreakerSave:
+			circuitBreakers[key] = breaker
+		case circuitBreakerDelete:
+			delete(circuitBreakers, key)
+		}
+		circuitBreakerLock.Unlock()
+		return nil
+	}
+	return fmt.Errorf("circuit breaker of channel #%d (key index %d) is being modified concurrently", key.channelId, key.keyIndex)
+}
+
+// SyncChannelCircuitBreakers 从 Redis 同步熔断记录到本节点，未启用 Redis 时不需要同步
+func SyncChannelCircuitBreakers() {
+	if !common.RedisEnabled {
+		return
+	}
+	ctx := context.Background()
+	members, err := common.RDB.SMembers(ctx, circuitBreakerRedisIndexKey).Result()
+	if err != nil {
+		common.SysError("failed to load circuit breakers from redis: " + err.Error())
+		return
+	}
+	keys := make([]circuitBreakerKey, 0, len(members))
+	cmds := make([]*redis.StringStringMapCmd, 0, len(members))
+	pipe := common.RDB.Pipeline()
+	for _, member := range members {
+		key, ok := parseCircuitBreakerMember(member)
+		if !ok {
+			continue
+		}
+		keys = append(keys, key)
+		cmds = append(cmds, pipe.HGetAll(ctx, key.redisKey()))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		common.SysError("failed to load circuit breakers from redis: " + err.Error())
+		return
+	}
+	loaded := make(map[circuitBreakerKey]*channelCircuitBreaker, len(keys))
+	for i, key := range keys {
+		fields, err := cmds[i].Result()
+		if err != nil || len(fields) == 0 {
+			// 记录已被其他节点删除，索引稍后清理
+			common.RDB.SRem(ctx, circuitBreakerRedisIndexKey, key.member())
+			continue
+		}
+		loaded[key] = circuitBreakerFromRedisFields(fields)
+	}
+	circuitBreakerLock.Lock()
+	circuitBreakers = loaded
+	circuitBreakerLock.Unlock()
+}
+
+func (b *channelCircuitBreaker) state(now int64) string {
+	if now < b.openUntil {
+		return CircuitBreakerStateOpen
+	}
+	return CircuitBreakerStateHalfOpen
+}
+
+func (b *channelCircuitBreaker) open(now int64, reason string) int64 {
+	setting := operation_setting.GetCircuitBreakerSetting()
+	duration := int64(setting.OpenSeconds) << min(b.openCount, 16)
+	if setting.MaxOpenSeconds > 0 && duration > int64(setting.MaxOpenSeconds) {
+		duration = int64(setting.MaxOpenSeconds)
+	}
+	b.openCount++
+	b.openUntil = now + duration
+	b.successes = 0
+	b.trialAt = 0
+	b.reason = reason
+	return duration
+}
+
+// tryAcquireTrial 半开状态下同一时间只放行一个试探请求
+func (b *channelCircuitBreaker) tryAcquireTrial(now int64) bool {
+	if b.state(now) != CircuitBreakerStateHalfOpen {
+		return false
+	}
+	if b.trialAt != 0 && now-b.trialAt < circuitBreakerTrialTimeout {
+		return false
+	}
+	b.trialAt = now
+	return true
+}
+
+func circuitBreakerKeyIndex(channel *Channel, usingKey string) int {
+	if !channel.ChannelInfo.IsMultiKey {
+		return CircuitBreakerChannelLevel
+	}
+	for i, key := range channel.GetKeys() {
+		if key == usingKey {
+			return i
+		}
+	}
+	return CircuitBreakerChannelLevel
+}
+
+// hasCircuitBreakers 用于请求路径上的快速判断，避免每次请求都查询渠道
+func hasCircuitBreakers() bool {
+	if !operation_setting.GetCircuitBreakerSetting().Enabled {
+		return false
+	}
+	circuitBreakerLock.Lock()
+	defer circuitBreakerLock.Unlock()
+	return len(circuitBreakers) > 0
+}
+
+func getCircuitBreakerKey(channelId int, usingKey string) (circuitBreakerKey, bool) {
+	channel, err := CacheGetChannel(channelId)
+	if err != nil {
+		return circuitBreakerKey{}, false
+	}
+	return circuitBreakerKey{channelId: channelId, keyIndex: circuitBreakerKeyIndex(channel, usingKey)}, true
+}
+
+// OpenChannelCircuitBreaker 渠道或 Key 被自动禁用后调用，已处于 open 状态时不重复计算退避
+func OpenChannelCircuitBreaker(channelId int, usingKey string, reason string) {
+	if !operation_setting.GetCircuitBreakerSetting().Enabled {
+		return
+	}
+	key, ok := getCircuitBreakerKey(channelId, usingKey)
+	if !ok {
+		return
+	}
+	now := common.GetTimestamp()
+	var duration int64
+	err := updateCircuitBreaker(key, func(breaker *channelCircuitBreaker, exists bool) circuitBreakerAction {
+		duration = 0
+		if exists && breaker.state(now) == CircuitBreakerStateOpen {
+			return circuitBreakerKeep
+		}
+		duration = breaker.open(now, reason)
+		return circuitBreakerSave
+	})
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to open circuit breaker of channel #%d (key index %d): %s", channelId, key.keyIndex, err.Error()))
+		return
+	}
+	if duration > 0 {
+		common.SysLog(fmt.Sprintf("circuit breaker of channel #%d (key index %d) is open for %d seconds: %s", channelId, key.keyIndex, duration, reason))
+	}
+}
+
+// RecordChannelCircuitBreakerResult 记录半开状态下试探请求的结果，返回 true 表示已恢复，需要启用渠道或 Key
+func RecordChannelCircuitBreakerResult(channelId int, usingKey string, success bool, reason string) bool {
+	if !hasCircuitBreakers() {
+		return false
+	}
+	key, ok := getCircuitBreakerKey(channelId, usingKey)
+	if !ok {
+		return false
+	}
+	now := common.GetTimestamp()
+	var duration int64
+	closed := false
+	err := updateCircuitBreaker(key, func(breaker *channelCircuitBreaker, exists bool) circuitBreakerAction {
+		duration, closed = 0, false
+		if !exists || breaker.state(now) != CircuitBreakerStateHalfOpen {
+			return circuitBreakerKeep
+		}
+		if !success {
+			duration = breaker.open(now, reason)
+			return circuitBreakerSave
+		}
+		breaker.trialAt = 0
+		breaker.successes++
+		if breaker.successes < operation_setting.GetCircuitBreakerSetting().RecoverySuccesses {
+			return circuitBreakerSave
+		}
+		closed = true
+		return circuitBreakerDelete
+	})
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to record circuit breaker result of channel #%d (key index %d): %s", channelId, key.keyIndex, err.Error()))
+		return false
+	}
+	if duration > 0 {
+		common.SysLog(fmt.Sprintf("circuit breaker of channel #%d (key index %d) half-open trial failed, open for %d seconds: %s", channelId, key.keyIndex, duration, reason))
+	}
+	if closed {
+		common.SysLog(fmt.Sprintf("circuit breaker of channel #%d (key index %d) is closed", channelId, key.keyIndex))
+	}
+	return closed
+}
+
+// ReleaseChannelCircuitBreakerTrial 试探请求因非渠道原因失败（如请求参数错误）时释放，允许重新试探
+func ReleaseChannelCircuitBreakerTrial(channelId int, usingKey string) {
+	if !hasCircuitBreakers() {
+		return
+	}
+	key, ok := getCircuitBreakerKey(channelId, usingKey)
+	if !ok {
+		return
+	}
+	err := updateCircuitBreaker(key, func(breaker *channelCircuitBreaker, exists bool) circuitBreakerAction {
+		if !exists || breaker.trialAt == 0 {
+			return circuitBreakerKeep
+		}
+		breaker.trialAt = 0
+		return circuitBreakerSave
+	})
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to release circuit breaker trial of channel #%d (key index %d): %s", channelId, key.keyIndex, err.Error()))
+	}
+}
+
+// CloseChannelCircuitBreaker 渠道或 Key 被启用后清除熔断记录
+func CloseChannelCircuitBreaker(channelId int, usingKey string) {
+	key, ok := getCircuitBreakerKey(channelId, usingKey)
+	if !ok {
+		return
+	}
+	removeChannelCircuitBreaker(key)
+}
+
+func removeChannelCircuitBreaker(key circuitBreakerKey) {
+	err := updateCircuitBreaker(key, func(breaker *channelCircuitBreaker, exists bool) circuitBreakerAction {
+		if !exists {
+			return circuitBreakerKeep
+		}
+		return circuitBreakerDelete
+	})
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to remove circuit breaker of channel #%d (key index %d): %s", key.channelId, key.keyIndex, err.Error()))
+	}
+}
+
+// acquireCircuitBreakerTrial 占用半开熔断器的试探名额，启用 Redis 时所有节点共用一个名额
+func acquireCircuitBreakerTrial(key circuitBreakerKey, now int64) bool {
+	acquired := false
+	err := updateCircuitBreaker(key, func(breaker *channelCircuitBreaker, exists bool) circuitBreakerAction {
+		acquired = exists && breaker.tryAcquireTrial(now)
+		if !acquired {
+			return circuitBreakerKeep
+		}
+		return circuitBreakerSave
+	})
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to acquire circuit breaker trial of channel #%d (key index %d): %s", key.channelId, key.keyIndex, err.Error()))
+		return false
+	}
+	return acquired
+}
+
+// halfOpenCircuitBreakerKeys 返回本节点记录中处于半开状态且满足 filter 的熔断器
+func halfOpenCircuitBreakerKeys(now int64, filter func(key circuitBreakerKey) bool) []circuitBreakerKey {
+	circuitBreakerLock.Lock()
+	defer circuitBreakerLock.Unlock()
+	keys := make([]circuitBreakerKey, 0)
+	for key, breaker := range circuitBreakers {
+		if breaker.state(now) == CircuitBreakerStateHalfOpen && filter(key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func GetChannelCircuitBreakers() []ChannelCircuitBreakerStatus {
+	SyncChannelCircuitBreakers()
+	circuitBreakerLock.Lock()
+	defer circuitBreakerLock.Unlock()
+	now := common.GetTimestamp()
+	result := make([]ChannelCircuitBreakerStatus, 0, len(circuitBreakers))
+	for key, breaker := range circuitBreakers {
+		result = append(result, ChannelCircuitBreakerStatus{
+			ChannelId: key.channelId,
+			KeyIndex:  key.keyIndex,
+			State:     breaker.state(now),
+			OpenCount: breaker.openCount,
+			OpenUntil: breaker.openUntil,
+			Successes: breaker.successes,
+			Reason:    breaker.reason,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].ChannelId != result[j].ChannelId {
+			return result[i].ChannelId < result[j].ChannelId
+		}
+		return result[i].KeyIndex < result[j].KeyIndex
+	})
+	return result
+}
+
+// InitChannelCircuitBreakers 为启动前已被自动禁用的渠道和 Key 建立熔断记录
+func InitChannelCircuitBreakers() {
+	if !operation_setting.GetCircuitBreakerSetting().Enabled {
+		return
+	}
+	var channels []*Channel
+	if err := DB.Omit("key").Find(&channels).Error; err != nil {
+		common.SysLog(fmt.Sprintf("failed to load channels for circuit breaker: %v", err))
+		return
+	}
+	now := common.GetTimestamp()
+	openIfAbsent := func(key circuitBreakerKey, reason string) {
+		err := updateCircuitBreaker(key, func(breaker *channelCircuitBreaker, exists bool) circuitBreakerAction {
+			if exists {
+				return circuitBreakerKeep
+			}
+			breaker.open(now, reason)
+			return circuitBreakerSave
+		})
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to init circuit breaker of channel #%d (key index %d): %s", key.channelId, key.keyIndex, err.Error()))
+		}
+	}
+	for _, channel := range channels {
+		if channel.ChannelInfo.IsMultiKey {
+			for keyIndex, status := range channel.ChannelInfo.MultiKeyStatusList {
+				if status != common.ChannelStatusAutoDisabled {
+					continue
+				}
+				openIfAbsent(circuitBreakerKey{channelId: channel.Id, keyIndex: keyIndex}, channel.ChannelInfo.MultiKeyDisabledReason[keyIndex])
+			}
+			continue
+		}
+		if channel.Status != common.ChannelStatusAutoDisabled {
+			continue
+		}
+		reason, _ := channel.GetOtherInfo()["status_reason"].(string)
+		openIfAbsent(circuitBreakerKey{channelId: channel.Id, keyIndex: CircuitBreakerChannelLevel}, reason)
+	}
+}
+
+// GetChannelCircuitBreakerProbes 返回需要主动测试的半开熔断器，并占用其试探名额；
+// 渠道或 Key 已不是自动禁用状态（如被手动启用或禁用）的熔断记录会被清除。多节点部署时只由选举出的节点调用
+func GetChannelCircuitBreakerProbes() []ChannelCircuitBreakerProbe {
+	SyncChannelCircuitBreakers()
+	if !hasCircuitBreakers() {
+		return nil
+	}
+	now := common.GetTimestamp()
+	keys := make([]circuitBreakerKey, 0)
+	for _, key := range halfOpenCircuitBreakerKeys(now, func(circuitBreakerKey) bool { return true }) {
+		if acquireCircuitBreakerTrial(key, now) {
+			keys = append(keys, key)
+		}
+	}
+
+	probes := make([]ChannelCircuitBreakerProbe, 0, len(keys))
+	for _, key := range keys {
+		channel, err := GetChannelById(key.channelId, true)
+		if err != nil {
+			removeChannelCircuitBreaker(key)
+			continue
+		}
+		if key.keyIndex == CircuitBreakerChannelLevel {
+			if channel.ChannelInfo.IsMultiKey || channel.Status != common.ChannelStatusAutoDisabled {
+				removeChannelCircuitBreaker(key)
+				continue
+			}
+			probes = append(probes, ChannelCircuitBreakerProbe{Channel: channel, KeyIndex: key.keyIndex, Key: channel.Key})
+			continue
+		}
+		keyList := channel.GetKeys()
+		if !channel.ChannelInfo.IsMultiKey || key.keyIndex >= len(keyList) ||
+			channel.ChannelInfo.MultiKeyStatusList[key.keyIndex] != common.ChannelStatusAutoDisabled {
+			removeChannelCircuitBreaker(key)
+			continue
+		}
+		probes = append(probes, ChannelCircuitBreakerProbe{Channel: channel, KeyIndex: key.keyIndex, Key: keyList[key.keyIndex]})
+	}
+	return probes
+}
+
+func halfOpenTrafficHit() bool {
+	setting := operation_setting.GetCircuitBreakerSetting()
+	return setting.Enabled && setting.HalfOpenTrafficPercent > 0 && rand.Float64()*100 < setting.HalfOpenTrafficPercent
+}
+
+// pickHalfOpenChannel 按比例将真实请求放行到半开状态的渠道，getChannel 用于按 Id 获取渠道（内存缓存或数据库）
+func pickHalfOpenChannel(group string, model string, getChannel func(id int) (*Channel, bool)) *Channel {
+	if !halfOpenTrafficHit() {
+		return nil
+	}
+	now := common.GetTimestamp()
+	halfOpenKeys := halfOpenCircuitBreakerKeys(now, func(key circuitBreakerKey) bool {
+		return key.keyIndex == CircuitBreakerChannelLevel
+	})
+	if len(halfOpenKeys) == 0 {
+		return nil
+	}
+
+	normalizedModel := ratio_setting.FormatMatchingModelName(model)
+	candidates := make([]*Channel, 0, len(halfOpenKeys))
+	for _, key := range halfOpenKeys {
+		channel, ok := getChannel(key.channelId)
+		if !ok || channel.Status != common.ChannelStatusAutoDisabled || !slices.Contains(channel.GetGroups(), group) {
+			continue
+		}
+		models := channel.GetModels()
+		if !slices.Contains(models, model) && !slices.Contains(models, normalizedModel) {
+			continue
+		}
+		candidates = append(candidates, channel)
+	}
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+
+	for _, channel := range candidates {
+		if acquireCircuitBreakerTrial(circuitBreakerKey{channelId: channel.Id, keyIndex: CircuitBreakerChannelLevel}, now) {
+			return channel
+		}
+	}
+	return nil
+}
+
+// isChannelCircuitBroken 渠道级别的熔断器处于 open 或 half-open 状态，半开状态的渠道只能通过 pickHalfOpenChannel 试探
+func isChannelCircuitBroken(channelId int) bool {
+	if !operation_setting.GetCircuitBreakerSetting().Enabled {
+		return false
+	}
+	circuitBreakerLock.Lock()
+	defer circuitBreakerLock.Unlock()
+	_, ok := circuitBreakers[circuitBreakerKey{channelId: channelId, keyIndex: CircuitBreakerChannelLevel}]
+	return ok
+}
+
+// pickHalfOpenKey 按比例将真实请求放行到多 Key 渠道中半开状态的 Key
+func pickHalfOpenKey(channelId int, keyCount int, getStatus func(int) int) (int, bool) {
+	if !halfOpenTrafficHit() {
+		return 0, false
+	}
+	now := common.GetTimestamp()
+	candidates := halfOpenCircuitBreakerKeys(now, func(key circuitBreakerKey) bool {
+		return key.channelId == channelId && key.keyIndex >= 0 && key.keyIndex < keyCount
+	})
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+	for _, key := range candidates {
+		if getStatus(key.keyIndex) == common.ChannelStatusAutoDisabled && acquireCircuitBreakerTrial(key, now) {
+			return key.keyIndex, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,219 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// setupCircuitBreakerTest 启用熔断并把渠道放入内存缓存，返回时恢复原有状态
+func setupCircuitBreakerTest(t *testing.T, channels ...*Channel) {
+	t.Helper()
+	setting := operation_setting.GetCircuitBreakerSetting()
+	oldSetting := *setting
+	oldMemoryCache, oldRedisEnabled := common.MemoryCacheEnabled, common.RedisEnabled
+	oldChannels := channelsIDM
+	t.Cleanup(func() {
+		*setting = oldSetting
+		common.MemoryCacheEnabled, common.RedisEnabled = oldMemoryCache, oldRedisEnabled
+		channelSyncLock.Lock()
+		channelsIDM = oldChannels
+		channelSyncLock.Unlock()
+		circuitBreakerLock.Lock()
+		circuitBreakers = make(map[circuitBreakerKey]*channelCircuitBreaker)
+		circuitBreakerLock.Unlock()
+	})
+	setting.Enabled = true
+	setting.OpenSeconds = 60
+	setting.MaxOpenSeconds = 3600
+	setting.RecoverySuccesses = 2
+	common.MemoryCacheEnabled = true
+	common.RedisEnabled = false
+	channelSyncLock.Lock()
+	channelsIDM = make(map[int]*Channel)
+	for _, channel := range channels {
+		channelsIDM[channel.Id] = channel
+	}
+	channelSyncLock.Unlock()
+	circuitBreakerLock.Lock()
+	circuitBreakers = make(map[circuitBreakerKey]*channelCircuitBreaker)
+	circuitBreakerLock.Unlock()
+}
+
+// useMiniRedis 让熔断记录保存到独立的 miniredis 中
+func useMiniRedis(t *testing.T) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	oldRedisEnabled, oldRDB := common.RedisEnabled, common.RDB
+	t.Cleanup(func() {
+		_ = client.Close()
+		common.RedisEnabled, common.RDB = oldRedisEnabled, oldRDB
+	})
+	common.RedisEnabled, common.RDB = true, client
+}
+
+// endCircuitBreakerOpen 让熔断时间提前结束，进入半开状态
+func endCircuitBreakerOpen(t *testing.T, key circuitBreakerKey) {
+	t.Helper()
+	err := updateCircuitBreaker(key, func(breaker *channelCircuitBreaker, exists bool) circuitBreakerAction {
+		if !exists {
+			t.Fatalf("circuit breaker of channel #%d does not exist", key.channelId)
+		}
+		breaker.openUntil = common.GetTimestamp() - 1
+		return circuitBreakerSave
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func getCircuitBreakerStatus(channelId int) (ChannelCircuitBreakerStatus, bool) {
+	for _, status := range GetChannelCircuitBreakers() {
+		if status.ChannelId == channelId {
+			return status, true
+		}
+	}
+	return ChannelCircuitBreakerStatus{}, false
+}
+
+func TestChannelCircuitBreakerTransitions(t *testing.T) {
+	channel := &Channel{Id: 9101, Key: "sk-test", Status: common.ChannelStatusAutoDisabled}
+	setupCircuitBreakerTest(t, channel)
+	key := circuitBreakerKey{channelId: channel.Id, keyIndex: CircuitBreakerChannelLevel}
+
+	OpenChannelCircuitBreaker(channel.Id, channel.Key, "upstream error")
+	status, ok := getCircuitBreakerStatus(channel.Id)
+	if !ok || status.State != CircuitBreakerStateOpen || status.OpenCount != 1 {
+		t.Fatalf("after open: %+v, want open with open count 1", status)
+	}
+	if !isChannelCircuitBroken(channel.Id) {
+		t.Fatal("open channel should be excluded from selection")
+	}
+	// 已处于 open 状态时再次禁用不重复计算退避
+	OpenChannelCircuitBreaker(channel.Id, channel.Key, "upstream error")
+	if status, _ := getCircuitBreakerStatus(channel.Id); status.OpenCount != 1 {
+		t.Fatalf("open count = %d after reopening an open breaker, want 1", status.OpenCount)
+	}
+	if acquireCircuitBreakerTrial(key, common.GetTimestamp()) {
+		t.Fatal("open breaker should not allow a trial")
+	}
+
+	endCircuitBreakerOpen(t, key)
+	if !acquireCircuitBreakerTrial(key, common.GetTimestamp()) {
+		t.Fatal("half-open breaker should allow a trial")
+	}
+	if acquireCircuitBreakerTrial(key, common.GetTimestamp()) {
+		t.Fatal("half-open breaker should allow only one trial at a time")
+	}
+
+	// 试探失败后以两倍时长重新熔断
+	before := common.GetTimestamp()
+	if RecordChannelCircuitBreakerResult(channel.Id, channel.Key, false, "still failing") {
+		t.Fatal("failed trial should not close the breaker")
+	}
+	status, _ = getCircuitBreakerStatus(channel.Id)
+	if status.State != CircuitBreakerStateOpen || status.OpenCount != 2 || status.OpenUntil < before+120 {
+		t.Fatalf("after failed trial: %+v, want open for 120 seconds", status)
+	}
+
+	// 连续成功 RecoverySuccesses 次后关闭
+	endCircuitBreakerOpen(t, key)
+	if !acquireCircuitBreakerTrial(key, common.GetTimestamp()) {
+		t.Fatal("half-open breaker should allow a trial")
+	}
+	if RecordChannelCircuitBreakerResult(channel.Id, channel.Key, true, "") {
+		t.Fatal("breaker closed before reaching the recovery successes")
+	}
+	if !acquireCircuitBreakerTrial(key, common.GetTimestamp()) {
+		t.Fatal("a successful trial should free the trial slot")
+	}
+	if !RecordChannelCircuitBreakerResult(channel.Id, channel.Key, true, "") {
+		t.Fatal("breaker should close after the recovery successes")
+	}
+	if _, ok := getCircuitBreakerStatus(channel.Id); ok || isChannelCircuitBroken(channel.Id) {
+		t.Fatal("closed breaker should be removed")
+	}
+}
+
+func TestChannelCircuitBreakerReleaseTrial(t *testing.T) {
+	channel := &Channel{Id: 9102, Key: "sk-test", Status: common.ChannelStatusAutoDisabled}
+	setupCircuitBreakerTest(t, channel)
+	key := circuitBreakerKey{channelId: channel.Id, keyIndex: CircuitBreakerChannelLevel}
+
+	OpenChannelCircuitBreaker(channel.Id, channel.Key, "upstream error")
+	endCircuitBreakerOpen(t, key)
+	if !acquireCircuitBreakerTrial(key, common.GetTimestamp()) {
+		t.Fatal("half-open breaker should allow a trial")
+	}
+	// 非渠道原因失败时释放名额，状态不变
+	ReleaseChannelCircuitBreakerTrial(channel.Id, channel.Key)
+	if !acquireCircuitBreakerTrial(key, common.GetTimestamp()) {
+		t.Fatal("released trial slot should be available again")
+	}
+	CloseChannelCircuitBreaker(channel.Id, channel.Key)
+	if isChannelCircuitBroken(channel.Id) {
+		t.Fatal("manually enabled channel should have no breaker")
+	}
+}
+
+func TestChannelCircuitBreakerMultiKey(t *testing.T) {
+	channel := &Channel{Id: 9103, Key: "sk-a\nsk-b", Status: common.ChannelStatusEnabled}
+	channel.ChannelInfo.IsMultiKey = true
+	setupCircuitBreakerTest(t, channel)
+
+	OpenChannelCircuitBreaker(channel.Id, "sk-b", "key error")
+	status, ok := getCircuitBreakerStatus(channel.Id)
+	if !ok || status.KeyIndex != 1 {
+		t.Fatalf("breaker = %+v, want key index 1", status)
+	}
+	// Key 级别的熔断不影响整个渠道
+	if isChannelCircuitBroken(channel.Id) {
+		t.Fatal("key breaker should not exclude the whole channel")
+	}
+}
+
+func TestChannelCircuitBreakerSharedThroughRedis(t *testing.T) {
+	channel := &Channel{Id: 9104, Key: "sk-test", Status: common.ChannelStatusAutoDisabled}
+	setupCircuitBreakerTest(t, channel)
+	useMiniRedis(t)
+	key := circuitBreakerKey{channelId: channel.Id, keyIndex: CircuitBreakerChannelLevel}
+
+	OpenChannelCircuitBreaker(channel.Id, channel.Key, "upstream error")
+	// 模拟另一个节点：本地没有记录，从 Redis 同步
+	circuitBreakerLock.Lock()
+	circuitBreakers = make(map[circuitBreakerKey]*channelCircuitBreaker)
+	circuitBreakerLock.Unlock()
+	SyncChannelCircuitBreakers()
+	if !isChannelCircuitBroken(channel.Id) {
+		t.Fatal("breaker opened on another node should be synced from redis")
+	}
+
+	endCircuitBreakerOpen(t, key)
+	if !acquireCircuitBreakerTrial(key, common.GetTimestamp()) {
+		t.Fatal("half-open breaker should allow a trial")
+	}
+	// 另一个节点本地副本中没有试探记录，但名额保存在 Redis 中
+	circuitBreakerLock.Lock()
+	circuitBreakers[key].trialAt = 0
+	circuitBreakerLock.Unlock()
+	if acquireCircuitBreakerTrial(key, common.GetTimestamp()) {
+		t.Fatal("trial slot should be shared by all nodes")
+	}
+
+	operation_setting.GetCircuitBreakerSetting().RecoverySuccesses = 1
+	if !RecordChannelCircuitBreakerResult(channel.Id, channel.Key, true, "") {
+		t.Fatal("breaker should close after a successful trial")
+	}
+	if common.RDB.Exists(t.Context(), key.redisKey()).Val() != 0 {
+		t.Fatal("closed breaker should be removed from redis")
+	}
+	SyncChannelCircuitBreakers()
+	if isChannelCircuitBroken(channel.Id) {
+		t.Fatal("closed breaker should be removed from the local copy")
+	}
+}
@@ -0,0 +1,97 @@
+package model
+
+import (
+	"sort"
+	"time"
+)
+
+// 渠道冷却：上游通过限流响应头告知额度耗尽时，在重置之前跳过对应的渠道或 Key，到期后自动恢复。
+// 与 RPM/TPM/并发限制共用 channelRateLimitLock，冷却状态同样只保存在当前节点内存中。
+
+// channelCooldowns channelId -> keyIndex -> 冷却结束时间（毫秒），keyIndex 为 ChannelRateLimitChannelLevel 表示整个渠道
+var channelCooldowns = make(map[int]map[int]int64)
+var channelCooldownReasons = make(map[channelRateLimitKey]string)
+
+type ChannelCooldownStatus struct {
+	ChannelId int    `json:"channel_id"`
+	KeyIndex  int    `json:"key_index"`
+	Until     int64  `json:"until"` // 毫秒时间戳
+	Reason    string `json:"reason"`
+}
+
+// CoolDownChannel 冷却渠道或 Key 直到 until，已在冷却中时取更晚的结束时间
+func CoolDownChannel(channelId int, keyIndex int, until time.Time, reason string) {
+	untilMs := until.UnixMilli()
+	if untilMs <= time.Now().UnixMilli() {
+		return
+	}
+	channelRateLimitLock.Lock()
+	defer channelRateLimitLock.Unlock()
+	keys, ok := channelCooldowns[channelId]
+	if !ok {
+		keys = make(map[int]int64)
+		channelCooldowns[channelId] = keys
+	}
+	if untilMs > keys[keyIndex] {
+		keys[keyIndex] = untilMs
+		channelCooldownReasons[channelRateLimitKey{channelId: channelId, keyIndex: keyIndex}] = reason
+	}
+}
+
+// ClearChannelCooldown 清除渠道及其所有 Key 的冷却
+func ClearChannelCooldown(channelId int) {
+	channelRateLimitLock.Lock()
+	defer channelRateLimitLock.Unlock()
+	for keyIndex := range channelCooldowns[channelId] {
+		delete(channelCooldownReasons, channelRateLimitKey{channelId: channelId, keyIndex: keyIndex})
+	}
+	delete(channelCooldowns, channelId)
+}
+
+// coolingDown 调用方需持有 channelRateLimitLock，顺便清理已到期的记录
+func coolingDown(channelId int, keyIndex int, nowMs int64) bool {
+	keys, ok := channelCooldowns[channelId]
+	if !ok {
+		return false
+	}
+	until, ok := keys[keyIndex]
+	if !ok {
+		return false
+	}
+	if until > nowMs {
+		return true
+	}
+	delete(keys, keyIndex)
+	delete(channelCooldownReasons, channelRateLimitKey{channelId: channelId, keyIndex: keyIndex})
+	if len(keys) == 0 {
+		delete(channelCooldowns, channelId)
+	}
+	return false
+}
+
+func GetChannelCooldowns() []ChannelCooldownStatus {
+	channelRateLimitLock.Lock()
+	defer channelRateLimitLock.Unlock()
+	nowMs := time.Now().UnixMilli()
+	statuses := make([]ChannelCooldownStatus, 0)
+	for channelId, keys := range channelCooldowns {
+		for keyIndex, until := range keys {
+			if until <= nowMs {
+				continue
+			}
+			statuses = append(statuses, ChannelCooldownStatus{
+				ChannelId: channelId,
+				KeyIndex:  keyIndex,
+				Until:     until,
+				Reason:    channelCooldownReasons[channelRateLimitKey{channelId: channelId, keyIndex: keyIndex}],
+			})
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].ChannelId != statuses[j].ChannelId {
+			return statuses[i].ChannelId < statuses[j].ChannelId
+		}
+		return statuses[i].KeyIndex < statuses[j].KeyIndex
+	})
+	return statuses
+}
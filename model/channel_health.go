@@ -0,0 +1,310 @@
+package model
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+)
+
+// 渠道健康统计：按时间分桶的滑动窗口，记录成功率、延迟和首字延迟，
+// 用于健康感知的渠道选择（降权、临时剔除异常渠道），不会修改渠道的启用状态
+
+const channelHealthBucketSeconds = 5
+
+type channelHealthBucket struct {
+	slot         int64
+	requests     int
+	failures     int
+	latencyMs    int64
+	latencyCount int
+	ttftMs       int64
+	ttftCount    int
+}
+
+type channelHealth struct {
+	mutex               sync.Mutex
+	channelId           int
+	buckets             []channelHealthBucket
+	consecutiveFailures int
+	ejectedUntil        int64
+	ejectCount          int
+	ejectReason         string
+}
+
+type ChannelHealthStats struct {
+	ChannelId           int     `json:"channel_id"`
+	Requests            int     `json:"requests"`
+	Failures            int     `json:"failures"`
+	SuccessRate         float64 `json:"success_rate"`
+	AvgLatencyMs        int64   `json:"avg_latency_ms"`
+	AvgTTFTMs           int64   `json:"avg_ttft_ms"`
+	ConsecutiveFailures int     `json:"consecutive_failures"`
+	EjectedUntil        int64   `json:"ejected_until"`
+	EjectReason         string  `json:"eject_reason,omitempty"`
+}
+
+// ResponseLatencyMs 用于比较渠道快慢的延迟，流式请求优先使用首字延迟
+func (s ChannelHealthStats) ResponseLatencyMs() int64 {
+	if s.AvgTTFTMs > 0 {
+		return s.AvgTTFTMs
+	}
+	return s.AvgLatencyMs
+}
+
+var channelHealthMap sync.Map // channelId -> *channelHealth
+
+func getChannelHealth(channelId int) *channelHealth {
+	if health, ok := channelHealthMap.Load(channelId); ok {
+		return health.(*channelHealth)
+	}
+	health, _ := channelHealthMap.LoadOrStore(channelId, &channelHealth{channelId: channelId})
+	return health.(*channelHealth)
+}
+
+func (h *channelHealth) bucketCount() int {
+	count := operation_setting.GetChannelHealthSetting().WindowSeconds / channelHealthBucketSeconds
+	if count < 1 {
+		count = 1
+	}
+	return count
+}
+
+func (h *channelHealth) currentBucket(now int64) *channelHealthBucket {
+	if count := h.bucketCount(); len(h.buckets) != count {
+		// 窗口长度变化时丢弃旧数据
+		h.buckets = make([]channelHealthBucket, count)
+	}
+	slot := now / channelHealthBucketSeconds
+	bucket := &h.buckets[slot%int64(len(h.buckets))]
+	if bucket.slot != slot {
+		*bucket = channelHealthBucket{slot: slot}
+	}
+	return bucket
+}
+
+func (h *channelHealth) stats(now int64) ChannelHealthStats {
+	stats := ChannelHealthStats{
+		ChannelId:           h.channelId,
+		ConsecutiveFailures: h.consecutiveFailures,
+		SuccessRate:         1,
+	}
+	if h.ejectedUntil > now {
+		stats.EjectedUntil = h.ejectedUntil
+		stats.EjectReason = h.ejectReason
+	}
+	oldestSlot := now/channelHealthBucketSeconds - int64(len(h.buckets)) + 1
+	var latencyMs, ttftMs int64
+	var latencyCount, ttftCount int
+	for _, bucket := range h.buckets {
+		if bucket.slot < oldestSlot {
+			continue
+		}
+		stats.Requests += bucket.requests
+		stats.Failures += bucket.failures
+		latencyMs += bucket.latencyMs
+		latencyCount += bucket.latencyCount
+		ttftMs += bucket.ttftMs
+		ttftCount += bucket.ttftCount
+	}
+	if stats.Requests > 0 {
+		stats.SuccessRate = float64(stats.Requests-stats.Failures) / float64(stats.Requests)
+	}
+	if latencyCount > 0 {
+		stats.AvgLatencyMs = latencyMs / int64(latencyCount)
+	}
+	if ttftCount > 0 {
+		stats.AvgTTFTMs = ttftMs / int64(ttftCount)
+	}
+	return stats
+}
+
+func (h *channelHealth) eject(now int64, reason string) {
+	setting := operation_setting.GetChannelHealthSetting()
+	// 距离上次剔除结束已经很久，重新开始退避
+	if h.ejectedUntil > 0 && now-h.ejectedUntil > int64(setting.MaxEjectSeconds) {
+		h.ejectCount = 0
+	}
+	duration := int64(setting.EjectSeconds) << min(h.ejectCount, 16)
+	if setting.MaxEjectSeconds > 0 && duration > int64(setting.MaxEjectSeconds) {
+		duration = int64(setting.MaxEjectSeconds)
+	}
+	h.ejectCount++
+	h.ejectedUntil = now + duration
+	h.ejectReason = reason
+	common.SysLog(fmt.Sprintf("channel #%d is ejected from selection for %d seconds: %s", h.channelId, duration, reason))
+}
+
+// RecordChannelHealth 记录一次渠道请求的结果，latency 或 ttft 为 0 时不计入对应的延迟统计
+func RecordChannelHealth(channelId int, success bool, latency time.Duration, ttft time.Duration) {
+	setting := operation_setting.GetChannelHealthSetting()
+	if !setting.Enabled || channelId <= 0 {
+		return
+	}
+	health := getChannelHealth(channelId)
+	health.mutex.Lock()
+	defer health.mutex.Unlock()
+
+	now := time.Now().Unix()
+	bucket := health.currentBucket(now)
+	bucket.requests++
+	if latency > 0 {
+		bucket.latencyMs += latency.Milliseconds()
+		bucket.latencyCount++
+	}
+	if ttft > 0 {
+		bucket.ttftMs += ttft.Milliseconds()
+		bucket.ttftCount++
+	}
+	if success {
+		health.consecutiveFailures = 0
+		return
+	}
+	bucket.failures++
+	health.consecutiveFailures++
+	if health.ejectedUntil > now {
+		return
+	}
+	stats := health.stats(now)
+	if setting.ConsecutiveFailures > 0 && health.consecutiveFailures >= setting.ConsecutiveFailures {
+		health.eject(now, fmt.Sprintf("%d consecutive failures", health.consecutiveFailures))
+	} else if stats.Requests >= setting.MinRequests && stats.SuccessRate < setting.EjectSuccessRate {
+		health.eject(now, fmt.Sprintf("success rate %.2f in last %d seconds", stats.SuccessRate, setting.WindowSeconds))
+	}
+}
+
+func GetChannelHealthStats(channelId int) ChannelHealthStats {
+	health, ok := channelHealthMap.Load(channelId)
+	if !ok {
+		return ChannelHealthStats{ChannelId: channelId, SuccessRate: 1}
+	}
+	h := health.(*channelHealth)
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.stats(time.Now().Unix())
+}
+
+// GetAllChannelHealthStats 返回所有有统计数据的渠道
+func GetAllChannelHealthStats() []ChannelHealthStats {
+	result := make([]ChannelHealthStats, 0)
+	channelHealthMap.Range(func(key, value any) bool {
+		stats := GetChannelHealthStats(key.(int))
+		if stats.Requests > 0 || stats.EjectedUntil > 0 {
+			result = append(result, stats)
+		}
+		return true
+	})
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ChannelId < result[j].ChannelId
+	})
+	return result
+}
+
+// isChannelEjected 渠道因健康统计异常正被临时剔除
+func isChannelEjected(channelId int) bool {
+	if !operation_setting.GetChannelHealthSetting().Enabled {
+		return false
+	}
+	health, ok := channelHealthMap.Load(channelId)
+	if !ok {
+		return false
+	}
+	h := health.(*channelHealth)
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.ejectedUntil > time.Now().Unix()
+}
+
+// ResetChannelHealth 清除渠道的健康统计，渠道被手动启用或修改后调用
+func ResetChannelHealth(channelId int) {
+	channelHealthMap.Delete(channelId)
+}
+
+// adaptiveChannelWeights 根据健康统计计算同优先级渠道的选择权重：
+// 成功率和相对延迟用于降权，被剔除的渠道以及延迟离群的渠道权重为 0，但最多剔除 MaxEjectPercent 比例的渠道
+func adaptiveChannelWeights(channels []*Channel, smoothingFactor int) []float64 {
+	setting := operation_setting.GetChannelHealthSetting()
+	now := time.Now().Unix()
+
+	stats := make([]ChannelHealthStats, len(channels))
+	latencies := make([]int64, 0, len(channels))
+	for i, channel := range channels {
+		stats[i] = GetChannelHealthStats(channel.Id)
+		if stats[i].Requests >= setting.MinRequests && stats[i].ResponseLatencyMs() > 0 {
+			latencies = append(latencies, stats[i].ResponseLatencyMs())
+		}
+	}
+	// 取下中位数作为参考延迟
+	var referenceLatency int64
+	if len(latencies) > 1 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		referenceLatency = latencies[(len(latencies)-1)/2]
+	}
+
+	weights := make([]float64, len(channels))
+	ejected := make([]int, 0)
+	latencyOutliers := make([]int, 0)
+	for i, channel := range channels {
+		baseWeight := float64(channel.GetWeight() + smoothingFactor)
+		weight := baseWeight
+		s := stats[i]
+		if s.Requests >= setting.MinRequests {
+			weight *= s.SuccessRate * s.SuccessRate
+			if latency := s.ResponseLatencyMs(); referenceLatency > 0 && latency > referenceLatency {
+				ratio := float64(referenceLatency) / float64(latency)
+				weight *= ratio * ratio
+				if setting.LatencyOutlierRatio > 0 && float64(latency) > float64(referenceLatency)*setting.LatencyOutlierRatio {
+					latencyOutliers = append(latencyOutliers, i)
+				}
+			}
+		}
+		// 保留少量流量，便于观察渠道恢复
+		weights[i] = max(weight, baseWeight*0.01)
+		if s.EjectedUntil > now {
+			ejected = append(ejected, i)
+		}
+	}
+
+	maxEject := len(channels) * setting.MaxEjectPercent / 100
+	for _, i := range append(ejected, latencyOutliers...) {
+		if maxEject <= 0 {
+			break
+		}
+		if weights[i] == 0 {
+			continue
+		}
+		weights[i] = 0
+		maxEject--
+	}
+	return weights
+}
+
+// pickAdaptiveChannel 按健康权重随机选择渠道
+func pickAdaptiveChannel(channels []*Channel, smoothingFactor int) *Channel {
+	weights := adaptiveChannelWeights(channels, smoothingFactor)
+	totalWeight := 0.0
+	for _, weight := range weights {
+		totalWeight += weight
+	}
+	if totalWeight <= 0 {
+		return nil
+	}
+	randomWeight := rand.Float64() * totalWeight
+	for i, channel := range channels {
+		randomWeight -= weights[i]
+		if randomWeight < 0 && weights[i] > 0 {
+			return channel
+		}
+	}
+	// 浮点误差兜底
+	for i := len(channels) - 1; i >= 0; i-- {
+		if weights[i] > 0 {
+			return channels[i]
+		}
+	}
+	return nil
+}
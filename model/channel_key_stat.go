@@ -0,0 +1,150 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ChannelKeyStat 多 Key 渠道中每个 Key 的累计用量，按 Key 的哈希记录，删除 Key 导致索引变化后统计不会错位
+type ChannelKeyStat struct {
+	Id               int    `json:"id"`
+	ChannelId        int    `json:"channel_id" gorm:"uniqueIndex:idx_channel_key_stat,priority:1"`
+	KeyHash          string `json:"key_hash" gorm:"type:varchar(64);uniqueIndex:idx_channel_key_stat,priority:2"`
+	KeyIndex         int    `json:"key_index"` // 最近一次使用时的索引
+	Requests         int64  `json:"requests" gorm:"bigint;default:0"`
+	PromptTokens     int64  `json:"prompt_tokens" gorm:"bigint;default:0"`
+	CompletionTokens int64  `json:"completion_tokens" gorm:"bigint;default:0"`
+	UsedQuota        int64  `json:"used_quota" gorm:"bigint;default:0"`
+	Errors           int64  `json:"errors" gorm:"bigint;default:0"`
+	LastUsedTime     int64  `json:"last_used_time" gorm:"bigint;default:0"`
+	LastError        string `json:"last_error" gorm:"type:text"`
+	LastErrorTime    int64  `json:"last_error_time" gorm:"bigint;default:0"`
+}
+
+// 开启批量更新时先在内存中累加，随 batchUpdate 一起写入
+var pendingChannelKeyStats = make(map[string]*ChannelKeyStat)
+var pendingChannelKeyStatsLock sync.Mutex
+
+const channelKeyStatMaxErrorLength = 512
+
+func ChannelKeyHash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// RecordChannelKeyUsage 记录一次成功请求的用量
+func RecordChannelKeyUsage(channelId int, keyIndex int, key string, promptTokens int, completionTokens int, quota int) {
+	recordChannelKeyStat(&ChannelKeyStat{
+		ChannelId:        channelId,
+		KeyHash:          ChannelKeyHash(key),
+		KeyIndex:         keyIndex,
+		Requests:         1,
+		PromptTokens:     int64(promptTokens),
+		CompletionTokens: int64(completionTokens),
+		UsedQuota:        int64(quota),
+		LastUsedTime:     common.GetTimestamp(),
+	})
+}
+
+// RecordChannelKeyError 记录一次失败请求及错误信息
+func RecordChannelKeyError(channelId int, keyIndex int, key string, message string) {
+	if len(message) > channelKeyStatMaxErrorLength {
+		message = message[:channelKeyStatMaxErrorLength]
+	}
+	now := common.GetTimestamp()
+	recordChannelKeyStat(&ChannelKeyStat{
+		ChannelId:     channelId,
+		KeyHash:       ChannelKeyHash(key),
+		KeyIndex:      keyIndex,
+		Requests:      1,
+		Errors:        1,
+		LastUsedTime:  now,
+		LastError:     message,
+		LastErrorTime: now,
+	})
+}
+
+func recordChannelKeyStat(delta *ChannelKeyStat) {
+	if delta.ChannelId == 0 {
+		return
+	}
+	if !common.BatchUpdateEnabled {
+		if err := upsertChannelKeyStat(delta); err != nil {
+			common.SysLog(fmt.Sprintf("failed to update channel key stat: channel_id=%d, key_index=%d, error=%v", delta.ChannelId, delta.KeyIndex, err))
+		}
+		return
+	}
+	pendingChannelKeyStatsLock.Lock()
+	defer pendingChannelKeyStatsLock.Unlock()
+	id := fmt.Sprintf("%d:%s", delta.ChannelId, delta.KeyHash)
+	pending, ok := pendingChannelKeyStats[id]
+	if !ok {
+		pendingChannelKeyStats[id] = delta
+		return
+	}
+	pending.KeyIndex = delta.KeyIndex
+	pending.Requests += delta.Requests
+	pending.PromptTokens += delta.PromptTokens
+	pending.CompletionTokens += delta.CompletionTokens
+	pending.UsedQuota += delta.UsedQuota
+	pending.Errors += delta.Errors
+	pending.LastUsedTime = max(pending.LastUsedTime, delta.LastUsedTime)
+	if delta.LastErrorTime > 0 {
+		pending.LastError = delta.LastError
+		pending.LastErrorTime = delta.LastErrorTime
+	}
+}
+
+func upsertChannelKeyStat(delta *ChannelKeyStat) error {
+	updates := map[string]interface{}{
+		"key_index":         delta.KeyIndex,
+		"requests":          gorm.Expr("channel_key_stats.requests + ?", delta.Requests),
+		"prompt_tokens":     gorm.Expr("channel_key_stats.prompt_tokens + ?", delta.PromptTokens),
+		"completion_tokens": gorm.Expr("channel_key_stats.completion_tokens + ?", delta.CompletionTokens),
+		"used_quota":        gorm.Expr("channel_key_stats.used_quota + ?", delta.UsedQuota),
+		"errors":            gorm.Expr("channel_key_stats.errors + ?", delta.Errors),
+		"last_used_time":    delta.LastUsedTime,
+	}
+	if delta.LastErrorTime > 0 {
+		updates["last_error"] = delta.LastError
+		updates["last_error_time"] = delta.LastErrorTime
+	}
+	// 使用表名限定列名，避免 PostgreSQL 的 ON CONFLICT 中列名有歧义
+	return DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "channel_id"}, {Name: "key_hash"}},
+		DoUpdates: clause.Assignments(updates),
+	}).Create(delta).Error
+}
+
+func flushChannelKeyStats() {
+	pendingChannelKeyStatsLock.Lock()
+	pending := pendingChannelKeyStats
+	pendingChannelKeyStats = make(map[string]*ChannelKeyStat)
+	pendingChannelKeyStatsLock.Unlock()
+	for _, delta := range pending {
+		if err := upsertChannelKeyStat(delta); err != nil {
+			common.SysLog(fmt.Sprintf("failed to batch update channel key stat: channel_id=%d, key_index=%d, error=%v", delta.ChannelId, delta.KeyIndex, err))
+		}
+	}
+}
+
+// GetChannelKeyStats 返回渠道下所有 Key 的累计用量，key 为 Key 的哈希
+func GetChannelKeyStats(channelId int) (map[string]*ChannelKeyStat, error) {
+	var stats []*ChannelKeyStat
+	err := DB.Where("channel_id = ?", channelId).Find(&stats).Error
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]*ChannelKeyStat, len(stats))
+	for _, stat := range stats {
+		result[stat.KeyHash] = stat
+	}
+	return result, nil
+}
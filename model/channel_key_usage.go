@@ -0,0 +1,149 @@
+package model
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/QuantumNous/new-api/constant"
+)
+
+// 多 Key 渠道中每个 Key 的使用情况，用于 lru、least_outstanding 模式选择 Key 以及在管理接口中展示。
+// 在 AcquireChannelRateLimit/Release 中随请求记录，与 RPM/TPM/并发限制共用 channelRateLimitLock，只保存在当前节点内存中。
+
+type ChannelKeyUsage struct {
+	Requests     int64 `json:"requests"`
+	Tokens       int64 `json:"tokens"`
+	Outstanding  int   `json:"outstanding"`
+	LastUsedTime int64 `json:"last_used_time"` // 毫秒时间戳
+}
+
+var channelKeyUsages = make(map[channelRateLimitKey]*ChannelKeyUsage)
+
+// GetChannelKeyUsages 返回多 Key 渠道中每个 Key 的使用情况，下标为 Key 索引
+func GetChannelKeyUsages(channelId int, keyCount int) []ChannelKeyUsage {
+	channelRateLimitLock.Lock()
+	defer channelRateLimitLock.Unlock()
+	usages := make([]ChannelKeyUsage, keyCount)
+	for i := range usages {
+		if usage, ok := channelKeyUsages[channelRateLimitKey{channelId: channelId, keyIndex: i}]; ok {
+			usages[i] = *usage
+		}
+	}
+	return usages
+}
+
+// ResetChannelKeyUsages Key 被删除导致索引变化时清空统计
+func ResetChannelKeyUsages(channelId int) {
+	channelRateLimitLock.Lock()
+	defer channelRateLimitLock.Unlock()
+	for key := range channelKeyUsages {
+		if key.channelId == channelId {
+			delete(channelKeyUsages, key)
+		}
+	}
+}
+
+// recordChannelKeyAcquire 调用方需持有 channelRateLimitLock
+func recordChannelKeyAcquire(key channelRateLimitKey) {
+	usage, ok := channelKeyUsages[key]
+	if !ok {
+		usage = &ChannelKeyUsage{}
+		channelKeyUsages[key] = usage
+	}
+	usage.Requests++
+	usage.Outstanding++
+	usage.LastUsedTime = time.Now().UnixMilli()
+}
+
+// recordChannelKeyRelease 调用方需持有 channelRateLimitLock
+func recordChannelKeyRelease(key channelRateLimitKey, tokens int) {
+	usage, ok := channelKeyUsages[key]
+	if !ok {
+		return
+	}
+	if usage.Outstanding > 0 {
+		usage.Outstanding--
+	}
+	if tokens > 0 {
+		usage.Tokens += int64(tokens)
+	}
+}
+
+func (channel *Channel) getKeyWeight(keyIndex int) int {
+	if channel.ChannelInfo.MultiKeyWeights == nil {
+		return 1
+	}
+	if weight, ok := channel.ChannelInfo.MultiKeyWeights[keyIndex]; ok && weight >= 0 {
+		return weight
+	}
+	return 1
+}
+
+// pickKeyByMode 按 weighted、lru、least_outstanding、sticky 模式从候选 Key 中选择，其他模式返回 false
+func (channel *Channel) pickKeyByMode(candidates []int, affinity string) (int, bool) {
+	switch channel.ChannelInfo.MultiKeyMode {
+	case constant.MultiKeyModeWeighted:
+		totalWeight := 0
+		for _, idx := range candidates {
+			totalWeight += channel.getKeyWeight(idx)
+		}
+		if totalWeight <= 0 {
+			return candidates[rand.Intn(len(candidates))], true
+		}
+		randomWeight := rand.Intn(totalWeight)
+		for _, idx := range candidates {
+			randomWeight -= channel.getKeyWeight(idx)
+			if randomWeight < 0 {
+				return idx, true
+			}
+		}
+		return candidates[len(candidates)-1], true
+	case constant.MultiKeyModeLeastRecentlyUsed, constant.MultiKeyModeLeastOutstanding:
+		channelRateLimitLock.Lock()
+		defer channelRateLimitLock.Unlock()
+		selected := candidates[0]
+		var selectedUsage ChannelKeyUsage
+		for i, idx := range candidates {
+			var usage ChannelKeyUsage
+			if u, ok := channelKeyUsages[channelRateLimitKey{channelId: channel.Id, keyIndex: idx}]; ok {
+				usage = *u
+			}
+			if i == 0 {
+				selectedUsage = usage
+				continue
+			}
+			better := usage.LastUsedTime < selectedUsage.LastUsedTime
+			if channel.ChannelInfo.MultiKeyMode == constant.MultiKeyModeLeastOutstanding {
+				// 进行中的请求数相同时选择最久未使用的，避免总是集中到第一个 Key
+				better = usage.Outstanding < selectedUsage.Outstanding ||
+					(usage.Outstanding == selectedUsage.Outstanding && usage.LastUsedTime < selectedUsage.LastUsedTime)
+			}
+			if better {
+				selected = idx
+				selectedUsage = usage
+			}
+		}
+		return selected, true
+	case constant.MultiKeyModeSticky:
+		if affinity == "" {
+			return candidates[rand.Intn(len(candidates))], true
+		}
+		// 最高随机权重哈希：Key 被禁用或冷却时只影响原本分配到该 Key 的用户
+		selected := candidates[0]
+		var selectedScore uint64
+		for i, idx := range candidates {
+			h := fnv.New64a()
+			_, _ = h.Write([]byte(affinity))
+			_, _ = h.Write([]byte("#" + strconv.Itoa(idx)))
+			score := h.Sum64()
+			if i == 0 || score > selectedScore {
+				selected = idx
+				selectedScore = score
+			}
+		}
+		return selected, true
+	}
+	return 0, false
+}
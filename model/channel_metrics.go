@@ -0,0 +1,111 @@
+package model
+
+import (
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// channelCollector 在抓取 /metrics 时输出渠道状态和多 Key 可用数量
+type channelCollector struct {
+	status      *prometheus.Desc
+	keys        *prometheus.Desc
+	enabledKeys *prometheus.Desc
+}
+
+func init() {
+	labels := []string{"channel_id", "channel_type"}
+	metrics.RegisterCollector(&channelCollector{
+		status:      prometheus.NewDesc("newapi_channel_status", "Channel status: 1 enabled, 2 manually disabled, 3 auto disabled.", labels, nil),
+		keys:        prometheus.NewDesc("newapi_channel_keys", "Number of keys of multi-key channels.", labels, nil),
+		enabledKeys: prometheus.NewDesc("newapi_channel_enabled_keys", "Number of enabled keys of multi-key channels.", labels, nil),
+	})
+}
+
+func (collector *channelCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- collector.status
+	ch <- collector.keys
+	ch <- collector.enabledKeys
+}
+
+type channelMetric struct {
+	id          int
+	channelType int
+	status      int
+	isMultiKey  bool
+	keys        int
+	enabledKeys int
+}
+
+func (collector *channelCollector) Collect(ch chan<- prometheus.Metric) {
+	if DB == nil {
+		return
+	}
+	for _, channel := range getChannelsForMetrics() {
+		channelId := strconv.Itoa(channel.id)
+		channelType := strconv.Itoa(channel.channelType)
+		ch <- prometheus.MustNewConstMetric(collector.status, prometheus.GaugeValue, float64(channel.status), channelId, channelType)
+		if !channel.isMultiKey {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(collector.keys, prometheus.GaugeValue, float64(channel.keys), channelId, channelType)
+		ch <- prometheus.MustNewConstMetric(collector.enabledKeys, prometheus.GaugeValue, float64(channel.enabledKeys), channelId, channelType)
+	}
+}
+
+func newChannelMetric(channel *Channel) channelMetric {
+	metric := channelMetric{
+		id:          channel.Id,
+		channelType: channel.Type,
+		status:      channel.Status,
+		isMultiKey:  channel.ChannelInfo.IsMultiKey,
+	}
+	if metric.isMultiKey {
+		metric.keys = channel.ChannelInfo.MultiKeySize
+		metric.enabledKeys = channel.ChannelInfo.MultiKeySize
+		for _, status := range channel.ChannelInfo.MultiKeyStatusList {
+			if status != common.ChannelStatusEnabled {
+				metric.enabledKeys--
+			}
+		}
+		metric.enabledKeys = max(metric.enabledKeys, 0)
+	}
+	return metric
+}
+
+// getChannelsForMetrics 开启内存缓存时读取缓存，否则查询数据库（不含 key）
+func getChannelsForMetrics() []channelMetric {
+	if common.MemoryCacheEnabled {
+		channelSyncLock.RLock()
+		channels := make([]*Channel, 0, len(channelsIDM))
+		for _, channel := range channelsIDM {
+			channels = append(channels, channel)
+		}
+		channelSyncLock.RUnlock()
+		result := make([]channelMetric, 0, len(channels))
+		for _, channel := range channels {
+			if !channel.ChannelInfo.IsMultiKey {
+				result = append(result, newChannelMetric(channel))
+				continue
+			}
+			// 与 GetNextEnabledKey 使用同一把锁，避免并发读写 key 状态
+			pollingLock := GetChannelPollingLock(channel.Id)
+			pollingLock.Lock()
+			result = append(result, newChannelMetric(channel))
+			pollingLock.Unlock()
+		}
+		return result
+	}
+	var channels []*Channel
+	if err := DB.Select("id", "type", "status", "channel_info").Find(&channels).Error; err != nil {
+		common.SysLog("failed to load channels for metrics: " + err.Error())
+	}
+	result := make([]channelMetric, 0, len(channels))
+	for _, channel := range channels {
+		result = append(result, newChannelMetric(channel))
+	}
+	return result
+}
@@ -0,0 +1,377 @@
+package model
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 渠道 RPM/TPM/并发限制：按渠道以及多 Key 渠道中的 Key 分别统计最近 60 秒的请求数、token 数和进行中的请求数，
+// 选择渠道和 Key 时跳过已达到限制的（以及上游限流后正在冷却的，见 channel_cooldown.go）。
+// 统计保存在当前节点内存中，多节点部署时每个节点分别限制。token 数在请求结束后按实际消耗计入。
+
+// ChannelRateLimitChannelLevel 表示渠道级别的统计，多 Key 渠道的 Key 按索引分别统计
+const ChannelRateLimitChannelLevel = -1
+
+const channelRateLimitWindowSeconds = 60
+
+// 排队等待时重新检查的间隔，RPM/TPM 的额度随时间释放，不会触发释放通知
+const channelRateLimitRecheckInterval = 500 * time.Millisecond
+
+var ErrChannelsSaturated = errors.New("所有渠道均已达到速率限制")
+
+type channelRateLimits struct {
+	rpm            int
+	tpm            int
+	concurrency    int
+	keyRpm         int
+	keyTpm         int
+	keyConcurrency int
+}
+
+func (l *channelRateLimits) channelLevel() bool {
+	return l.rpm > 0 || l.tpm > 0 || l.concurrency > 0
+}
+
+func (l *channelRateLimits) keyLevel() bool {
+	return l.keyRpm > 0 || l.keyTpm > 0 || l.keyConcurrency > 0
+}
+
+func parseChannelRateLimits(channel *Channel) *channelRateLimits {
+	setting := channel.GetSetting()
+	return &channelRateLimits{
+		rpm:            setting.RPMLimit,
+		tpm:            setting.TPMLimit,
+		concurrency:    setting.ConcurrencyLimit,
+		keyRpm:         setting.KeyRPMLimit,
+		keyTpm:         setting.KeyTPMLimit,
+		keyConcurrency: setting.KeyConcurrencyLimit,
+	}
+}
+
+// getRateLimits 缓存中的渠道在同步时已解析，其他渠道每次解析
+func (channel *Channel) getRateLimits() *channelRateLimits {
+	if channel.rateLimits != nil {
+		return channel.rateLimits
+	}
+	return parseChannelRateLimits(channel)
+}
+
+type channelRateLimitKey struct {
+	channelId int
+	keyIndex  int
+}
+
+type channelRateLimitBucket struct {
+	second   int64
+	requests int
+	tokens   int
+}
+
+type channelRateLimitWindow struct {
+	buckets  [channelRateLimitWindowSeconds]channelRateLimitBucket
+	inflight int
+}
+
+func (w *channelRateLimitWindow) bucket(now int64) *channelRateLimitBucket {
+	b := &w.buckets[now%channelRateLimitWindowSeconds]
+	if b.second != now {
+		*b = channelRateLimitBucket{second: now}
+	}
+	return b
+}
+
+func (w *channelRateLimitWindow) usage(now int64) (requests int, tokens int) {
+	for _, b := range w.buckets {
+		if now-b.second < channelRateLimitWindowSeconds {
+			requests += b.requests
+			tokens += b.tokens
+		}
+	}
+	return
+}
+
+func (w *channelRateLimitWindow) saturated(now int64, rpm int, tpm int, concurrency int) bool {
+	if concurrency > 0 && w.inflight >= concurrency {
+		return true
+	}
+	if rpm <= 0 && tpm <= 0 {
+		return false
+	}
+	requests, tokens := w.usage(now)
+	return (rpm > 0 && requests >= rpm) || (tpm > 0 && tokens >= tpm)
+}
+
+var channelRateLimitWindows = make(map[channelRateLimitKey]*channelRateLimitWindow)
+var channelRateLimitLock sync.Mutex
+
+// channelRateLimitReleased 每次释放占用时关闭并替换，用于唤醒排队的请求
+var channelRateLimitReleased = make(chan struct{})
+var channelRateLimitWaiters atomic.Int64
+
+// windowSaturated 调用方需持有 channelRateLimitLock
+func windowSaturated(key channelRateLimitKey, now int64, rpm int, tpm int, concurrency int) bool {
+	w, ok := channelRateLimitWindows[key]
+	if !ok {
+		return false
+	}
+	return w.saturated(now, rpm, tpm, concurrency)
+}
+
+func getKeyStatus(channel *Channel, keyIndex int) int {
+	if channel.ChannelInfo.MultiKeyStatusList == nil {
+		return common.ChannelStatusEnabled
+	}
+	if status, ok := channel.ChannelInfo.MultiKeyStatusList[keyIndex]; ok {
+		return status
+	}
+	return common.ChannelStatusEnabled
+}
+
+// isChannelSaturated 渠道级别达到限制或正在冷却，或多 Key 渠道中所有启用的 Key 都达到限制或正在冷却
+func isChannelSaturated(channel *Channel) bool {
+	limits := channel.getRateLimits()
+	multiKey := channel.ChannelInfo.IsMultiKey
+	keyCount := 0
+	if multiKey {
+		keyCount = len(channel.Keys)
+		if keyCount == 0 {
+			keyCount = len(channel.GetKeys())
+		}
+	}
+
+	channelRateLimitLock.Lock()
+	defer channelRateLimitLock.Unlock()
+	_, hasCooldown := channelCooldowns[channel.Id]
+	checkKeys := multiKey && (limits.keyLevel() || hasCooldown)
+	if !limits.channelLevel() && !checkKeys && !hasCooldown {
+		return false
+	}
+	nowMs := time.Now().UnixMilli()
+	now := nowMs / 1000
+	if coolingDown(channel.Id, ChannelRateLimitChannelLevel, nowMs) {
+		return true
+	}
+	if limits.channelLevel() && windowSaturated(channelRateLimitKey{channelId: channel.Id, keyIndex: ChannelRateLimitChannelLevel}, now, limits.rpm, limits.tpm, limits.concurrency) {
+		return true
+	}
+	if !checkKeys {
+		return false
+	}
+	hasEnabledKey := false
+	for i := 0; i < keyCount; i++ {
+		if getKeyStatus(channel, i) != common.ChannelStatusEnabled {
+			continue
+		}
+		hasEnabledKey = true
+		if coolingDown(channel.Id, i, nowMs) {
+			continue
+		}
+		if !limits.keyLevel() || !windowSaturated(channelRateLimitKey{channelId: channel.Id, keyIndex: i}, now, limits.keyRpm, limits.keyTpm, limits.keyConcurrency) {
+			return false
+		}
+	}
+	return hasEnabledKey
+}
+
+// saturatedChannelKeys 返回多 Key 渠道中已达到限制或正在冷却的 Key 索引
+func saturatedChannelKeys(channel *Channel, keyCount int) map[int]bool {
+	limits := channel.getRateLimits()
+	channelRateLimitLock.Lock()
+	defer channelRateLimitLock.Unlock()
+	_, hasCooldown := channelCooldowns[channel.Id]
+	if !limits.keyLevel() && !hasCooldown {
+		return nil
+	}
+	nowMs := time.Now().UnixMilli()
+	now := nowMs / 1000
+	saturated := make(map[int]bool)
+	for i := 0; i < keyCount; i++ {
+		if coolingDown(channel.Id, i, nowMs) {
+			saturated[i] = true
+		} else if limits.keyLevel() && windowSaturated(channelRateLimitKey{channelId: channel.Id, keyIndex: i}, now, limits.keyRpm, limits.keyTpm, limits.keyConcurrency) {
+			saturated[i] = true
+		}
+	}
+	return saturated
+}
+
+// filterSaturatedChannels 跳过已达到限制的渠道，全部达到限制时返回 ErrChannelsSaturated，getChannel 用于按 Id 获取渠道（内存缓存或数据库）
+func filterSaturatedChannels(channelIds []int, getChannel func(id int) (*Channel, bool)) ([]int, error) {
+	var filtered []int
+	for i, channelId := range channelIds {
+		channel, ok := getChannel(channelId)
+		saturated := ok && isChannelSaturated(channel)
+		if saturated && filtered == nil {
+			filtered = make([]int, i, len(channelIds))
+			copy(filtered, channelIds[:i])
+		} else if !saturated && filtered != nil {
+			filtered = append(filtered, channelId)
+		}
+	}
+	if filtered == nil {
+		return channelIds, nil
+	}
+	if len(filtered) == 0 {
+		return nil, ErrChannelsSaturated
+	}
+	return filtered, nil
+}
+
+// ChannelRateLimitLease 一次请求对渠道和 Key 的占用，请求结束后调用 Release
+type ChannelRateLimitLease struct {
+	keys     []channelRateLimitKey
+	usageKey *channelRateLimitKey // 多 Key 渠道记录 Key 的使用情况
+	released bool
+}
+
+// AcquireChannelRateLimit 检查渠道和 Key 是否达到限制或正在冷却，未达到时记录一次请求并占用并发数。
+// 检查与占用在同一临界区内完成，并发请求不会同时通过检查后超出限制；达到限制时返回 false，调用方应选择其他渠道。
+// 渠道未配置限制且不是多 Key 渠道时返回 nil, true
+func AcquireChannelRateLimit(channel *Channel, keyIndex int) (*ChannelRateLimitLease, bool) {
+	limits := channel.getRateLimits()
+	lease := &ChannelRateLimitLease{}
+	if limits.channelLevel() {
+		lease.keys = append(lease.keys, channelRateLimitKey{channelId: channel.Id, keyIndex: ChannelRateLimitChannelLevel})
+	}
+	if channel.ChannelInfo.IsMultiKey {
+		key := channelRateLimitKey{channelId: channel.Id, keyIndex: keyIndex}
+		lease.usageKey = &key
+		if limits.keyLevel() {
+			lease.keys = append(lease.keys, key)
+		}
+	}
+
+	channelRateLimitLock.Lock()
+	defer channelRateLimitLock.Unlock()
+	nowMs := time.Now().UnixMilli()
+	now := nowMs / 1000
+	if coolingDown(channel.Id, ChannelRateLimitChannelLevel, nowMs) {
+		return nil, false
+	}
+	if lease.usageKey != nil && coolingDown(channel.Id, keyIndex, nowMs) {
+		return nil, false
+	}
+	for _, key := range lease.keys {
+		rpm, tpm, concurrency := limits.rpm, limits.tpm, limits.concurrency
+		if key.keyIndex != ChannelRateLimitChannelLevel {
+			rpm, tpm, concurrency = limits.keyRpm, limits.keyTpm, limits.keyConcurrency
+		}
+		if windowSaturated(key, now, rpm, tpm, concurrency) {
+			return nil, false
+		}
+	}
+	if len(lease.keys) == 0 && lease.usageKey == nil {
+		return nil, true
+	}
+	for _, key := range lease.keys {
+		w, ok := channelRateLimitWindows[key]
+		if !ok {
+			w = &channelRateLimitWindow{}
+			channelRateLimitWindows[key] = w
+		}
+		w.bucket(now).requests++
+		w.inflight++
+	}
+	if lease.usageKey != nil {
+		recordChannelKeyAcquire(*lease.usageKey)
+	}
+	return lease, true
+}
+
+// Release 释放并发占用，并计入本次请求实际消耗的 token
+func (l *ChannelRateLimitLease) Release(tokens int) {
+	if l == nil || l.released {
+		return
+	}
+	l.released = true
+
+	channelRateLimitLock.Lock()
+	defer channelRateLimitLock.Unlock()
+	now := common.GetTimestamp()
+	for _, key := range l.keys {
+		w, ok := channelRateLimitWindows[key]
+		if !ok {
+			continue
+		}
+		if w.inflight > 0 {
+			w.inflight--
+		}
+		if tokens > 0 {
+			w.bucket(now).tokens += tokens
+		}
+	}
+	if l.usageKey != nil {
+		recordChannelKeyRelease(*l.usageKey, tokens)
+	}
+	close(channelRateLimitReleased)
+	channelRateLimitReleased = make(chan struct{})
+}
+
+func channelRateLimitReleasedSignal() <-chan struct{} {
+	channelRateLimitLock.Lock()
+	defer channelRateLimitLock.Unlock()
+	return channelRateLimitReleased
+}
+
+// ChannelAcquireFunc 选中渠道后立即占用其速率限制额度，额度已被并发请求占满时返回 false，由选择逻辑换下一个候选渠道
+type ChannelAcquireFunc func(channel *Channel) bool
+
+// 选中的渠道占用失败后重新选择的次数，占用失败的渠道此时已达到限制，重新选择时会被跳过
+const maxChannelAcquireAttempts = 5
+
+// acquireSelectedChannel 选择渠道并占用额度，多次占用失败时按所有渠道均已达到限制处理，acquire 为 nil 时不占用
+func acquireSelectedChannel(acquire ChannelAcquireFunc, selectChannel func() (*Channel, string, error)) (*Channel, string, error) {
+	var selectGroup string
+	for i := 0; i < maxChannelAcquireAttempts; i++ {
+		channel, group, err := selectChannel()
+		if err != nil || channel == nil || acquire == nil || acquire(channel) {
+			return channel, group, err
+		}
+		selectGroup = group
+	}
+	return nil, selectGroup, ErrChannelsSaturated
+}
+
+// waitForSaturatedChannel 所有渠道都达到限制时排队等待，直到有渠道可用、超时或请求被取消
+func waitForSaturatedChannel(c *gin.Context, selectChannel func() (*Channel, string, error)) (*Channel, string, error) {
+	channel, selectGroup, err := selectChannel()
+	setting := operation_setting.GetChannelRateLimitSetting()
+	if !errors.Is(err, ErrChannelsSaturated) || !setting.QueueEnabled || setting.QueueTimeoutSeconds <= 0 {
+		return channel, selectGroup, err
+	}
+	waiters := channelRateLimitWaiters.Add(1)
+	defer channelRateLimitWaiters.Add(-1)
+	if setting.QueueMaxSize > 0 && waiters > int64(setting.QueueMaxSize) {
+		return channel, selectGroup, err
+	}
+
+	var done <-chan struct{}
+	if c != nil && c.Request != nil {
+		done = c.Request.Context().Done()
+	}
+	timeout := time.NewTimer(time.Duration(setting.QueueTimeoutSeconds) * time.Second)
+	defer timeout.Stop()
+	ticker := time.NewTicker(channelRateLimitRecheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-channelRateLimitReleasedSignal():
+		case <-ticker.C:
+		case <-timeout.C:
+			return channel, selectGroup, err
+		case <-done:
+			return channel, selectGroup, err
+		}
+		channel, selectGroup, err = selectChannel()
+		if !errors.Is(err, ErrChannelsSaturated) {
+			return channel, selectGroup, err
+		}
+	}
+}
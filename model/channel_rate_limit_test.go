@@ -0,0 +1,152 @@
+package model
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+// newRateLimitedChannel 同时清空该渠道之前的统计，使 -count>1 时每轮从空窗口开始
+func newRateLimitedChannel(id int, setting string) *Channel {
+	channelRateLimitLock.Lock()
+	for key := range channelRateLimitWindows {
+		if key.channelId == id {
+			delete(channelRateLimitWindows, key)
+		}
+	}
+	channelRateLimitLock.Unlock()
+	return &Channel{Id: id, Status: common.ChannelStatusEnabled, Setting: &setting}
+}
+
+// acquireConcurrently 并发占用额度，返回成功占用的租约
+func acquireConcurrently(n int, acquire func() (*ChannelRateLimitLease, bool)) []*ChannelRateLimitLease {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var leases []*ChannelRateLimitLease
+	start := make(chan struct{})
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if lease, ok := acquire(); ok {
+				mu.Lock()
+				leases = append(leases, lease)
+				mu.Unlock()
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	return leases
+}
+
+func TestAcquireChannelRateLimitConcurrencyHoldsUnderLoad(t *testing.T) {
+	channel := newRateLimitedChannel(9001, `{"concurrency_limit":3}`)
+	leases := acquireConcurrently(50, func() (*ChannelRateLimitLease, bool) {
+		return AcquireChannelRateLimit(channel, 0)
+	})
+	if len(leases) != 3 {
+		t.Fatalf("acquired %d leases, want 3", len(leases))
+	}
+	if _, ok := AcquireChannelRateLimit(channel, 0); ok {
+		t.Fatal("acquired a lease past the concurrency limit")
+	}
+	leases[0].Release(0)
+	lease, ok := AcquireChannelRateLimit(channel, 0)
+	if !ok {
+		t.Fatal("expected a lease after one was released")
+	}
+	lease.Release(0)
+	for _, lease := range leases[1:] {
+		lease.Release(0)
+	}
+}
+
+func TestAcquireChannelRateLimitRPMHoldsUnderLoad(t *testing.T) {
+	channel := newRateLimitedChannel(9002, `{"rpm_limit":5}`)
+	leases := acquireConcurrently(50, func() (*ChannelRateLimitLease, bool) {
+		return AcquireChannelRateLimit(channel, 0)
+	})
+	if len(leases) != 5 {
+		t.Fatalf("acquired %d leases, want 5", len(leases))
+	}
+	for _, lease := range leases {
+		lease.Release(0)
+	}
+	// 释放并发占用不返还本分钟的请求数
+	if _, ok := AcquireChannelRateLimit(channel, 0); ok {
+		t.Fatal("acquired a lease past the RPM limit")
+	}
+}
+
+func TestAcquireChannelRateLimitKeyLevel(t *testing.T) {
+	channel := newRateLimitedChannel(9003, `{"key_concurrency_limit":1}`)
+	channel.ChannelInfo.IsMultiKey = true
+	leases := acquireConcurrently(20, func() (*ChannelRateLimitLease, bool) {
+		return AcquireChannelRateLimit(channel, 0)
+	})
+	if len(leases) != 1 {
+		t.Fatalf("acquired %d leases on key 0, want 1", len(leases))
+	}
+	other, ok := AcquireChannelRateLimit(channel, 1)
+	if !ok {
+		t.Fatal("key 1 should not be limited by key 0")
+	}
+	other.Release(0)
+	leases[0].Release(0)
+}
+
+func TestCacheGetRandomSatisfiedChannelAcquiresAtomically(t *testing.T) {
+	oldMemoryCache := common.MemoryCacheEnabled
+	oldGroups, oldChannels := group2model2channels, channelsIDM
+	defer func() {
+		common.MemoryCacheEnabled = oldMemoryCache
+		group2model2channels, channelsIDM = oldGroups, oldChannels
+	}()
+	common.MemoryCacheEnabled = true
+	channelSyncLock.Lock()
+	channelsIDM = map[int]*Channel{
+		9011: newRateLimitedChannel(9011, `{"concurrency_limit":2}`),
+		9012: newRateLimitedChannel(9012, `{"concurrency_limit":2}`),
+	}
+	group2model2channels = map[string]map[string][]int{"default": {"gpt-test": {9011, 9012}}}
+	channelSyncLock.Unlock()
+
+	var inflight [2]atomic.Int64
+	var mu sync.Mutex
+	var leases []*ChannelRateLimitLease
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < 40; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			channel, _, err := CacheGetRandomSatisfiedChannel(nil, "default", "gpt-test", 0, func(channel *Channel) bool {
+				lease, ok := AcquireChannelRateLimit(channel, 0)
+				if ok {
+					mu.Lock()
+					leases = append(leases, lease)
+					mu.Unlock()
+				}
+				return ok
+			})
+			if err == nil && channel != nil {
+				inflight[channel.Id-9011].Add(1)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	for i := range inflight {
+		if got := inflight[i].Load(); got != 2 {
+			t.Errorf("channel #%d selected %d times, want 2", 9011+i, got)
+		}
+	}
+	for _, lease := range leases {
+		lease.Release(0)
+	}
+}
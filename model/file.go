@@ -0,0 +1,164 @@
+package model
+
+import (
+	"database/sql/driver"
+	"errors"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	FilePurposeAssistants  = "assistants"
+	FilePurposeBatch       = "batch"
+	FilePurposeBatchOutput = "batch_output"
+	FilePurposeFineTune    = "fine-tune"
+	FilePurposeVision      = "vision"
+	FilePurposeUserData    = "user_data"
+	FilePurposeEvals       = "evals"
+)
+
+const (
+	FileStatusUploaded  = "uploaded"
+	FileStatusProcessed = "processed"
+	FileStatusError     = "error"
+)
+
+// FileUpstreamMap 记录文件已同步到的上游渠道，channel id -> 上游 file id
+type FileUpstreamMap map[int]string
+
+func (m FileUpstreamMap) Value() (driver.Value, error) {
+	return common.Marshal(m)
+}
+
+func (m *FileUpstreamMap) Scan(value interface{}) error {
+	var bytesValue []byte
+	switch v := value.(type) {
+	case []byte:
+		bytesValue = v
+	case string:
+		bytesValue = []byte(v)
+	}
+	if len(bytesValue) == 0 {
+		*m = FileUpstreamMap{}
+		return nil
+	}
+	return common.Unmarshal(bytesValue, m)
+}
+
+// File 用户通过 /v1/files 上传的文件，内容保存在文件存储中，数据库只保存元信息
+type File struct {
+	Id            int             `json:"id"`
+	FileId        string          `json:"file_id" gorm:"type:varchar(64);uniqueIndex"`
+	UserId        int             `json:"user_id" gorm:"index"`
+	TokenId       int             `json:"token_id" gorm:"index"`
+	Filename      string          `json:"filename" gorm:"type:varchar(255)"`
+	Purpose       string          `json:"purpose" gorm:"type:varchar(32);index"`
+	Bytes         int64           `json:"bytes" gorm:"bigint"`
+	ContentType   string          `json:"content_type" gorm:"type:varchar(128)"`
+	Status        string          `json:"status" gorm:"type:varchar(20)"`
+	StorageKey    string          `json:"-" gorm:"type:varchar(255)"`
+	UpstreamFiles FileUpstreamMap `json:"-" gorm:"type:text"`
+	CreatedAt     int64           `json:"created_at" gorm:"bigint;index"`
+	ExpiresAt     int64           `json:"expires_at" gorm:"bigint;default:0"`
+	DeletedAt     gorm.DeletedAt  `json:"-" gorm:"index"`
+}
+
+func (file *File) Insert() error {
+	if file.CreatedAt == 0 {
+		file.CreatedAt = common.GetTimestamp()
+	}
+	return DB.Create(file).Error
+}
+
+func (file *File) Delete() error {
+	return DB.Delete(file).Error
+}
+
+// SetUpstreamFileId 记录文件在某个渠道上的上游 file id。
+// 在事务中加锁重新读取映射后合并写入，避免并发转发到不同渠道时互相覆盖
+func (file *File) SetUpstreamFileId(channelId int, upstreamFileId string) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		var latest File
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "upstream_files").First(&latest, file.Id).Error
+		if err != nil {
+			return err
+		}
+		if latest.UpstreamFiles == nil {
+			latest.UpstreamFiles = FileUpstreamMap{}
+		}
+		latest.UpstreamFiles[channelId] = upstreamFileId
+		if err := tx.Model(&File{}).Where("id = ?", file.Id).Update("upstream_files", latest.UpstreamFiles).Error; err != nil {
+			return err
+		}
+		file.UpstreamFiles = latest.UpstreamFiles
+		return nil
+	})
+}
+
+func (file *File) GetUpstreamFileId(channelId int) (string, bool) {
+	if file.UpstreamFiles == nil {
+		return "", false
+	}
+	id, ok := file.UpstreamFiles[channelId]
+	return id, ok
+}
+
+// IsExpired 文件设置了过期时间且已过期，过期文件在定时清理前也不可再使用
+func (file *File) IsExpired() bool {
+	return file.ExpiresAt > 0 && file.ExpiresAt < common.GetTimestamp()
+}
+
+func GetUserFileByFileId(userId int, fileId string) (*File, error) {
+	if fileId == "" {
+		return nil, errors.New("file id is empty")
+	}
+	var file File
+	err := DB.Where("user_id = ? and file_id = ?", userId, fileId).First(&file).Error
+	if err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+func GetUserFilesByFileIds(userId int, fileIds []string) ([]*File, error) {
+	if len(fileIds) == 0 {
+		return nil, nil
+	}
+	var files []*File
+	err := DB.Where("user_id = ? and file_id in (?)", userId, fileIds).Find(&files).Error
+	return files, err
+}
+
+// GetUserFiles 按 OpenAI 文件列表接口语义分页查询，after 为上一页最后一个 file id。已过期但尚未清理的文件不返回
+func GetUserFiles(userId int, purpose string, after string, limit int, order string) ([]*File, error) {
+	var files []*File
+	query := DB.Where("user_id = ?", userId).Where("expires_at = 0 or expires_at >= ?", common.GetTimestamp())
+	if purpose != "" {
+		query = query.Where("purpose = ?", purpose)
+	}
+	if order != "asc" {
+		order = "desc"
+	}
+	if after != "" {
+		afterFile, err := GetUserFileByFileId(userId, after)
+		if err != nil {
+			return nil, err
+		}
+		if order == "asc" {
+			query = query.Where("id > ?", afterFile.Id)
+		} else {
+			query = query.Where("id < ?", afterFile.Id)
+		}
+	}
+	err := query.Order("id " + order).Limit(limit).Find(&files).Error
+	return files, err
+}
+
+func GetExpiredFiles(now int64, limit int) ([]*File, error) {
+	var files []*File
+	err := DB.Where("expires_at > 0 and expires_at < ?", now).Limit(limit).Find(&files).Error
+	return files, err
+}
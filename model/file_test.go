@@ -0,0 +1,41 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+func TestGetUserFilesSkipsExpired(t *testing.T) {
+	t.Setenv("SQL_DSN", "")
+	common.SQLitePath = "file:model_file_test?mode=memory&cache=shared"
+	common.RedisEnabled = false
+	common.IsMasterNode = true
+	if err := InitDB(); err != nil {
+		t.Fatal(err)
+	}
+	if err := DB.Exec("DELETE FROM files").Error; err != nil {
+		t.Fatal(err)
+	}
+	now := common.GetTimestamp()
+	for _, file := range []*File{
+		{FileId: "file-permanent", UserId: 1},
+		{FileId: "file-valid", UserId: 1, ExpiresAt: now + 3600},
+		{FileId: "file-expired", UserId: 1, ExpiresAt: now - 1},
+	} {
+		if err := file.Insert(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := GetUserFiles(1, "", "", 10, "asc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0].FileId != "file-permanent" || files[1].FileId != "file-valid" {
+		ids := make([]string, 0, len(files))
+		for _, file := range files {
+			ids = append(ids, file.FileId)
+		}
+		t.Fatalf("listed %v, want [file-permanent file-valid]", ids)
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"time"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/metrics"
+	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/logger"
 	"github.com/QuantumNous/new-api/types"
 
@@ -33,6 +35,7 @@ type Log struct {
 	IsStream         bool   `json:"is_stream"`
 	ChannelId        int    `json:"channel" gorm:"index"`
 	ChannelName      string `json:"channel_name" gorm:"->"`
+	MultiKeyIndex    int    `json:"multi_key_index" gorm:"default:0"` // 多 Key 渠道中实际使用的 Key 索引
 	TokenId          int    `json:"token_id" gorm:"default:0;index"`
 	Group            string `json:"group" gorm:"index"`
 	Ip               string `json:"ip" gorm:"index;default:''"`
@@ -53,6 +56,7 @@ const (
 func formatUserLogs(logs []*Log) {
 	for i := range logs {
 		logs[i].ChannelName = ""
+		logs[i].MultiKeyIndex = 0
 		var otherMap map[string]interface{}
 		otherMap, _ = common.StrToMap(logs[i].Other)
 		if otherMap != nil {
@@ -96,6 +100,14 @@ func RecordLog(userId int, logType int, content string) {
 	}
 }
 
+// getLogMultiKeyIndex 重试切换到单 Key 渠道时上下文中可能残留之前的索引，只在多 Key 渠道时记录
+func getLogMultiKeyIndex(c *gin.Context) int {
+	if !common.GetContextKeyBool(c, constant.ContextKeyChannelIsMultiKey) {
+		return 0
+	}
+	return common.GetContextKeyInt(c, constant.ContextKeyChannelMultiKeyIndex)
+}
+
 func RecordErrorLog(c *gin.Context, userId int, channelId int, modelName string, tokenName string, content string, tokenId int, useTimeSeconds int,
 	isStream bool, group string, other map[string]interface{}) {
 	logger.LogInfo(c, fmt.Sprintf("record error log: userId=%d, channelId=%d, modelName=%s, tokenName=%s, content=%s", userId, channelId, modelName, tokenName, content))
@@ -120,6 +132,7 @@ func RecordErrorLog(c *gin.Context, userId int, channelId int, modelName string,
 		ModelName:        modelName,
 		Quota:            0,
 		ChannelId:        channelId,
+		MultiKeyIndex:    getLogMultiKeyIndex(c),
 		TokenId:          tokenId,
 		UseTime:          useTimeSeconds,
 		IsStream:         isStream,
@@ -154,6 +167,14 @@ type RecordConsumeLogParams struct {
 }
 
 func RecordConsumeLog(c *gin.Context, userId int, params RecordConsumeLogParams) {
+	// 记录本次请求的实际消耗，供批处理汇总额度、TPM 限流修正用量
+	common.SetContextKey(c, constant.ContextKeyConsumedQuota, params.Quota)
+	common.SetContextKey(c, constant.ContextKeyConsumedTokens, params.PromptTokens+params.CompletionTokens)
+	metrics.RecordConsume(params.ModelName, params.Group, params.ChannelId, common.GetContextKeyInt(c, constant.ContextKeyChannelType), params.PromptTokens, params.CompletionTokens, params.Quota)
+	if common.GetContextKeyBool(c, constant.ContextKeyChannelIsMultiKey) {
+		RecordChannelKeyUsage(params.ChannelId, common.GetContextKeyInt(c, constant.ContextKeyChannelMultiKeyIndex), common.GetContextKeyString(c, constant.ContextKeyChannelKey),
+			params.PromptTokens, params.CompletionTokens, params.Quota)
+	}
 	if !common.LogConsumeEnabled {
 		return
 	}
@@ -179,6 +200,7 @@ func RecordConsumeLog(c *gin.Context, userId int, params RecordConsumeLogParams)
 		ModelName:        params.ModelName,
 		Quota:            params.Quota,
 		ChannelId:        params.ChannelId,
+		MultiKeyIndex:    getLogMultiKeyIndex(c),
 		TokenId:          params.TokenId,
 		UseTime:          params.UseTimeSeconds,
 		IsStream:         params.IsStream,
@@ -267,6 +267,12 @@ func migrateDB() error {
 		&Setup{},
 		&TwoFA{},
 		&TwoFABackupCode{},
+		&File{},
+		&Batch{},
+		&QuotaSpend{},
+		&TaskCallback{},
+		&TaskArtifact{},
+		&ChannelKeyStat{},
 	)
 	if err != nil {
 		return err
@@ -300,6 +306,12 @@ func migrateDBFast() error {
 		{&Setup{}, "Setup"},
 		{&TwoFA{}, "TwoFA"},
 		{&TwoFABackupCode{}, "TwoFABackupCode"},
+		{&File{}, "File"},
+		{&Batch{}, "Batch"},
+		{&QuotaSpend{}, "QuotaSpend"},
+		{&TaskCallback{}, "TaskCallback"},
+		{&TaskArtifact{}, "TaskArtifact"},
+		{&ChannelKeyStat{}, "ChannelKeyStat"},
 	}
 	// 动态计算migration数量，确保errChan缓冲区足够大
 	errChan := make(chan error, len(migrations))
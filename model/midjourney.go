@@ -4,6 +4,7 @@ type Midjourney struct {
 	Id          int    `json:"id"`
 	Code        int    `json:"code"`
 	UserId      int    `json:"user_id" gorm:"index"`
+	TokenId     int    `json:"-" gorm:"default:0"` // 提交任务的令牌，退款时从令牌的按天、周、月消费中扣除
 	Action      string `json:"action" gorm:"type:varchar(40);index"`
 	MjId        string `json:"mj_id" gorm:"index"`
 	Prompt      string `json:"prompt"`
@@ -23,6 +24,10 @@ type Midjourney struct {
 	Quota       int    `json:"quota"`
 	Buttons     string `json:"buttons"`
 	Properties  string `json:"properties"`
+	CallbackUrl string `json:"callback_url,omitempty" gorm:"type:varchar(512)"` // 任务完成后推送通知的地址
+	// NextPollTime 下次查询上游状态的时间，PollAttempts 为状态未变化时的连续查询次数
+	NextPollTime int64 `json:"-" gorm:"bigint;index;default:0"`
+	PollAttempts int   `json:"-" gorm:"default:0"`
 }
 
 // TaskQueryParams 用于包含所有搜索条件的结构体，可以根据需求添加更多字段
@@ -90,17 +95,27 @@ func GetAllTasks(startIdx int, num int, queryParams TaskQueryParams) []*Midjourn
 	return tasks
 }
 
-func GetAllUnFinishTasks() []*Midjourney {
+// GetDueUnFinishTasks 获取未完成且到达查询时间的任务
+func GetDueUnFinishTasks(now int64, limit int) []*Midjourney {
 	var tasks []*Midjourney
 	var err error
 	// get all tasks progress is not 100%
-	err = DB.Where("progress != ?", "100%").Find(&tasks).Error
+	err = DB.Where("progress != ?", "100%").Where("next_poll_time <= ?", now).
+		Limit(limit).Order("next_poll_time, id").Find(&tasks).Error
 	if err != nil {
 		return nil
 	}
 	return tasks
 }
 
+// MjSetNextPoll 记录任务下次查询的时间和连续查询次数
+func MjSetNextPoll(id int, nextPollTime int64, pollAttempts int) error {
+	return DB.Model(&Midjourney{}).Where("id = ?", id).Updates(map[string]any{
+		"next_poll_time": nextPollTime,
+		"poll_attempts":  pollAttempts,
+	}).Error
+}
+
 func GetByOnlyMJId(mjId string) *Midjourney {
 	var mj *Midjourney
 	var err error
@@ -157,6 +172,15 @@ func (midjourney *Midjourney) Update() error {
 	return err
 }
 
+// UpdateWithStatus 仅当数据库中的状态仍为 fromStatus 时保存任务，返回是否保存成功，防止并发更新重复退还额度
+func (midjourney *Midjourney) UpdateWithStatus(fromStatus string) (bool, error) {
+	result := DB.Model(midjourney).Where("status = ?", fromStatus).Select("*").Updates(midjourney)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
 func MjBulkUpdate(mjIds []string, params map[string]any) error {
 	return DB.Model(&Midjourney{}).
 		Where("mj_id in (?)", mjIds).
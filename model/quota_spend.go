@@ -0,0 +1,239 @@
+package model
+
+import (
+	"errors"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 令牌和用户按天、周、月的消费额度限制。
+// 每个时间窗口的消费记录在 quota_spends 表中，窗口按服务器时区的自然日、自然周（周一开始）和自然月划分，
+// 进入新窗口后自动从 0 开始计算。只记录设置了限制的令牌和用户的消费。
+
+const (
+	QuotaSpendSubjectToken = "token"
+	QuotaSpendSubjectUser  = "user"
+
+	QuotaSpendPeriodDaily   = "daily"
+	QuotaSpendPeriodWeekly  = "weekly"
+	QuotaSpendPeriodMonthly = "monthly"
+)
+
+// quotaSpendRetention 超过该时间的窗口记录会被清理
+const quotaSpendRetention = 62 * 24 * time.Hour
+
+type QuotaSpend struct {
+	Id          int    `json:"id"`
+	SubjectType string `json:"subject_type" gorm:"type:varchar(16);uniqueIndex:idx_quota_spend_window,priority:1"`
+	SubjectId   int    `json:"subject_id" gorm:"uniqueIndex:idx_quota_spend_window,priority:2"`
+	Period      string `json:"period" gorm:"type:varchar(16);uniqueIndex:idx_quota_spend_window,priority:3"`
+	WindowStart int64  `json:"window_start" gorm:"bigint;uniqueIndex:idx_quota_spend_window,priority:4;index"`
+	UsedQuota   int    `json:"used_quota" gorm:"default:0"`
+}
+
+// QuotaSpendLimits 各时间窗口的额度限制，0 表示不限制
+type QuotaSpendLimits struct {
+	Daily   int `json:"daily"`
+	Weekly  int `json:"weekly"`
+	Monthly int `json:"monthly"`
+}
+
+func (limits QuotaSpendLimits) Enabled() bool {
+	return limits.Daily > 0 || limits.Weekly > 0 || limits.Monthly > 0
+}
+
+// QuotaSpendUsage 当前各时间窗口已消费的额度及窗口重置时间
+type QuotaSpendUsage struct {
+	Daily          int   `json:"daily"`
+	Weekly         int   `json:"weekly"`
+	Monthly        int   `json:"monthly"`
+	DailyResetAt   int64 `json:"daily_reset_at"`
+	WeeklyResetAt  int64 `json:"weekly_reset_at"`
+	MonthlyResetAt int64 `json:"monthly_reset_at"`
+}
+
+type quotaSpendWindows struct {
+	daily, weekly, monthly          time.Time
+	dailyEnd, weeklyEnd, monthlyEnd time.Time
+}
+
+func currentQuotaSpendWindows(now time.Time) quotaSpendWindows {
+	daily := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	// time.Weekday 以周日为 0，窗口从周一开始
+	weekly := daily.AddDate(0, 0, -((int(daily.Weekday()) + 6) % 7))
+	monthly := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	return quotaSpendWindows{
+		daily:      daily,
+		weekly:     weekly,
+		monthly:    monthly,
+		dailyEnd:   daily.AddDate(0, 0, 1),
+		weeklyEnd:  weekly.AddDate(0, 0, 7),
+		monthlyEnd: monthly.AddDate(0, 1, 0),
+	}
+}
+
+// GetQuotaSpendUsages 批量查询当前时间窗口的消费额度
+func GetQuotaSpendUsages(subjectType string, subjectIds []int) (map[int]*QuotaSpendUsage, error) {
+	windows := currentQuotaSpendWindows(time.Now())
+	usages := make(map[int]*QuotaSpendUsage, len(subjectIds))
+	for _, id := range subjectIds {
+		usages[id] = &QuotaSpendUsage{
+			DailyResetAt:   windows.dailyEnd.Unix(),
+			WeeklyResetAt:  windows.weeklyEnd.Unix(),
+			MonthlyResetAt: windows.monthlyEnd.Unix(),
+		}
+	}
+	if len(subjectIds) == 0 {
+		return usages, nil
+	}
+	var spends []QuotaSpend
+	err := DB.Where("subject_type = ? AND subject_id IN ? AND window_start >= ?", subjectType, subjectIds,
+		min(windows.weekly.Unix(), windows.monthly.Unix())).Find(&spends).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, spend := range spends {
+		usage, ok := usages[spend.SubjectId]
+		if !ok {
+			continue
+		}
+		used := max(spend.UsedQuota, 0)
+		switch {
+		case spend.Period == QuotaSpendPeriodDaily && spend.WindowStart == windows.daily.Unix():
+			usage.Daily = used
+		case spend.Period == QuotaSpendPeriodWeekly && spend.WindowStart == windows.weekly.Unix():
+			usage.Weekly = used
+		case spend.Period == QuotaSpendPeriodMonthly && spend.WindowStart == windows.monthly.Unix():
+			usage.Monthly = used
+		}
+	}
+	return usages, nil
+}
+
+func GetQuotaSpendUsage(subjectType string, subjectId int) (*QuotaSpendUsage, error) {
+	usages, err := GetQuotaSpendUsages(subjectType, []int{subjectId})
+	if err != nil {
+		return nil, err
+	}
+	return usages[subjectId], nil
+}
+
+// RecordQuotaSpend 将消费额度计入当前各时间窗口，quota 为负数时表示返还
+func RecordQuotaSpend(subjectType string, subjectId int, limits QuotaSpendLimits, quota int) error {
+	if quota == 0 || !limits.Enabled() {
+		return nil
+	}
+	windows := currentQuotaSpendWindows(time.Now())
+	periods := []struct {
+		period string
+		limit  int
+		start  time.Time
+	}{
+		{QuotaSpendPeriodDaily, limits.Daily, windows.daily},
+		{QuotaSpendPeriodWeekly, limits.Weekly, windows.weekly},
+		{QuotaSpendPeriodMonthly, limits.Monthly, windows.monthly},
+	}
+	for _, p := range periods {
+		if p.limit <= 0 {
+			continue
+		}
+		spend := QuotaSpend{
+			SubjectType: subjectType,
+			SubjectId:   subjectId,
+			Period:      p.period,
+			WindowStart: p.start.Unix(),
+			UsedQuota:   quota,
+		}
+		// 使用表名限定列名，避免 PostgreSQL 的 ON CONFLICT 中列名有歧义
+		err := DB.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "subject_type"}, {Name: "subject_id"}, {Name: "period"}, {Name: "window_start"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"used_quota": gorm.Expr("quota_spends.used_quota + ?", quota),
+			}),
+		}).Create(&spend).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReserveQuotaSpend 在不超过各时间窗口限制的前提下原子地计入 quota，返回超过限制的窗口，超过时不计入任何窗口。
+// 检查和计入在同一条条件更新中完成，并发请求不会同时通过检查；quota 为 0 时只检查窗口是否已用完
+func ReserveQuotaSpend(subjectType string, subjectId int, limits QuotaSpendLimits, quota int) (exceeded string, err error) {
+	if !limits.Enabled() {
+		return "", nil
+	}
+	windows := currentQuotaSpendWindows(time.Now())
+	periods := []struct {
+		period string
+		limit  int
+		start  time.Time
+	}{
+		{QuotaSpendPeriodDaily, limits.Daily, windows.daily},
+		{QuotaSpendPeriodWeekly, limits.Weekly, windows.weekly},
+		{QuotaSpendPeriodMonthly, limits.Monthly, windows.monthly},
+	}
+	errExceeded := errors.New("quota spend limit exceeded")
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		for _, p := range periods {
+			if p.limit <= 0 {
+				continue
+			}
+			query := tx.Model(&QuotaSpend{}).Where("subject_type = ? AND subject_id = ? AND period = ? AND window_start = ?",
+				subjectType, subjectId, p.period, p.start.Unix())
+			if quota <= 0 {
+				var count int64
+				if err := query.Where("used_quota >= ?", p.limit).Count(&count).Error; err != nil {
+					return err
+				}
+				if count > 0 {
+					exceeded = p.period
+					return errExceeded
+				}
+				continue
+			}
+			if quota > p.limit {
+				exceeded = p.period
+				return errExceeded
+			}
+			spend := QuotaSpend{
+				SubjectType: subjectType,
+				SubjectId:   subjectId,
+				Period:      p.period,
+				WindowStart: p.start.Unix(),
+			}
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&spend).Error; err != nil {
+				return err
+			}
+			result := query.Where("used_quota + ? <= ?", quota, p.limit).Update("used_quota", gorm.Expr("used_quota + ?", quota))
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				exceeded = p.period
+				return errExceeded
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, errExceeded) {
+		return exceeded, nil
+	}
+	return "", err
+}
+
+// CleanupQuotaSpends 定期清理过期的时间窗口记录
+func CleanupQuotaSpends() {
+	for {
+		before := time.Now().Add(-quotaSpendRetention).Unix()
+		if err := DB.Where("window_start < ?", before).Delete(&QuotaSpend{}).Error; err != nil {
+			common.SysError("failed to cleanup quota spends: " + err.Error())
+		}
+		time.Sleep(24 * time.Hour)
+	}
+}
@@ -0,0 +1,140 @@
+package model
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+func TestCurrentQuotaSpendWindows(t *testing.T) {
+	loc := time.FixedZone("UTC+8", 8*3600)
+	tests := []struct {
+		name       string
+		now        time.Time
+		daily      time.Time
+		weekly     time.Time
+		monthly    time.Time
+		weeklyEnd  time.Time
+		monthlyEnd time.Time
+	}{
+		{
+			name:       "monday starts the week",
+			now:        time.Date(2026, 10, 12, 0, 0, 0, 0, loc),
+			daily:      time.Date(2026, 10, 12, 0, 0, 0, 0, loc),
+			weekly:     time.Date(2026, 10, 12, 0, 0, 0, 0, loc),
+			monthly:    time.Date(2026, 10, 1, 0, 0, 0, 0, loc),
+			weeklyEnd:  time.Date(2026, 10, 19, 0, 0, 0, 0, loc),
+			monthlyEnd: time.Date(2026, 11, 1, 0, 0, 0, 0, loc),
+		},
+		{
+			name:       "sunday belongs to the previous monday",
+			now:        time.Date(2026, 10, 18, 23, 59, 59, 0, loc),
+			daily:      time.Date(2026, 10, 18, 0, 0, 0, 0, loc),
+			weekly:     time.Date(2026, 10, 12, 0, 0, 0, 0, loc),
+			monthly:    time.Date(2026, 10, 1, 0, 0, 0, 0, loc),
+			weeklyEnd:  time.Date(2026, 10, 19, 0, 0, 0, 0, loc),
+			monthlyEnd: time.Date(2026, 11, 1, 0, 0, 0, 0, loc),
+		},
+		{
+			name:       "week spanning a month boundary",
+			now:        time.Date(2026, 3, 1, 12, 0, 0, 0, loc),
+			daily:      time.Date(2026, 3, 1, 0, 0, 0, 0, loc),
+			weekly:     time.Date(2026, 2, 23, 0, 0, 0, 0, loc),
+			monthly:    time.Date(2026, 3, 1, 0, 0, 0, 0, loc),
+			weeklyEnd:  time.Date(2026, 3, 2, 0, 0, 0, 0, loc),
+			monthlyEnd: time.Date(2026, 4, 1, 0, 0, 0, 0, loc),
+		},
+		{
+			name:       "month and year rollover",
+			now:        time.Date(2026, 12, 31, 23, 0, 0, 0, loc),
+			daily:      time.Date(2026, 12, 31, 0, 0, 0, 0, loc),
+			weekly:     time.Date(2026, 12, 28, 0, 0, 0, 0, loc),
+			monthly:    time.Date(2026, 12, 1, 0, 0, 0, 0, loc),
+			weeklyEnd:  time.Date(2027, 1, 4, 0, 0, 0, 0, loc),
+			monthlyEnd: time.Date(2027, 1, 1, 0, 0, 0, 0, loc),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := currentQuotaSpendWindows(tt.now)
+			if !w.daily.Equal(tt.daily) || !w.dailyEnd.Equal(tt.daily.AddDate(0, 0, 1)) {
+				t.Errorf("daily = %v..%v, want %v", w.daily, w.dailyEnd, tt.daily)
+			}
+			if !w.weekly.Equal(tt.weekly) || !w.weeklyEnd.Equal(tt.weeklyEnd) {
+				t.Errorf("weekly = %v..%v, want %v..%v", w.weekly, w.weeklyEnd, tt.weekly, tt.weeklyEnd)
+			}
+			if !w.monthly.Equal(tt.monthly) || !w.monthlyEnd.Equal(tt.monthlyEnd) {
+				t.Errorf("monthly = %v..%v, want %v..%v", w.monthly, w.monthlyEnd, tt.monthly, tt.monthlyEnd)
+			}
+		})
+	}
+}
+
+func setupQuotaSpendTestDB(t *testing.T) {
+	t.Helper()
+	t.Setenv("SQL_DSN", "")
+	common.SQLitePath = "file:model_quota_spend_test?mode=memory&cache=shared"
+	common.RedisEnabled = false
+	common.IsMasterNode = true
+	if err := InitDB(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReserveQuotaSpendHoldsLimitUnderConcurrency(t *testing.T) {
+	setupQuotaSpendTestDB(t)
+	if err := DB.Where("subject_id = ?", 201).Delete(&QuotaSpend{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	limits := QuotaSpendLimits{Daily: 1000, Monthly: 5000}
+	var reserved atomic.Int64
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < 25; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			for {
+				exceeded, err := ReserveQuotaSpend(QuotaSpendSubjectToken, 201, limits, 100)
+				if err != nil {
+					// SQLite 共享缓存下并发写可能返回锁冲突，重试即可
+					time.Sleep(time.Millisecond)
+					continue
+				}
+				if exceeded == "" {
+					reserved.Add(100)
+				}
+				return
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	if got := reserved.Load(); got != 1000 {
+		t.Fatalf("reserved %d, want exactly the daily limit 1000", got)
+	}
+	usage, err := GetQuotaSpendUsage(QuotaSpendSubjectToken, 201)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Daily != 1000 || usage.Monthly != 1000 {
+		t.Fatalf("usage = daily %d monthly %d, want 1000", usage.Daily, usage.Monthly)
+	}
+	// 某个窗口超过限制时其他窗口也不计入
+	exceeded, err := ReserveQuotaSpend(QuotaSpendSubjectToken, 201, limits, 1)
+	if err != nil || exceeded != QuotaSpendPeriodDaily {
+		t.Fatalf("exceeded = %q err = %v, want daily", exceeded, err)
+	}
+	usage, _ = GetQuotaSpendUsage(QuotaSpendSubjectToken, 201)
+	if usage.Monthly != 1000 {
+		t.Fatalf("monthly usage = %d after a rejected reservation, want 1000", usage.Monthly)
+	}
+	// 窗口已用完时不预扣的请求同样被拒绝
+	if exceeded, err := ReserveQuotaSpend(QuotaSpendSubjectToken, 201, limits, 0); err != nil || exceeded != QuotaSpendPeriodDaily {
+		t.Fatalf("zero-quota reservation: exceeded = %q err = %v, want daily", exceeded, err)
+	}
+}
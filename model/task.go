@@ -46,6 +46,7 @@ type Task struct {
 	TaskID     string                `json:"task_id" gorm:"type:varchar(191);index"` // 第三方id，不一定有/ song id\ Task id
 	Platform   constant.TaskPlatform `json:"platform" gorm:"type:varchar(30);index"` // 平台
 	UserId     int                   `json:"user_id" gorm:"index"`
+	TokenId    int                   `json:"-" gorm:"default:0"`            // 提交任务的令牌，退款时从令牌的按天、周、月消费中扣除
 	Group      string                `json:"group" gorm:"type:varchar(50)"` // 修正计费用
 	ChannelId  int                   `json:"channel_id" gorm:"index"`
 	Quota      int                   `json:"quota"`
@@ -57,6 +58,11 @@ type Task struct {
 	FinishTime int64                 `json:"finish_time" gorm:"index"`
 	Progress   string                `json:"progress" gorm:"type:varchar(20);index"`
 	Properties Properties            `json:"properties" gorm:"type:json"`
+	// CallbackUrl 任务完成后推送通知的地址，来自请求的 callback_url 或令牌配置
+	CallbackUrl string `json:"callback_url,omitempty" gorm:"type:varchar(512)"`
+	// NextPollTime 下次查询上游状态的时间，PollAttempts 为状态未变化时的连续查询次数，用于计算退避间隔
+	NextPollTime int64 `json:"-" gorm:"bigint;index;default:0"`
+	PollAttempts int   `json:"-" gorm:"default:0"`
 
 	Data json.RawMessage `json:"data" gorm:"type:json"`
 }
@@ -73,6 +79,10 @@ func (t *Task) GetData(v any) error {
 
 type Properties struct {
 	Input string `json:"input"`
+	// PushEnabled 提交时附带了网关的推送地址，上游会主动推送任务状态
+	PushEnabled bool `json:"push_enabled,omitempty"`
+	// KeyIndex 多 Key 渠道提交任务时使用的 Key 索引
+	KeyIndex int `json:"key_index,omitempty"`
 }
 
 func (m *Properties) Scan(val interface{}) error {
@@ -107,9 +117,30 @@ func InitTask(platform constant.TaskPlatform, relayInfo *commonRelay.RelayInfo)
 		ChannelId:  relayInfo.ChannelId,
 		Platform:   platform,
 	}
+	if !relayInfo.IsPlayground {
+		t.TokenId = relayInfo.TokenId
+	}
+	if relayInfo.ChannelIsMultiKey {
+		t.Properties.KeyIndex = relayInfo.ChannelMultiKeyIndex
+	}
 	return t
 }
 
+// ChannelKey 返回提交任务时使用的渠道 Key，查询状态和下载结果时需要使用同一个 Key
+func (t *Task) ChannelKey(channel *Channel) string {
+	if !channel.ChannelInfo.IsMultiKey {
+		return channel.Key
+	}
+	keys := channel.GetKeys()
+	if t.Properties.KeyIndex >= 0 && t.Properties.KeyIndex < len(keys) {
+		return keys[t.Properties.KeyIndex]
+	}
+	if len(keys) > 0 {
+		return keys[0]
+	}
+	return channel.Key
+}
+
 func TaskGetAllUserTask(userId int, startIdx int, num int, queryParams SyncTaskQueryParams) []*Task {
 	var tasks []*Task
 	var err error
@@ -191,17 +222,27 @@ func TaskGetAllTasks(startIdx int, num int, queryParams SyncTaskQueryParams) []*
 	return tasks
 }
 
-func GetAllUnFinishSyncTasks(limit int) []*Task {
+// GetDueUnFinishSyncTasks 获取未完成且到达查询时间的任务
+func GetDueUnFinishSyncTasks(now int64, limit int) []*Task {
 	var tasks []*Task
 	var err error
 	// get all tasks progress is not 100%
-	err = DB.Where("progress != ?", "100%").Where("status != ?", TaskStatusFailure).Where("status != ?", TaskStatusSuccess).Limit(limit).Order("id").Find(&tasks).Error
+	err = DB.Where("progress != ?", "100%").Where("status != ?", TaskStatusFailure).Where("status != ?", TaskStatusSuccess).
+		Where("next_poll_time <= ?", now).Limit(limit).Order("next_poll_time, id").Find(&tasks).Error
 	if err != nil {
 		return nil
 	}
 	return tasks
 }
 
+// TaskSetNextPoll 记录任务下次查询的时间和连续查询次数
+func TaskSetNextPoll(id int64, nextPollTime int64, pollAttempts int) error {
+	return DB.Model(&Task{}).Where("id = ?", id).Updates(map[string]any{
+		"next_poll_time": nextPollTime,
+		"poll_attempts":  pollAttempts,
+	}).Error
+}
+
 func GetByOnlyTaskId(taskId string) (*Task, bool, error) {
 	if taskId == "" {
 		return nil, false, nil
@@ -261,6 +302,15 @@ func (Task *Task) Update() error {
 	return err
 }
 
+// UpdateWithStatus 仅当数据库中的状态仍为 fromStatus 时保存任务，返回是否保存成功，防止并发更新重复结算
+func (Task *Task) UpdateWithStatus(fromStatus TaskStatus) (bool, error) {
+	result := DB.Model(Task).Where("status = ?", fromStatus).Select("*").Updates(Task)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
 func TaskBulkUpdate(TaskIds []string, params map[string]any) error {
 	if len(TaskIds) == 0 {
 		return nil
@@ -0,0 +1,74 @@
+package model
+
+import "github.com/QuantumNous/new-api/common"
+
+// 异步任务生成结果在网关存储中的副本。
+// 任务成功后下载上游结果保存到存储中，通过 /v1/artifacts/:artifact_id 提供固定地址，
+// 设置了保留天数时到期后删除存储内容和记录。
+
+const (
+	TaskArtifactTypeTask       = "task"
+	TaskArtifactTypeMidjourney = "midjourney"
+)
+
+type TaskArtifact struct {
+	Id          int64  `json:"id"`
+	ArtifactId  string `json:"artifact_id" gorm:"type:varchar(64);uniqueIndex"`
+	UserId      int    `json:"user_id" gorm:"index"`
+	TaskType    string `json:"task_type" gorm:"type:varchar(20);uniqueIndex:idx_task_artifact_task,priority:1"`
+	TaskId      string `json:"task_id" gorm:"type:varchar(191);uniqueIndex:idx_task_artifact_task,priority:2"`
+	SourceUrl   string `json:"source_url" gorm:"type:text"`
+	StorageKey  string `json:"storage_key" gorm:"type:varchar(255)"`
+	ContentType string `json:"content_type" gorm:"type:varchar(100)"`
+	Bytes       int64  `json:"bytes"`
+	CreatedAt   int64  `json:"created_at" gorm:"bigint"`
+	ExpiresAt   int64  `json:"expires_at" gorm:"bigint;index"`
+}
+
+func (artifact *TaskArtifact) Insert() error {
+	return DB.Create(artifact).Error
+}
+
+func (artifact *TaskArtifact) Delete() error {
+	return DB.Delete(artifact).Error
+}
+
+// IsExpired 结果副本设置了过期时间且已过期，过期副本在定时清理前也不再返回
+func (artifact *TaskArtifact) IsExpired() bool {
+	return artifact.ExpiresAt > 0 && artifact.ExpiresAt < common.GetTimestamp()
+}
+
+func GetTaskArtifactByArtifactId(artifactId string) (*TaskArtifact, error) {
+	var artifact TaskArtifact
+	err := DB.Where("artifact_id = ?", artifactId).First(&artifact).Error
+	if err != nil {
+		return nil, err
+	}
+	return &artifact, nil
+}
+
+// GetTaskArtifact 获取任务的结果副本，不存在时返回 nil
+func GetTaskArtifact(taskType string, taskId string) (*TaskArtifact, error) {
+	var artifacts []*TaskArtifact
+	err := DB.Where("task_type = ? and task_id = ?", taskType, taskId).Order("id desc").Limit(1).Find(&artifacts).Error
+	if err != nil || len(artifacts) == 0 {
+		return nil, err
+	}
+	return artifacts[0], nil
+}
+
+func GetExpiredTaskArtifacts(now int64, limit int) ([]*TaskArtifact, error) {
+	var artifacts []*TaskArtifact
+	err := DB.Where("expires_at > 0 and expires_at < ?", now).Limit(limit).Find(&artifacts).Error
+	return artifacts, err
+}
+
+// SetTaskResultUrl 更新视频任务的结果地址，成功的视频任务把结果地址保存在 fail_reason 中
+func SetTaskResultUrl(id int64, resultUrl string) error {
+	return DB.Model(&Task{}).Where("id = ?", id).Update("fail_reason", resultUrl).Error
+}
+
+// SetMidjourneyResultUrl 更新 Midjourney 任务的图片或视频地址
+func SetMidjourneyResultUrl(id int, column string, resultUrl string) error {
+	return DB.Model(&Midjourney{}).Where("id = ?", id).Update(column, resultUrl).Error
+}
@@ -0,0 +1,82 @@
+package model
+
+// 异步任务完成回调的投递记录。
+// 任务进入成功或失败状态时写入一条 pending 记录并立即投递，失败后按 next_retry_time 重试，
+// 达到最大次数后标记为 failed。投递前先把 next_retry_time 推后作为租约，避免同一条记录被重复投递。
+
+const (
+	TaskCallbackTypeTask       = "task"
+	TaskCallbackTypeMidjourney = "midjourney"
+
+	TaskCallbackStatusPending = "pending"
+	TaskCallbackStatusSuccess = "success"
+	TaskCallbackStatusFailed  = "failed"
+)
+
+type TaskCallback struct {
+	Id            int64  `json:"id"`
+	CreatedAt     int64  `json:"created_at" gorm:"index"`
+	UpdatedAt     int64  `json:"updated_at"`
+	UserId        int    `json:"user_id" gorm:"index"`
+	TaskType      string `json:"task_type" gorm:"type:varchar(20)"`
+	TaskId        string `json:"task_id" gorm:"type:varchar(191);index"`
+	TaskStatus    string `json:"task_status" gorm:"type:varchar(20)"`
+	Url           string `json:"url" gorm:"type:varchar(512)"`
+	Payload       string `json:"payload" gorm:"type:text"`
+	Status        string `json:"status" gorm:"type:varchar(20);index"`
+	Attempts      int    `json:"attempts" gorm:"default:0"`
+	NextRetryTime int64  `json:"next_retry_time" gorm:"bigint;index"`
+	StatusCode    int    `json:"status_code"`
+	LastError     string `json:"last_error" gorm:"type:text"`
+}
+
+func (callback *TaskCallback) Insert() error {
+	return DB.Create(callback).Error
+}
+
+// Claim 把 next_retry_time 推后到 leaseUntil，只有记录仍是 pending 且没有被其他投递抢先时才成功
+func (callback *TaskCallback) Claim(leaseUntil int64) (bool, error) {
+	result := DB.Model(&TaskCallback{}).
+		Where("id = ? and status = ? and next_retry_time = ?", callback.Id, TaskCallbackStatusPending, callback.NextRetryTime).
+		Update("next_retry_time", leaseUntil)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	callback.NextRetryTime = leaseUntil
+	return true, nil
+}
+
+// SaveAttempt 记录一次投递的结果
+func (callback *TaskCallback) SaveAttempt() error {
+	return DB.Model(callback).Select("status", "attempts", "next_retry_time", "status_code", "last_error").Updates(callback).Error
+}
+
+// GetDueTaskCallbacks 获取到达重试时间的待投递记录
+func GetDueTaskCallbacks(now int64, limit int) ([]*TaskCallback, error) {
+	var callbacks []*TaskCallback
+	err := DB.Where("status = ? and next_retry_time <= ?", TaskCallbackStatusPending, now).
+		Order("next_retry_time asc").Limit(limit).Find(&callbacks).Error
+	return callbacks, err
+}
+
+func GetUserTaskCallbacks(userId int, taskId string, startIdx int, num int) ([]*TaskCallback, int64, error) {
+	var callbacks []*TaskCallback
+	var total int64
+	query := DB.Model(&TaskCallback{}).Where("user_id = ?", userId)
+	if taskId != "" {
+		query = query.Where("task_id = ?", taskId)
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err := query.Order("id desc").Limit(num).Offset(startIdx).Find(&callbacks).Error
+	return callbacks, total, err
+}
+
+// DeleteTaskCallbacksBefore 删除创建时间早于 before 且已经结束投递的记录
+func DeleteTaskCallbacksBefore(before int64) error {
+	return DB.Where("created_at < ? and status <> ?", before, TaskCallbackStatusPending).Delete(&TaskCallback{}).Error
+}
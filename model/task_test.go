@@ -0,0 +1,28 @@
+package model
+
+import "testing"
+
+func TestTaskChannelKey(t *testing.T) {
+	single := &Channel{Key: "sk-single"}
+	multi := &Channel{Key: "sk-a\nsk-b\nsk-c"}
+	multi.ChannelInfo.IsMultiKey = true
+
+	tests := []struct {
+		name     string
+		channel  *Channel
+		keyIndex int
+		want     string
+	}{
+		{name: "single key channel", channel: single, keyIndex: 2, want: "sk-single"},
+		{name: "multi key uses the submit key", channel: multi, keyIndex: 1, want: "sk-b"},
+		{name: "index out of range falls back to the first key", channel: multi, keyIndex: 5, want: "sk-a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			task := &Task{Properties: Properties{KeyIndex: tt.keyIndex}}
+			if got := task.ChannelKey(tt.channel); got != tt.want {
+				t.Fatalf("ChannelKey = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
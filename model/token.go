@@ -27,6 +27,13 @@ type Token struct {
 	AllowIps           *string        `json:"allow_ips" gorm:"default:''"`
 	UsedQuota          int            `json:"used_quota" gorm:"default:0"` // used quota
 	Group              string         `json:"group" gorm:"default:''"`
+	ResponseCache      bool           `json:"response_cache"`                                   // 开启响应缓存
+	DailyQuotaLimit    int            `json:"daily_quota_limit" gorm:"default:0"`               // 每日额度限制，0 表示不限制
+	WeeklyQuotaLimit   int            `json:"weekly_quota_limit" gorm:"default:0"`              // 每周额度限制，0 表示不限制
+	MonthlyQuotaLimit  int            `json:"monthly_quota_limit" gorm:"default:0"`             // 每月额度限制，0 表示不限制
+	TPMLimit           int            `json:"tpm_limit" gorm:"default:0"`                       // 每分钟 token 数限制，0 表示使用系统默认
+	ConcurrencyLimit   int            `json:"concurrency_limit" gorm:"default:0"`               // 同时进行的请求数限制，0 表示使用系统默认
+	CallbackUrl        string         `json:"callback_url" gorm:"type:varchar(512);default:''"` // 异步任务完成回调地址，请求中未指定 callback_url 时使用
 	DeletedAt          gorm.DeletedAt `gorm:"index"`
 }
 
@@ -34,6 +41,14 @@ func (token *Token) Clean() {
 	token.Key = ""
 }
 
+func (token *Token) GetQuotaSpendLimits() QuotaSpendLimits {
+	return QuotaSpendLimits{
+		Daily:   token.DailyQuotaLimit,
+		Weekly:  token.WeeklyQuotaLimit,
+		Monthly: token.MonthlyQuotaLimit,
+	}
+}
+
 func (token *Token) GetIpLimitsMap() map[string]any {
 	// delete empty spaces
 	//split with \n
@@ -185,7 +200,8 @@ func (token *Token) Update() (err error) {
 		}
 	}()
 	err = DB.Model(token).Select("name", "status", "expired_time", "remain_quota", "unlimited_quota",
-		"model_limits_enabled", "model_limits", "allow_ips", "group").Updates(token).Error
+		"model_limits_enabled", "model_limits", "allow_ips", "group", "response_cache",
+		"daily_quota_limit", "weekly_quota_limit", "monthly_quota_limit", "tpm_limit", "concurrency_limit", "callback_url").Updates(token).Error
 	return err
 }
 
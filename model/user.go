@@ -13,6 +13,7 @@ import (
 
 	"github.com/bytedance/gopkg/util/gopool"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // User if you add sensitive fields, don't forget to clean them in setupLogin function.
@@ -46,6 +47,10 @@ type User struct {
 	Setting          string         `json:"setting" gorm:"type:text;column:setting"`
 	Remark           string         `json:"remark,omitempty" gorm:"type:varchar(255)" validate:"max=255"`
 	StripeCustomer   string         `json:"stripe_customer" gorm:"type:varchar(64);column:stripe_customer;index"`
+	// 按天、周、月的额度限制，0 表示不限制
+	DailyQuotaLimit   int `json:"daily_quota_limit" gorm:"type:int;default:0"`
+	WeeklyQuotaLimit  int `json:"weekly_quota_limit" gorm:"type:int;default:0"`
+	MonthlyQuotaLimit int `json:"monthly_quota_limit" gorm:"type:int;default:0"`
 }
 
 func (user *User) ToBaseUser() *UserBase {
@@ -57,6 +62,10 @@ func (user *User) ToBaseUser() *UserBase {
 		Username: user.Username,
 		Setting:  user.Setting,
 		Email:    user.Email,
+
+		DailyQuotaLimit:   user.DailyQuotaLimit,
+		WeeklyQuotaLimit:  user.WeeklyQuotaLimit,
+		MonthlyQuotaLimit: user.MonthlyQuotaLimit,
 	}
 	return cache
 }
@@ -462,6 +471,10 @@ func (user *User) Edit(updatePassword bool) error {
 		"group":        newUser.Group,
 		"quota":        newUser.Quota,
 		"remark":       newUser.Remark,
+
+		"daily_quota_limit":   newUser.DailyQuotaLimit,
+		"weekly_quota_limit":  newUser.WeeklyQuotaLimit,
+		"monthly_quota_limit": newUser.MonthlyQuotaLimit,
 	}
 	if updatePassword {
 		updates["password"] = newUser.Password
@@ -750,6 +763,43 @@ func GetUserSetting(id int, fromDB bool) (settingMap dto.UserSetting, err error)
 	return userBase.GetSetting(), nil
 }
 
+// EnsureUserWebhookSecret 返回用户的 webhook 密钥，未设置时生成并保存。
+// 任务回调总是签名，用户首次使用回调地址时生成密钥，可在个人设置中查看
+func EnsureUserWebhookSecret(id int) (string, error) {
+	var secret string
+	var setting string
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var user User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "setting").First(&user, id).Error; err != nil {
+			return err
+		}
+		userSetting := user.GetSetting()
+		if userSetting.WebhookSecret == "" {
+			generated, err := common.GenerateRandomCharsKey(32)
+			if err != nil {
+				return err
+			}
+			userSetting.WebhookSecret = generated
+			user.SetSetting(userSetting)
+			if err := tx.Model(&User{}).Where("id = ?", id).Update("setting", user.Setting).Error; err != nil {
+				return err
+			}
+			setting = user.Setting
+		}
+		secret = userSetting.WebhookSecret
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if setting != "" {
+		if err := updateUserSettingCache(id, setting); err != nil {
+			common.SysLog("failed to update user setting cache: " + err.Error())
+		}
+	}
+	return secret, nil
+}
+
 func IncreaseUserQuota(id int, quota int, db bool) (err error) {
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
@@ -22,6 +22,10 @@ type UserBase struct {
 	Status   int    `json:"status"`
 	Username string `json:"username"`
 	Setting  string `json:"setting"`
+
+	DailyQuotaLimit   int `json:"daily_quota_limit"`
+	WeeklyQuotaLimit  int `json:"weekly_quota_limit"`
+	MonthlyQuotaLimit int `json:"monthly_quota_limit"`
 }
 
 func (user *UserBase) WriteContext(c *gin.Context) {
@@ -31,6 +35,15 @@ func (user *UserBase) WriteContext(c *gin.Context) {
 	common.SetContextKey(c, constant.ContextKeyUserEmail, user.Email)
 	common.SetContextKey(c, constant.ContextKeyUserName, user.Username)
 	common.SetContextKey(c, constant.ContextKeyUserSetting, user.GetSetting())
+	common.SetContextKey(c, constant.ContextKeyUserQuotaSpendLimited, user.GetQuotaSpendLimits().Enabled())
+}
+
+func (user *UserBase) GetQuotaSpendLimits() QuotaSpendLimits {
+	return QuotaSpendLimits{
+		Daily:   user.DailyQuotaLimit,
+		Weekly:  user.WeeklyQuotaLimit,
+		Monthly: user.MonthlyQuotaLimit,
+	}
 }
 
 func (user *UserBase) GetSetting() dto.UserSetting {
@@ -99,15 +112,7 @@ func GetUserCache(userId int) (userCache *UserBase, err error) {
 	}
 
 	// Create cache object from user data
-	userCache = &UserBase{
-		Id:       user.Id,
-		Group:    user.Group,
-		Quota:    user.Quota,
-		Status:   user.Status,
-		Username: user.Username,
-		Setting:  user.Setting,
-		Email:    user.Email,
-	}
+	userCache = user.ToBaseUser()
 
 	return userCache, nil
 }
@@ -0,0 +1,46 @@
+package relay
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common/tracing"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/relay/channel"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// tracedAdaptor 为 DoRequest / DoResponse 创建 span，开启链路追踪时由 GetAdaptor 返回
+type tracedAdaptor struct {
+	channel.Adaptor
+}
+
+func (a *tracedAdaptor) DoRequest(c *gin.Context, info *relaycommon.RelayInfo, requestBody io.Reader) (any, error) {
+	span := tracing.StartClient(c, "adaptor.DoRequest",
+		attribute.String("newapi.upstream_model", info.UpstreamModelName),
+		attribute.Bool("newapi.stream", info.IsStream),
+	)
+	resp, err := a.Adaptor.DoRequest(c, info, requestBody)
+	if httpResp, ok := resp.(*http.Response); ok && httpResp != nil {
+		span.SetAttributes(attribute.Int("http.response.status_code", httpResp.StatusCode))
+	}
+	span.End(err)
+	return resp, err
+}
+
+func (a *tracedAdaptor) DoResponse(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (any, *types.NewAPIError) {
+	span := tracing.Start(c, "adaptor.DoResponse")
+	usage, newAPIError := a.Adaptor.DoResponse(c, resp, info)
+	if u, ok := usage.(*dto.Usage); ok && u != nil {
+		span.SetAttributes(
+			attribute.Int("newapi.prompt_tokens", u.PromptTokens),
+			attribute.Int("newapi.completion_tokens", u.CompletionTokens),
+		)
+	}
+	span.End(newAPIError)
+	return usage, newAPIError
+}
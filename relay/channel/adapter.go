@@ -52,6 +52,17 @@ type TaskAdaptor interface {
 	ParseTaskResult(respBody []byte) (*relaycommon.TaskInfo, error)
 }
 
+// TaskPushParser 支持上游主动推送任务状态的任务适配器，提交任务时把 RelayInfo.PushUrl 作为回调地址传给上游
+type TaskPushParser interface {
+	// ParseTaskPush 从推送内容中取出上游任务 ID，推送内容不作为任务结果，收到推送后立即通过 FetchTask 查询
+	ParseTaskPush(body []byte) (taskID string, err error)
+}
+
+// TaskCanceler 支持取消上游任务的任务适配器
+type TaskCanceler interface {
+	CancelTask(baseUrl, key string, body map[string]any) (*http.Response, error)
+}
+
 type OpenAIVideoConverter interface {
 	ConvertToOpenAIVideo(originTask *model.Task) ([]byte, error)
 }
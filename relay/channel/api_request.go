@@ -11,6 +11,7 @@ import (
 	"time"
 
 	common2 "github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/tracing"
 	"github.com/QuantumNous/new-api/logger"
 	"github.com/QuantumNous/new-api/relay/common"
 	"github.com/QuantumNous/new-api/relay/constant"
@@ -284,6 +285,12 @@ func doRequest(c *gin.Context, req *http.Request, info *common.RelayInfo) (*http
 		}
 	}
 
+	// 对冲请求中落败的尝试需要及时取消上游请求
+	if attempt := service.GetHedgeAttempt(c); attempt != nil {
+		req = req.WithContext(attempt.Context())
+	}
+	// 向上游传递 W3C traceparent
+	tracing.Inject(c.Request.Context(), req.Header)
 	resp, err := client.Do(req)
 	if err != nil {
 		logger.LogError(c, "do request failed: "+err.Error())
@@ -292,6 +299,9 @@ func doRequest(c *gin.Context, req *http.Request, info *common.RelayInfo) (*http
 	if resp == nil {
 		return nil, errors.New("resp is nil")
 	}
+	if info.ChannelMeta != nil {
+		service.RecordUpstreamRateLimit(info.ChannelId, info.ChannelIsMultiKey, info.ChannelMultiKeyIndex, resp.StatusCode, resp.Header)
+	}
 
 	_ = req.Body.Close()
 	_ = c.Request.Body.Close()
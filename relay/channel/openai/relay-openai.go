@@ -143,6 +143,16 @@ func OaiStreamHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http.Re
 		return true
 	})
 
+	// 上游中断等待续写时，中断前收到的最后一块数据仍要发给客户端并计入续写内容，之后的结束标志和用量不再发送
+	if info.StreamResume.Pending() && lastStreamData != "" {
+		info.StreamResume.Reset()
+		err := HandleStreamFormat(c, info, lastStreamData, info.ChannelSetting.ForceFormat, info.ChannelSetting.ThinkingToContent)
+		if err != nil {
+			common.SysLog("error handling stream format: " + err.Error())
+		}
+		info.StreamResume.MarkPending()
+	}
+
 	// 处理最后的响应
 	shouldSendLastResp := true
 	if err := handleLastResponse(lastStreamData, &responseId, &createAt, &systemFingerprint, &model, &usage,
@@ -153,6 +153,8 @@ func (a *TaskAdaptor) BuildRequestBody(c *gin.Context, info *relaycommon.RelayIn
 	if err != nil {
 		return nil, err
 	}
+	// 上游只推送到网关，客户端的回调地址（包括 metadata 中的 callback_url）由网关收到推送后再投递，不转发给上游
+	body.CallbackUrl = info.PushUrl
 	if body.Image == "" && body.ImageTail == "" {
 		c.Set("action", constant.TaskActionTextGenerate)
 	}
@@ -364,6 +366,20 @@ func (a *TaskAdaptor) ParseTaskResult(respBody []byte) (*relaycommon.TaskInfo, e
 	return taskInfo, nil
 }
 
+// ParseTaskPush 可灵推送的内容为查询接口响应中的 data 部分
+func (a *TaskAdaptor) ParseTaskPush(body []byte) (string, error) {
+	var pushData struct {
+		TaskId string `json:"task_id"`
+	}
+	if err := json.Unmarshal(body, &pushData); err != nil {
+		return "", errors.Wrap(err, "failed to unmarshal push body")
+	}
+	if pushData.TaskId == "" {
+		return "", errors.New("task_id is empty")
+	}
+	return pushData.TaskId, nil
+}
+
 func isNewAPIRelay(apiKey string) bool {
 	return strings.HasPrefix(apiKey, "sk-")
 }
@@ -85,7 +85,7 @@ func (a *TaskAdaptor) ValidateRequestAndSetAction(c *gin.Context, info *relaycom
 	return relaycommon.ValidateBasicTaskRequest(c, info, constant.TaskActionGenerate)
 }
 
-func (a *TaskAdaptor) BuildRequestBody(c *gin.Context, _ *relaycommon.RelayInfo) (io.Reader, error) {
+func (a *TaskAdaptor) BuildRequestBody(c *gin.Context, info *relaycommon.RelayInfo) (io.Reader, error) {
 	v, exists := c.Get("task_request")
 	if !exists {
 		return nil, fmt.Errorf("request not found in context")
@@ -96,6 +96,8 @@ func (a *TaskAdaptor) BuildRequestBody(c *gin.Context, _ *relaycommon.RelayInfo)
 	if err != nil {
 		return nil, err
 	}
+	// 上游只推送到网关，客户端的回调地址（包括 metadata 中的 callback_url）由网关收到推送后再投递，不转发给上游
+	body.CallbackUrl = info.PushUrl
 
 	if len(body.Images) == 0 {
 		c.Set("action", constant.TaskActionTextGenerate)
@@ -184,6 +186,30 @@ func (a *TaskAdaptor) FetchTask(baseUrl, key string, body map[string]any) (*http
 	return service.GetHttpClient().Do(req)
 }
 
+func (a *TaskAdaptor) CancelTask(baseUrl, key string, body map[string]any) (*http.Response, error) {
+	taskID, ok := body["task_id"].(string)
+	if !ok {
+		return nil, fmt.Errorf("invalid task_id")
+	}
+
+	url := fmt.Sprintf("%s/ent/v2/tasks/%s/cancel", baseUrl, taskID)
+	data, err := json.Marshal(map[string]string{"id": taskID})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Token "+key)
+
+	return service.GetHttpClient().Do(req)
+}
+
 func (a *TaskAdaptor) GetModelList() []string {
 	return []string{"viduq1", "vidu2.0", "vidu1.5"}
 }
@@ -291,3 +317,22 @@ func (a *TaskAdaptor) ConvertToOpenAIVideo(originTask *model.Task) ([]byte, erro
 	jsonData, _ := common.Marshal(openAIVideo)
 	return jsonData, nil
 }
+
+// ParseTaskPush Vidu 推送的内容与查询任务接口的响应相同
+func (a *TaskAdaptor) ParseTaskPush(body []byte) (string, error) {
+	var pushData struct {
+		Id     string `json:"id"`
+		TaskId string `json:"task_id"`
+	}
+	if err := json.Unmarshal(body, &pushData); err != nil {
+		return "", errors.Wrap(err, "failed to unmarshal push body")
+	}
+	taskId := pushData.TaskId
+	if taskId == "" {
+		taskId = pushData.Id
+	}
+	if taskId == "" {
+		return "", errors.New("task id is empty")
+	}
+	return taskId, nil
+}
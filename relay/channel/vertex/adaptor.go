@@ -191,6 +191,9 @@ func (a *Adaptor) GetRequestURL(info *relaycommon.RelayInfo) (string, error) {
 		}
 		return a.getRequestUrl(info, info.UpstreamModelName, suffix)
 	} else if a.RequestMode == RequestModeClaude {
+		if info.RelayMode == constant.RelayModeClaudeCountTokens {
+			return a.getRequestUrl(info, "count-tokens", "rawPredict")
+		}
 		if info.IsStream {
 			suffix = "streamRawPredict?alt=sse"
 		} else {
@@ -0,0 +1,122 @@
+package relay
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/relay/helper"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ClaudeCountTokensHelper 处理 /v1/messages/count_tokens，不扣除额度。
+// Anthropic 与 Vertex（服务账号）渠道转发到上游获取准确值，其余渠道使用本地估算。
+// AWS 暂不支持上游计数：当前依赖的 bedrockruntime SDK 版本未提供 CountTokens 接口，同样使用本地估算。
+func ClaudeCountTokensHelper(c *gin.Context, info *relaycommon.RelayInfo) *types.NewAPIError {
+	info.InitChannelMeta(c)
+
+	claudeReq, ok := info.Request.(*dto.ClaudeRequest)
+	if !ok {
+		return types.NewErrorWithStatusCode(fmt.Errorf("invalid request type, expected *dto.ClaudeRequest, got %T", info.Request), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+	request, err := common.DeepCopy(claudeReq)
+	if err != nil {
+		return types.NewError(fmt.Errorf("failed to copy request to ClaudeRequest: %w", err), types.ErrorCodeInvalidRequest, types.ErrOptionWithSkipRetry())
+	}
+	err = helper.ModelMappedHelper(c, info, request)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeChannelModelMappedError, types.ErrOptionWithSkipRetry())
+	}
+
+	if supportUpstreamCountTokens(info) {
+		resp, newAPIError := doUpstreamCountTokens(c, info, request)
+		if newAPIError == nil {
+			c.JSON(http.StatusOK, resp)
+			return nil
+		}
+		// 请求本身有误时直接返回上游错误，其他错误降级为本地估算
+		if newAPIError.StatusCode == http.StatusBadRequest {
+			return newAPIError
+		}
+		logger.LogWarn(c, fmt.Sprintf("upstream count_tokens failed, fallback to local counting: %s", newAPIError.Error()))
+	}
+
+	tokens, err := service.CountTokenClaudeRequest(*claudeReq, info.UpstreamModelName)
+	if err != nil {
+		return types.NewErrorWithStatusCode(err, types.ErrorCodeCountTokenFailed, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+	c.JSON(http.StatusOK, dto.ClaudeCountTokensResponse{InputTokens: tokens})
+	return nil
+}
+
+func supportUpstreamCountTokens(info *relaycommon.RelayInfo) bool {
+	switch info.ApiType {
+	case constant.APITypeAnthropic:
+		return true
+	case constant.APITypeVertexAi:
+		return info.ChannelOtherSettings.VertexKeyType != dto.VertexKeyTypeAPIKey && strings.HasPrefix(info.UpstreamModelName, "claude")
+	}
+	return false
+}
+
+func doUpstreamCountTokens(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ClaudeRequest) (*dto.ClaudeCountTokensResponse, *types.NewAPIError) {
+	adaptor := GetAdaptor(info.ApiType)
+	if adaptor == nil {
+		return nil, types.NewError(fmt.Errorf("invalid api type: %d", info.ApiType), types.ErrorCodeInvalidApiType, types.ErrOptionWithSkipRetry())
+	}
+	adaptor.Init(info)
+
+	// 复用渠道的请求转换，获取上游实际使用的模型名（如 Vertex 的 claude-xxx@version）
+	if _, err := adaptor.ConvertClaudeRequest(c, info, request); err != nil {
+		return nil, types.NewError(err, types.ErrorCodeConvertRequestFailed, types.ErrOptionWithSkipRetry())
+	}
+	upstreamModel := c.GetString("request_model")
+	if upstreamModel == "" || info.ApiType == constant.APITypeAnthropic {
+		upstreamModel = request.Model
+	}
+	countRequest := dto.ClaudeCountTokensRequest{
+		Model:      upstreamModel,
+		System:     request.System,
+		Messages:   request.Messages,
+		Tools:      request.Tools,
+		ToolChoice: request.ToolChoice,
+		Thinking:   request.Thinking,
+		McpServers: request.McpServers,
+	}
+	jsonData, err := common.Marshal(countRequest)
+	if err != nil {
+		return nil, types.NewError(err, types.ErrorCodeJsonMarshalFailed, types.ErrOptionWithSkipRetry())
+	}
+
+	resp, err := adaptor.DoRequest(c, info, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, types.NewOpenAIError(err, types.ErrorCodeDoRequestFailed, http.StatusInternalServerError)
+	}
+	httpResp, ok := resp.(*http.Response)
+	if !ok || httpResp == nil {
+		return nil, types.NewOpenAIError(fmt.Errorf("invalid response type %T", resp), types.ErrorCodeBadResponse, http.StatusInternalServerError)
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, service.RelayErrorHandler(c.Request.Context(), httpResp, false)
+	}
+	defer httpResp.Body.Close()
+	body, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, types.NewOpenAIError(err, types.ErrorCodeReadResponseBodyFailed, http.StatusInternalServerError)
+	}
+	var countResponse dto.ClaudeCountTokensResponse
+	if err := common.Unmarshal(body, &countResponse); err != nil {
+		return nil, types.NewOpenAIError(err, types.ErrorCodeBadResponseBody, http.StatusInternalServerError)
+	}
+	return &countResponse, nil
+}
@@ -37,6 +37,23 @@ type ClaudeConvertInfo struct {
 	Done             bool
 }
 
+// ResponsesConvertInfo 记录 chat completions 流转换为 Responses 事件时的状态
+type ResponsesConvertInfo struct {
+	ResponseId     string
+	CreatedAt      int64
+	Model          string
+	Started        bool
+	SequenceNumber int
+	// 已完成的输出项
+	Output []dto.ResponsesOutput
+	// 正在输出的项，文本、推理摘要或函数参数累积在 CurrentText 中
+	CurrentItem      *dto.ResponsesOutput
+	CurrentText      strings.Builder
+	CurrentToolIndex int
+	FinishReason     string
+	Usage            *dto.Usage
+}
+
 type RerankerInfo struct {
 	Documents       []any
 	ReturnDocuments bool
@@ -79,6 +96,7 @@ type RelayInfo struct {
 	UsingGroup        string // 使用的分组
 	UserGroup         string // 用户所在分组
 	TokenUnlimited    bool
+	QuotaSpendLimited bool // 令牌或用户设置了按天、周、月的额度限制
 	StartTime         time.Time
 	FirstResponseTime time.Time
 	isFirstResponse   bool
@@ -106,8 +124,11 @@ type RelayInfo struct {
 	UserQuota              int
 	RelayFormat            types.RelayFormat
 	SendResponseCount      int
-	FinalPreConsumedQuota  int  // 最终预消耗的配额
-	IsClaudeBetaQuery      bool // /v1/messages?beta=true
+	FinalPreConsumedQuota  int                // 最终预消耗的配额
+	IsClaudeBetaQuery      bool               // /v1/messages?beta=true
+	ResponseCacheHit       bool               // 响应来自缓存，没有请求上游
+	StreamInterruption     string             // 上游流式响应提前中断的原因，正常结束时为空
+	StreamResume           *StreamResumeState // 不为空时流式响应中断后在其他渠道续写
 
 	PriceData types.PriceData
 
@@ -115,6 +136,7 @@ type RelayInfo struct {
 
 	ThinkingContentInfo
 	*ClaudeConvertInfo
+	ResponsesConvertInfo *ResponsesConvertInfo
 	*RerankerInfo
 	*ResponsesUsageInfo
 	*ChannelMeta
@@ -174,6 +196,26 @@ func (info *RelayInfo) InitChannelMeta(c *gin.Context) {
 	}
 }
 
+// Clone 复制一份用于同时发起的另一次尝试（对冲请求），流式转换中会修改的状态不与原对象共享
+func (info *RelayInfo) Clone() *RelayInfo {
+	clone := *info
+	if info.ClaudeConvertInfo != nil {
+		claudeConvertInfo := *info.ClaudeConvertInfo
+		clone.ClaudeConvertInfo = &claudeConvertInfo
+	}
+	if info.ResponsesUsageInfo != nil {
+		builtInTools := make(map[string]*BuildInToolInfo, len(info.ResponsesUsageInfo.BuiltInTools))
+		for name, tool := range info.ResponsesUsageInfo.BuiltInTools {
+			toolInfo := *tool
+			builtInTools[name] = &toolInfo
+		}
+		clone.ResponsesUsageInfo = &ResponsesUsageInfo{BuiltInTools: builtInTools}
+	}
+	// 在处理请求时按需创建
+	clone.ResponsesConvertInfo = nil
+	return &clone
+}
+
 func (info *RelayInfo) ToString() string {
 	if info == nil {
 		return "RelayInfo<nil>"
@@ -395,6 +437,9 @@ func genBaseRelayInfo(c *gin.Context, request dto.Request) *RelayInfo {
 		TokenKey:       common.GetContextKeyString(c, constant.ContextKeyTokenKey),
 		TokenUnlimited: common.GetContextKeyBool(c, constant.ContextKeyTokenUnlimited),
 
+		QuotaSpendLimited: common.GetContextKeyBool(c, constant.ContextKeyTokenQuotaSpendLimited) ||
+			common.GetContextKeyBool(c, constant.ContextKeyUserQuotaSpendLimited),
+
 		isFirstResponse: true,
 		RelayMode:       relayconstant.Path2RelayMode(c.Request.URL.Path),
 		RequestURLPath:  c.Request.URL.String(),
@@ -479,6 +524,8 @@ func (info *RelayInfo) HasSendResponse() bool {
 type TaskRelayInfo struct {
 	Action       string
 	OriginTaskID string
+	// PushUrl 上游推送任务状态的网关地址，适配器未使用时需置空
+	PushUrl string
 
 	ConsumeQuota bool
 }
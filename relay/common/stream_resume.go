@@ -0,0 +1,22 @@
+package common
+
+import "sync/atomic"
+
+// StreamResumeState 流式响应中断后等待在其他渠道续写的状态，同一请求的各次尝试（包括对冲请求）共享
+type StreamResumeState struct {
+	pending atomic.Bool
+}
+
+// MarkPending 上游流式响应中断，当前尝试之后写出的内容（结束标志、用量等）不再发给客户端
+func (s *StreamResumeState) MarkPending() {
+	s.pending.Store(true)
+}
+
+func (s *StreamResumeState) Pending() bool {
+	return s != nil && s.pending.Load()
+}
+
+// Reset 开始续写或放弃续写时调用
+func (s *StreamResumeState) Reset() {
+	s.pending.Store(false)
+}
@@ -47,6 +47,9 @@ func TextHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *types
 		return types.NewError(err, types.ErrorCodeChannelModelMappedError, types.ErrOptionWithSkipRetry())
 	}
 
+	cacheWriter := startResponseCache(c)
+	defer cacheWriter.stop(c)
+
 	includeUsage := true
 	// 判断用户是否需要返回使用情况
 	if request.StreamOptions != nil {
@@ -150,6 +153,12 @@ func TextHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *types
 			}
 		}
 
+		// 将引用的网关文件同步到上游渠道
+		jsonData, err = service.ForwardFilesToChannel(c, info, jsonData)
+		if err != nil {
+			return types.NewError(err, types.ErrorCodeDoRequestFailed)
+		}
+
 		logger.LogDebug(c, fmt.Sprintf("text request body: %s", string(jsonData)))
 
 		requestBody = bytes.NewBuffer(jsonData)
@@ -180,6 +189,7 @@ func TextHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *types
 		service.ResetStatusCode(newApiErr, statusCodeMappingStr)
 		return newApiErr
 	}
+	cacheWriter.save(info, usage.(*dto.Usage))
 
 	if strings.HasPrefix(info.OriginModelName, "gpt-4o-audio") {
 		service.PostAudioConsumeQuota(c, info, usage.(*dto.Usage), "")
@@ -190,6 +200,10 @@ func TextHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *types
 }
 
 func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage *dto.Usage, extraContent string) {
+	if service.IsHedgeLoser(ctx) {
+		// 对冲请求中落败的尝试不计费
+		return
+	}
 	if usage == nil {
 		usage = &dto.Usage{
 			PromptTokens:     relayInfo.PromptTokens,
@@ -350,6 +364,13 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage
 	// 添加 image generation call 计费
 	quotaCalculateDecimal = quotaCalculateDecimal.Add(dImageGenerationCallQuota)
 
+	var cacheBillingRatio float64
+	if relayInfo.ResponseCacheHit {
+		cacheBillingRatio = operation_setting.GetResponseCacheBillingRatio()
+		quotaCalculateDecimal = quotaCalculateDecimal.Mul(decimal.NewFromFloat(cacheBillingRatio))
+		extraContent += fmt.Sprintf("响应缓存命中，计费比例 %.2f", cacheBillingRatio)
+	}
+
 	quota := int(quotaCalculateDecimal.Round(0).IntPart())
 	totalTokens := promptTokens + completionTokens
 
@@ -364,11 +385,13 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage
 		logger.LogError(ctx, fmt.Sprintf("total tokens is 0, cannot consume quota, userId %d, channelId %d, "+
 			"tokenId %d, model %s， pre-consumed quota %d", relayInfo.UserId, relayInfo.ChannelId, relayInfo.TokenId, modelName, relayInfo.FinalPreConsumedQuota))
 	} else {
-		if !ratio.IsZero() && quota == 0 {
+		if !ratio.IsZero() && quota == 0 && !(relayInfo.ResponseCacheHit && cacheBillingRatio == 0) {
 			quota = 1
 		}
 		model.UpdateUserUsedQuotaAndRequestCount(relayInfo.UserId, quota)
-		model.UpdateChannelUsedQuota(relayInfo.ChannelId, quota)
+		if !relayInfo.ResponseCacheHit {
+			model.UpdateChannelUsedQuota(relayInfo.ChannelId, quota)
+		}
 	}
 
 	quotaDelta := quota - relayInfo.FinalPreConsumedQuota
@@ -451,6 +474,10 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage
 		other["image_generation_call"] = true
 		other["image_generation_call_price"] = imageGenerationCallPrice
 	}
+	if relayInfo.ResponseCacheHit {
+		other["response_cache_hit"] = true
+		other["response_cache_billing_ratio"] = cacheBillingRatio
+	}
 	model.RecordConsumeLog(ctx, relayInfo.UserId, model.RecordConsumeLogParams{
 		ChannelId:        relayInfo.ChannelId,
 		PromptTokens:     promptTokens,
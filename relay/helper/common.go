@@ -8,6 +8,7 @@ import (
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/dto"
 	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
 	"github.com/QuantumNous/new-api/types"
 
 	"github.com/gin-gonic/gin"
@@ -94,6 +95,42 @@ func Done(c *gin.Context) {
 	_ = StringData(c, "[DONE]")
 }
 
+// StreamErrorData 响应头已经写出后，按客户端请求的格式在流中发送错误事件
+func StreamErrorData(c *gin.Context, info *relaycommon.RelayInfo, newAPIError *types.NewAPIError) {
+	switch info.RelayFormat {
+	case types.RelayFormatClaude:
+		_ = ClaudeData(c, dto.ClaudeResponse{
+			Type:  "error",
+			Error: newAPIError.ToClaudeError(),
+		})
+	case types.RelayFormatGemini:
+		_ = ObjectData(c, gin.H{
+			"error": gin.H{
+				"code":    newAPIError.StatusCode,
+				"message": newAPIError.ToOpenAIError().Message,
+				"status":  "UNAVAILABLE",
+			},
+		})
+	case types.RelayFormatOpenAIResponses:
+		openaiError := newAPIError.ToOpenAIError()
+		jsonData, err := common.Marshal(gin.H{
+			"type":    "error",
+			"code":    openaiError.Code,
+			"message": openaiError.Message,
+			"param":   nil,
+		})
+		if err != nil {
+			common.SysError("error marshalling stream error: " + err.Error())
+			return
+		}
+		ResponseChunkData(c, dto.ResponsesStreamResponse{Type: "error"}, string(jsonData))
+	default:
+		_ = ObjectData(c, gin.H{
+			"error": newAPIError.ToOpenAIError(),
+		})
+	}
+}
+
 func WssString(c *gin.Context, ws *websocket.Conn, str string) error {
 	if ws == nil {
 		logger.LogError(c, "websocket connection is nil")
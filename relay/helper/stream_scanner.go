@@ -3,11 +3,14 @@ package helper
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/QuantumNous/new-api/common"
@@ -15,6 +18,7 @@ import (
 	"github.com/QuantumNous/new-api/logger"
 	relaycommon "github.com/QuantumNous/new-api/relay/common"
 	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
 
 	"github.com/bytedance/gopkg/util/gopool"
 
@@ -27,6 +31,25 @@ const (
 	DefaultPingInterval      = 10 * time.Second
 )
 
+// 带有非空结束原因的数据块，如 OpenAI 的 finish_reason、Claude 的 stop_reason、Gemini 的 finishReason
+var streamFinishReasonPattern = regexp.MustCompile(`"(finish_reason|finishReason|stop_reason)"\s*:\s*"[^"]+"`)
+
+// 各格式中表示流结束的事件
+var streamFinishMarkers = []string{`"message_stop"`, `"response.completed"`, `"response.incomplete"`, `"response.failed"`, `"message_end"`, `"is_end":true`}
+
+// isStreamFinishData 数据块中是否带有结束原因或结束事件
+func isStreamFinishData(data string) bool {
+	for _, marker := range streamFinishMarkers {
+		if strings.Contains(data, marker) {
+			return true
+		}
+	}
+	if !strings.Contains(data, "eason") {
+		return false
+	}
+	return streamFinishReasonPattern.MatchString(data)
+}
+
 func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo, dataHandler func(data string) bool) {
 
 	if resp == nil || dataHandler == nil {
@@ -49,6 +72,9 @@ func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon
 		pingTicker *time.Ticker
 		writeMutex sync.Mutex     // Mutex to protect concurrent writes
 		wg         sync.WaitGroup // 用于等待所有 goroutine 退出
+		finished   atomic.Bool    // 收到了 [DONE] 或结束原因
+		stopped    atomic.Bool    // 由 dataHandler 主动结束或处理超时，不属于上游中断
+		scanErr    atomic.Value   // 读取上游数据出错
 	)
 
 	generalSettings := operation_setting.GetGeneralSetting()
@@ -210,6 +236,9 @@ func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon
 			data = strings.TrimSuffix(data, "\r")
 			if !strings.HasPrefix(data, "[DONE]") {
 				info.SetFirstResponseTime()
+				if !finished.Load() && isStreamFinishData(data) {
+					finished.Store(true)
+				}
 
 				// 使用超时机制防止写操作阻塞
 				done := make(chan bool, 1)
@@ -222,10 +251,12 @@ func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon
 				select {
 				case success := <-done:
 					if !success {
+						stopped.Store(true)
 						return
 					}
 				case <-time.After(10 * time.Second):
 					logger.LogError(c, "data handler timeout")
+					stopped.Store(true)
 					return
 				case <-ctx.Done():
 					return
@@ -234,6 +265,7 @@ func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon
 				}
 			} else {
 				// done, 处理完成标志，直接退出停止读取剩余数据防止出错
+				finished.Store(true)
 				if common.DebugEnabled {
 					println("received [DONE], stopping scanner")
 				}
@@ -244,20 +276,57 @@ func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon
 		if err := scanner.Err(); err != nil {
 			if err != io.EOF {
 				logger.LogError(c, "scanner error: "+err.Error())
+				scanErr.Store(err.Error())
 			}
 		}
 	})
 
 	// 主循环等待完成或超时
+	var interruption string
 	select {
 	case <-ticker.C:
 		// 超时处理逻辑
 		logger.LogError(c, "streaming timeout")
+		interruption = "streaming timeout"
 	case <-stopChan:
 		// 正常结束
 		logger.LogInfo(c, "streaming finished")
+		if errMsg, ok := scanErr.Load().(string); ok {
+			interruption = "read upstream stream failed: " + errMsg
+		} else {
+			interruption = "upstream stream ended without finish"
+		}
 	case <-c.Request.Context().Done():
 		// 客户端断开连接
 		logger.LogInfo(c, "client disconnected")
 	}
+	if interruption == "" || finished.Load() || stopped.Load() {
+		return
+	}
+	if c.Request.Context().Err() != nil || (resp.Request != nil && resp.Request.Context().Err() != nil) {
+		// 客户端断开或上游请求被取消（如对冲请求落败），不属于上游中断
+		return
+	}
+	handleStreamInterruption(c, info, interruption, &writeMutex)
+}
+
+// handleStreamInterruption 上游流式响应在结束前中断，开启流式故障转移时可续写的请求标记为等待续写，
+// 其余请求按客户端请求的格式发送错误事件。中断前的部分输出照常计费
+func handleStreamInterruption(c *gin.Context, info *relaycommon.RelayInfo, reason string, writeMutex *sync.Mutex) {
+	if !operation_setting.GetStreamFailoverSetting().Enabled {
+		return
+	}
+	info.StreamInterruption = reason
+	logger.LogWarn(c, "upstream stream interrupted: "+reason)
+	if info.StreamResume != nil {
+		info.StreamResume.MarkPending()
+		return
+	}
+	// 超时时写操作可能仍被阻塞，拿不到锁就放弃发送
+	if !writeMutex.TryLock() {
+		return
+	}
+	defer writeMutex.Unlock()
+	message := common.MessageWithRequestId("upstream stream interrupted: "+reason, c.GetString(common.RequestIdKey))
+	StreamErrorData(c, info, types.NewOpenAIError(errors.New(message), types.ErrorCodeStreamInterrupted, http.StatusBadGateway))
 }
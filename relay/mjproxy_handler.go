@@ -20,7 +20,6 @@ import (
 	"github.com/QuantumNous/new-api/relay/helper"
 	"github.com/QuantumNous/new-api/service"
 	"github.com/QuantumNous/new-api/setting"
-	"github.com/QuantumNous/new-api/setting/system_setting"
 
 	"github.com/gin-gonic/gin"
 )
@@ -28,12 +27,25 @@ import (
 func RelayMidjourneyImage(c *gin.Context) {
 	taskId := c.Param("id")
 	midjourneyTask := model.GetByOnlyMJId(taskId)
-	if midjourneyTask == nil {
+	if midjourneyTask == nil || !service.CanAccessMedia(c, midjourneyTask.UserId) {
 		c.JSON(400, gin.H{
 			"error": "midjourney_task_not_found",
 		})
 		return
 	}
+	// 已保存结果副本时直接从网关存储返回，副本过期时按不存在处理
+	if artifact, err := model.GetTaskArtifact(model.TaskArtifactTypeMidjourney, midjourneyTask.MjId); err == nil && artifact != nil {
+		if !service.CanAccessArtifact(c, artifact) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "midjourney_task_not_found",
+			})
+			return
+		}
+		if err := service.ServeArtifact(c, artifact); err == nil {
+			return
+		}
+		common.SysError(fmt.Sprintf("failed to serve artifact %s: %s", artifact.ArtifactId, err.Error()))
+	}
 	var httpClient *http.Client
 	if channel, err := model.CacheGetChannel(midjourneyTask.ChannelId); err == nil {
 		proxy := channel.GetSetting().Proxy
@@ -100,6 +112,7 @@ func RelayMidjourneyNotify(c *gin.Context) *dto.MidjourneyResponse {
 			Result:      "",
 		}
 	}
+	preStatus := midjourneyTask.Status
 	midjourneyTask.Progress = midjRequest.Progress
 	midjourneyTask.PromptEn = midjRequest.PromptEn
 	midjourneyTask.State = midjRequest.State
@@ -119,6 +132,7 @@ func RelayMidjourneyNotify(c *gin.Context) *dto.MidjourneyResponse {
 			Description: "update_midjourney_task_failed",
 		}
 	}
+	service.AfterMidjourneyTaskUpdate(midjourneyTask, preStatus)
 
 	return nil
 }
@@ -133,15 +147,19 @@ func coverMidjourneyTaskDto(c *gin.Context, originTask *model.Midjourney) (midjo
 	midjourneyTask.FinishTime = originTask.FinishTime
 	midjourneyTask.ImageUrl = ""
 	if originTask.ImageUrl != "" && setting.MjForwardUrlEnabled {
-		midjourneyTask.ImageUrl = system_setting.ServerAddress + "/mj/image/" + originTask.MjId
+		midjourneyTask.ImageUrl = service.MidjourneyImageUrl(originTask.MjId)
 		if originTask.Status != "SUCCESS" {
-			midjourneyTask.ImageUrl += "?rand=" + strconv.FormatInt(time.Now().UnixNano(), 10)
+			separator := "?"
+			if strings.Contains(midjourneyTask.ImageUrl, "?") {
+				separator = "&"
+			}
+			midjourneyTask.ImageUrl += separator + "rand=" + strconv.FormatInt(time.Now().UnixNano(), 10)
 		}
 	} else {
-		midjourneyTask.ImageUrl = originTask.ImageUrl
+		midjourneyTask.ImageUrl = service.SignMediaUrl(originTask.ImageUrl)
 	}
 	if originTask.VideoUrl != "" {
-		midjourneyTask.VideoUrl = originTask.VideoUrl
+		midjourneyTask.VideoUrl = service.SignMediaUrl(originTask.VideoUrl)
 	}
 	midjourneyTask.Status = originTask.Status
 	midjourneyTask.FailReason = originTask.FailReason
@@ -184,6 +202,10 @@ func RelaySwapFace(c *gin.Context, info *relaycommon.RelayInfo) *dto.MidjourneyR
 	if swapFaceRequest.SourceBase64 == "" || swapFaceRequest.TargetBase64 == "" {
 		return service.MidjourneyErrorWrapper(constant.MjRequestError, "sour_base64_and_target_base64_is_required")
 	}
+	callbackUrl, err := service.GetTaskCallbackUrl(c)
+	if err != nil {
+		return service.MidjourneyErrorWrapper(constant.MjRequestError, "invalid_callback_url")
+	}
 	modelName := service.CoverActionToModelName(constant.MjActionSwapFace)
 
 	priceData := helper.ModelPriceHelperPerCall(c, info)
@@ -202,6 +224,14 @@ func RelaySwapFace(c *gin.Context, info *relaycommon.RelayInfo) *dto.MidjourneyR
 			Description: "quota_not_enough",
 		}
 	}
+	if err := service.ReserveQuotaSpendLimits(info, priceData.Quota); err != nil {
+		return &dto.MidjourneyResponse{
+			Code:        4,
+			Description: err.Error(),
+		}
+	}
+	// 成功时由 PostConsumeQuota 计入实际消费，预留的额度在结束时返还
+	defer service.ReleaseQuotaSpend(info, priceData.Quota)
 	requestURL := getMjRequestPath(c.Request.URL.String())
 	baseURL := c.GetString("base_url")
 	fullRequestURL := fmt.Sprintf("%s%s", baseURL, requestURL)
@@ -236,6 +266,7 @@ func RelaySwapFace(c *gin.Context, info *relaycommon.RelayInfo) *dto.MidjourneyR
 	midjResponse := &mjResp.Response
 	midjourneyTask := &model.Midjourney{
 		UserId:      info.UserId,
+		TokenId:     info.TokenId,
 		Code:        midjResponse.Code,
 		Action:      constant.MjActionSwapFace,
 		MjId:        midjResponse.Result,
@@ -252,6 +283,7 @@ func RelaySwapFace(c *gin.Context, info *relaycommon.RelayInfo) *dto.MidjourneyR
 		FailReason:  "",
 		ChannelId:   c.GetInt("channel_id"),
 		Quota:       priceData.Quota,
+		CallbackUrl: callbackUrl,
 	}
 	err = midjourneyTask.Insert()
 	if err != nil {
@@ -475,6 +507,10 @@ func RelayMidjourneySubmit(c *gin.Context, relayInfo *relaycommon.RelayInfo) *dt
 	if midjRequest.Action == constant.MjActionInPaint || midjRequest.Action == constant.MjActionCustomZoom {
 		consumeQuota = false
 	}
+	callbackUrl, err := service.GetTaskCallbackUrl(c)
+	if err != nil {
+		return service.MidjourneyErrorWrapper(constant.MjRequestError, "invalid_callback_url")
+	}
 
 	//baseURL := common.ChannelBaseURLs[channelType]
 	requestURL := getMjRequestPath(c.Request.URL.String())
@@ -503,6 +539,16 @@ func RelayMidjourneySubmit(c *gin.Context, relayInfo *relaycommon.RelayInfo) *dt
 			Description: "quota_not_enough",
 		}
 	}
+	if consumeQuota {
+		if err := service.ReserveQuotaSpendLimits(relayInfo, priceData.Quota); err != nil {
+			return &dto.MidjourneyResponse{
+				Code:        4,
+				Description: err.Error(),
+			}
+		}
+		// 成功时由 PostConsumeQuota 计入实际消费，预留的额度在结束时返还
+		defer service.ReleaseQuotaSpend(relayInfo, priceData.Quota)
+	}
 
 	midjResponseWithStatus, responseBody, err := service.DoMidjourneyHttpRequest(c, time.Second*60, fullRequestURL)
 	if err != nil {
@@ -543,6 +589,7 @@ func RelayMidjourneySubmit(c *gin.Context, relayInfo *relaycommon.RelayInfo) *dt
 	// other: 提交错误，description为错误描述
 	midjourneyTask := &model.Midjourney{
 		UserId:      relayInfo.UserId,
+		TokenId:     relayInfo.TokenId,
 		Code:        midjResponse.Code,
 		Action:      midjRequest.Action,
 		MjId:        midjResponse.Result,
@@ -559,6 +606,7 @@ func RelayMidjourneySubmit(c *gin.Context, relayInfo *relaycommon.RelayInfo) *dt
 		FailReason:  "",
 		ChannelId:   c.GetInt("channel_id"),
 		Quota:       priceData.Quota,
+		CallbackUrl: callbackUrl,
 	}
 	if midjResponse.Code == 3 {
 		//无实例账号自动禁用渠道（No available account instance）
@@ -610,6 +658,8 @@ func RelayMidjourneySubmit(c *gin.Context, relayInfo *relaycommon.RelayInfo) *dt
 			Description: "insert_midjourney_task_failed",
 		}
 	}
+	// 任务已存在且已完成、上传等提交即完成的任务没有后续状态变化，直接回调
+	service.AfterMidjourneyTaskUpdate(midjourneyTask, "")
 
 	if midjResponse.Code == 22 { //22-排队中，说明任务已存在
 		//修改返回值
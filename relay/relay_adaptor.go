@@ -46,6 +46,14 @@ import (
 )
 
 func GetAdaptor(apiType int) channel.Adaptor {
+	adaptor := getAdaptor(apiType)
+	if adaptor == nil || !constant.TracingEnabled {
+		return adaptor
+	}
+	return &tracedAdaptor{Adaptor: adaptor}
+}
+
+func getAdaptor(apiType int) channel.Adaptor {
 	switch apiType {
 	case constant.APITypeAli:
 		return &ali.Adaptor{}
@@ -18,6 +18,7 @@ import (
 	relaycommon "github.com/QuantumNous/new-api/relay/common"
 	relayconstant "github.com/QuantumNous/new-api/relay/constant"
 	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
 	"github.com/QuantumNous/new-api/setting/ratio_setting"
 
 	"github.com/gin-gonic/gin"
@@ -48,6 +49,10 @@ func RelayTaskSubmit(c *gin.Context, info *relaycommon.RelayInfo) (taskErr *dto.
 	if taskErr != nil {
 		return
 	}
+	callbackUrl, err := service.GetTaskCallbackUrl(c)
+	if err != nil {
+		return service.TaskErrorWrapperLocal(err, "invalid_callback_url", http.StatusBadRequest)
+	}
 
 	modelName := info.OriginModelName
 	if modelName == "" {
@@ -93,6 +98,12 @@ func RelayTaskSubmit(c *gin.Context, info *relaycommon.RelayInfo) (taskErr *dto.
 		taskErr = service.TaskErrorWrapperLocal(errors.New("user quota is not enough"), "quota_not_enough", http.StatusForbidden)
 		return
 	}
+	if err := service.ReserveQuotaSpendLimits(info, quota); err != nil {
+		taskErr = service.TaskErrorWrapperLocal(err, "quota_not_enough", http.StatusForbidden)
+		return
+	}
+	// 成功时由 PostConsumeQuota 计入实际消费，预留的额度在结束时返还
+	defer service.ReleaseQuotaSpend(info, quota)
 
 	if info.OriginTaskID != "" {
 		originTask, exist, err := model.GetByTaskId(info.UserId, info.OriginTaskID)
@@ -122,6 +133,11 @@ func RelayTaskSubmit(c *gin.Context, info *relaycommon.RelayInfo) (taskErr *dto.
 		}
 	}
 
+	// 上游支持推送任务状态时，提交时附带网关的推送地址
+	if _, ok := adaptor.(channel.TaskPushParser); ok && operation_setting.GetTaskPollSetting().PushEnabled {
+		info.PushUrl = service.TaskPushUrl(platform, info.ChannelId)
+	}
+
 	// build body
 	requestBody, err := adaptor.BuildRequestBody(c, info)
 	if err != nil {
@@ -208,6 +224,8 @@ func RelayTaskSubmit(c *gin.Context, info *relaycommon.RelayInfo) (taskErr *dto.
 	task.Quota = quota
 	task.Data = taskData
 	task.Action = info.Action
+	task.CallbackUrl = callbackUrl
+	task.Properties.PushEnabled = info.PushUrl != ""
 	err = task.Insert()
 	if err != nil {
 		taskErr = service.TaskErrorWrapper(err, "insert_task_failed", http.StatusInternalServerError)
@@ -385,7 +403,7 @@ func videoFetchByIDRespBodyBuilder(c *gin.Context) (respBody []byte, taskResp *d
 				"metadata": nil,
 				"status":   status,
 				"task_id":  originTask.TaskID,
-				"url":      originTask.FailReason,
+				"url":      service.SignMediaUrl(originTask.FailReason),
 			}
 			respBody, _ = json.Marshal(dto.TaskResponse[any]{
 				Code: "success",
@@ -431,7 +449,7 @@ func TaskModel2Dto(task *model.Task) *dto.TaskDto {
 		TaskID:     task.TaskID,
 		Action:     task.Action,
 		Status:     string(task.Status),
-		FailReason: task.FailReason,
+		FailReason: service.SignMediaUrl(task.FailReason),
 		SubmitTime: task.SubmitTime,
 		StartTime:  task.StartTime,
 		FinishTime: task.FinishTime,
@@ -0,0 +1,118 @@
+package relay
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/relay/helper"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// responseCacheHeader 告知客户端响应是否来自缓存：HIT 或 MISS
+const responseCacheHeader = "X-Response-Cache"
+
+// ServeResponseCache 输出选择渠道前命中的缓存响应，并按配置的计费比例计费
+func ServeResponseCache(c *gin.Context, info *relaycommon.RelayInfo, entry *service.ResponseCacheEntry) {
+	info.InitChannelMeta(c)
+	logger.LogInfo(c, "response cache hit")
+	info.ResponseCacheHit = true
+	info.IsStream = entry.IsStream
+	info.SetFirstResponseTime()
+	c.Writer.Header().Set(responseCacheHeader, "HIT")
+	if entry.IsStream {
+		// 按事件逐个写出，保持与上游流式响应相同的格式
+		helper.SetEventStreamHeaders(c)
+		c.Status(http.StatusOK)
+		for _, event := range strings.SplitAfter(string(entry.Body), "\n\n") {
+			if event == "" {
+				continue
+			}
+			_, _ = c.Writer.WriteString(event)
+			_ = helper.FlushWriter(c)
+		}
+	} else {
+		c.Data(http.StatusOK, entry.ContentType, entry.Body)
+	}
+	postConsumeQuota(c, info, entry.Usage, "")
+}
+
+// responseCacheWriter 记录渠道写给客户端的响应，请求成功后写入缓存
+type responseCacheWriter struct {
+	gin.ResponseWriter
+	key      string
+	body     bytes.Buffer
+	limit    int
+	overflow bool
+}
+
+// startResponseCache 记录未命中缓存的响应，没有缓存键时返回 nil，nil 上调用 stop 和 save 无效
+func startResponseCache(c *gin.Context) *responseCacheWriter {
+	key := common.GetContextKeyString(c, constant.ContextKeyResponseCacheKey)
+	if key == "" {
+		return nil
+	}
+	c.Writer.Header().Set(responseCacheHeader, "MISS")
+	writer := &responseCacheWriter{
+		ResponseWriter: c.Writer,
+		key:            key,
+		limit:          operation_setting.GetResponseCacheSetting().MaxBodyKB * 1024,
+	}
+	c.Writer = writer
+	return writer
+}
+
+func (w *responseCacheWriter) record(data []byte) {
+	if w.overflow {
+		return
+	}
+	if w.body.Len()+len(data) > w.limit {
+		// 超过大小限制的响应不缓存
+		w.overflow = true
+		w.body.Reset()
+		return
+	}
+	w.body.Write(data)
+}
+
+func (w *responseCacheWriter) Write(data []byte) (int, error) {
+	w.record(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseCacheWriter) WriteString(s string) (int, error) {
+	w.record([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *responseCacheWriter) stop(c *gin.Context) {
+	if w == nil {
+		return
+	}
+	c.Writer = w.ResponseWriter
+}
+
+func (w *responseCacheWriter) save(info *relaycommon.RelayInfo, usage *dto.Usage) {
+	if w == nil || w.overflow || w.body.Len() == 0 || w.Status() != http.StatusOK || info.StreamInterruption != "" {
+		return
+	}
+	body := bytes.Clone(w.body.Bytes())
+	if info.IsStream && !bytes.Contains(body, []byte("data: [DONE]")) {
+		// 流没有正常结束，不缓存不完整的响应
+		return
+	}
+	service.SetResponseCache(w.key, &service.ResponseCacheEntry{
+		ContentType: w.Header().Get("Content-Type"),
+		Body:        body,
+		IsStream:    info.IsStream,
+		Usage:       usage,
+	})
+}
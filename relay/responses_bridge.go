@@ -0,0 +1,190 @@
+package relay
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// supportNativeResponses 渠道是否原生支持 /v1/responses，其余渠道通过 chat completions 桥接
+func supportNativeResponses(apiType int) bool {
+	switch apiType {
+	case constant.APITypeOpenAI, constant.APITypeOpenRouter, constant.APITypeXinference, constant.APITypeCloudflare:
+		return true
+	}
+	return false
+}
+
+// responsesViaChatCompletionsHelper 将 Responses 请求转换为 chat completions 请求交给渠道处理，
+// 并把渠道输出的 chat completions 响应转换回 Responses 格式
+func responsesViaChatCompletionsHelper(c *gin.Context, info *relaycommon.RelayInfo, responsesReq *dto.OpenAIResponsesRequest) *types.NewAPIError {
+	chatReq, err := service.ResponsesRequestToOpenAIRequest(responsesReq)
+	if err != nil {
+		return types.NewErrorWithStatusCode(err, types.ErrorCodeConvertRequestFailed, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+
+	// 以 chat completions 的身份调用 TextHelper，结束后恢复，保证重试到原生渠道时不受影响
+	request, relayMode, relayFormat, requestURLPath := info.Request, info.RelayMode, info.RelayFormat, info.RequestURLPath
+	info.Request = chatReq
+	info.RelayMode = relayconstant.RelayModeChatCompletions
+	info.RelayFormat = types.RelayFormatOpenAI
+	info.RequestURLPath = "/v1/chat/completions"
+	info.ResponsesConvertInfo = &relaycommon.ResponsesConvertInfo{
+		Model: info.OriginModelName,
+	}
+	writer := &responsesBridgeWriter{
+		ResponseWriter: c.Writer,
+		info:           info,
+	}
+	c.Writer = writer
+	defer func() {
+		c.Writer = writer.ResponseWriter
+		info.Request, info.RelayMode, info.RelayFormat, info.RequestURLPath = request, relayMode, relayFormat, requestURLPath
+		info.ResponsesConvertInfo = nil
+	}()
+
+	newAPIError := TextHelper(c, info)
+	if newAPIError != nil {
+		// 已经开始输出时只能以事件形式告知客户端
+		if writer.streaming && info.ResponsesConvertInfo.Started {
+			writer.writeEvents(service.FailResponsesStream(info, newAPIError.ToOpenAIError()))
+		}
+		return newAPIError
+	}
+	writer.finish(c)
+	return nil
+}
+
+// responsesBridgeWriter 拦截渠道写出的 chat completions 响应并转换为 Responses 格式
+type responsesBridgeWriter struct {
+	gin.ResponseWriter
+	info      *relaycommon.RelayInfo
+	streaming bool
+	decided   bool
+	// 流式时保存未处理完的行，非流式时保存完整响应体
+	buffer bytes.Buffer
+	// 流式输出的文本，上游没有返回 usage 时用于估算
+	responseText strings.Builder
+}
+
+func (w *responsesBridgeWriter) decide() {
+	if w.decided {
+		return
+	}
+	w.decided = true
+	w.streaming = strings.HasPrefix(w.ResponseWriter.Header().Get("Content-Type"), "text/event-stream")
+}
+
+func (w *responsesBridgeWriter) Write(data []byte) (int, error) {
+	w.decide()
+	w.buffer.Write(data)
+	if !w.streaming {
+		return len(data), nil
+	}
+	for {
+		line, err := w.buffer.ReadString('\n')
+		if err != nil {
+			// 不完整的行放回缓冲区等待后续数据
+			rest := line
+			w.buffer.Reset()
+			w.buffer.WriteString(rest)
+			break
+		}
+		w.handleStreamLine(strings.TrimRight(line, "\r\n"))
+	}
+	return len(data), nil
+}
+
+func (w *responsesBridgeWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *responsesBridgeWriter) Flush() {
+	w.decide()
+	// 非流式响应在 finish 中统一写出
+	if w.streaming {
+		w.ResponseWriter.Flush()
+	}
+}
+
+func (w *responsesBridgeWriter) handleStreamLine(line string) {
+	if strings.HasPrefix(line, ":") {
+		// ping 等注释行原样透传
+		_, _ = w.ResponseWriter.WriteString(line + "\n\n")
+		return
+	}
+	if !strings.HasPrefix(line, "data:") {
+		return
+	}
+	data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+	if data == "" || data == "[DONE]" {
+		return
+	}
+	var streamResponse dto.ChatCompletionsStreamResponse
+	if err := common.UnmarshalJsonStr(data, &streamResponse); err != nil {
+		common.SysLog("error unmarshalling stream response: " + err.Error())
+		return
+	}
+	for _, choice := range streamResponse.Choices {
+		w.responseText.WriteString(choice.Delta.GetContentString())
+		w.responseText.WriteString(choice.Delta.GetReasoningContent())
+		for _, toolCall := range choice.Delta.ToolCalls {
+			w.responseText.WriteString(toolCall.Function.Arguments)
+		}
+	}
+	w.writeEvents(service.StreamResponseOpenAI2Responses(&streamResponse, w.info))
+}
+
+func (w *responsesBridgeWriter) writeEvents(events []dto.ResponsesStreamResponse) {
+	for _, event := range events {
+		data, err := common.Marshal(event)
+		if err != nil {
+			common.SysLog("error marshalling responses event: " + err.Error())
+			continue
+		}
+		_, _ = w.ResponseWriter.WriteString(fmt.Sprintf("event: %s\ndata: %s\n\n", event.Type, data))
+	}
+	w.ResponseWriter.Flush()
+}
+
+func (w *responsesBridgeWriter) finish(c *gin.Context) {
+	w.decide()
+	if w.streaming {
+		if w.buffer.Len() > 0 {
+			w.handleStreamLine(strings.TrimRight(w.buffer.String(), "\r\n"))
+			w.buffer.Reset()
+		}
+		usage := w.info.ResponsesConvertInfo.Usage
+		if usage == nil {
+			usage = service.ResponseText2Usage(w.responseText.String(), w.info.UpstreamModelName, w.info.PromptTokens)
+		}
+		w.writeEvents(service.FinishResponsesStream(w.info, usage))
+		return
+	}
+
+	body := w.buffer.Bytes()
+	var openAIResponse dto.OpenAITextResponse
+	if err := common.Unmarshal(body, &openAIResponse); err == nil && len(openAIResponse.Choices) > 0 {
+		responsesResponse := service.ResponseOpenAI2Responses(&openAIResponse, w.info)
+		if converted, err := common.Marshal(responsesResponse); err == nil {
+			body = converted
+		}
+	} else {
+		logger.LogWarn(c, "failed to convert chat completions response to responses format")
+	}
+	w.ResponseWriter.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	_, _ = w.ResponseWriter.Write(body)
+}
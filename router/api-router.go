@@ -132,6 +132,10 @@ func SetApiRouter(router *gin.Engine) {
 			channelRoute.GET("/search", controller.SearchChannels)
 			channelRoute.GET("/models", controller.ChannelListModels)
 			channelRoute.GET("/models_enabled", controller.EnabledListModels)
+			channelRoute.GET("/health", controller.GetChannelHealth)
+			channelRoute.GET("/circuit_breaker", controller.GetChannelCircuitBreakers)
+			channelRoute.GET("/cooldown", controller.GetChannelCooldowns)
+			channelRoute.DELETE("/cooldown/:id", controller.ClearChannelCooldown)
 			channelRoute.GET("/:id", controller.GetChannel)
 			channelRoute.POST("/:id/key", middleware.CriticalRateLimit(), middleware.DisableCache(), middleware.SecureVerificationRequired(), controller.GetChannelKey)
 			channelRoute.GET("/test", controller.TestAllChannels)
@@ -226,6 +230,8 @@ func SetApiRouter(router *gin.Engine) {
 		taskRoute := apiRouter.Group("/task")
 		{
 			taskRoute.GET("/self", middleware.UserAuth(), controller.GetUserTask)
+			taskRoute.GET("/callbacks/self", middleware.UserAuth(), controller.GetUserTaskCallbacks)
+			taskRoute.POST("/push/:platform/:channel_id", controller.TaskPush)
 			taskRoute.GET("/", middleware.AdminAuth(), controller.GetAllTask)
 		}
 
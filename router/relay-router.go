@@ -5,6 +5,7 @@ import (
 	"github.com/QuantumNous/new-api/controller"
 	"github.com/QuantumNous/new-api/middleware"
 	"github.com/QuantumNous/new-api/relay"
+	"github.com/QuantumNous/new-api/service"
 	"github.com/QuantumNous/new-api/types"
 
 	"github.com/gin-gonic/gin"
@@ -14,6 +15,7 @@ func SetRelayRouter(router *gin.Engine) {
 	router.Use(middleware.CORS())
 	router.Use(middleware.DecompressRequestMiddleware())
 	router.Use(middleware.StatsMiddleware())
+	router.Use(middleware.Tracing())
 	// https://platform.openai.com/docs/api-reference/introduction
 	modelsRouter := router.Group("/v1/models")
 	modelsRouter.Use(middleware.TokenAuth())
@@ -71,6 +73,27 @@ func SetRelayRouter(router *gin.Engine) {
 			controller.Relay(c, types.RelayFormatOpenAIRealtime)
 		})
 	}
+	{
+		// 文件与批处理接口不指定模型，不经过渠道分发
+		filesRouter := relayV1Router.Group("/files")
+		filesRouter.POST("", controller.UploadFile)
+		filesRouter.GET("", controller.ListFiles)
+		filesRouter.GET("/:id", controller.RetrieveFile)
+		filesRouter.DELETE("/:id", controller.DeleteFile)
+		filesRouter.GET("/:id/content", controller.RetrieveFileContent)
+
+		batchesRouter := relayV1Router.Group("/batches")
+		batchesRouter.POST("", controller.CreateBatch)
+		batchesRouter.GET("", controller.ListBatches)
+		batchesRouter.GET("/:id", controller.RetrieveBatch)
+		batchesRouter.POST("/:id/cancel", controller.CancelBatch)
+	}
+	{
+		// count_tokens 不占用渠道的速率限制额度
+		countTokensRouter := relayV1Router.Group("")
+		countTokensRouter.Use(middleware.DistributeWithoutRateLimit())
+		countTokensRouter.POST("/messages/count_tokens", controller.RelayClaudeCountTokens)
+	}
 	{
 		//http router
 		httpRouter := relayV1Router.Group("")
@@ -141,11 +164,6 @@ func SetRelayRouter(router *gin.Engine) {
 
 		// not implemented
 		httpRouter.POST("/images/variations", controller.RelayNotImplemented)
-		httpRouter.GET("/files", controller.RelayNotImplemented)
-		httpRouter.POST("/files", controller.RelayNotImplemented)
-		httpRouter.DELETE("/files/:id", controller.RelayNotImplemented)
-		httpRouter.GET("/files/:id", controller.RelayNotImplemented)
-		httpRouter.GET("/files/:id/content", controller.RelayNotImplemented)
 		httpRouter.POST("/fine-tunes", controller.RelayNotImplemented)
 		httpRouter.GET("/fine-tunes", controller.RelayNotImplemented)
 		httpRouter.GET("/fine-tunes/:id", controller.RelayNotImplemented)
@@ -182,7 +200,8 @@ func SetRelayRouter(router *gin.Engine) {
 }
 
 func registerMjRouterGroup(relayMjRouter *gin.RouterGroup) {
-	relayMjRouter.GET("/image/:id", relay.RelayMidjourneyImage)
+	relayMjRouter.GET("/image/:id", middleware.MediaAuth(service.MediaResourceMidjourneyImage, "id"), relay.RelayMidjourneyImage)
+	relayMjRouter.POST("/image/:id/signed_url", middleware.TokenAuth(), controller.CreateMidjourneyImageSignedUrl)
 	relayMjRouter.Use(middleware.TokenAuth(), middleware.Distribute())
 	{
 		relayMjRouter.POST("/submit/action", controller.RelayMidjourney)
@@ -3,13 +3,17 @@ package router
 import (
 	"github.com/QuantumNous/new-api/controller"
 	"github.com/QuantumNous/new-api/middleware"
+	"github.com/QuantumNous/new-api/service"
 
 	"github.com/gin-gonic/gin"
 )
 
 func SetVideoRouter(router *gin.Engine) {
 	videoV1Router := router.Group("/v1")
-	videoV1Router.GET("/videos/:task_id/content", controller.VideoProxy)
+	videoV1Router.GET("/videos/:task_id/content", middleware.MediaAuth(service.MediaResourceVideo, "task_id"), controller.VideoProxy)
+	videoV1Router.POST("/videos/:task_id/content/signed_url", middleware.TokenAuth(), controller.CreateVideoContentSignedUrl)
+	videoV1Router.GET("/artifacts/:artifact_id", middleware.MediaAuth(service.MediaResourceArtifact, "artifact_id"), controller.GetArtifactContent)
+	videoV1Router.DELETE("/videos/:task_id", middleware.TokenAuth(), controller.CancelVideoTask)
 	videoV1Router.Use(middleware.TokenAuth(), middleware.Distribute())
 	{
 		videoV1Router.POST("/video/generations", controller.RelayTask)
@@ -0,0 +1,294 @@
+package service
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/setting/system_setting"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+)
+
+const artifactIdPrefix = "art_"
+
+// artifactDownloadLimiter 限制同时下载保存的结果数量，避免大量任务同时完成时占满带宽和磁盘
+var artifactDownloadLimiter = make(chan struct{}, 4)
+
+// AfterTaskUpdate 视频任务状态更新后保存结果副本并推送回调，副本保存完成后再推送，使回调中的结果地址为网关地址
+func AfterTaskUpdate(task *model.Task, preStatus model.TaskStatus, resultUrl string, channel *model.Channel) {
+	gopool.Go(func() {
+		SaveTaskArtifact(task, preStatus, resultUrl, channel)
+		NotifyTaskCallback(task, preStatus)
+	})
+}
+
+// AfterMidjourneyTaskUpdate Midjourney 任务状态更新后保存结果副本并推送回调
+func AfterMidjourneyTaskUpdate(task *model.Midjourney, preStatus string) {
+	gopool.Go(func() {
+		SaveMidjourneyArtifact(task, preStatus)
+		NotifyMidjourneyCallback(task, preStatus)
+	})
+}
+
+// ArtifactURL 结果副本的固定访问地址
+func ArtifactURL(artifact *model.TaskArtifact) string {
+	return system_setting.ServerAddress + "/v1/artifacts/" + artifact.ArtifactId
+}
+
+// SaveTaskArtifact 视频任务成功后保存结果副本，并把任务的结果地址替换为网关地址
+func SaveTaskArtifact(task *model.Task, preStatus model.TaskStatus, resultUrl string, channel *model.Channel) {
+	if !operation_setting.GetArtifactSetting().Enabled || task.Status != model.TaskStatusSuccess ||
+		preStatus == model.TaskStatusSuccess || resultUrl == "" {
+		return
+	}
+	var header http.Header
+	// Sora 等 OpenAI 格式的结果地址指向网关自身的代理接口，直接从上游的 content 接口下载
+	if channel != nil && strings.HasPrefix(resultUrl, system_setting.ServerAddress+"/v1/videos/") {
+		baseURL := channel.GetBaseURL()
+		if baseURL == "" {
+			baseURL = "https://api.openai.com"
+		}
+		resultUrl = fmt.Sprintf("%s/v1/videos/%s/content", baseURL, task.TaskID)
+		header = http.Header{}
+		header.Set("Authorization", "Bearer "+task.ChannelKey(channel))
+	}
+	artifact, err := saveArtifact(task.UserId, model.TaskArtifactTypeTask, task.TaskID, resultUrl, header, nil)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to save artifact for task %s: %s", task.TaskID, err.Error()))
+		return
+	}
+	artifactURL := ArtifactURL(artifact)
+	if err := model.SetTaskResultUrl(task.ID, artifactURL); err != nil {
+		common.SysError(fmt.Sprintf("failed to update result url for task %s: %s", task.TaskID, err.Error()))
+		return
+	}
+	task.FailReason = artifactURL
+}
+
+// SaveMidjourneyArtifact Midjourney 任务成功后保存图片或视频副本，并把任务中的地址替换为网关地址
+func SaveMidjourneyArtifact(task *model.Midjourney, preStatus string) {
+	if !operation_setting.GetArtifactSetting().Enabled || task.Status != model.TaskStatusSuccess || preStatus == model.TaskStatusSuccess {
+		return
+	}
+	column, sourceUrl := "image_url", task.ImageUrl
+	if task.VideoUrl != "" {
+		column, sourceUrl = "video_url", task.VideoUrl
+	}
+	if sourceUrl == "" {
+		return
+	}
+	var client *http.Client
+	if channel, err := model.CacheGetChannel(task.ChannelId); err == nil {
+		if proxy := channel.GetSetting().Proxy; proxy != "" {
+			client, _ = NewProxyHttpClient(proxy)
+		}
+	}
+	artifact, err := saveArtifact(task.UserId, model.TaskArtifactTypeMidjourney, task.MjId, sourceUrl, nil, client)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to save artifact for midjourney task %s: %s", task.MjId, err.Error()))
+		return
+	}
+	artifactURL := ArtifactURL(artifact)
+	if err := model.SetMidjourneyResultUrl(task.Id, column, artifactURL); err != nil {
+		common.SysError(fmt.Sprintf("failed to update result url for midjourney task %s: %s", task.MjId, err.Error()))
+		return
+	}
+	if column == "video_url" {
+		task.VideoUrl = artifactURL
+	} else {
+		task.ImageUrl = artifactURL
+	}
+}
+
+// saveArtifact 下载结果到临时文件，再写入结果存储并记录。
+// 同一任务的结果可能同时被推送和轮询触发保存，(task_type, task_id) 唯一，插入冲突时返回已保存的副本
+func saveArtifact(userId int, taskType string, taskId string, sourceUrl string, header http.Header, client *http.Client) (*model.TaskArtifact, error) {
+	if existing, err := model.GetTaskArtifact(taskType, taskId); err == nil && existing != nil {
+		return existing, nil
+	}
+	artifactDownloadLimiter <- struct{}{}
+	defer func() { <-artifactDownloadLimiter }()
+	// 等待下载名额期间其他请求可能已保存
+	if existing, err := model.GetTaskArtifact(taskType, taskId); err == nil && existing != nil {
+		return existing, nil
+	}
+
+	setting := operation_setting.GetArtifactSetting()
+	content, contentType, err := openArtifactSource(sourceUrl, header, client)
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+
+	tmp, err := os.CreateTemp("", "artifact-*")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}()
+	limit := int64(setting.MaxSizeMB) << 20
+	size, err := io.Copy(tmp, io.LimitReader(content, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download artifact: %w", err)
+	}
+	if size > limit {
+		return nil, fmt.Errorf("artifact is too large, max size is %d MB", setting.MaxSizeMB)
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	artifactId := artifactIdPrefix + strings.ReplaceAll(common.GetUUID(), "-", "")
+	storageKey := fmt.Sprintf("%d/%s%s", userId, artifactId, artifactExtension(contentType))
+	if err := GetArtifactStorage().Save(storageKey, tmp, size, contentType); err != nil {
+		return nil, fmt.Errorf("failed to store artifact: %w", err)
+	}
+	artifact := &model.TaskArtifact{
+		ArtifactId:  artifactId,
+		UserId:      userId,
+		TaskType:    taskType,
+		TaskId:      taskId,
+		SourceUrl:   sourceUrl,
+		StorageKey:  storageKey,
+		ContentType: contentType,
+		Bytes:       size,
+		CreatedAt:   common.GetTimestamp(),
+	}
+	if strings.HasPrefix(sourceUrl, "data:") {
+		// 不在数据库中重复保存 base64 内容
+		artifact.SourceUrl = ""
+	}
+	if setting.RetentionDays > 0 {
+		artifact.ExpiresAt = artifact.CreatedAt + int64(setting.RetentionDays)*24*3600
+	}
+	if err := artifact.Insert(); err != nil {
+		_ = GetArtifactStorage().Delete(storageKey)
+		if existing, getErr := model.GetTaskArtifact(taskType, taskId); getErr == nil && existing != nil {
+			return existing, nil
+		}
+		return nil, err
+	}
+	return artifact, nil
+}
+
+// openArtifactSource 打开结果内容，支持 data: URL 和 http(s) 地址，返回内容及其类型
+func openArtifactSource(sourceUrl string, header http.Header, client *http.Client) (io.ReadCloser, string, error) {
+	if strings.HasPrefix(sourceUrl, "data:") {
+		meta, data, ok := strings.Cut(strings.TrimPrefix(sourceUrl, "data:"), ",")
+		if !ok || !strings.HasSuffix(meta, ";base64") {
+			return nil, "", errors.New("unsupported data url")
+		}
+		reader := base64.NewDecoder(base64.StdEncoding, strings.NewReader(data))
+		return io.NopCloser(reader), strings.TrimSuffix(meta, ";base64"), nil
+	}
+	var resp *http.Response
+	var err error
+	if header == nil && client == nil {
+		resp, err = DoDownloadRequest(sourceUrl, "task artifact")
+	} else {
+		if client == nil {
+			client = GetHttpClient()
+		}
+		var req *http.Request
+		req, err = http.NewRequest(http.MethodGet, sourceUrl, nil)
+		if err != nil {
+			return nil, "", err
+		}
+		for k, values := range header {
+			req.Header[k] = values
+		}
+		resp, err = client.Do(req)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, "", fmt.Errorf("download artifact failed with status code %d", resp.StatusCode)
+	}
+	return resp.Body, resp.Header.Get("Content-Type"), nil
+}
+
+func artifactExtension(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	switch mediaType {
+	case "video/mp4":
+		return ".mp4"
+	case "image/png":
+		return ".png"
+	case "image/jpeg":
+		return ".jpg"
+	case "image/webp":
+		return ".webp"
+	}
+	return ""
+}
+
+// ServeArtifact 返回结果副本的内容，支持 Range 和条件请求
+func ServeArtifact(c *gin.Context, artifact *model.TaskArtifact) error {
+	content, err := GetArtifactStorage().Open(artifact.StorageKey)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+	if artifact.ContentType != "" {
+		c.Header("Content-Type", artifact.ContentType)
+	}
+	c.Header("Cache-Control", "private, max-age=86400")
+	http.ServeContent(c.Writer, c.Request, "", time.Unix(artifact.CreatedAt, 0), content)
+	return nil
+}
+
+// DeleteArtifact 删除结果副本的记录及其内容
+func DeleteArtifact(artifact *model.TaskArtifact) error {
+	if err := artifact.Delete(); err != nil {
+		return err
+	}
+	if err := GetArtifactStorage().Delete(artifact.StorageKey); err != nil {
+		common.SysError(fmt.Sprintf("failed to delete artifact content %s: %s", artifact.ArtifactId, err.Error()))
+	}
+	return nil
+}
+
+// AutomaticallyCleanupExpiredArtifacts 定期删除超过保留时间的结果副本
+func AutomaticallyCleanupExpiredArtifacts() {
+	for {
+		CleanupExpiredArtifacts()
+		time.Sleep(time.Hour)
+	}
+}
+
+func CleanupExpiredArtifacts() {
+	for {
+		artifacts, err := model.GetExpiredTaskArtifacts(common.GetTimestamp(), 100)
+		if err != nil {
+			common.SysError("failed to query expired artifacts: " + err.Error())
+			return
+		}
+		for _, artifact := range artifacts {
+			if err := DeleteArtifact(artifact); err != nil {
+				common.SysError(fmt.Sprintf("failed to delete expired artifact %s: %s", artifact.ArtifactId, err.Error()))
+				return
+			}
+		}
+		if len(artifacts) < 100 {
+			return
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+// ArtifactStorage 异步任务生成结果的存储，Open 返回的内容需要支持 Seek，以便按 Range 读取
+type ArtifactStorage interface {
+	Save(key string, reader io.Reader, size int64, contentType string) error
+	Open(key string) (io.ReadSeekCloser, error)
+	Delete(key string) error
+}
+
+// LocalArtifactStorage 基于本地磁盘的结果存储，多节点部署时需要共享目录或改用 S3
+type LocalArtifactStorage struct {
+	files *LocalFileStorage
+}
+
+func NewLocalArtifactStorage(root string) *LocalArtifactStorage {
+	return &LocalArtifactStorage{files: NewLocalFileStorage(root)}
+}
+
+func (s *LocalArtifactStorage) Save(key string, reader io.Reader, size int64, contentType string) error {
+	_, err := s.files.Save(key, reader)
+	return err
+}
+
+func (s *LocalArtifactStorage) Open(key string) (io.ReadSeekCloser, error) {
+	p, err := s.files.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(p)
+}
+
+func (s *LocalArtifactStorage) Delete(key string) error {
+	return s.files.Delete(key)
+}
+
+// S3ArtifactStorage 基于 S3 兼容对象存储的结果存储，请求使用 SigV4 签名
+type S3ArtifactStorage struct {
+	Endpoint    string
+	Region      string
+	Bucket      string
+	Credentials aws.Credentials
+	PathStyle   bool
+
+	signer *v4.Signer
+	client *http.Client
+}
+
+func NewS3ArtifactStorage(endpoint string, region string, bucket string, accessKeyId string, secretAccessKey string, pathStyle bool) (*S3ArtifactStorage, error) {
+	if endpoint == "" || bucket == "" {
+		return nil, errors.New("s3 endpoint and bucket are required")
+	}
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	return &S3ArtifactStorage{
+		Endpoint: strings.TrimSuffix(endpoint, "/"),
+		Region:   region,
+		Bucket:   bucket,
+		Credentials: aws.Credentials{
+			AccessKeyID:     accessKeyId,
+			SecretAccessKey: secretAccessKey,
+		},
+		PathStyle: pathStyle,
+		signer:    v4.NewSigner(),
+		client:    newS3HttpClient(),
+	}, nil
+}
+
+const (
+	// s3RequestTimeout HEAD、DELETE 等不传输内容的请求的超时时间
+	s3RequestTimeout = time.Minute
+	// s3UploadTimeout 上传结果的超时时间，结果最大为 ArtifactSetting.MaxSizeMB
+	s3UploadTimeout = 30 * time.Minute
+)
+
+// newS3HttpClient 读取内容时按客户端下载速度流式返回，不设置整体超时，只限制连接和等待响应头的时间
+func newS3HttpClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = s3RequestTimeout
+	return &http.Client{Transport: transport}
+}
+
+func (s *S3ArtifactStorage) objectURL(key string) (string, error) {
+	u, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return "", err
+	}
+	if s.PathStyle {
+		u.Path = "/" + s.Bucket + "/" + key
+	} else {
+		u.Host = s.Bucket + "." + u.Host
+		u.Path = "/" + key
+	}
+	return u.String(), nil
+}
+
+func (s *S3ArtifactStorage) do(ctx context.Context, method string, key string, body io.Reader, size int64, header http.Header) (*http.Response, error) {
+	objectURL, err := s.objectURL(key)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, objectURL, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	for k, values := range header {
+		for _, v := range values {
+			req.Header.Add(k, v)
+		}
+	}
+	// 内容不参与签名，避免上传前需要计算整个文件的哈希
+	req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	err = s.signer.SignHTTP(ctx, s.Credentials, req, "UNSIGNED-PAYLOAD", "s3", s.Region, time.Now(), func(options *v4.SignerOptions) {
+		options.DisableURIPathEscaping = true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.client.Do(req)
+}
+
+func s3ResponseError(method string, key string, resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("s3 %s %s failed with status code %d: %s", method, key, resp.StatusCode, string(body))
+}
+
+func (s *S3ArtifactStorage) Save(key string, reader io.Reader, size int64, contentType string) error {
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s3UploadTimeout)
+	defer cancel()
+	resp, err := s.do(ctx, http.MethodPut, key, reader, size, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s3ResponseError(http.MethodPut, key, resp)
+	}
+	return nil
+}
+
+func (s *S3ArtifactStorage) Open(key string) (io.ReadSeekCloser, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s3RequestTimeout)
+	defer cancel()
+	resp, err := s.do(ctx, http.MethodHead, key, nil, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, os.ErrNotExist
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("s3 HEAD %s failed with status code %d", key, resp.StatusCode)
+	}
+	return &s3Object{storage: s, key: key, size: resp.ContentLength}, nil
+}
+
+func (s *S3ArtifactStorage) Delete(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s3RequestTimeout)
+	defer cancel()
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return s3ResponseError(http.MethodDelete, key, resp)
+	}
+	return nil
+}
+
+// s3Object 按需发起 Range 请求读取对象，Seek 后从新的位置重新请求
+type s3Object struct {
+	storage *S3ArtifactStorage
+	key     string
+	size    int64
+	offset  int64
+	body    io.ReadCloser
+}
+
+func (o *s3Object) Read(p []byte) (int, error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+	if o.body == nil {
+		header := http.Header{}
+		header.Set("Range", "bytes="+strconv.FormatInt(o.offset, 10)+"-")
+		resp, err := o.storage.do(context.Background(), http.MethodGet, o.key, nil, 0, header)
+		if err != nil {
+			return 0, err
+		}
+		if resp.StatusCode != http.StatusPartialContent && resp.StatusCode != http.StatusOK {
+			defer resp.Body.Close()
+			return 0, s3ResponseError(http.MethodGet, o.key, resp)
+		}
+		o.body = resp.Body
+	}
+	n, err := o.body.Read(p)
+	o.offset += int64(n)
+	return n, err
+}
+
+func (o *s3Object) Seek(offset int64, whence int) (int64, error) {
+	var next int64
+	switch whence {
+	case io.SeekStart:
+		next = offset
+	case io.SeekCurrent:
+		next = o.offset + offset
+	case io.SeekEnd:
+		next = o.size + offset
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if next < 0 {
+		return 0, errors.New("negative position")
+	}
+	if next != o.offset && o.body != nil {
+		_ = o.body.Close()
+		o.body = nil
+	}
+	o.offset = next
+	return next, nil
+}
+
+func (o *s3Object) Close() error {
+	if o.body == nil {
+		return nil
+	}
+	err := o.body.Close()
+	o.body = nil
+	return err
+}
+
+var (
+	artifactStorage     ArtifactStorage
+	artifactStorageOnce sync.Once
+)
+
+// GetArtifactStorage 返回全局结果存储，ARTIFACT_STORAGE=s3 时使用 ARTIFACT_S3_* 配置的对象存储，否则使用 ARTIFACT_STORAGE_PATH 指定的本地目录
+func GetArtifactStorage() ArtifactStorage {
+	artifactStorageOnce.Do(func() {
+		if artifactStorage != nil {
+			return
+		}
+		if constant.ArtifactStorage == "s3" {
+			storage, err := NewS3ArtifactStorage(constant.ArtifactS3Endpoint, constant.ArtifactS3Region, constant.ArtifactS3Bucket,
+				constant.ArtifactS3AccessKeyId, constant.ArtifactS3SecretAccessKey, constant.ArtifactS3PathStyle)
+			if err == nil {
+				artifactStorage = storage
+				return
+			}
+			common.SysError("failed to init s3 artifact storage, fallback to local storage: " + err.Error())
+		}
+		artifactStorage = NewLocalArtifactStorage(filepath.Clean(constant.ArtifactStoragePath))
+	})
+	return artifactStorage
+}
+
+// SetArtifactStorage 替换全局结果存储实现，需在服务启动前调用
+func SetArtifactStorage(storage ArtifactStorage) {
+	artifactStorage = storage
+}
@@ -0,0 +1,47 @@
+package service
+
+import (
+	"encoding/base64"
+	"sync"
+	"testing"
+
+	"github.com/QuantumNous/new-api/model"
+)
+
+func TestSaveArtifactConcurrentSavesKeepOneCopy(t *testing.T) {
+	setupQuotaSpendTestDB(t)
+	if err := model.DB.Exec("DELETE FROM task_artifacts").Error; err != nil {
+		t.Fatal(err)
+	}
+	SetArtifactStorage(NewLocalArtifactStorage(t.TempDir()))
+	sourceUrl := "data:image/png;base64," + base64.StdEncoding.EncodeToString([]byte("png content"))
+
+	// 推送和轮询可能同时触发保存同一任务的结果
+	var wg sync.WaitGroup
+	ids := make([]string, 8)
+	for i := range ids {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			artifact, err := saveArtifact(1, model.TaskArtifactTypeTask, "task_artifact", sourceUrl, nil, nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			ids[i] = artifact.ArtifactId
+		}(i)
+	}
+	wg.Wait()
+	for _, id := range ids[1:] {
+		if id != ids[0] {
+			t.Fatalf("concurrent saves returned different artifacts %v", ids)
+		}
+	}
+	var count int64
+	if err := model.DB.Model(&model.TaskArtifact{}).Where("task_id = ?", "task_artifact").Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("saved %d artifacts for one task, want 1", count)
+	}
+}
@@ -0,0 +1,223 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+)
+
+const (
+	BatchCompletionWindow = "24h"
+	batchIdPrefix         = "batch_"
+	// 单行请求的最大长度
+	batchMaxLineBytes = 16 << 20
+)
+
+// batchEndpoints 支持批处理的接口
+var batchEndpoints = map[string]bool{
+	"/v1/chat/completions": true,
+	"/v1/embeddings":       true,
+	"/v1/completions":      true,
+}
+
+func IsBatchEndpointSupported(endpoint string) bool {
+	return batchEndpoints[endpoint]
+}
+
+// CreateBatch 校验输入文件并创建批处理任务，任务的实际执行由调度器异步完成
+func CreateBatch(userId int, tokenId int, req dto.BatchRequest) (*model.Batch, error) {
+	if !IsBatchEndpointSupported(req.Endpoint) {
+		return nil, fmt.Errorf("unsupported endpoint: %s", req.Endpoint)
+	}
+	if req.CompletionWindow != BatchCompletionWindow {
+		return nil, fmt.Errorf("unsupported completion_window: %s, only %s is supported", req.CompletionWindow, BatchCompletionWindow)
+	}
+	file, err := model.GetUserFileByFileId(userId, req.InputFileId)
+	if err != nil || file.IsExpired() {
+		return nil, fmt.Errorf("input file %s not found", req.InputFileId)
+	}
+	if file.Purpose != model.FilePurposeBatch {
+		return nil, fmt.Errorf("input file %s must have purpose %s", req.InputFileId, model.FilePurposeBatch)
+	}
+
+	now := common.GetTimestamp()
+	batch := &model.Batch{
+		BatchId:          batchIdPrefix + common.GetUUID()[:24],
+		UserId:           userId,
+		TokenId:          tokenId,
+		Endpoint:         req.Endpoint,
+		InputFileId:      req.InputFileId,
+		CompletionWindow: req.CompletionWindow,
+		Status:           model.BatchStatusValidating,
+		Metadata:         req.Metadata,
+		CreatedAt:        now,
+		ExpiresAt:        now + 24*60*60,
+	}
+	if err := batch.Insert(); err != nil {
+		return nil, err
+	}
+	return batch, nil
+}
+
+func optionalTimestamp(timestamp int64) *int64 {
+	if timestamp == 0 {
+		return nil
+	}
+	return &timestamp
+}
+
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+func BatchToOpenAIBatch(batch *model.Batch) dto.Batch {
+	openAIBatch := dto.Batch{
+		Id:               batch.BatchId,
+		Object:           "batch",
+		Endpoint:         batch.Endpoint,
+		InputFileId:      batch.InputFileId,
+		CompletionWindow: batch.CompletionWindow,
+		Status:           batch.Status,
+		OutputFileId:     optionalString(batch.OutputFileId),
+		ErrorFileId:      optionalString(batch.ErrorFileId),
+		CreatedAt:        batch.CreatedAt,
+		InProgressAt:     optionalTimestamp(batch.InProgressAt),
+		ExpiresAt:        optionalTimestamp(batch.ExpiresAt),
+		FinalizingAt:     optionalTimestamp(batch.FinalizingAt),
+		CompletedAt:      optionalTimestamp(batch.CompletedAt),
+		FailedAt:         optionalTimestamp(batch.FailedAt),
+		ExpiredAt:        optionalTimestamp(batch.ExpiredAt),
+		CancellingAt:     optionalTimestamp(batch.CancellingAt),
+		CancelledAt:      optionalTimestamp(batch.CancelledAt),
+		RequestCounts: dto.BatchRequestCounts{
+			Total:     batch.RequestTotal,
+			Completed: batch.RequestCompleted,
+			Failed:    batch.RequestFailed,
+		},
+		Metadata: batch.Metadata,
+	}
+	if batch.Errors != "" {
+		var batchErrors []dto.BatchError
+		if err := common.UnmarshalJsonStr(batch.Errors, &batchErrors); err == nil && len(batchErrors) > 0 {
+			openAIBatch.Errors = &dto.BatchErrors{
+				Object: "list",
+				Data:   batchErrors,
+			}
+		}
+	}
+	return openAIBatch
+}
+
+func SetBatchErrors(batch *model.Batch, batchErrors []dto.BatchError) {
+	data, err := common.Marshal(batchErrors)
+	if err != nil {
+		return
+	}
+	batch.Errors = string(data)
+}
+
+func newBatchError(line int, code string, message string) dto.BatchError {
+	batchError := dto.BatchError{
+		Code:    code,
+		Message: message,
+	}
+	if line > 0 {
+		batchError.Line = &line
+	}
+	return batchError
+}
+
+// ParseBatchInput 读取并校验批处理输入文件，返回所有请求行；校验失败时返回错误列表
+func ParseBatchInput(batch *model.Batch) ([]dto.BatchInputLine, []dto.BatchError) {
+	file, err := model.GetUserFileByFileId(batch.UserId, batch.InputFileId)
+	if err != nil {
+		return nil, []dto.BatchError{newBatchError(0, "invalid_file", fmt.Sprintf("input file %s not found", batch.InputFileId))}
+	}
+	content, err := OpenFileContent(file)
+	if err != nil {
+		return nil, []dto.BatchError{newBatchError(0, "invalid_file", "failed to read input file")}
+	}
+	defer content.Close()
+
+	maxRequests := operation_setting.GetBatchSetting().MaxRequests
+	lines := make([]dto.BatchInputLine, 0)
+	customIds := make(map[string]bool)
+	batchErrors := make([]dto.BatchError, 0)
+
+	scanner := bufio.NewScanner(content)
+	scanner.Buffer(make([]byte, 64*1024), batchMaxLineBytes)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+		var line dto.BatchInputLine
+		if err := common.Unmarshal(raw, &line); err != nil {
+			batchErrors = append(batchErrors, newBatchError(lineNum, "invalid_json_line", "This line is not parseable as valid JSON."))
+			continue
+		}
+		if line.CustomId == "" {
+			batchErrors = append(batchErrors, newBatchError(lineNum, "missing_required_parameter", "custom_id is required."))
+			continue
+		}
+		if customIds[line.CustomId] {
+			batchErrors = append(batchErrors, newBatchError(lineNum, "duplicate_custom_id", fmt.Sprintf("The custom_id %s is duplicated.", line.CustomId)))
+			continue
+		}
+		customIds[line.CustomId] = true
+		if strings.ToUpper(line.Method) != http.MethodPost {
+			batchErrors = append(batchErrors, newBatchError(lineNum, "invalid_method", "Only POST is supported."))
+			continue
+		}
+		if line.Url != batch.Endpoint {
+			batchErrors = append(batchErrors, newBatchError(lineNum, "mismatched_endpoint", fmt.Sprintf("The url %s does not match the batch endpoint %s.", line.Url, batch.Endpoint)))
+			continue
+		}
+		if len(line.Body) == 0 || line.Body[0] != '{' {
+			batchErrors = append(batchErrors, newBatchError(lineNum, "invalid_request", "body must be a JSON object."))
+			continue
+		}
+		lines = append(lines, line)
+		if maxRequests > 0 && len(lines) > maxRequests {
+			return nil, []dto.BatchError{newBatchError(0, "too_many_requests", fmt.Sprintf("The input file contains more than %d requests.", maxRequests))}
+		}
+		// 错误过多时不再继续校验
+		if len(batchErrors) >= 100 {
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			batchErrors = append(batchErrors, newBatchError(lineNum+1, "line_too_long", fmt.Sprintf("The line exceeds %d bytes.", batchMaxLineBytes)))
+		} else {
+			batchErrors = append(batchErrors, newBatchError(0, "invalid_file", "failed to read input file: "+err.Error()))
+		}
+	}
+	if len(batchErrors) > 0 {
+		return nil, batchErrors
+	}
+	if len(lines) == 0 {
+		return nil, []dto.BatchError{newBatchError(0, "empty_file", "The input file does not contain any requests.")}
+	}
+	return lines, nil
+}
+
+// SaveBatchResultFile 将输出/错误 JSONL 保存为属于用户的文件
+func SaveBatchResultFile(batch *model.Batch, name string, reader io.Reader) (*model.File, error) {
+	filename := fmt.Sprintf("%s_%s.jsonl", batch.BatchId, name)
+	return CreateFile(batch.UserId, batch.TokenId, filename, model.FilePurposeBatchOutput, "application/jsonl", reader, 0)
+}
@@ -11,6 +11,8 @@ import (
 	"github.com/QuantumNous/new-api/model"
 	"github.com/QuantumNous/new-api/setting/operation_setting"
 	"github.com/QuantumNous/new-api/types"
+
+	"github.com/bytedance/gopkg/util/gopool"
 )
 
 func formatNotifyType(channelId int, status int) string {
@@ -29,6 +31,7 @@ func DisableChannel(channelError types.ChannelError, reason string) {
 
 	success := model.UpdateChannelStatus(channelError.ChannelId, channelError.UsingKey, common.ChannelStatusAutoDisabled, reason)
 	if success {
+		model.OpenChannelCircuitBreaker(channelError.ChannelId, channelError.UsingKey, reason)
 		subject := fmt.Sprintf("通道「%s」（#%d）已被禁用", channelError.ChannelName, channelError.ChannelId)
 		content := fmt.Sprintf("通道「%s」（#%d）已被禁用，原因：%s", channelError.ChannelName, channelError.ChannelId, reason)
 		NotifyRootUser(formatNotifyType(channelError.ChannelId, common.ChannelStatusAutoDisabled), subject, content)
@@ -38,12 +41,45 @@ func DisableChannel(channelError types.ChannelError, reason string) {
 func EnableChannel(channelId int, usingKey string, channelName string) {
 	success := model.UpdateChannelStatus(channelId, usingKey, common.ChannelStatusEnabled, "")
 	if success {
+		model.ResetChannelHealth(channelId)
+		model.CloseChannelCircuitBreaker(channelId, usingKey)
 		subject := fmt.Sprintf("通道「%s」（#%d）已被启用", channelName, channelId)
 		content := fmt.Sprintf("通道「%s」（#%d）已被启用", channelName, channelId)
 		NotifyRootUser(formatNotifyType(channelId, common.ChannelStatusEnabled), subject, content)
 	}
 }
 
+// IsChannelHealthFailure 错误是否由渠道引起，用于渠道健康统计，请求本身的错误（如参数错误）不计入
+func IsChannelHealthFailure(err *types.NewAPIError) bool {
+	if err == nil {
+		return false
+	}
+	if types.IsChannelError(err) {
+		return true
+	}
+	switch err.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return true
+	}
+	return err.StatusCode >= 500
+}
+
+// RecordChannelCircuitBreakerResult 将请求结果反馈给渠道熔断器，半开状态的渠道或 Key 恢复后自动启用
+func RecordChannelCircuitBreakerResult(channelError types.ChannelError, err *types.NewAPIError) {
+	switch {
+	case err == nil:
+		if model.RecordChannelCircuitBreakerResult(channelError.ChannelId, channelError.UsingKey, true, "") {
+			gopool.Go(func() {
+				EnableChannel(channelError.ChannelId, channelError.UsingKey, channelError.ChannelName)
+			})
+		}
+	case IsChannelHealthFailure(err):
+		model.RecordChannelCircuitBreakerResult(channelError.ChannelId, channelError.UsingKey, false, err.Error())
+	default:
+		model.ReleaseChannelCircuitBreakerTrial(channelError.ChannelId, channelError.UsingKey)
+	}
+}
+
 func ShouldDisableChannel(channelType int, err *types.NewAPIError) bool {
 	if !common.AutomaticDisableChannelEnabled {
 		return false
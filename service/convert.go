@@ -2,6 +2,7 @@ package service
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 
@@ -10,6 +11,7 @@ import (
 	"github.com/QuantumNous/new-api/dto"
 	"github.com/QuantumNous/new-api/relay/channel/openrouter"
 	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/types"
 )
 
 func ClaudeToOpenAIRequest(claudeRequest dto.ClaudeRequest, info *relaycommon.RelayInfo) (*dto.GeneralOpenAIRequest, error) {
@@ -808,3 +810,679 @@ func StreamResponseOpenAI2Gemini(openAIResponse *dto.ChatCompletionsStreamRespon
 
 	return geminiResponse
 }
+
+type responsesInputItem struct {
+	Type      string          `json:"type"`
+	Role      string          `json:"role"`
+	Content   json.RawMessage `json:"content"`
+	CallId    string          `json:"call_id"`
+	Name      string          `json:"name"`
+	Arguments string          `json:"arguments"`
+	Output    json.RawMessage `json:"output"`
+}
+
+type responsesInputContent struct {
+	Type     string          `json:"type"`
+	Text     string          `json:"text"`
+	Refusal  string          `json:"refusal"`
+	ImageUrl json.RawMessage `json:"image_url"`
+	Detail   string          `json:"detail"`
+	FileId   string          `json:"file_id"`
+	FileData string          `json:"file_data"`
+	FileUrl  string          `json:"file_url"`
+	Filename string          `json:"filename"`
+}
+
+type responsesTool struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Parameters  any    `json:"parameters"`
+}
+
+type responsesTextFormat struct {
+	Format *struct {
+		Type        string          `json:"type"`
+		Name        string          `json:"name"`
+		Description string          `json:"description"`
+		Schema      any             `json:"schema"`
+		Strict      json.RawMessage `json:"strict"`
+	} `json:"format"`
+	Verbosity json.RawMessage `json:"verbosity"`
+}
+
+// ResponsesRequestToOpenAIRequest 将 Responses API 请求转换为 chat completions 请求，供不支持 /v1/responses 的渠道使用
+func ResponsesRequestToOpenAIRequest(responsesRequest *dto.OpenAIResponsesRequest) (*dto.GeneralOpenAIRequest, error) {
+	// 网关不保存 response，无法还原上下文
+	if responsesRequest.PreviousResponseID != "" {
+		return nil, errors.New("previous_response_id is not supported by this channel")
+	}
+	if len(responsesRequest.Prompt) > 0 && common.GetJsonType(responsesRequest.Prompt) != "null" {
+		return nil, errors.New("prompt is not supported by this channel")
+	}
+
+	openAIRequest := &dto.GeneralOpenAIRequest{
+		Model:     responsesRequest.Model,
+		Stream:    responsesRequest.Stream,
+		MaxTokens: responsesRequest.MaxOutputTokens,
+		TopP:      responsesRequest.TopP,
+		User:      responsesRequest.User,
+	}
+	if responsesRequest.Stream {
+		openAIRequest.StreamOptions = &dto.StreamOptions{
+			IncludeUsage: true,
+		}
+	}
+	if responsesRequest.Temperature != 0 {
+		openAIRequest.Temperature = common.GetPointer[float64](responsesRequest.Temperature)
+	}
+	if responsesRequest.Reasoning != nil && responsesRequest.Reasoning.Effort != "" {
+		openAIRequest.ReasoningEffort = responsesRequest.Reasoning.Effort
+	}
+	if len(responsesRequest.ParallelToolCalls) > 0 {
+		var parallelToolCalls bool
+		if err := common.Unmarshal(responsesRequest.ParallelToolCalls, &parallelToolCalls); err == nil {
+			openAIRequest.ParallelTooCalls = &parallelToolCalls
+		}
+	}
+	if len(responsesRequest.PromptCacheKey) > 0 {
+		var promptCacheKey string
+		if err := common.Unmarshal(responsesRequest.PromptCacheKey, &promptCacheKey); err == nil {
+			openAIRequest.PromptCacheKey = promptCacheKey
+		}
+	}
+
+	// Convert text format
+	if len(responsesRequest.Text) > 0 {
+		var text responsesTextFormat
+		if err := common.Unmarshal(responsesRequest.Text, &text); err != nil {
+			return nil, fmt.Errorf("invalid text: %w", err)
+		}
+		openAIRequest.Verbosity = text.Verbosity
+		if text.Format != nil {
+			switch text.Format.Type {
+			case "json_object":
+				openAIRequest.ResponseFormat = &dto.ResponseFormat{Type: "json_object"}
+			case "json_schema":
+				jsonSchema, err := common.Marshal(dto.FormatJsonSchema{
+					Description: text.Format.Description,
+					Name:        text.Format.Name,
+					Schema:      text.Format.Schema,
+					Strict:      text.Format.Strict,
+				})
+				if err != nil {
+					return nil, err
+				}
+				openAIRequest.ResponseFormat = &dto.ResponseFormat{
+					Type:       "json_schema",
+					JsonSchema: jsonSchema,
+				}
+			}
+		}
+	}
+
+	// Convert tools, 内置工具（web_search、file_search 等）无法在其他渠道执行，直接忽略
+	if len(responsesRequest.Tools) > 0 {
+		var tools []responsesTool
+		if err := common.Unmarshal(responsesRequest.Tools, &tools); err != nil {
+			return nil, fmt.Errorf("invalid tools: %w", err)
+		}
+		for _, tool := range tools {
+			if tool.Type != "function" {
+				continue
+			}
+			openAIRequest.Tools = append(openAIRequest.Tools, dto.ToolCallRequest{
+				Type: "function",
+				Function: dto.FunctionRequest{
+					Name:        tool.Name,
+					Description: tool.Description,
+					Parameters:  tool.Parameters,
+				},
+			})
+		}
+	}
+	if len(openAIRequest.Tools) > 0 && len(responsesRequest.ToolChoice) > 0 {
+		openAIRequest.ToolChoice = toolChoiceResponses2OpenAI(responsesRequest.ToolChoice)
+	}
+
+	// Convert messages
+	openAIMessages := make([]dto.Message, 0)
+	if len(responsesRequest.Instructions) > 0 {
+		var instructions string
+		if err := common.Unmarshal(responsesRequest.Instructions, &instructions); err == nil && instructions != "" {
+			systemMessage := dto.Message{
+				Role: "system",
+			}
+			systemMessage.SetStringContent(instructions)
+			openAIMessages = append(openAIMessages, systemMessage)
+		}
+	}
+	inputMessages, err := inputResponses2OpenAIMessages(responsesRequest.Input)
+	if err != nil {
+		return nil, err
+	}
+	openAIRequest.Messages = append(openAIMessages, inputMessages...)
+	return openAIRequest, nil
+}
+
+func toolChoiceResponses2OpenAI(toolChoice json.RawMessage) any {
+	if common.GetJsonType(toolChoice) == "string" {
+		var choice string
+		_ = common.Unmarshal(toolChoice, &choice)
+		return choice
+	}
+	var choice struct {
+		Type string `json:"type"`
+		Name string `json:"name"`
+	}
+	if err := common.Unmarshal(toolChoice, &choice); err != nil {
+		return nil
+	}
+	if choice.Type == "function" && choice.Name != "" {
+		return map[string]any{
+			"type": "function",
+			"function": map[string]any{
+				"name": choice.Name,
+			},
+		}
+	}
+	return nil
+}
+
+func inputResponses2OpenAIMessages(input json.RawMessage) ([]dto.Message, error) {
+	messages := make([]dto.Message, 0)
+	switch common.GetJsonType(input) {
+	case "string":
+		var text string
+		if err := common.Unmarshal(input, &text); err != nil {
+			return nil, err
+		}
+		message := dto.Message{
+			Role: "user",
+		}
+		message.SetStringContent(text)
+		return append(messages, message), nil
+	case "array":
+	default:
+		return nil, errors.New("input must be a string or an array")
+	}
+
+	var items []responsesInputItem
+	if err := common.Unmarshal(input, &items); err != nil {
+		return nil, fmt.Errorf("invalid input: %w", err)
+	}
+
+	// 连续的 function_call 合并到同一条 assistant 消息
+	var toolCalls []dto.ToolCallRequest
+	flushToolCalls := func() {
+		if len(toolCalls) == 0 {
+			return
+		}
+		if last := len(messages) - 1; last >= 0 && messages[last].Role == "assistant" && messages[last].ToolCalls == nil {
+			messages[last].SetToolCalls(toolCalls)
+		} else {
+			message := dto.Message{
+				Role: "assistant",
+			}
+			message.SetToolCalls(toolCalls)
+			messages = append(messages, message)
+		}
+		toolCalls = nil
+	}
+
+	for _, item := range items {
+		switch item.Type {
+		case "function_call":
+			toolCalls = append(toolCalls, dto.ToolCallRequest{
+				ID:   item.CallId,
+				Type: "function",
+				Function: dto.FunctionRequest{
+					Name:      item.Name,
+					Arguments: item.Arguments,
+				},
+			})
+			continue
+		case "function_call_output":
+			flushToolCalls()
+			message := dto.Message{
+				Role:       "tool",
+				ToolCallId: item.CallId,
+			}
+			if common.GetJsonType(item.Output) == "string" {
+				var output string
+				_ = common.Unmarshal(item.Output, &output)
+				message.SetStringContent(output)
+			} else {
+				message.SetStringContent(string(item.Output))
+			}
+			messages = append(messages, message)
+		case "message", "":
+			flushToolCalls()
+			message, err := inputMessageResponses2OpenAI(item)
+			if err != nil {
+				return nil, err
+			}
+			messages = append(messages, *message)
+		case "reasoning":
+			// 推理内容不回传给上游
+			continue
+		default:
+			return nil, fmt.Errorf("input item type %s is not supported by this channel", item.Type)
+		}
+	}
+	flushToolCalls()
+	return messages, nil
+}
+
+func inputMessageResponses2OpenAI(item responsesInputItem) (*dto.Message, error) {
+	role := item.Role
+	if role == "developer" {
+		role = "system"
+	}
+	message := &dto.Message{
+		Role: role,
+	}
+	if common.GetJsonType(item.Content) == "string" {
+		var text string
+		_ = common.Unmarshal(item.Content, &text)
+		message.SetStringContent(text)
+		return message, nil
+	}
+
+	var contents []responsesInputContent
+	if err := common.Unmarshal(item.Content, &contents); err != nil {
+		return nil, fmt.Errorf("invalid message content: %w", err)
+	}
+	mediaContents := make([]dto.MediaContent, 0, len(contents))
+	onlyText := true
+	var textBuilder strings.Builder
+	for _, content := range contents {
+		switch content.Type {
+		case "input_text", "output_text", "refusal":
+			text := content.Text
+			if content.Type == "refusal" {
+				text = content.Refusal
+			}
+			textBuilder.WriteString(text)
+			mediaContents = append(mediaContents, dto.MediaContent{
+				Type: dto.ContentTypeText,
+				Text: text,
+			})
+		case "input_image":
+			if content.FileId != "" {
+				return nil, errors.New("input_image with file_id is not supported by this channel")
+			}
+			imageUrl := &dto.MessageImageUrl{
+				Detail: content.Detail,
+			}
+			if common.GetJsonType(content.ImageUrl) == "string" {
+				_ = common.Unmarshal(content.ImageUrl, &imageUrl.Url)
+			} else {
+				_ = common.Unmarshal(content.ImageUrl, imageUrl)
+			}
+			if imageUrl.Detail == "" {
+				imageUrl.Detail = "auto"
+			}
+			onlyText = false
+			mediaContents = append(mediaContents, dto.MediaContent{
+				Type:     dto.ContentTypeImageURL,
+				ImageUrl: imageUrl,
+			})
+		case "input_file":
+			if content.FileUrl != "" {
+				return nil, errors.New("input_file with file_url is not supported by this channel")
+			}
+			onlyText = false
+			mediaContents = append(mediaContents, dto.MediaContent{
+				Type: dto.ContentTypeFile,
+				File: &dto.MessageFile{
+					FileName: content.Filename,
+					FileData: content.FileData,
+					FileId:   content.FileId,
+				},
+			})
+		default:
+			return nil, fmt.Errorf("content type %s is not supported by this channel", content.Type)
+		}
+	}
+	if onlyText {
+		message.SetStringContent(textBuilder.String())
+	} else {
+		message.SetMediaContent(mediaContents)
+	}
+	return message, nil
+}
+
+func finishReasonOpenAI2ResponsesStatus(finishReason string) (string, *dto.IncompleteDetails) {
+	switch finishReason {
+	case "length", "max_tokens":
+		return "incomplete", &dto.IncompleteDetails{Reason: "max_output_tokens"}
+	case "content_filter":
+		return "incomplete", &dto.IncompleteDetails{Reason: "content_filter"}
+	}
+	return "completed", nil
+}
+
+func usageOpenAI2Responses(usage *dto.Usage) *dto.Usage {
+	if usage == nil {
+		return nil
+	}
+	responsesUsage := *usage
+	responsesUsage.InputTokens = usage.PromptTokens
+	responsesUsage.OutputTokens = usage.CompletionTokens
+	responsesUsage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	responsesUsage.InputTokensDetails = &dto.InputTokenDetails{
+		CachedTokens: usage.PromptTokensDetails.CachedTokens,
+	}
+	return &responsesUsage
+}
+
+func ResponseOpenAI2Responses(openAIResponse *dto.OpenAITextResponse, info *relaycommon.RelayInfo) *dto.OpenAIResponsesResponse {
+	responseId := "resp_" + common.GetUUID()
+	output := make([]dto.ResponsesOutput, 0)
+	var finishReason string
+	if len(openAIResponse.Choices) > 0 {
+		choice := openAIResponse.Choices[0]
+		finishReason = choice.FinishReason
+		reasoning := choice.Message.ReasoningContent
+		if reasoning == "" {
+			reasoning = choice.Message.Reasoning
+		}
+		if reasoning != "" {
+			output = append(output, dto.ResponsesOutput{
+				Type: "reasoning",
+				ID:   "rs_" + common.GetUUID(),
+				Summary: []dto.ResponsesOutputContent{
+					{Type: "summary_text", Text: reasoning},
+				},
+			})
+		}
+		if text := choice.Message.StringContent(); text != "" {
+			output = append(output, dto.ResponsesOutput{
+				Type:   "message",
+				ID:     "msg_" + common.GetUUID(),
+				Status: "completed",
+				Role:   "assistant",
+				Content: []dto.ResponsesOutputContent{
+					{Type: "output_text", Text: text, Annotations: []interface{}{}},
+				},
+			})
+		}
+		for _, toolCall := range choice.Message.ParseToolCalls() {
+			output = append(output, dto.ResponsesOutput{
+				Type:      "function_call",
+				ID:        "fc_" + common.GetUUID(),
+				Status:    "completed",
+				CallId:    toolCall.ID,
+				Name:      toolCall.Function.Name,
+				Arguments: toolCall.Function.Arguments,
+			})
+		}
+	}
+	status, incompleteDetails := finishReasonOpenAI2ResponsesStatus(finishReason)
+	return &dto.OpenAIResponsesResponse{
+		ID:                responseId,
+		Object:            "response",
+		CreatedAt:         int(common.GetTimestamp()),
+		Status:            status,
+		IncompleteDetails: incompleteDetails,
+		Model:             info.OriginModelName,
+		Output:            output,
+		Usage:             usageOpenAI2Responses(&openAIResponse.Usage),
+	}
+}
+
+func newResponsesStreamEvent(info *relaycommon.RelayInfo, eventType string) dto.ResponsesStreamResponse {
+	event := dto.ResponsesStreamResponse{
+		Type:           eventType,
+		SequenceNumber: info.ResponsesConvertInfo.SequenceNumber,
+	}
+	info.ResponsesConvertInfo.SequenceNumber++
+	return event
+}
+
+func buildStreamResponsesResponse(info *relaycommon.RelayInfo, status string) *dto.OpenAIResponsesResponse {
+	convertInfo := info.ResponsesConvertInfo
+	output := convertInfo.Output
+	if output == nil {
+		output = make([]dto.ResponsesOutput, 0)
+	}
+	return &dto.OpenAIResponsesResponse{
+		ID:        convertInfo.ResponseId,
+		Object:    "response",
+		CreatedAt: int(convertInfo.CreatedAt),
+		Status:    status,
+		Model:     convertInfo.Model,
+		Output:    output,
+	}
+}
+
+func startResponsesStream(info *relaycommon.RelayInfo) []dto.ResponsesStreamResponse {
+	convertInfo := info.ResponsesConvertInfo
+	if convertInfo.Started {
+		return nil
+	}
+	convertInfo.Started = true
+	if convertInfo.ResponseId == "" {
+		convertInfo.ResponseId = "resp_" + common.GetUUID()
+	}
+	if convertInfo.CreatedAt == 0 {
+		convertInfo.CreatedAt = common.GetTimestamp()
+	}
+	created := newResponsesStreamEvent(info, "response.created")
+	created.Response = buildStreamResponsesResponse(info, "in_progress")
+	inProgress := newResponsesStreamEvent(info, "response.in_progress")
+	inProgress.Response = buildStreamResponsesResponse(info, "in_progress")
+	return []dto.ResponsesStreamResponse{created, inProgress}
+}
+
+// openResponsesOutputItem 结束当前输出项并开始新的输出项
+func openResponsesOutputItem(info *relaycommon.RelayInfo, item *dto.ResponsesOutput) []dto.ResponsesStreamResponse {
+	convertInfo := info.ResponsesConvertInfo
+	events := closeResponsesOutputItem(info)
+	outputIndex := len(convertInfo.Output)
+	convertInfo.CurrentItem = item
+	convertInfo.CurrentText.Reset()
+
+	added := newResponsesStreamEvent(info, dto.ResponsesOutputTypeItemAdded)
+	added.OutputIndex = common.GetPointer[int](outputIndex)
+	addedItem := *item
+	added.Item = &addedItem
+	events = append(events, added)
+
+	switch item.Type {
+	case "message":
+		partAdded := newResponsesStreamEvent(info, "response.content_part.added")
+		partAdded.ItemId = item.ID
+		partAdded.OutputIndex = common.GetPointer[int](outputIndex)
+		partAdded.ContentIndex = common.GetPointer[int](0)
+		partAdded.Part = &dto.ResponsesOutputContent{Type: "output_text", Annotations: []interface{}{}}
+		events = append(events, partAdded)
+	case "reasoning":
+		partAdded := newResponsesStreamEvent(info, "response.reasoning_summary_part.added")
+		partAdded.ItemId = item.ID
+		partAdded.OutputIndex = common.GetPointer[int](outputIndex)
+		partAdded.SummaryIndex = common.GetPointer[int](0)
+		partAdded.Part = &dto.ResponsesOutputContent{Type: "summary_text"}
+		events = append(events, partAdded)
+	}
+	return events
+}
+
+func closeResponsesOutputItem(info *relaycommon.RelayInfo) []dto.ResponsesStreamResponse {
+	convertInfo := info.ResponsesConvertInfo
+	item := convertInfo.CurrentItem
+	if item == nil {
+		return nil
+	}
+	var events []dto.ResponsesStreamResponse
+	outputIndex := len(convertInfo.Output)
+	text := convertInfo.CurrentText.String()
+	switch item.Type {
+	case "message":
+		part := dto.ResponsesOutputContent{Type: "output_text", Text: text, Annotations: []interface{}{}}
+		textDone := newResponsesStreamEvent(info, "response.output_text.done")
+		textDone.ItemId = item.ID
+		textDone.OutputIndex = common.GetPointer[int](outputIndex)
+		textDone.ContentIndex = common.GetPointer[int](0)
+		textDone.Text = text
+		partDone := newResponsesStreamEvent(info, "response.content_part.done")
+		partDone.ItemId = item.ID
+		partDone.OutputIndex = common.GetPointer[int](outputIndex)
+		partDone.ContentIndex = common.GetPointer[int](0)
+		partDone.Part = &part
+		events = append(events, textDone, partDone)
+		item.Content = []dto.ResponsesOutputContent{part}
+	case "reasoning":
+		part := dto.ResponsesOutputContent{Type: "summary_text", Text: text}
+		textDone := newResponsesStreamEvent(info, "response.reasoning_summary_text.done")
+		textDone.ItemId = item.ID
+		textDone.OutputIndex = common.GetPointer[int](outputIndex)
+		textDone.SummaryIndex = common.GetPointer[int](0)
+		textDone.Text = text
+		partDone := newResponsesStreamEvent(info, "response.reasoning_summary_part.done")
+		partDone.ItemId = item.ID
+		partDone.OutputIndex = common.GetPointer[int](outputIndex)
+		partDone.SummaryIndex = common.GetPointer[int](0)
+		partDone.Part = &part
+		events = append(events, textDone, partDone)
+		item.Summary = []dto.ResponsesOutputContent{part}
+	case "function_call":
+		argumentsDone := newResponsesStreamEvent(info, "response.function_call_arguments.done")
+		argumentsDone.ItemId = item.ID
+		argumentsDone.OutputIndex = common.GetPointer[int](outputIndex)
+		argumentsDone.Arguments = text
+		events = append(events, argumentsDone)
+		item.Arguments = text
+	}
+	if item.Type != "reasoning" {
+		item.Status = "completed"
+	}
+	itemDone := newResponsesStreamEvent(info, dto.ResponsesOutputTypeItemDone)
+	itemDone.OutputIndex = common.GetPointer[int](outputIndex)
+	itemDone.Item = item
+	events = append(events, itemDone)
+
+	convertInfo.Output = append(convertInfo.Output, *item)
+	convertInfo.CurrentItem = nil
+	convertInfo.CurrentText.Reset()
+	return events
+}
+
+func appendResponsesDelta(info *relaycommon.RelayInfo, eventType string, delta string) dto.ResponsesStreamResponse {
+	convertInfo := info.ResponsesConvertInfo
+	convertInfo.CurrentText.WriteString(delta)
+	event := newResponsesStreamEvent(info, eventType)
+	event.ItemId = convertInfo.CurrentItem.ID
+	event.OutputIndex = common.GetPointer[int](len(convertInfo.Output))
+	switch eventType {
+	case "response.output_text.delta":
+		event.ContentIndex = common.GetPointer[int](0)
+	case "response.reasoning_summary_text.delta":
+		event.SummaryIndex = common.GetPointer[int](0)
+	}
+	event.Delta = delta
+	return event
+}
+
+// StreamResponseOpenAI2Responses 将 chat completions 流式响应转换为 Responses 事件
+func StreamResponseOpenAI2Responses(openAIResponse *dto.ChatCompletionsStreamResponse, info *relaycommon.RelayInfo) []dto.ResponsesStreamResponse {
+	convertInfo := info.ResponsesConvertInfo
+	if convertInfo.Model == "" {
+		convertInfo.Model = openAIResponse.Model
+	}
+	events := startResponsesStream(info)
+	if ValidUsage(openAIResponse.Usage) {
+		convertInfo.Usage = openAIResponse.Usage
+	}
+	if len(openAIResponse.Choices) == 0 {
+		return events
+	}
+
+	choice := openAIResponse.Choices[0]
+	currentType := ""
+	if convertInfo.CurrentItem != nil {
+		currentType = convertInfo.CurrentItem.Type
+	}
+	if reasoning := choice.Delta.GetReasoningContent(); reasoning != "" {
+		if currentType != "reasoning" {
+			events = append(events, openResponsesOutputItem(info, &dto.ResponsesOutput{
+				Type:    "reasoning",
+				ID:      "rs_" + common.GetUUID(),
+				Summary: []dto.ResponsesOutputContent{},
+			})...)
+			currentType = "reasoning"
+		}
+		events = append(events, appendResponsesDelta(info, "response.reasoning_summary_text.delta", reasoning))
+	}
+	if text := choice.Delta.GetContentString(); text != "" {
+		if currentType != "message" {
+			events = append(events, openResponsesOutputItem(info, &dto.ResponsesOutput{
+				Type:    "message",
+				ID:      "msg_" + common.GetUUID(),
+				Status:  "in_progress",
+				Role:    "assistant",
+				Content: []dto.ResponsesOutputContent{},
+			})...)
+			currentType = "message"
+		}
+		events = append(events, appendResponsesDelta(info, "response.output_text.delta", text))
+	}
+	for _, toolCall := range choice.Delta.ToolCalls {
+		toolIndex := 0
+		if toolCall.Index != nil {
+			toolIndex = *toolCall.Index
+		}
+		// 新的工具调用：类型切换、index 变化或者出现新的 id
+		if currentType != "function_call" || toolIndex != convertInfo.CurrentToolIndex ||
+			(toolCall.ID != "" && toolCall.ID != convertInfo.CurrentItem.CallId) {
+			events = append(events, openResponsesOutputItem(info, &dto.ResponsesOutput{
+				Type:   "function_call",
+				ID:     "fc_" + common.GetUUID(),
+				Status: "in_progress",
+				CallId: toolCall.ID,
+				Name:   toolCall.Function.Name,
+			})...)
+			convertInfo.CurrentToolIndex = toolIndex
+			currentType = "function_call"
+		}
+		if toolCall.Function.Arguments != "" {
+			events = append(events, appendResponsesDelta(info, "response.function_call_arguments.delta", toolCall.Function.Arguments))
+		}
+	}
+	if choice.FinishReason != nil && *choice.FinishReason != "" {
+		convertInfo.FinishReason = *choice.FinishReason
+	}
+	return events
+}
+
+// FinishResponsesStream 结束所有输出项并生成 response.completed 事件
+func FinishResponsesStream(info *relaycommon.RelayInfo, usage *dto.Usage) []dto.ResponsesStreamResponse {
+	events := startResponsesStream(info)
+	events = append(events, closeResponsesOutputItem(info)...)
+	status, incompleteDetails := finishReasonOpenAI2ResponsesStatus(info.ResponsesConvertInfo.FinishReason)
+	response := buildStreamResponsesResponse(info, status)
+	response.IncompleteDetails = incompleteDetails
+	response.Usage = usageOpenAI2Responses(usage)
+	eventType := "response.completed"
+	if status == "incomplete" {
+		eventType = "response.incomplete"
+	}
+	completed := newResponsesStreamEvent(info, eventType)
+	completed.Response = response
+	return append(events, completed)
+}
+
+// FailResponsesStream 流式输出过程中出错时生成 response.failed 事件
+func FailResponsesStream(info *relaycommon.RelayInfo, openAIError types.OpenAIError) []dto.ResponsesStreamResponse {
+	events := startResponsesStream(info)
+	events = append(events, closeResponsesOutputItem(info)...)
+	response := buildStreamResponsesResponse(info, "failed")
+	response.Error = map[string]any{
+		"code":    openAIError.Code,
+		"message": openAIError.Message,
+	}
+	failed := newResponsesStreamEvent(info, "response.failed")
+	failed.Response = response
+	return append(events, failed)
+}
@@ -0,0 +1,393 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+)
+
+const fileIdPrefix = "file-"
+
+// CreateFile 保存文件内容并写入文件记录
+func CreateFile(userId int, tokenId int, filename string, purpose string, contentType string, reader io.Reader, expiresAfterSeconds int64) (*model.File, error) {
+	fileId := fileIdPrefix + common.GetUUID()[:24]
+	storageKey := fmt.Sprintf("%d/%s", userId, fileId)
+
+	limit := int64(constant.FileMaxUploadMB) << 20
+	size, err := GetFileStorage().Save(storageKey, io.LimitReader(reader, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to save file: %w", err)
+	}
+	if size > limit {
+		_ = GetFileStorage().Delete(storageKey)
+		return nil, fmt.Errorf("file is too large, max size is %d MB", constant.FileMaxUploadMB)
+	}
+
+	file := &model.File{
+		FileId:      fileId,
+		UserId:      userId,
+		TokenId:     tokenId,
+		Filename:    filename,
+		Purpose:     purpose,
+		Bytes:       size,
+		ContentType: contentType,
+		Status:      model.FileStatusProcessed,
+		StorageKey:  storageKey,
+		CreatedAt:   common.GetTimestamp(),
+	}
+	if expiresAfterSeconds > 0 {
+		file.ExpiresAt = file.CreatedAt + expiresAfterSeconds
+	}
+	if err := file.Insert(); err != nil {
+		_ = GetFileStorage().Delete(storageKey)
+		return nil, err
+	}
+	return file, nil
+}
+
+// DeleteFile 删除文件记录及其内容
+func DeleteFile(file *model.File) error {
+	if err := file.Delete(); err != nil {
+		return err
+	}
+	if err := GetFileStorage().Delete(file.StorageKey); err != nil {
+		common.SysError(fmt.Sprintf("failed to delete file content %s: %s", file.FileId, err.Error()))
+	}
+	if len(file.UpstreamFiles) > 0 {
+		upstreamFiles := file.UpstreamFiles
+		gopool.Go(func() {
+			deleteUpstreamFiles(file.FileId, upstreamFiles)
+		})
+	}
+	return nil
+}
+
+// deleteUpstreamFiles 删除文件转发到各个上游渠道时产生的副本，失败只记录日志
+func deleteUpstreamFiles(fileId string, upstreamFiles model.FileUpstreamMap) {
+	for channelId, upstreamFileId := range upstreamFiles {
+		channel, err := model.GetChannelById(channelId, true)
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to delete upstream copy of file %s: channel #%d not found: %s", fileId, channelId, err.Error()))
+			continue
+		}
+		if err := deleteUpstreamFile(channel, upstreamFileId); err != nil {
+			common.SysError(fmt.Sprintf("failed to delete upstream file %s of file %s on channel #%d: %s", upstreamFileId, fileId, channelId, err.Error()))
+		}
+	}
+}
+
+// deleteUpstreamFile 调用上游 DELETE /v1/files/{id}。多 Key 渠道不记录上传时使用的 Key，依次尝试直到某个 Key 删除成功
+func deleteUpstreamFile(channel *model.Channel, upstreamFileId string) error {
+	baseURL := strings.TrimSuffix(channel.GetBaseURL(), "/")
+	if baseURL == "" && channel.Type < len(constant.ChannelBaseURLs) {
+		baseURL = strings.TrimSuffix(constant.ChannelBaseURLs[channel.Type], "/")
+	}
+	var fullURL string
+	if channel.Type == constant.ChannelTypeAzure {
+		apiVersion := channel.Other
+		if apiVersion == "" {
+			apiVersion = constant.AzureDefaultAPIVersion
+		}
+		fullURL = fmt.Sprintf("%s/openai/files/%s?api-version=%s", baseURL, upstreamFileId, apiVersion)
+	} else {
+		fullURL = fmt.Sprintf("%s/v1/files/%s", baseURL, upstreamFileId)
+	}
+
+	client := GetHttpClient()
+	if proxy := channel.GetSetting().Proxy; proxy != "" {
+		var err error
+		client, err = NewProxyHttpClient(proxy)
+		if err != nil {
+			return err
+		}
+	}
+	var lastErr error
+	for _, key := range channel.GetKeys() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		req, err := http.NewRequestWithContext(ctx, http.MethodDelete, fullURL, nil)
+		if err != nil {
+			cancel()
+			return err
+		}
+		if channel.Type == constant.ChannelTypeAzure {
+			req.Header.Set("api-key", key)
+		} else {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		if channel.OpenAIOrganization != nil && *channel.OpenAIOrganization != "" {
+			req.Header.Set("OpenAI-Organization", *channel.OpenAIOrganization)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			cancel()
+			lastErr = err
+			continue
+		}
+		respBody, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		cancel()
+		if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNoContent {
+			return nil
+		}
+		lastErr = fmt.Errorf("upstream returned status %d: %s", resp.StatusCode, string(respBody))
+	}
+	return lastErr
+}
+
+func OpenFileContent(file *model.File) (io.ReadCloser, error) {
+	return GetFileStorage().Open(file.StorageKey)
+}
+
+func FileToOpenAIFile(file *model.File) dto.OpenAIFile {
+	openAIFile := dto.OpenAIFile{
+		Id:        file.FileId,
+		Object:    "file",
+		Bytes:     file.Bytes,
+		CreatedAt: file.CreatedAt,
+		Filename:  file.Filename,
+		Purpose:   file.Purpose,
+		Status:    file.Status,
+	}
+	if file.ExpiresAt > 0 {
+		expiresAt := file.ExpiresAt
+		openAIFile.ExpiresAt = &expiresAt
+	}
+	return openAIFile
+}
+
+// AutomaticallyCleanupExpiredFiles 定期清理设置了 expires_after 且已过期的文件
+func AutomaticallyCleanupExpiredFiles() {
+	for {
+		CleanupExpiredFiles()
+		time.Sleep(time.Hour)
+	}
+}
+
+// CleanupExpiredFiles 删除已过期的文件
+func CleanupExpiredFiles() {
+	for {
+		files, err := model.GetExpiredFiles(common.GetTimestamp(), 100)
+		if err != nil {
+			common.SysError("failed to query expired files: " + err.Error())
+			return
+		}
+		for _, file := range files {
+			if err := DeleteFile(file); err != nil {
+				common.SysError(fmt.Sprintf("failed to delete expired file %s: %s", file.FileId, err.Error()))
+				return
+			}
+		}
+		if len(files) < 100 {
+			return
+		}
+	}
+}
+
+// ForwardFilesToChannel 将请求体中引用的网关文件上传到当前选中的上游渠道，并把 file_id 替换为上游 file id。
+// 同一文件在同一渠道只会上传一次，上游 file id 记录在文件的 UpstreamFiles 中。
+func ForwardFilesToChannel(c *gin.Context, info *relaycommon.RelayInfo, jsonData []byte) ([]byte, error) {
+	if !bytes.Contains(jsonData, []byte(`"file_id"`)) {
+		return jsonData, nil
+	}
+	var data any
+	if err := common.Unmarshal(jsonData, &data); err != nil {
+		return jsonData, nil
+	}
+	fileIds := make([]string, 0)
+	collectFileIds(data, &fileIds)
+	if len(fileIds) == 0 {
+		return jsonData, nil
+	}
+	files, err := model.GetUserFilesByFileIds(info.UserId, fileIds)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		// 引用的不是网关文件，原样透传
+		return jsonData, nil
+	}
+	if info.ApiType != constant.APITypeOpenAI {
+		return nil, fmt.Errorf("channel type %d does not support file references", info.ChannelType)
+	}
+
+	replacements := make(map[string]string, len(files))
+	for _, file := range files {
+		if file.IsExpired() {
+			return nil, fmt.Errorf("file %s has expired", file.FileId)
+		}
+		upstreamFileId, ok := file.GetUpstreamFileId(info.ChannelId)
+		if !ok {
+			upstreamFileId, err = uploadFileToChannel(c.Request.Context(), info, file)
+			if err != nil {
+				return nil, fmt.Errorf("failed to forward file %s to channel #%d: %w", file.FileId, info.ChannelId, err)
+			}
+			if err := file.SetUpstreamFileId(info.ChannelId, upstreamFileId); err != nil {
+				logger.LogWarn(c, fmt.Sprintf("failed to save upstream file id of %s: %s", file.FileId, err.Error()))
+			}
+			logger.LogInfo(c, fmt.Sprintf("file %s forwarded to channel #%d as %s", file.FileId, info.ChannelId, upstreamFileId))
+		}
+		replacements[file.FileId] = upstreamFileId
+	}
+	data = replaceFileIds(data, replacements)
+	return common.Marshal(data)
+}
+
+func collectFileIds(data any, fileIds *[]string) {
+	switch v := data.(type) {
+	case map[string]any:
+		for key, value := range v {
+			if key == "file_id" {
+				if id, ok := value.(string); ok && strings.HasPrefix(id, fileIdPrefix) {
+					*fileIds = append(*fileIds, id)
+				}
+				continue
+			}
+			collectFileIds(value, fileIds)
+		}
+	case []any:
+		for _, item := range v {
+			collectFileIds(item, fileIds)
+		}
+	}
+}
+
+func replaceFileIds(data any, replacements map[string]string) any {
+	switch v := data.(type) {
+	case map[string]any:
+		for key, value := range v {
+			if key == "file_id" {
+				if id, ok := value.(string); ok {
+					if upstreamId, ok := replacements[id]; ok {
+						v[key] = upstreamId
+					}
+				}
+				continue
+			}
+			v[key] = replaceFileIds(value, replacements)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = replaceFileIds(item, replacements)
+		}
+	}
+	return data
+}
+
+// upstreamFilePurpose 上游不接受 batch_output 等只读用途，统一转换为 user_data
+func upstreamFilePurpose(purpose string) string {
+	switch purpose {
+	case model.FilePurposeAssistants, model.FilePurposeBatch, model.FilePurposeFineTune,
+		model.FilePurposeVision, model.FilePurposeUserData, model.FilePurposeEvals:
+		return purpose
+	default:
+		return model.FilePurposeUserData
+	}
+}
+
+// uploadFileToChannel 以流的方式把文件上传到上游渠道，不把整个文件读入内存，超过 FileForwardTimeout 时取消上传
+func uploadFileToChannel(ctx context.Context, info *relaycommon.RelayInfo, file *model.File) (string, error) {
+	content, err := OpenFileContent(file)
+	if err != nil {
+		return "", err
+	}
+
+	if constant.FileForwardTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(constant.FileForwardTimeout)*time.Second)
+		defer cancel()
+	}
+
+	body, bodyWriter := io.Pipe()
+	writer := multipart.NewWriter(bodyWriter)
+	go func() {
+		defer content.Close()
+		err := writer.WriteField("purpose", upstreamFilePurpose(file.Purpose))
+		if err == nil {
+			var part io.Writer
+			part, err = writer.CreateFormFile("file", file.Filename)
+			if err == nil {
+				_, err = io.Copy(part, content)
+			}
+		}
+		if err == nil {
+			err = writer.Close()
+		}
+		// 请求失败或被取消时读取端已关闭，写入返回错误后退出
+		bodyWriter.CloseWithError(err)
+	}()
+	defer body.Close()
+
+	baseURL := info.ChannelBaseUrl
+	if baseURL == "" && info.ChannelType < len(constant.ChannelBaseURLs) {
+		baseURL = constant.ChannelBaseURLs[info.ChannelType]
+	}
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	var fullURL string
+	if info.ChannelType == constant.ChannelTypeAzure {
+		apiVersion := info.ApiVersion
+		if apiVersion == "" {
+			apiVersion = constant.AzureDefaultAPIVersion
+		}
+		fullURL = fmt.Sprintf("%s/openai/files?api-version=%s", baseURL, apiVersion)
+	} else {
+		fullURL = fmt.Sprintf("%s/v1/files", baseURL)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fullURL, body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	if info.ChannelType == constant.ChannelTypeAzure {
+		req.Header.Set("api-key", info.ApiKey)
+	} else {
+		req.Header.Set("Authorization", "Bearer "+info.ApiKey)
+	}
+	if info.Organization != "" {
+		req.Header.Set("OpenAI-Organization", info.Organization)
+	}
+
+	client := GetHttpClient()
+	if info.ChannelSetting.Proxy != "" {
+		client, err = NewProxyHttpClient(info.ChannelSetting.Proxy)
+		if err != nil {
+			return "", err
+		}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("upstream returned status %d: %s", resp.StatusCode, string(respBody))
+	}
+	var uploaded dto.OpenAIFile
+	if err := common.Unmarshal(respBody, &uploaded); err != nil {
+		return "", err
+	}
+	if uploaded.Id == "" {
+		return "", errors.New("upstream returned empty file id")
+	}
+	return uploaded.Id, nil
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/QuantumNous/new-api/constant"
+)
+
+// FileStorage 文件内容存储，数据库只保存元信息，便于后续替换为对象存储等实现
+type FileStorage interface {
+	// Save 写入内容并返回写入的字节数
+	Save(key string, reader io.Reader) (int64, error)
+	Open(key string) (io.ReadCloser, error)
+	Delete(key string) error
+}
+
+// LocalFileStorage 基于本地磁盘的文件存储
+type LocalFileStorage struct {
+	Root string
+}
+
+func NewLocalFileStorage(root string) *LocalFileStorage {
+	return &LocalFileStorage{Root: root}
+}
+
+func (s *LocalFileStorage) path(key string) (string, error) {
+	cleanKey := filepath.Clean("/" + key)
+	if cleanKey == "/" || strings.Contains(key, "..") {
+		return "", fmt.Errorf("invalid storage key: %s", key)
+	}
+	return filepath.Join(s.Root, cleanKey), nil
+}
+
+func (s *LocalFileStorage) Save(key string, reader io.Reader) (int64, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return 0, err
+	}
+	// 先写临时文件再重命名，避免读到写了一半的内容
+	tmp := p + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, reader)
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return 0, err
+	}
+	if err := os.Rename(tmp, p); err != nil {
+		_ = os.Remove(tmp)
+		return 0, err
+	}
+	return n, nil
+}
+
+func (s *LocalFileStorage) Open(key string) (io.ReadCloser, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(p)
+}
+
+func (s *LocalFileStorage) Delete(key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+var (
+	fileStorage     FileStorage
+	fileStorageOnce sync.Once
+)
+
+// GetFileStorage 返回全局文件存储，默认使用 FILE_STORAGE_PATH 指定的本地目录
+func GetFileStorage() FileStorage {
+	fileStorageOnce.Do(func() {
+		if fileStorage == nil {
+			fileStorage = NewLocalFileStorage(constant.FileStoragePath)
+		}
+	})
+	return fileStorage
+}
+
+// SetFileStorage 替换全局文件存储实现，需在服务启动前调用
+func SetFileStorage(storage FileStorage) {
+	fileStorage = storage
+}
@@ -0,0 +1,43 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+)
+
+func TestDeleteUpstreamFileTriesEachKey(t *testing.T) {
+	InitHttpClient()
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.Method != http.MethodDelete || r.URL.Path != "/v1/files/file-upstream" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		// 文件只存在于上传时使用的第二个 Key 所在的账号下
+		if r.Header.Get("Authorization") != "Bearer sk-second" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	baseURL := server.URL
+	channel := &model.Channel{Id: 1, Type: constant.ChannelTypeOpenAI, Key: "sk-first\nsk-second", BaseURL: &baseURL}
+	if err := deleteUpstreamFile(channel, "file-upstream"); err != nil {
+		t.Fatal(err)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Fatalf("upstream called %d times, want 2", got)
+	}
+
+	channel.Key = "sk-first"
+	if err := deleteUpstreamFile(channel, "file-upstream"); err == nil {
+		t.Fatal("expected an error when no key can delete the file")
+	}
+}
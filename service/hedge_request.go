@@ -0,0 +1,105 @@
+package service
+
+import (
+	"context"
+	"sync"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 对冲请求：同一个请求同时发往两个渠道，先向客户端写出数据（或先进入计费）的尝试胜出，
+// 其余尝试的上游请求被取消，写出的内容被丢弃，也不会计费。
+
+// HedgeRace 一次对冲请求中各尝试的竞争状态
+type HedgeRace struct {
+	mu       sync.Mutex
+	winner   *HedgeAttempt
+	attempts []*HedgeAttempt
+	claimed  chan struct{}
+}
+
+// HedgeAttempt 对冲请求中的一次尝试
+type HedgeAttempt struct {
+	race   *HedgeRace
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewHedgeRace() *HedgeRace {
+	return &HedgeRace{claimed: make(chan struct{})}
+}
+
+// NewAttempt 加入一次尝试，已经决出胜者时返回 nil
+func (r *HedgeRace) NewAttempt(parent context.Context) *HedgeAttempt {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.winner != nil {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(parent)
+	attempt := &HedgeAttempt{race: r, ctx: ctx, cancel: cancel}
+	r.attempts = append(r.attempts, attempt)
+	return attempt
+}
+
+// Claimed 决出胜者后关闭
+func (r *HedgeRace) Claimed() <-chan struct{} {
+	return r.claimed
+}
+
+// Claim 尚未决出胜者时由当前尝试胜出并取消其他尝试，返回当前尝试是否为胜者
+func (a *HedgeAttempt) Claim() bool {
+	r := a.race
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.winner != nil {
+		return r.winner == a
+	}
+	r.winner = a
+	close(r.claimed)
+	for _, attempt := range r.attempts {
+		if attempt != a {
+			attempt.cancel()
+		}
+	}
+	return true
+}
+
+// Won 当前尝试是否已经胜出
+func (a *HedgeAttempt) Won() bool {
+	a.race.mu.Lock()
+	defer a.race.mu.Unlock()
+	return a.race.winner == a
+}
+
+// Lost 是否已有其他尝试胜出
+func (a *HedgeAttempt) Lost() bool {
+	a.race.mu.Lock()
+	defer a.race.mu.Unlock()
+	return a.race.winner != nil && a.race.winner != a
+}
+
+// Context 尝试的上游请求使用的 context，落败时被取消
+func (a *HedgeAttempt) Context() context.Context {
+	return a.ctx
+}
+
+// Cancel 释放 context 的资源，尝试结束后调用
+func (a *HedgeAttempt) Cancel() {
+	a.cancel()
+}
+
+func GetHedgeAttempt(c *gin.Context) *HedgeAttempt {
+	attempt, _ := common.GetContextKeyType[*HedgeAttempt](c, constant.ContextKeyHedgeAttempt)
+	return attempt
+}
+
+// IsHedgeLoser 计费前调用，对冲请求中已有其他尝试胜出时返回 true，此时不应计费；
+// 尚未决出胜者时由当前尝试胜出
+func IsHedgeLoser(c *gin.Context) bool {
+	attempt := GetHedgeAttempt(c)
+	return attempt != nil && !attempt.Claim()
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"context"
+	"testing"
+)
+
+func TestHedgeRaceFirstClaimWins(t *testing.T) {
+	race := NewHedgeRace()
+	primary := race.NewAttempt(context.Background())
+	hedge := race.NewAttempt(context.Background())
+
+	if !hedge.Claim() {
+		t.Fatal("first claim should win")
+	}
+	select {
+	case <-race.Claimed():
+	default:
+		t.Fatal("claimed channel should be closed after a claim")
+	}
+	if primary.Claim() {
+		t.Fatal("second claim should lose")
+	}
+	if !hedge.Claim() || !hedge.Won() || hedge.Lost() {
+		t.Fatal("winner should stay the winner")
+	}
+	if !primary.Lost() || primary.Won() {
+		t.Fatal("other attempt should be marked as lost")
+	}
+	if primary.Context().Err() == nil {
+		t.Fatal("losing attempt should be cancelled")
+	}
+	if hedge.Context().Err() != nil {
+		t.Fatal("winning attempt should not be cancelled")
+	}
+	if race.NewAttempt(context.Background()) != nil {
+		t.Fatal("no attempt should join after the race is decided")
+	}
+}
+
+func TestHedgeAttemptFollowsParentContext(t *testing.T) {
+	parent, cancel := context.WithCancel(context.Background())
+	attempt := NewHedgeRace().NewAttempt(parent)
+	// 客户端断开时所有尝试一起取消
+	cancel()
+	if attempt.Context().Err() == nil {
+		t.Fatal("attempt should be cancelled with the client request")
+	}
+	if attempt.Lost() {
+		t.Fatal("cancelled attempt without a winner should not be marked as lost")
+	}
+}
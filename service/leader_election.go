@@ -0,0 +1,75 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 多节点部署时，后台任务通过 Redis 锁选出一个节点执行，持有锁的节点定期续期，
+// 节点退出或续期失败后锁过期，由其他节点接替。未启用 Redis 时只有主节点执行。
+
+var leaderAcquireScript = redis.NewScript(`
+local current = redis.call("GET", KEYS[1])
+if current == ARGV[1] then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+	return 1
+end
+if current == false then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+	return 1
+end
+return 0
+`)
+
+var (
+	leaderNodeId     string
+	leaderNodeIdOnce sync.Once
+)
+
+func getLeaderNodeId() string {
+	leaderNodeIdOnce.Do(func() {
+		hostname, _ := os.Hostname()
+		leaderNodeId = fmt.Sprintf("%s-%s", hostname, common.GetRandomString(8))
+	})
+	return leaderNodeId
+}
+
+type LeaderElector struct {
+	key    string
+	ttl    time.Duration
+	leader bool
+}
+
+// NewLeaderElector 创建名为 name 的选举，ttl 为锁的有效期，需要在有效期内调用 IsLeader 续期
+func NewLeaderElector(name string, ttl time.Duration) *LeaderElector {
+	return &LeaderElector{key: "leader:" + name, ttl: ttl}
+}
+
+// IsLeader 尝试获取或续期锁，返回当前节点是否为执行节点
+func (e *LeaderElector) IsLeader() bool {
+	if !common.RedisEnabled {
+		return common.IsMasterNode
+	}
+	result, err := leaderAcquireScript.Run(context.Background(), common.RDB, []string{e.key}, getLeaderNodeId(), e.ttl.Milliseconds()).Int()
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to acquire leader lock %s: %s", e.key, err.Error()))
+		result = 0
+	}
+	leader := result == 1
+	if leader != e.leader {
+		e.leader = leader
+		if leader {
+			common.SysLog(fmt.Sprintf("node %s became leader of %s", getLeaderNodeId(), e.key))
+		} else {
+			common.SysLog(fmt.Sprintf("node %s is no longer leader of %s", getLeaderNodeId(), e.key))
+		}
+	}
+	return leader
+}
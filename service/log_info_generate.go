@@ -49,6 +49,20 @@ func GenerateTextOtherInfo(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, m
 		other["is_model_mapped"] = true
 		other["upstream_model_name"] = relayInfo.UpstreamModelName
 	}
+	if fallbackFrom := common.GetContextKeyString(ctx, constant.ContextKeyModelFallbackFrom); fallbackFrom != "" {
+		other["is_model_fallback"] = true
+		other["fallback_from_model"] = fallbackFrom
+	}
+	if relayInfo.StreamInterruption != "" {
+		other["stream_interruption"] = relayInfo.StreamInterruption
+	}
+
+	if batchId := common.GetContextKeyString(ctx, constant.ContextKeyBatchId); batchId != "" {
+		other["batch_id"] = batchId
+		if discount, ok := common.GetContextKeyType[float64](ctx, constant.ContextKeyBatchDiscountRatio); ok {
+			other["batch_discount_ratio"] = discount
+		}
+	}
 
 	isSystemPromptOverwritten := common.GetContextKeyBool(ctx, constant.ContextKeySystemPromptOverride)
 	if isSystemPromptOverwritten {
@@ -0,0 +1,109 @@
+package service
+
+import (
+	"crypto/hmac"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/setting/system_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 网关代理的任务结果地址（视频内容、Midjourney 图片、结果副本）默认需要令牌鉴权且只能访问自己的任务，
+// 返回给用户的地址附带 expires 和 signature 参数，签名有效期内无需鉴权即可访问，便于在浏览器中打开或分享。
+
+const (
+	MediaResourceVideo           = "video"
+	MediaResourceMidjourneyImage = "mj_image"
+	MediaResourceArtifact        = "artifact"
+)
+
+func mediaSignature(resource string, id string, expires int64) string {
+	return common.GenerateHMAC(fmt.Sprintf("media:%s:%s:%d", resource, id, expires))
+}
+
+// SignMediaResource 生成资源的签名参数，ttl 为有效期（秒），返回签名参数和过期时间
+func SignMediaResource(resource string, id string, ttl int) (url.Values, int64) {
+	expires := common.GetTimestamp() + int64(ttl)
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires, 10))
+	query.Set("signature", mediaSignature(resource, id, expires))
+	return query, expires
+}
+
+// VerifyMediaSignature 校验签名参数，过期或签名不匹配时返回 false
+func VerifyMediaSignature(resource string, id string, expires string, signature string) bool {
+	if expires == "" || signature == "" {
+		return false
+	}
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || expiresAt < common.GetTimestamp() {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(mediaSignature(resource, id, expiresAt)))
+}
+
+// CanAccessMedia 判断当前请求能否访问 userId 的任务结果，通过签名地址访问或未开启归属校验时不限制
+func CanAccessMedia(c *gin.Context, userId int) bool {
+	if !operation_setting.GetMediaAccessSetting().VerifyOwnership || common.GetContextKeyBool(c, constant.ContextKeyMediaSignedAccess) {
+		return true
+	}
+	return c.GetInt("id") == userId
+}
+
+// CanAccessArtifact 结果副本未过期且当前请求可以访问其所有者的媒体资源
+func CanAccessArtifact(c *gin.Context, artifact *model.TaskArtifact) bool {
+	return artifact != nil && !artifact.IsExpired() && CanAccessMedia(c, artifact.UserId)
+}
+
+func mediaUrl(path string, resource string, id string) string {
+	mediaUrl := system_setting.ServerAddress + path
+	setting := operation_setting.GetMediaAccessSetting()
+	if !setting.VerifyOwnership || !setting.SignedUrlEnabled {
+		return mediaUrl
+	}
+	query, _ := SignMediaResource(resource, id, setting.SignedUrlTTL)
+	return mediaUrl + "?" + query.Encode()
+}
+
+// VideoContentUrl 视频任务内容的网关地址，开启签名时附带默认有效期的签名
+func VideoContentUrl(taskId string) string {
+	return mediaUrl("/v1/videos/"+taskId+"/content", MediaResourceVideo, taskId)
+}
+
+// MidjourneyImageUrl Midjourney 图片的网关地址，开启签名时附带默认有效期的签名
+func MidjourneyImageUrl(mjId string) string {
+	return mediaUrl("/mj/image/"+mjId, MediaResourceMidjourneyImage, mjId)
+}
+
+// ArtifactContentUrl 结果副本的网关地址，开启签名时附带默认有效期的签名
+func ArtifactContentUrl(artifactId string) string {
+	return mediaUrl("/v1/artifacts/"+artifactId, MediaResourceArtifact, artifactId)
+}
+
+// SignMediaUrl 为保存在任务中的网关结果地址附加签名，其他地址原样返回
+func SignMediaUrl(rawUrl string) string {
+	videoPrefix := system_setting.ServerAddress + "/v1/videos/"
+	if strings.HasPrefix(rawUrl, videoPrefix) && strings.HasSuffix(rawUrl, "/content") {
+		taskId := strings.TrimSuffix(strings.TrimPrefix(rawUrl, videoPrefix), "/content")
+		if taskId != "" && !strings.Contains(taskId, "/") {
+			return VideoContentUrl(taskId)
+		}
+	}
+	imagePrefix := system_setting.ServerAddress + "/mj/image/"
+	if mjId, ok := strings.CutPrefix(rawUrl, imagePrefix); ok && mjId != "" && !strings.ContainsAny(mjId, "/?") {
+		return MidjourneyImageUrl(mjId)
+	}
+	artifactPrefix := system_setting.ServerAddress + "/v1/artifacts/"
+	if artifactId, ok := strings.CutPrefix(rawUrl, artifactPrefix); ok && artifactId != "" && !strings.ContainsAny(artifactId, "/?") {
+		return ArtifactContentUrl(artifactId)
+	}
+	return rawUrl
+}
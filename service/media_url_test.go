@@ -0,0 +1,120 @@
+package service
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/setting/system_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestVerifyMediaSignature(t *testing.T) {
+	query, _ := SignMediaResource(MediaResourceVideo, "task_1", 60)
+	expires, signature := query.Get("expires"), query.Get("signature")
+	if !VerifyMediaSignature(MediaResourceVideo, "task_1", expires, signature) {
+		t.Fatal("valid signature rejected")
+	}
+	if VerifyMediaSignature(MediaResourceVideo, "task_2", expires, signature) {
+		t.Fatal("signature accepted for another task")
+	}
+	if VerifyMediaSignature(MediaResourceArtifact, "task_1", expires, signature) {
+		t.Fatal("signature accepted for another resource type")
+	}
+	if VerifyMediaSignature(MediaResourceVideo, "task_1", expires+"0", signature) {
+		t.Fatal("signature accepted with a tampered expiry")
+	}
+	if VerifyMediaSignature(MediaResourceVideo, "task_1", "", "") {
+		t.Fatal("missing signature accepted")
+	}
+	expired, _ := SignMediaResource(MediaResourceVideo, "task_1", -10)
+	if VerifyMediaSignature(MediaResourceVideo, "task_1", expired.Get("expires"), expired.Get("signature")) {
+		t.Fatal("expired signature accepted")
+	}
+}
+
+func TestSignMediaUrl(t *testing.T) {
+	setting := operation_setting.GetMediaAccessSetting()
+	oldSetting, oldServerAddress := *setting, system_setting.ServerAddress
+	defer func() {
+		*setting = oldSetting
+		system_setting.ServerAddress = oldServerAddress
+	}()
+	system_setting.ServerAddress = "https://gateway.example.com"
+	setting.SignedUrlEnabled = true
+	setting.SignedUrlTTL = 3600
+
+	// 未开启归属校验时地址不需要签名
+	setting.VerifyOwnership = false
+	videoUrl := "https://gateway.example.com/v1/videos/task_1/content"
+	if got := SignMediaUrl(videoUrl); got != videoUrl {
+		t.Fatalf("SignMediaUrl = %s, want unsigned url", got)
+	}
+
+	setting.VerifyOwnership = true
+	signed := SignMediaUrl(videoUrl)
+	if !strings.HasPrefix(signed, videoUrl+"?") || !strings.Contains(signed, "signature=") {
+		t.Fatalf("SignMediaUrl = %s, want a signed video url", signed)
+	}
+	if got := SignMediaUrl("https://gateway.example.com/mj/image/mj_1"); !strings.Contains(got, "signature=") {
+		t.Fatalf("midjourney image url not signed: %s", got)
+	}
+	if got := SignMediaUrl("https://gateway.example.com/v1/artifacts/art_1"); !strings.Contains(got, "signature=") {
+		t.Fatalf("artifact url not signed: %s", got)
+	}
+	// 上游地址和其他路径原样返回
+	for _, rawUrl := range []string{
+		"https://upstream.example.com/v1/videos/task_1/content",
+		"https://gateway.example.com/v1/videos/task_1",
+		"https://gateway.example.com/v1/artifacts/art_1/other",
+	} {
+		if got := SignMediaUrl(rawUrl); got != rawUrl {
+			t.Errorf("SignMediaUrl(%s) = %s, want unchanged", rawUrl, got)
+		}
+	}
+}
+
+func TestCanAccessArtifact(t *testing.T) {
+	setting := operation_setting.GetMediaAccessSetting()
+	oldSetting := *setting
+	defer func() { *setting = oldSetting }()
+	gin.SetMode(gin.TestMode)
+	newContext := func(userId int, signed bool) *gin.Context {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Set("id", userId)
+		if signed {
+			common.SetContextKey(c, constant.ContextKeyMediaSignedAccess, true)
+		}
+		return c
+	}
+	now := common.GetTimestamp()
+	artifact := &model.TaskArtifact{UserId: 1, ExpiresAt: now + 3600}
+	expired := &model.TaskArtifact{UserId: 1, ExpiresAt: now - 1}
+
+	setting.VerifyOwnership = false
+	if !CanAccessArtifact(newContext(2, false), artifact) {
+		t.Fatal("ownership is not verified, other users should have access")
+	}
+	if CanAccessArtifact(newContext(1, false), expired) {
+		t.Fatal("expired artifact should not be accessible")
+	}
+
+	setting.VerifyOwnership = true
+	if !CanAccessArtifact(newContext(1, false), artifact) {
+		t.Fatal("owner should have access")
+	}
+	if CanAccessArtifact(newContext(2, false), artifact) {
+		t.Fatal("other users should not have access")
+	}
+	if !CanAccessArtifact(newContext(0, true), artifact) {
+		t.Fatal("signed url should grant access")
+	}
+	if CanAccessArtifact(newContext(0, true), expired) {
+		t.Fatal("signed url should not grant access to an expired artifact")
+	}
+}
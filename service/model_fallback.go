@@ -0,0 +1,85 @@
+package service
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/setting/ratio_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+const modelFallbackHeader = "X-Model-Fallback"
+
+// GetModelFallbacks 返回当前模型之后还可以依次尝试的备用模型，令牌限制了可用模型时跳过不允许的模型。
+// 备用链按用户请求的模型配置，当前模型已经是备用模型时从它之后继续
+func GetModelFallbacks(c *gin.Context, group string, modelName string) []string {
+	requestedModel := common.GetContextKeyString(c, constant.ContextKeyModelFallbackFrom)
+	if requestedModel == "" {
+		requestedModel = modelName
+	}
+	chain := operation_setting.GetModelFallbackChain(group, requestedModel)
+	if requestedModel != modelName {
+		chain = chain[slices.Index(chain, modelName)+1:]
+	}
+	var fallbacks []string
+	for _, fallbackModel := range chain {
+		if fallbackModel == "" || fallbackModel == requestedModel || fallbackModel == modelName {
+			continue
+		}
+		if !isTokenModelAllowed(c, fallbackModel) {
+			continue
+		}
+		fallbacks = append(fallbacks, fallbackModel)
+	}
+	return fallbacks
+}
+
+func isTokenModelAllowed(c *gin.Context, modelName string) bool {
+	if !common.GetContextKeyBool(c, constant.ContextKeyTokenModelLimitEnabled) {
+		return true
+	}
+	tokenModelLimit, ok := common.GetContextKeyType[map[string]bool](c, constant.ContextKeyTokenModelLimit)
+	if !ok {
+		return false
+	}
+	_, ok = tokenModelLimit[ratio_setting.FormatMatchingModelName(modelName)]
+	return ok
+}
+
+// ShouldFallbackModel 当前模型的渠道都失败后是否切换到备用模型，请求本身有问题（如参数错误）时切换也无济于事
+func ShouldFallbackModel(c *gin.Context, err *types.NewAPIError) bool {
+	if err == nil {
+		return false
+	}
+	if _, ok := c.Get("specific_channel_id"); ok {
+		return false
+	}
+	if operation_setting.IsModelFallbackStatusCode(err.StatusCode) {
+		return true
+	}
+	if err.GetErrorCode() == types.ErrorCodeGetChannelFailed || types.IsChannelError(err) {
+		return true
+	}
+	if types.IsSkipRetryError(err) {
+		return false
+	}
+	return err.StatusCode == http.StatusTooManyRequests || err.StatusCode/100 == 5
+}
+
+// SetModelFallback 记录模型替换，写入响应头，消费日志中记录原请求的模型
+func SetModelFallback(c *gin.Context, requestedModel string, servingModel string) {
+	if from := common.GetContextKeyString(c, constant.ContextKeyModelFallbackFrom); from != "" {
+		requestedModel = from
+	} else {
+		common.SetContextKey(c, constant.ContextKeyModelFallbackFrom, requestedModel)
+	}
+	common.SetContextKey(c, constant.ContextKeyOriginalModel, servingModel)
+	// 缓存键对应请求的模型，备用模型的响应不写入缓存
+	common.SetContextKey(c, constant.ContextKeyResponseCacheKey, "")
+	c.Header(modelFallbackHeader, requestedModel+" -> "+servingModel)
+}
@@ -236,6 +236,10 @@ func PostWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, mod
 }
 
 func PostClaudeConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage *dto.Usage) {
+	if IsHedgeLoser(ctx) {
+		// 对冲请求中落败的尝试不计费
+		return
+	}
 
 	useTimeSeconds := time.Now().Unix() - relayInfo.StartTime.Unix()
 	promptTokens := usage.PromptTokens
@@ -362,6 +366,10 @@ func CalcOpenRouterCacheCreateTokens(usage dto.Usage, priceData types.PriceData)
 }
 
 func PostAudioConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage *dto.Usage, extraContent string) {
+	if IsHedgeLoser(ctx) {
+		// 对冲请求中落败的尝试不计费
+		return
+	}
 
 	useTimeSeconds := time.Now().Unix() - relayInfo.StartTime.Unix()
 	textInputTokens := usage.PromptTokensDetails.TextTokens
@@ -481,8 +489,17 @@ func PreConsumeTokenQuota(relayInfo *relaycommon.RelayInfo, quota int) error {
 	if !relayInfo.TokenUnlimited && token.RemainQuota < quota {
 		return fmt.Errorf("token quota is not enough, token remain quota: %s, need quota: %s", logger.FormatQuota(token.RemainQuota), logger.FormatQuota(quota))
 	}
+	// 按天、周、月的额度限制对无限额度令牌同样生效，预扣的额度同时计入时间窗口
+	err = ReserveQuotaSpendLimits(relayInfo, quota)
+	if err != nil {
+		return err
+	}
+	if quota == 0 {
+		return nil
+	}
 	err = model.DecreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, quota)
 	if err != nil {
+		ReleaseQuotaSpend(relayInfo, quota)
 		return err
 	}
 	return nil
@@ -509,6 +526,7 @@ func PostConsumeQuota(relayInfo *relaycommon.RelayInfo, quota int, preConsumedQu
 			return err
 		}
 	}
+	recordQuotaSpend(relayInfo, quota)
 
 	if sendEmail {
 		if (quota + preConsumedQuota) != 0 {
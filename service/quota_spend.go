@@ -0,0 +1,114 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+)
+
+// ReserveQuotaSpendLimits 检查令牌和用户在当前天、周、月窗口内的消费加上本次额度是否超过限制，未超过时原子地计入本次额度。
+// 之后未实际消费时需调用 ReleaseQuotaSpend 返还
+func ReserveQuotaSpendLimits(relayInfo *relaycommon.RelayInfo, quota int) error {
+	if !relayInfo.QuotaSpendLimited {
+		return nil
+	}
+	var token *model.Token
+	if !relayInfo.IsPlayground {
+		var err error
+		token, err = model.GetTokenByKey(relayInfo.TokenKey, false)
+		if err != nil {
+			return err
+		}
+		if err := reserveQuotaSpend(model.QuotaSpendSubjectToken, token.Id, token.GetQuotaSpendLimits(), quota); err != nil {
+			return err
+		}
+	}
+	user, err := model.GetUserCache(relayInfo.UserId)
+	if err == nil {
+		err = reserveQuotaSpend(model.QuotaSpendSubjectUser, user.Id, user.GetQuotaSpendLimits(), quota)
+	}
+	if err != nil && token != nil {
+		// 用户超过限制时撤销令牌已计入的额度
+		if err := model.RecordQuotaSpend(model.QuotaSpendSubjectToken, token.Id, token.GetQuotaSpendLimits(), -quota); err != nil {
+			common.SysError("failed to release token quota spend: " + err.Error())
+		}
+	}
+	return err
+}
+
+func reserveQuotaSpend(subjectType string, subjectId int, limits model.QuotaSpendLimits, quota int) error {
+	exceeded, err := model.ReserveQuotaSpend(subjectType, subjectId, limits, quota)
+	if err != nil || exceeded == "" {
+		return err
+	}
+	limit := map[string]int{
+		model.QuotaSpendPeriodDaily:   limits.Daily,
+		model.QuotaSpendPeriodWeekly:  limits.Weekly,
+		model.QuotaSpendPeriodMonthly: limits.Monthly,
+	}[exceeded]
+	used := 0
+	if usage, err := model.GetQuotaSpendUsage(subjectType, subjectId); err == nil {
+		used = map[string]int{
+			model.QuotaSpendPeriodDaily:   usage.Daily,
+			model.QuotaSpendPeriodWeekly:  usage.Weekly,
+			model.QuotaSpendPeriodMonthly: usage.Monthly,
+		}[exceeded]
+	}
+	return fmt.Errorf("%s %s quota limit exceeded, used: %s, limit: %s, need quota: %s", subjectType, exceeded,
+		logger.FormatQuota(used), logger.FormatQuota(limit), logger.FormatQuota(quota))
+}
+
+// ReleaseQuotaSpend 返还 ReserveQuotaSpendLimits 预留但未实际消费的额度
+func ReleaseQuotaSpend(relayInfo *relaycommon.RelayInfo, quota int) {
+	recordQuotaSpend(relayInfo, -quota)
+}
+
+// recordQuotaSpend 将消费计入设置了时间窗口限制的令牌和用户，quota 为负数时表示返还
+func recordQuotaSpend(relayInfo *relaycommon.RelayInfo, quota int) {
+	if quota == 0 || !relayInfo.QuotaSpendLimited {
+		return
+	}
+	if !relayInfo.IsPlayground {
+		token, err := model.GetTokenByKey(relayInfo.TokenKey, false)
+		if err == nil {
+			err = model.RecordQuotaSpend(model.QuotaSpendSubjectToken, token.Id, token.GetQuotaSpendLimits(), quota)
+		}
+		if err != nil {
+			common.SysError("failed to record token quota spend: " + err.Error())
+		}
+	}
+	user, err := model.GetUserCache(relayInfo.UserId)
+	if err == nil {
+		err = model.RecordQuotaSpend(model.QuotaSpendSubjectUser, user.Id, user.GetQuotaSpendLimits(), quota)
+	}
+	if err != nil {
+		common.SysError("failed to record user quota spend: " + err.Error())
+	}
+}
+
+// RefundTaskQuota 退还异步任务的额度，同时从令牌和用户当前时间窗口的消费中扣除，tokenId 为 0 时只处理用户
+func RefundTaskQuota(userId int, tokenId int, quota int) error {
+	if err := model.IncreaseUserQuota(userId, quota, false); err != nil {
+		return err
+	}
+	if tokenId != 0 {
+		token, err := model.GetTokenById(tokenId)
+		if err == nil {
+			err = model.RecordQuotaSpend(model.QuotaSpendSubjectToken, token.Id, token.GetQuotaSpendLimits(), -quota)
+		}
+		if err != nil {
+			common.SysError("failed to refund token quota spend: " + err.Error())
+		}
+	}
+	user, err := model.GetUserCache(userId)
+	if err == nil {
+		err = model.RecordQuotaSpend(model.QuotaSpendSubjectUser, user.Id, user.GetQuotaSpendLimits(), -quota)
+	}
+	if err != nil {
+		common.SysError("failed to refund user quota spend: " + err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+)
+
+func setupQuotaSpendTestDB(t *testing.T) {
+	t.Helper()
+	t.Setenv("SQL_DSN", "")
+	common.SQLitePath = "file:quota_spend_test?mode=memory&cache=shared"
+	common.RedisEnabled = false
+	common.IsMasterNode = true
+	if err := model.InitDB(); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []string{"users", "tokens", "quota_spends", "task_callbacks"} {
+		if err := model.DB.Exec("DELETE FROM " + table).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRefundTaskQuotaCreditsSpendWindows(t *testing.T) {
+	setupQuotaSpendTestDB(t)
+	user := &model.User{Id: 101, Username: "spend_refund", Status: common.UserStatusEnabled, Quota: 0, DailyQuotaLimit: 1000}
+	if err := model.DB.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	token := &model.Token{Id: 101, UserId: user.Id, Key: "spendrefundkey", Status: common.TokenStatusEnabled, DailyQuotaLimit: 1000, WeeklyQuotaLimit: 5000}
+	if err := model.DB.Create(token).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := model.RecordQuotaSpend(model.QuotaSpendSubjectToken, token.Id, token.GetQuotaSpendLimits(), 800); err != nil {
+		t.Fatal(err)
+	}
+	if err := model.RecordQuotaSpend(model.QuotaSpendSubjectUser, user.Id, model.QuotaSpendLimits{Daily: 1000}, 800); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RefundTaskQuota(user.Id, token.Id, 300); err != nil {
+		t.Fatal(err)
+	}
+
+	tokenUsage, err := model.GetQuotaSpendUsage(model.QuotaSpendSubjectToken, token.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokenUsage.Daily != 500 || tokenUsage.Weekly != 500 {
+		t.Fatalf("token usage after refund = daily %d weekly %d, want 500", tokenUsage.Daily, tokenUsage.Weekly)
+	}
+	userUsage, err := model.GetQuotaSpendUsage(model.QuotaSpendSubjectUser, user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userUsage.Daily != 500 {
+		t.Fatalf("user daily usage after refund = %d, want 500", userUsage.Daily)
+	}
+	refunded, err := model.GetUserQuota(user.Id, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refunded != 300 {
+		t.Fatalf("user quota after refund = %d, want 300", refunded)
+	}
+}
+
+func TestReserveQuotaSpendLimitsUndoesTokenWhenUserExceeds(t *testing.T) {
+	setupQuotaSpendTestDB(t)
+	user := &model.User{Id: 102, Username: "spend_reserve", Status: common.UserStatusEnabled, DailyQuotaLimit: 500}
+	if err := model.DB.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	token := &model.Token{Id: 102, UserId: user.Id, Key: "spendreservekey", Status: common.TokenStatusEnabled, DailyQuotaLimit: 1000}
+	if err := model.DB.Create(token).Error; err != nil {
+		t.Fatal(err)
+	}
+	relayInfo := &relaycommon.RelayInfo{UserId: user.Id, TokenId: token.Id, TokenKey: token.Key, QuotaSpendLimited: true}
+
+	if err := ReserveQuotaSpendLimits(relayInfo, 400); err != nil {
+		t.Fatal(err)
+	}
+	// 令牌还剩 600，但用户只剩 100
+	if err := ReserveQuotaSpendLimits(relayInfo, 200); err == nil {
+		t.Fatal("expected the user daily limit to reject the reservation")
+	}
+	tokenUsage, err := model.GetQuotaSpendUsage(model.QuotaSpendSubjectToken, token.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokenUsage.Daily != 400 {
+		t.Fatalf("token daily usage = %d, want 400 after the rejected reservation was undone", tokenUsage.Daily)
+	}
+
+	ReleaseQuotaSpend(relayInfo, 400)
+	userUsage, err := model.GetQuotaSpendUsage(model.QuotaSpendSubjectUser, user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userUsage.Daily != 0 {
+		t.Fatalf("user daily usage after release = %d, want 0", userUsage.Daily)
+	}
+}
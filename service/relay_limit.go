@@ -0,0 +1,194 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/limiter"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 按令牌、用户、用户+模型限制每分钟 token 数（TPM）和同时进行的请求数。
+// TPM 使用 common/limiter 的令牌桶，桶容量为一分钟的 token 数，每秒补充 TPM/60，
+// 为避免整数速率丢失精度，令牌数统一乘以 60。
+
+// relayConcurrencyTTL 并发名额租约的有效期，进程异常退出时未释放的名额到期后自动失效
+const relayConcurrencyTTL = 1800
+
+type relayLimitScope struct {
+	name  string
+	key   string
+	limit operation_setting.RelayLimit
+}
+
+type relayConcurrencyLease struct {
+	key     string
+	leaseId string
+}
+
+// RelayLimitLease 请求占用的并发名额和预扣的 TPM，请求结束后调用 Release
+type RelayLimitLease struct {
+	concurrencyLeases []relayConcurrencyLease
+	tpmScopes         []relayLimitScope
+	estimatedTokens   int
+}
+
+// tighterLimit 两个限制中较小的非零值
+func tighterLimit(a int, b int) int {
+	if a <= 0 {
+		return b
+	}
+	if b <= 0 {
+		return a
+	}
+	return min(a, b)
+}
+
+func getRelayLimitScopes(c *gin.Context, relayInfo *relaycommon.RelayInfo) []relayLimitScope {
+	setting := operation_setting.GetRelayLimitSetting()
+	var scopes []relayLimitScope
+	if relayInfo.TokenId != 0 {
+		scopes = append(scopes, relayLimitScope{
+			name: "令牌",
+			key:  fmt.Sprintf("token:%d", relayInfo.TokenId),
+			limit: operation_setting.RelayLimit{
+				TPM:         tighterLimit(setting.Token.TPM, common.GetContextKeyInt(c, constant.ContextKeyTokenTPMLimit)),
+				Concurrency: tighterLimit(setting.Token.Concurrency, common.GetContextKeyInt(c, constant.ContextKeyTokenConcurrencyLimit)),
+			},
+		})
+	}
+	scopes = append(scopes, relayLimitScope{
+		name:  "用户",
+		key:   fmt.Sprintf("user:%d", relayInfo.UserId),
+		limit: operation_setting.GetUserRelayLimit(relayInfo.UserGroup),
+	})
+	if limit := operation_setting.GetModelRelayLimit(relayInfo.OriginModelName); limit.TPM > 0 || limit.Concurrency > 0 {
+		scopes = append(scopes, relayLimitScope{
+			name:  fmt.Sprintf("模型 %s ", relayInfo.OriginModelName),
+			key:   fmt.Sprintf("model:%d:%s", relayInfo.UserId, relayInfo.OriginModelName),
+			limit: limit,
+		})
+	}
+	return scopes
+}
+
+func tpmOptions(tpm int, tokens int) []limiter.Option {
+	return []limiter.Option{
+		limiter.WithCapacity(int64(tpm) * 60),
+		limiter.WithRate(int64(tpm)),
+		limiter.WithRequested(int64(tokens) * 60),
+	}
+}
+
+// AcquireRelayLimits 占用并发名额并按预估的 token 数扣减 TPM，超过限制时返回 429。
+// 预估 token 数超过 TPM 桶容量的请求重试也无法通过，直接返回 400 且不可重试
+func AcquireRelayLimits(c *gin.Context, relayInfo *relaycommon.RelayInfo, estimatedTokens int) (*RelayLimitLease, *types.NewAPIError) {
+	lease := &RelayLimitLease{estimatedTokens: estimatedTokens}
+	if !operation_setting.GetRelayLimitSetting().Enabled {
+		return lease, nil
+	}
+	for _, scope := range getRelayLimitScopes(c, relayInfo) {
+		if scope.limit.Concurrency > 0 {
+			key := "relayLimit:concurrency:" + scope.key
+			leaseId, allowed, err := acquireConcurrency(key, scope.limit.Concurrency)
+			if err != nil {
+				lease.Release(c)
+				return nil, types.NewError(err, types.ErrorCodeRateLimitCheckFailed, types.ErrOptionWithSkipRetry())
+			}
+			if !allowed {
+				lease.Release(c)
+				return nil, types.NewErrorWithStatusCode(fmt.Errorf("%s同时进行的请求数已达上限 %d", scope.name, scope.limit.Concurrency),
+					types.ErrorCodeRateLimitExceeded, http.StatusTooManyRequests, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
+			}
+			lease.concurrencyLeases = append(lease.concurrencyLeases, relayConcurrencyLease{key: key, leaseId: leaseId})
+		}
+		if scope.limit.TPM > 0 {
+			if estimatedTokens > scope.limit.TPM {
+				lease.Release(c)
+				return nil, types.NewErrorWithStatusCode(fmt.Errorf("request exceeds TPM limit: 预估 token 数 %d 超过%s每分钟 token 数上限 %d", estimatedTokens, scope.name, scope.limit.TPM),
+					types.ErrorCodeRequestExceedsTPM, http.StatusBadRequest, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
+			}
+			scope.key = "relayLimit:tpm:" + scope.key
+			allowed, err := allowTPM(scope.key, scope.limit.TPM, estimatedTokens)
+			if err != nil {
+				lease.Release(c)
+				return nil, types.NewError(err, types.ErrorCodeRateLimitCheckFailed, types.ErrOptionWithSkipRetry())
+			}
+			if !allowed {
+				lease.Release(c)
+				return nil, types.NewErrorWithStatusCode(fmt.Errorf("%s每分钟 token 数已达上限 %d", scope.name, scope.limit.TPM),
+					types.ErrorCodeRateLimitExceeded, http.StatusTooManyRequests, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
+			}
+			lease.tpmScopes = append(lease.tpmScopes, scope)
+		}
+	}
+	return lease, nil
+}
+
+// Release 释放并发名额，并按实际消耗的 token 数修正 TPM，请求失败时返还预估的 token 数
+func (lease *RelayLimitLease) Release(c *gin.Context) {
+	if lease == nil {
+		return
+	}
+	for _, concurrencyLease := range lease.concurrencyLeases {
+		if err := releaseConcurrency(concurrencyLease.key, concurrencyLease.leaseId); err != nil {
+			logger.LogError(c, err.Error())
+		}
+	}
+	lease.concurrencyLeases = nil
+	if len(lease.tpmScopes) == 0 {
+		return
+	}
+	delta := common.GetContextKeyInt(c, constant.ContextKeyConsumedTokens) - lease.estimatedTokens
+	if delta != 0 {
+		for _, scope := range lease.tpmScopes {
+			if err := adjustTPM(scope.key, scope.limit.TPM, delta); err != nil {
+				logger.LogError(c, err.Error())
+			}
+		}
+	}
+	lease.tpmScopes = nil
+}
+
+func acquireConcurrency(key string, limit int) (string, bool, error) {
+	if common.RedisEnabled {
+		ctx := context.Background()
+		return limiter.New(ctx, common.RDB).AcquireConcurrency(ctx, key, int64(limit), relayConcurrencyTTL)
+	}
+	leaseId, allowed := limiter.NewMemory().AcquireConcurrency(key, int64(limit), relayConcurrencyTTL)
+	return leaseId, allowed, nil
+}
+
+func releaseConcurrency(key string, leaseId string) error {
+	if common.RedisEnabled {
+		ctx := context.Background()
+		return limiter.New(ctx, common.RDB).ReleaseConcurrency(ctx, key, leaseId)
+	}
+	limiter.NewMemory().ReleaseConcurrency(key, leaseId)
+	return nil
+}
+
+func allowTPM(key string, tpm int, tokens int) (bool, error) {
+	if common.RedisEnabled {
+		ctx := context.Background()
+		return limiter.New(ctx, common.RDB).Allow(ctx, key, tpmOptions(tpm, tokens)...)
+	}
+	return limiter.NewMemory().Allow(key, tpmOptions(tpm, tokens)...), nil
+}
+
+func adjustTPM(key string, tpm int, delta int) error {
+	if common.RedisEnabled {
+		ctx := context.Background()
+		return limiter.New(ctx, common.RDB).Adjust(ctx, key, tpmOptions(tpm, delta)...)
+	}
+	limiter.NewMemory().Adjust(key, tpmOptions(tpm, delta)...)
+	return nil
+}
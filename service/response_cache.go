@@ -0,0 +1,202 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+// 响应缓存：以客户端请求为键，缓存 chat completions 和 embeddings 的响应。
+// 启用 Redis 时缓存在 Redis 中，多节点共享，否则缓存在当前节点内存中。
+
+const responseCacheKeyPrefix = "response_cache:"
+
+type ResponseCacheEntry struct {
+	ContentType string     `json:"content_type"`
+	Body        []byte     `json:"body"`
+	IsStream    bool       `json:"is_stream"`
+	Usage       *dto.Usage `json:"usage"`
+	CreatedAt   int64      `json:"created_at"`
+}
+
+type memoryResponseCacheEntry struct {
+	entry     *ResponseCacheEntry
+	expiresAt time.Time
+}
+
+var (
+	memoryResponseCache     = make(map[string]*memoryResponseCacheEntry)
+	memoryResponseCacheLock sync.Mutex
+)
+
+// responseCacheIgnoredFields 不影响响应内容的请求字段，生成缓存键时忽略
+var responseCacheIgnoredFields = []string{"user", "stream_options"}
+
+// GetResponseCacheKey 根据客户端请求生成缓存键，未开启响应缓存或请求不支持缓存时返回空字符串。
+// 请求体按字段名排序后计算哈希，忽略 user、stream_options 等不影响响应内容的字段
+func GetResponseCacheKey(c *gin.Context, group string) string {
+	relayMode := relayconstant.Path2RelayMode(c.Request.URL.Path)
+	if relayMode != relayconstant.RelayModeChatCompletions && relayMode != relayconstant.RelayModeEmbeddings {
+		return ""
+	}
+	if !operation_setting.IsResponseCacheEnabled(common.GetContextKeyBool(c, constant.ContextKeyTokenResponseCache), group) {
+		return ""
+	}
+	body, err := common.GetRequestBody(c)
+	if err != nil {
+		return ""
+	}
+	var request map[string]any
+	if err := common.Unmarshal(body, &request); err != nil {
+		return ""
+	}
+	// 音频输出单独计费，不缓存
+	if modelName, _ := request["model"].(string); strings.HasPrefix(modelName, "gpt-4o-audio") {
+		return ""
+	}
+	for _, field := range responseCacheIgnoredFields {
+		delete(request, field)
+	}
+	data, err := common.Marshal(request)
+	if err != nil {
+		return ""
+	}
+	var scope string
+	switch operation_setting.GetResponseCacheSetting().Scope {
+	case operation_setting.ResponseCacheScopeGlobal:
+		scope = "global"
+	case operation_setting.ResponseCacheScopeGroup:
+		scope = "group:" + group
+	default:
+		scope = fmt.Sprintf("user:%d", common.GetContextKeyInt(c, constant.ContextKeyUserId))
+	}
+	hash := sha256.New()
+	hash.Write([]byte(fmt.Sprintf("%s|%d|%s|", scope, relayMode, c.Request.URL.Path)))
+	hash.Write(data)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// LookupResponseCache 在选择渠道前查找响应缓存，记录缓存键供请求成功后写入，命中时返回 true
+func LookupResponseCache(c *gin.Context, group string) bool {
+	key := GetResponseCacheKey(c, group)
+	if key == "" {
+		return false
+	}
+	common.SetContextKey(c, constant.ContextKeyResponseCacheKey, key)
+	if ShouldBypassResponseCache(c) {
+		return false
+	}
+	entry := GetResponseCache(key)
+	if entry == nil {
+		return false
+	}
+	common.SetContextKey(c, constant.ContextKeyResponseCacheEntry, entry)
+	return true
+}
+
+// GetResponseCacheHit 返回选择渠道前命中的缓存响应，未命中时返回 nil
+func GetResponseCacheHit(c *gin.Context) *ResponseCacheEntry {
+	entry, _ := common.GetContextKeyType[*ResponseCacheEntry](c, constant.ContextKeyResponseCacheEntry)
+	return entry
+}
+
+// ShouldBypassResponseCache 请求头 Cache-Control: no-cache 时不读取缓存，但仍会写入
+func ShouldBypassResponseCache(c *gin.Context) bool {
+	return strings.Contains(strings.ToLower(c.GetHeader("Cache-Control")), "no-cache")
+}
+
+func GetResponseCache(key string) *ResponseCacheEntry {
+	if key == "" {
+		return nil
+	}
+	if common.RedisEnabled {
+		value, err := common.RedisGet(responseCacheKeyPrefix + key)
+		if err != nil {
+			if !errors.Is(err, redis.Nil) {
+				common.SysError("failed to get response cache: " + err.Error())
+			}
+			return nil
+		}
+		var entry ResponseCacheEntry
+		if err := common.UnmarshalJsonStr(value, &entry); err != nil {
+			return nil
+		}
+		return &entry
+	}
+	memoryResponseCacheLock.Lock()
+	defer memoryResponseCacheLock.Unlock()
+	cached, ok := memoryResponseCache[key]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(cached.expiresAt) {
+		delete(memoryResponseCache, key)
+		return nil
+	}
+	return cached.entry
+}
+
+func SetResponseCache(key string, entry *ResponseCacheEntry) {
+	setting := operation_setting.GetResponseCacheSetting()
+	if key == "" || entry == nil || setting.TTLSeconds <= 0 {
+		return
+	}
+	if len(entry.Body) > setting.MaxBodyKB*1024 {
+		return
+	}
+	entry.CreatedAt = common.GetTimestamp()
+	ttl := time.Duration(setting.TTLSeconds) * time.Second
+	if common.RedisEnabled {
+		data, err := common.Marshal(entry)
+		if err != nil {
+			return
+		}
+		if err := common.RedisSet(responseCacheKeyPrefix+key, string(data), ttl); err != nil {
+			common.SysError("failed to set response cache: " + err.Error())
+		}
+		return
+	}
+	memoryResponseCacheLock.Lock()
+	defer memoryResponseCacheLock.Unlock()
+	if _, ok := memoryResponseCache[key]; !ok && len(memoryResponseCache) >= setting.MaxMemoryEntries {
+		evictMemoryResponseCache(setting.MaxMemoryEntries)
+		if len(memoryResponseCache) >= setting.MaxMemoryEntries {
+			return
+		}
+	}
+	memoryResponseCache[key] = &memoryResponseCacheEntry{entry: entry, expiresAt: time.Now().Add(ttl)}
+}
+
+// evictMemoryResponseCache 清理过期的缓存，仍然超出上限时淘汰最早过期的缓存
+func evictMemoryResponseCache(maxEntries int) {
+	now := time.Now()
+	for key, cached := range memoryResponseCache {
+		if now.After(cached.expiresAt) {
+			delete(memoryResponseCache, key)
+		}
+	}
+	for len(memoryResponseCache) >= maxEntries && len(memoryResponseCache) > 0 {
+		var oldestKey string
+		var oldest time.Time
+		for key, cached := range memoryResponseCache {
+			if oldestKey == "" || cached.expiresAt.Before(oldest) {
+				oldestKey = key
+				oldest = cached.expiresAt
+			}
+		}
+		delete(memoryResponseCache, oldestKey)
+	}
+}
@@ -2,7 +2,10 @@ package service
 
 import (
 	"errors"
+	"sort"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/QuantumNous/new-api/dto"
 	"github.com/QuantumNous/new-api/setting"
@@ -50,28 +53,120 @@ func SensitiveWordContains(text string) (bool, []string) {
 
 // SensitiveWordReplace 敏感词替换，返回是否包含敏感词和替换后的文本
 func SensitiveWordReplace(text string, returnImmediately bool) (bool, []string, string) {
-	if len(setting.SensitiveWords) == 0 {
+	if len(setting.SensitiveWords) == 0 || len(text) == 0 {
 		return false, nil, text
 	}
-	checkText := strings.ToLower(text)
+	runes := []rune(text)
+	spans := sensitiveWordSpans(runes, returnImmediately)
+	if len(spans) == 0 {
+		return false, nil, text
+	}
+	return true, sensitiveSpanWords(spans), replaceSensitiveSpans(runes, spans)
+}
+
+// sensitiveSpan 敏感词在文本中的区间（按 rune 计），重叠的敏感词合并为一个区间
+type sensitiveSpan struct {
+	start int
+	end   int
+	words []string
+}
+
+func sensitiveWordSpans(runes []rune, returnImmediately bool) []sensitiveSpan {
 	m := getOrBuildAC(setting.SensitiveWords)
-	hits := m.MultiPatternSearch([]rune(checkText), returnImmediately)
-	if len(hits) > 0 {
-		words := make([]string, 0, len(hits))
-		var builder strings.Builder
-		builder.Grow(len(text))
-		lastPos := 0
-
-		for _, hit := range hits {
-			pos := hit.Pos
-			word := string(hit.Word)
-			builder.WriteString(text[lastPos:pos])
-			builder.WriteString("**###**")
-			lastPos = pos + len(word)
-			words = append(words, word)
+	if m == nil {
+		return nil
+	}
+	// 逐个字符转小写，保证位置与原文一致
+	checkRunes := make([]rune, len(runes))
+	for i, r := range runes {
+		checkRunes[i] = unicode.ToLower(r)
+	}
+	hits := m.MultiPatternSearch(checkRunes, returnImmediately)
+	if len(hits) == 0 {
+		return nil
+	}
+	sort.SliceStable(hits, func(i, j int) bool {
+		return hits[i].Pos < hits[j].Pos
+	})
+	spans := make([]sensitiveSpan, 0, len(hits))
+	for _, hit := range hits {
+		end := hit.Pos + len(hit.Word)
+		if n := len(spans); n > 0 && hit.Pos < spans[n-1].end {
+			spans[n-1].end = max(spans[n-1].end, end)
+			spans[n-1].words = append(spans[n-1].words, string(hit.Word))
+			continue
+		}
+		spans = append(spans, sensitiveSpan{start: hit.Pos, end: end, words: []string{string(hit.Word)}})
+	}
+	return spans
+}
+
+func sensitiveSpanWords(spans []sensitiveSpan) []string {
+	var words []string
+	for _, span := range spans {
+		words = append(words, span.words...)
+	}
+	return words
+}
+
+func replaceSensitiveSpans(runes []rune, spans []sensitiveSpan) string {
+	var builder strings.Builder
+	builder.Grow(len(runes))
+	lastPos := 0
+	for _, span := range spans {
+		builder.WriteString(string(runes[lastPos:span.start]))
+		builder.WriteString("**###**")
+		lastPos = span.end
+	}
+	builder.WriteString(string(runes[lastPos:]))
+	return builder.String()
+}
+
+// SensitiveStreamFilter 流式输出的敏感词检测。末尾可能与后续输出组成敏感词的部分暂不发出，
+// 避免敏感词被拆分到多个数据块中漏检
+type SensitiveStreamFilter struct {
+	pending []rune
+	hold    int
+}
+
+func NewSensitiveStreamFilter() *SensitiveStreamFilter {
+	maxLen := 0
+	for _, word := range setting.SensitiveWords {
+		maxLen = max(maxLen, utf8.RuneCountInString(strings.TrimSpace(word)))
+	}
+	return &SensitiveStreamFilter{hold: max(maxLen-1, 0)}
+}
+
+// Push 追加一段输出，返回可以发出的文本（敏感词已替换）和其中的敏感词
+func (f *SensitiveStreamFilter) Push(text string) (string, []string) {
+	f.pending = append(f.pending, []rune(text)...)
+	return f.emit(len(f.pending) - f.hold)
+}
+
+// Flush 输出结束时发出保留的部分
+func (f *SensitiveStreamFilter) Flush() (string, []string) {
+	return f.emit(len(f.pending))
+}
+
+// Pending 是否还有保留未发出的文本
+func (f *SensitiveStreamFilter) Pending() bool {
+	return len(f.pending) > 0
+}
+
+func (f *SensitiveStreamFilter) emit(boundary int) (string, []string) {
+	if boundary <= 0 {
+		return "", nil
+	}
+	var emitSpans []sensitiveSpan
+	for _, span := range sensitiveWordSpans(f.pending, false) {
+		if span.start >= boundary {
+			// 保留部分中的敏感词在后续发出时处理
+			break
 		}
-		builder.WriteString(text[lastPos:])
-		return true, words, builder.String()
+		boundary = max(boundary, span.end)
+		emitSpans = append(emitSpans, span)
 	}
-	return false, nil, text
+	text := replaceSensitiveSpans(f.pending[:boundary], emitSpans)
+	f.pending = append([]rune(nil), f.pending[boundary:]...)
+	return text, sensitiveSpanWords(emitSpans)
 }
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/setting"
+)
+
+func useSensitiveWords(t *testing.T, words ...string) {
+	t.Helper()
+	oldWords := setting.SensitiveWords
+	t.Cleanup(func() {
+		setting.SensitiveWords = oldWords
+	})
+	setting.SensitiveWords = words
+}
+
+// filterStream 依次推入数据块，返回每次发出的文本、全部输出和检测到的敏感词
+func filterStream(filter *SensitiveStreamFilter, chunks ...string) ([]string, string, []string) {
+	var emitted []string
+	var words []string
+	for _, chunk := range chunks {
+		text, found := filter.Push(chunk)
+		emitted = append(emitted, text)
+		words = append(words, found...)
+	}
+	text, found := filter.Flush()
+	emitted = append(emitted, text)
+	words = append(words, found...)
+	return emitted, strings.Join(emitted, ""), words
+}
+
+func TestSensitiveStreamFilterWordAcrossChunks(t *testing.T) {
+	useSensitiveWords(t, "badword", "敏感词")
+
+	tests := []struct {
+		name      string
+		chunks    []string
+		want      string
+		wantWords []string
+	}{
+		{
+			name:      "split ascii word",
+			chunks:    []string{"hello b", "adw", "ord world"},
+			want:      "hello **###** world",
+			wantWords: []string{"badword"},
+		},
+		{
+			name:      "case insensitive",
+			chunks:    []string{"say BAD", "Word now"},
+			want:      "say **###** now",
+			wantWords: []string{"badword"},
+		},
+		{
+			name:      "split multibyte word",
+			chunks:    []string{"这是敏", "感词。"},
+			want:      "这是**###**。",
+			wantWords: []string{"敏感词"},
+		},
+		{
+			name:   "clean text",
+			chunks: []string{"nothing ", "to see"},
+			want:   "nothing to see",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			emitted, got, words := filterStream(NewSensitiveStreamFilter(), tt.chunks...)
+			if got != tt.want {
+				t.Fatalf("output = %q, want %q", got, tt.want)
+			}
+			if !slices.Equal(words, tt.wantWords) {
+				t.Fatalf("words = %v, want %v", words, tt.wantWords)
+			}
+			for _, text := range emitted {
+				if strings.Contains(strings.ToLower(text), "bad") || strings.Contains(text, "敏") {
+					t.Fatalf("emitted chunk %q leaks part of a sensitive word", text)
+				}
+			}
+		})
+	}
+}
+
+func TestSensitiveStreamFilterHoldsPossiblePrefix(t *testing.T) {
+	useSensitiveWords(t, "badword")
+	filter := NewSensitiveStreamFilter()
+
+	// 保留的长度为最长敏感词减一，之前的部分立即发出
+	text, words := filter.Push("0123456789")
+	if text != "0123" || len(words) != 0 {
+		t.Fatalf("push = %q %v, want %q", text, words, "0123")
+	}
+	if !filter.Pending() {
+		t.Fatal("filter should hold the tail that may start a sensitive word")
+	}
+	text, _ = filter.Flush()
+	if text != "456789" || filter.Pending() {
+		t.Fatalf("flush = %q, pending = %v, want %q and nothing pending", text, filter.Pending(), "456789")
+	}
+}
+
+func TestSensitiveStreamFilterWithoutWords(t *testing.T) {
+	useSensitiveWords(t)
+	filter := NewSensitiveStreamFilter()
+	if text, _ := filter.Push("anything"); text != "anything" || filter.Pending() {
+		t.Fatalf("push = %q, want the text to pass through", text)
+	}
+}
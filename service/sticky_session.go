@@ -0,0 +1,215 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+// 会话粘性：把同一会话固定到同一个渠道和 Key，让上游的提示词缓存（Claude cache_control、OpenAI cached input）能够命中。
+// 会话 ID 依次取自请求头、请求体的 user 字段、对话前缀的哈希，与用户、分组、模型一起组成绑定的键。
+// 启用 Redis 时绑定保存在 Redis 中，多节点共享，否则保存在当前节点内存中。
+
+const stickySessionKeyPrefix = "sticky_session:"
+
+type StickySession struct {
+	Group     string `json:"group"`
+	ChannelId int    `json:"channel_id"`
+	KeyIndex  int    `json:"key_index"`
+}
+
+type memoryStickySession struct {
+	session   StickySession
+	expiresAt time.Time
+}
+
+var (
+	memoryStickySessions     = make(map[string]*memoryStickySession)
+	memoryStickySessionsLock sync.Mutex
+)
+
+// stickySessionRequest 只解析识别会话需要的字段，兼容 OpenAI、Claude、Gemini 格式
+type stickySessionRequest struct {
+	User     string `json:"user"`
+	Metadata struct {
+		UserId string `json:"user_id"`
+	} `json:"metadata"`
+	System            json.RawMessage   `json:"system"`
+	Instructions      json.RawMessage   `json:"instructions"`
+	SystemInstruction json.RawMessage   `json:"systemInstruction"`
+	Messages          []json.RawMessage `json:"messages"`
+	Contents          []json.RawMessage `json:"contents"`
+	Input             json.RawMessage   `json:"input"`
+}
+
+func (r *stickySessionRequest) prefixHash(n int) string {
+	hash := sha256.New()
+	written := false
+	write := func(data []byte) {
+		if len(data) == 0 || string(data) == "null" {
+			return
+		}
+		hash.Write(data)
+		hash.Write([]byte{0})
+		written = true
+	}
+	write(r.System)
+	write(r.Instructions)
+	write(r.SystemInstruction)
+	messages := r.Messages
+	if len(messages) == 0 {
+		messages = r.Contents
+	}
+	if len(messages) == 0 && len(r.Input) > 0 {
+		var input []json.RawMessage
+		if err := common.Unmarshal(r.Input, &input); err == nil {
+			messages = input
+		} else {
+			write(r.Input)
+		}
+	}
+	for i := 0; i < len(messages) && i < n; i++ {
+		write(messages[i])
+	}
+	if !written {
+		return ""
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// GetStickySessionKey 生成会话绑定的键，未开启会话粘性或无法识别会话时返回空字符串
+func GetStickySessionKey(c *gin.Context, group string, modelName string) string {
+	if !operation_setting.IsStickySessionEnabled(group) {
+		return ""
+	}
+	setting := operation_setting.GetStickySessionSetting()
+	sessionId := ""
+	if setting.Header != "" {
+		if value := strings.TrimSpace(c.GetHeader(setting.Header)); value != "" {
+			sessionId = "header:" + value
+		}
+	}
+	if sessionId == "" && (setting.UseUserField || setting.PrefixMessages > 0) && c.Request.Method == "POST" &&
+		strings.Contains(c.Request.Header.Get("Content-Type"), "application/json") {
+		var request stickySessionRequest
+		if err := common.UnmarshalBodyReusable(c, &request); err == nil {
+			if setting.UseUserField {
+				if request.User != "" {
+					sessionId = "user:" + request.User
+				} else if request.Metadata.UserId != "" {
+					sessionId = "user:" + request.Metadata.UserId
+				}
+			}
+			if sessionId == "" && setting.PrefixMessages > 0 {
+				if hash := request.prefixHash(setting.PrefixMessages); hash != "" {
+					sessionId = "prefix:" + hash
+				}
+			}
+		}
+	}
+	if sessionId == "" {
+		return ""
+	}
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%d|%s|%s|%s", common.GetContextKeyInt(c, constant.ContextKeyUserId), group, modelName, sessionId)))
+	return hex.EncodeToString(hash[:])
+}
+
+func GetStickySession(key string) *StickySession {
+	if key == "" {
+		return nil
+	}
+	if common.RedisEnabled {
+		value, err := common.RedisGet(stickySessionKeyPrefix + key)
+		if err != nil {
+			if !errors.Is(err, redis.Nil) {
+				common.SysError("failed to get sticky session: " + err.Error())
+			}
+			return nil
+		}
+		var session StickySession
+		if err := common.UnmarshalJsonStr(value, &session); err != nil {
+			return nil
+		}
+		return &session
+	}
+	memoryStickySessionsLock.Lock()
+	defer memoryStickySessionsLock.Unlock()
+	cached, ok := memoryStickySessions[key]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(cached.expiresAt) {
+		delete(memoryStickySessions, key)
+		return nil
+	}
+	session := cached.session
+	return &session
+}
+
+func SetStickySession(key string, session StickySession) {
+	setting := operation_setting.GetStickySessionSetting()
+	if key == "" || session.ChannelId == 0 || setting.TTLSeconds <= 0 {
+		return
+	}
+	ttl := time.Duration(setting.TTLSeconds) * time.Second
+	if common.RedisEnabled {
+		data, err := common.Marshal(session)
+		if err != nil {
+			return
+		}
+		if err := common.RedisSet(stickySessionKeyPrefix+key, string(data), ttl); err != nil {
+			common.SysError("failed to set sticky session: " + err.Error())
+		}
+		return
+	}
+	memoryStickySessionsLock.Lock()
+	defer memoryStickySessionsLock.Unlock()
+	if _, ok := memoryStickySessions[key]; !ok && setting.MaxMemoryEntries > 0 && len(memoryStickySessions) >= setting.MaxMemoryEntries {
+		evictMemoryStickySessions(setting.MaxMemoryEntries)
+	}
+	memoryStickySessions[key] = &memoryStickySession{session: session, expiresAt: time.Now().Add(ttl)}
+}
+
+// evictMemoryStickySessions 清理过期的绑定，仍然超出上限时随机淘汰一成，避免每次写入都遍历全部绑定
+func evictMemoryStickySessions(maxEntries int) {
+	now := time.Now()
+	for key, cached := range memoryStickySessions {
+		if now.After(cached.expiresAt) {
+			delete(memoryStickySessions, key)
+		}
+	}
+	evictCount := len(memoryStickySessions) - maxEntries*9/10
+	for key := range memoryStickySessions {
+		if evictCount <= 0 {
+			break
+		}
+		delete(memoryStickySessions, key)
+		evictCount--
+	}
+}
+
+// SaveStickySession 请求成功后把会话绑定到实际使用的渠道和 Key，并刷新有效期
+func SaveStickySession(c *gin.Context, group string, channelId int) {
+	key := common.GetContextKeyString(c, constant.ContextKeyStickySessionKey)
+	// 备用模型的渠道不能服务原请求的模型，不绑定
+	if key == "" || common.GetContextKeyString(c, constant.ContextKeyModelFallbackFrom) != "" {
+		return
+	}
+	session := StickySession{Group: group, ChannelId: channelId}
+	if common.GetContextKeyBool(c, constant.ContextKeyChannelIsMultiKey) {
+		session.KeyIndex = common.GetContextKeyInt(c, constant.ContextKeyChannelMultiKeyIndex)
+	}
+	SetStickySession(key, session)
+}
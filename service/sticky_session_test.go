@@ -0,0 +1,124 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setupStickySessionTest 开启会话粘性并使用内存保存绑定，返回时恢复原有配置
+func setupStickySessionTest(t *testing.T) *operation_setting.StickySessionSetting {
+	t.Helper()
+	setting := operation_setting.GetStickySessionSetting()
+	oldSetting := *setting
+	oldRedisEnabled := common.RedisEnabled
+	t.Cleanup(func() {
+		*setting = oldSetting
+		common.RedisEnabled = oldRedisEnabled
+	})
+	setting.Enabled = true
+	setting.Groups = []string{}
+	setting.Header = "X-Session-Id"
+	setting.UseUserField = true
+	setting.PrefixMessages = 2
+	setting.TTLSeconds = 60
+	common.RedisEnabled = false
+	gin.SetMode(gin.TestMode)
+	return setting
+}
+
+func newStickySessionContext(userId int, body string, header string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	if header != "" {
+		c.Request.Header.Set("X-Session-Id", header)
+	}
+	common.SetContextKey(c, constant.ContextKeyUserId, userId)
+	return c
+}
+
+func TestGetStickySessionKey(t *testing.T) {
+	setting := setupStickySessionTest(t)
+	const (
+		firstTurn  = `{"model":"m","messages":[{"role":"system","content":"be brief"},{"role":"user","content":"hi"}]}`
+		secondTurn = `{"model":"m","messages":[{"role":"system","content":"be brief"},{"role":"user","content":"hi"},{"role":"assistant","content":"hello"},{"role":"user","content":"more"}]}`
+		otherTurn  = `{"model":"m","messages":[{"role":"system","content":"be brief"},{"role":"user","content":"bye"}]}`
+	)
+	key := func(userId int, body string, header string) string {
+		return GetStickySessionKey(newStickySessionContext(userId, body, header), "default", "m")
+	}
+
+	// 对话前缀相同的后续轮次使用同一个绑定
+	if k := key(1, firstTurn, ""); k == "" || k != key(1, secondTurn, "") {
+		t.Fatal("later turns of a conversation should share the prefix key")
+	}
+	if key(1, firstTurn, "") == key(1, otherTurn, "") {
+		t.Fatal("different conversations should not share a key")
+	}
+	// 不同用户的相同会话不共享绑定
+	if key(1, firstTurn, "") == key(2, firstTurn, "") {
+		t.Fatal("sessions of different users should not share a key")
+	}
+	// 请求头优先于 user 字段，user 字段优先于对话前缀
+	withUser := `{"model":"m","user":"alice","messages":[{"role":"user","content":"x"}]}`
+	if key(1, withUser, "s1") != key(1, otherTurn, "s1") {
+		t.Fatal("session header should take precedence over the request body")
+	}
+	if key(1, withUser, "") != key(1, `{"model":"m","user":"alice","messages":[{"role":"user","content":"y"}]}`, "") {
+		t.Fatal("user field should take precedence over the message prefix")
+	}
+
+	setting.Groups = []string{"vip"}
+	if k := key(1, firstTurn, "s1"); k != "" {
+		t.Fatalf("key = %q for a group without sticky sessions, want empty", k)
+	}
+	setting.Groups = []string{}
+	setting.Enabled = false
+	if k := key(1, firstTurn, "s1"); k != "" {
+		t.Fatalf("key = %q with sticky sessions disabled, want empty", k)
+	}
+}
+
+func TestSaveStickySession(t *testing.T) {
+	setupStickySessionTest(t)
+
+	c := newStickySessionContext(1, `{}`, "")
+	common.SetContextKey(c, constant.ContextKeyStickySessionKey, "sticky-save-test")
+	common.SetContextKey(c, constant.ContextKeyChannelIsMultiKey, true)
+	common.SetContextKey(c, constant.ContextKeyChannelMultiKeyIndex, 2)
+	SaveStickySession(c, "default", 7)
+	session := GetStickySession("sticky-save-test")
+	if session == nil || session.Group != "default" || session.ChannelId != 7 || session.KeyIndex != 2 {
+		t.Fatalf("session = %+v, want channel 7 key 2 in group default", session)
+	}
+
+	// 切换到备用模型后不改变原模型的绑定
+	c = newStickySessionContext(1, `{}`, "")
+	common.SetContextKey(c, constant.ContextKeyStickySessionKey, "sticky-save-test")
+	common.SetContextKey(c, constant.ContextKeyModelFallbackFrom, "m")
+	SaveStickySession(c, "default", 8)
+	if session := GetStickySession("sticky-save-test"); session == nil || session.ChannelId != 7 {
+		t.Fatalf("session = %+v after a fallback request, want channel 7", session)
+	}
+}
+
+func TestStickySessionExpires(t *testing.T) {
+	setupStickySessionTest(t)
+
+	SetStickySession("sticky-expire-test", StickySession{Group: "default", ChannelId: 3})
+	memoryStickySessionsLock.Lock()
+	memoryStickySessions["sticky-expire-test"].expiresAt = memoryStickySessions["sticky-expire-test"].expiresAt.Add(-2 * time.Minute)
+	memoryStickySessionsLock.Unlock()
+	if session := GetStickySession("sticky-expire-test"); session != nil {
+		t.Fatalf("expired session = %+v, want nil", session)
+	}
+}
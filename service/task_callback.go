@@ -0,0 +1,279 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/setting/system_setting"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	TaskCallbackEventSucceeded = "task.succeeded"
+	TaskCallbackEventFailed    = "task.failed"
+)
+
+// taskCallbackLease 投递前把记录的重试时间推后，期间其他投递不会再次领取该记录
+const taskCallbackLease = 5 * 60
+
+// TaskCallbackPayload 异步任务完成回调的负载数据。
+// 投递时 X-Webhook-Timestamp 为投递时间，X-Webhook-Signature 为用户 webhook 密钥对 "时间戳.请求体" 的 HMAC-SHA256
+
+type TaskCallbackPayload struct {
+	Type       string          `json:"type"`
+	TaskId     string          `json:"task_id"`
+	Platform   string          `json:"platform"`
+	Action     string          `json:"action"`
+	Status     string          `json:"status"`
+	Progress   string          `json:"progress"`
+	FailReason string          `json:"fail_reason,omitempty"`
+	ResultUrl  string          `json:"result_url,omitempty"`
+	SubmitTime int64           `json:"submit_time"`
+	FinishTime int64           `json:"finish_time"`
+	Data       json.RawMessage `json:"data,omitempty"`
+	Timestamp  int64           `json:"timestamp"`
+}
+
+// ValidateTaskCallbackUrl 校验回调地址，投递时还会按 SSRF 设置再次校验
+func ValidateTaskCallbackUrl(callbackUrl string) error {
+	if callbackUrl == "" {
+		return nil
+	}
+	if len(callbackUrl) > 512 {
+		return errors.New("callback_url is too long")
+	}
+	u, err := url.Parse(callbackUrl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("callback_url must be a valid http or https url")
+	}
+	if system_setting.EnableWorker() {
+		return nil
+	}
+	fetchSetting := system_setting.GetFetchSetting()
+	if err := common.ValidateURLWithFetchSetting(callbackUrl, fetchSetting.EnableSSRFProtection, fetchSetting.AllowPrivateIp, fetchSetting.DomainFilterMode, fetchSetting.IpFilterMode, fetchSetting.DomainList, fetchSetting.IpList, fetchSetting.AllowedPorts, fetchSetting.ApplyIPFilterForDomain); err != nil {
+		return fmt.Errorf("callback_url rejected: %v", err)
+	}
+	return nil
+}
+
+// GetTaskCallbackUrl 获取任务提交请求的回调地址，请求体中的 callback_url 优先，其次是令牌配置的回调地址。
+// 使用回调时确保用户已有 webhook 密钥，回调总是签名
+func GetTaskCallbackUrl(c *gin.Context) (string, error) {
+	if !operation_setting.GetTaskCallbackSetting().Enabled {
+		return "", nil
+	}
+	callbackUrl := ""
+	if strings.HasPrefix(c.Request.Header.Get("Content-Type"), "multipart/form-data") {
+		if form, err := common.ParseMultipartFormReusable(c); err == nil {
+			if values := form.Value["callback_url"]; len(values) > 0 {
+				callbackUrl = values[0]
+			}
+		}
+	} else if body, err := common.GetRequestBody(c); err == nil {
+		callbackUrl = gjson.GetBytes(body, "callback_url").String()
+	}
+	callbackUrl = strings.TrimSpace(callbackUrl)
+	if callbackUrl == "" {
+		callbackUrl = common.GetContextKeyString(c, constant.ContextKeyTokenCallbackUrl)
+	}
+	if err := ValidateTaskCallbackUrl(callbackUrl); err != nil {
+		return "", err
+	}
+	if callbackUrl != "" {
+		if _, err := model.EnsureUserWebhookSecret(c.GetInt("id")); err != nil {
+			return "", fmt.Errorf("failed to prepare webhook secret: %v", err)
+		}
+	}
+	return callbackUrl, nil
+}
+
+func isTerminalTaskStatus(status string) bool {
+	return status == model.TaskStatusSuccess || status == model.TaskStatusFailure
+}
+
+func taskCallbackEvent(status string) string {
+	if status == model.TaskStatusSuccess {
+		return TaskCallbackEventSucceeded
+	}
+	return TaskCallbackEventFailed
+}
+
+// NotifyTaskCallback 视频、Suno 任务从未完成进入成功或失败状态时推送回调
+func NotifyTaskCallback(task *model.Task, preStatus model.TaskStatus) {
+	status := string(task.Status)
+	if task.CallbackUrl == "" || !isTerminalTaskStatus(status) || isTerminalTaskStatus(string(preStatus)) {
+		return
+	}
+	payload := TaskCallbackPayload{
+		Type:       taskCallbackEvent(status),
+		TaskId:     task.TaskID,
+		Platform:   string(task.Platform),
+		Action:     task.Action,
+		Status:     status,
+		Progress:   task.Progress,
+		SubmitTime: task.SubmitTime,
+		FinishTime: task.FinishTime,
+	}
+	if task.Platform == constant.TaskPlatformSuno {
+		payload.Data = task.Data
+		payload.FailReason = task.FailReason
+	} else if status == model.TaskStatusSuccess {
+		// 视频任务成功时 fail_reason 中保存的是结果地址
+		payload.ResultUrl = SignMediaUrl(task.FailReason)
+	} else {
+		payload.FailReason = task.FailReason
+	}
+	enqueueTaskCallback(task.UserId, model.TaskCallbackTypeTask, task.TaskID, task.CallbackUrl, payload)
+}
+
+// NotifyMidjourneyCallback Midjourney 任务从未完成进入成功或失败状态时推送回调
+func NotifyMidjourneyCallback(task *model.Midjourney, preStatus string) {
+	if task.CallbackUrl == "" || !isTerminalTaskStatus(task.Status) || isTerminalTaskStatus(preStatus) {
+		return
+	}
+	payload := TaskCallbackPayload{
+		Type:       taskCallbackEvent(task.Status),
+		TaskId:     task.MjId,
+		Platform:   string(constant.TaskPlatformMidjourney),
+		Action:     task.Action,
+		Status:     task.Status,
+		Progress:   task.Progress,
+		FailReason: task.FailReason,
+		ResultUrl:  SignMediaUrl(task.ImageUrl),
+		SubmitTime: task.SubmitTime,
+		FinishTime: task.FinishTime,
+	}
+	if task.VideoUrl != "" {
+		payload.ResultUrl = SignMediaUrl(task.VideoUrl)
+	}
+	enqueueTaskCallback(task.UserId, model.TaskCallbackTypeMidjourney, task.MjId, task.CallbackUrl, payload)
+}
+
+func enqueueTaskCallback(userId int, taskType string, taskId string, callbackUrl string, payload TaskCallbackPayload) {
+	if !operation_setting.GetTaskCallbackSetting().Enabled {
+		return
+	}
+	payload.Timestamp = time.Now().Unix()
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to marshal task callback payload for task %s: %s", taskId, err.Error()))
+		return
+	}
+	callback := &model.TaskCallback{
+		UserId:     userId,
+		TaskType:   taskType,
+		TaskId:     taskId,
+		TaskStatus: payload.Status,
+		Url:        callbackUrl,
+		Payload:    string(payloadBytes),
+		Status:     model.TaskCallbackStatusPending,
+		// 首次投递直接占用租约，重试循环在租约到期前不会领取
+		NextRetryTime: common.GetTimestamp() + taskCallbackLease,
+	}
+	if err := callback.Insert(); err != nil {
+		common.SysError(fmt.Sprintf("failed to insert task callback for task %s: %s", taskId, err.Error()))
+		return
+	}
+	gopool.Go(func() {
+		deliverTaskCallback(callback)
+	})
+}
+
+// deliverTaskCallback 投递一次回调并记录结果，失败时按指数退避安排重试
+func deliverTaskCallback(callback *model.TaskCallback) {
+	setting := operation_setting.GetTaskCallbackSetting()
+	var statusCode int
+	secret, err := model.EnsureUserWebhookSecret(callback.UserId)
+	if err == nil {
+		statusCode, err = postWebhook(callback.Url, taskCallbackHeaders(secret, time.Now().Unix(), []byte(callback.Payload)), []byte(callback.Payload))
+	}
+	callback.Attempts++
+	callback.StatusCode = statusCode
+	if err == nil {
+		callback.Status = model.TaskCallbackStatusSuccess
+		callback.LastError = ""
+	} else {
+		callback.LastError = err.Error()
+		if callback.Attempts >= setting.MaxAttempts {
+			callback.Status = model.TaskCallbackStatusFailed
+			common.SysLog(fmt.Sprintf("task callback for task %s failed after %d attempts: %s", callback.TaskId, callback.Attempts, err.Error()))
+		} else {
+			callback.NextRetryTime = common.GetTimestamp() + taskCallbackBackoff(setting.RetryInterval, callback.Attempts)
+		}
+	}
+	if err := callback.SaveAttempt(); err != nil {
+		common.SysError(fmt.Sprintf("failed to save task callback %d: %s", callback.Id, err.Error()))
+	}
+}
+
+// taskCallbackHeaders 每次投递使用新的时间戳重新签名
+func taskCallbackHeaders(secret string, timestamp int64, payload []byte) map[string]string {
+	return map[string]string{
+		"X-Webhook-Timestamp": strconv.FormatInt(timestamp, 10),
+		"X-Webhook-Signature": generateTimestampSignature(secret, timestamp, payload),
+	}
+}
+
+func taskCallbackBackoff(interval int, attempts int) int64 {
+	if interval <= 0 {
+		interval = 30
+	}
+	backoff := int64(interval)
+	for i := 1; i < attempts && backoff < 3600; i++ {
+		backoff *= 2
+	}
+	return min(backoff, 3600)
+}
+
+// RetryTaskCallbacks 投递到达重试时间的回调
+func RetryTaskCallbacks() {
+	for {
+		callbacks, err := model.GetDueTaskCallbacks(common.GetTimestamp(), 100)
+		if err != nil {
+			common.SysError("failed to query due task callbacks: " + err.Error())
+			return
+		}
+		for _, callback := range callbacks {
+			claimed, err := callback.Claim(common.GetTimestamp() + taskCallbackLease)
+			if err != nil {
+				common.SysError(fmt.Sprintf("failed to claim task callback %d: %s", callback.Id, err.Error()))
+				return
+			}
+			if claimed {
+				deliverTaskCallback(callback)
+			}
+		}
+		if len(callbacks) < 100 {
+			return
+		}
+	}
+}
+
+// AutomaticallyRetryTaskCallbacks 定期重试投递失败的回调，并清理过期的投递记录
+func AutomaticallyRetryTaskCallbacks() {
+	lastCleanup := time.Time{}
+	for {
+		time.Sleep(15 * time.Second)
+		RetryTaskCallbacks()
+		retentionDays := operation_setting.GetTaskCallbackSetting().RetentionDays
+		if retentionDays > 0 && time.Since(lastCleanup) > time.Hour {
+			lastCleanup = time.Now()
+			before := time.Now().AddDate(0, 0, -retentionDays).Unix()
+			if err := model.DeleteTaskCallbacksBefore(before); err != nil {
+				common.SysError("failed to cleanup task callbacks: " + err.Error())
+			}
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/system_setting"
+)
+
+func TestDeliverTaskCallbackSignsTimestampedPayload(t *testing.T) {
+	setupQuotaSpendTestDB(t)
+	InitHttpClient()
+	fetchSetting := system_setting.GetFetchSetting()
+	oldSSRF := fetchSetting.EnableSSRFProtection
+	fetchSetting.EnableSSRFProtection = false
+	defer func() { fetchSetting.EnableSSRFProtection = oldSSRF }()
+
+	user := &model.User{Id: 301, Username: "callback_sign", Status: common.UserStatusEnabled}
+	if err := model.DB.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	var gotTimestamp, gotSignature string
+	var gotBody []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotTimestamp = r.Header.Get("X-Webhook-Timestamp")
+		gotSignature = r.Header.Get("X-Webhook-Signature")
+		gotBody, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	payload := `{"type":"task.succeeded","task_id":"task_1"}`
+	callback := &model.TaskCallback{UserId: user.Id, TaskId: "task_1", Url: server.URL, Payload: payload, Status: model.TaskCallbackStatusPending}
+	if err := callback.Insert(); err != nil {
+		t.Fatal(err)
+	}
+	deliverTaskCallback(callback)
+	if callback.Status != model.TaskCallbackStatusSuccess {
+		t.Fatalf("callback status = %s (%s), want success", callback.Status, callback.LastError)
+	}
+
+	// 用户没有配置密钥时首次投递生成并保存
+	setting, err := model.GetUserSetting(user.Id, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if setting.WebhookSecret == "" {
+		t.Fatal("expected a webhook secret to be generated on first use")
+	}
+	timestamp, err := strconv.ParseInt(gotTimestamp, 10, 64)
+	if err != nil {
+		t.Fatalf("invalid X-Webhook-Timestamp %q", gotTimestamp)
+	}
+	if string(gotBody) != payload {
+		t.Fatalf("body = %s, want %s", gotBody, payload)
+	}
+	if want := generateTimestampSignature(setting.WebhookSecret, timestamp, gotBody); gotSignature != want {
+		t.Fatalf("signature = %s, want %s", gotSignature, want)
+	}
+	// 签名包含时间戳，同一请求体换一个时间戳签名不同
+	if gotSignature == generateTimestampSignature(setting.WebhookSecret, timestamp+1, gotBody) {
+		t.Fatal("signature does not depend on the timestamp")
+	}
+
+	// 已有密钥时保持不变
+	secret, err := model.EnsureUserWebhookSecret(user.Id)
+	if err != nil || secret != setting.WebhookSecret {
+		t.Fatalf("EnsureUserWebhookSecret = %q, %v; want the existing secret", secret, err)
+	}
+}
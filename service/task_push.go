@@ -0,0 +1,32 @@
+package service
+
+import (
+	"crypto/hmac"
+	"fmt"
+	"net/url"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/setting/system_setting"
+)
+
+// 上游推送任务状态的地址按平台和渠道签名，推送内容中的任务必须属于该平台和渠道才会被接受，
+// 推送只触发立即查询，任务状态以向上游查询的结果为准。
+
+func taskPushSignature(platform string, channelId int) string {
+	return common.GenerateHMAC(fmt.Sprintf("task_push:%s:%d", platform, channelId))
+}
+
+// TaskPushUrl 提交任务时传给上游的推送地址
+func TaskPushUrl(platform constant.TaskPlatform, channelId int) string {
+	return fmt.Sprintf("%s/api/task/push/%s/%d?signature=%s", system_setting.ServerAddress,
+		url.PathEscape(string(platform)), channelId, taskPushSignature(string(platform), channelId))
+}
+
+// VerifyTaskPushSignature 校验推送地址的签名
+func VerifyTaskPushSignature(platform string, channelId int, signature string) bool {
+	if signature == "" {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(taskPushSignature(platform, channelId)))
+}
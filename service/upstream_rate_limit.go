@@ -0,0 +1,121 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+)
+
+// 上游限流响应头中剩余额度和重置时间的对应关系
+var upstreamRateLimitHeaders = []struct {
+	remaining string
+	reset     string
+}{
+	// OpenAI
+	{"x-ratelimit-remaining-requests", "x-ratelimit-reset-requests"},
+	{"x-ratelimit-remaining-tokens", "x-ratelimit-reset-tokens"},
+	// Anthropic
+	{"anthropic-ratelimit-requests-remaining", "anthropic-ratelimit-requests-reset"},
+	{"anthropic-ratelimit-tokens-remaining", "anthropic-ratelimit-tokens-reset"},
+	{"anthropic-ratelimit-input-tokens-remaining", "anthropic-ratelimit-input-tokens-reset"},
+	{"anthropic-ratelimit-output-tokens-remaining", "anthropic-ratelimit-output-tokens-reset"},
+}
+
+// parseRateLimitReset 支持时长（OpenAI 的 "6m0s"、"20ms"）、秒数和时间（Anthropic 的 RFC 3339、HTTP 日期）
+func parseRateLimitReset(value string, now time.Time) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, false
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		if seconds < 0 {
+			return time.Time{}, false
+		}
+		return now.Add(time.Duration(seconds * float64(time.Second))), true
+	}
+	if duration, err := time.ParseDuration(value); err == nil {
+		return now.Add(duration), true
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// parseRetryAfter 优先使用毫秒精度的 retry-after-ms
+func parseRetryAfter(header http.Header, now time.Time) (time.Time, bool) {
+	if value := header.Get("retry-after-ms"); value != "" {
+		if ms, err := strconv.ParseFloat(value, 64); err == nil && ms >= 0 {
+			return now.Add(time.Duration(ms * float64(time.Millisecond))), true
+		}
+	}
+	return parseRateLimitReset(header.Get("Retry-After"), now)
+}
+
+// upstreamCooldownUntil 根据上游响应计算冷却结束时间，不需要冷却时返回零值
+func upstreamCooldownUntil(statusCode int, header http.Header, now time.Time) (time.Time, string) {
+	var until time.Time
+	reason := ""
+	extend := func(t time.Time, r string) {
+		if t.After(until) {
+			until = t
+			reason = r
+		}
+	}
+
+	for _, h := range upstreamRateLimitHeaders {
+		remaining := header.Get(h.remaining)
+		if remaining == "" {
+			continue
+		}
+		if n, err := strconv.ParseInt(strings.TrimSpace(remaining), 10, 64); err != nil || n > 0 {
+			continue
+		}
+		if t, ok := parseRateLimitReset(header.Get(h.reset), now); ok {
+			extend(t, fmt.Sprintf("%s: 0", h.remaining))
+		}
+	}
+
+	if statusCode == http.StatusTooManyRequests || statusCode == http.StatusServiceUnavailable {
+		if t, ok := parseRetryAfter(header, now); ok {
+			extend(t, fmt.Sprintf("status code %d, retry after %s", statusCode, t.Sub(now).Round(time.Millisecond)))
+		} else if statusCode == http.StatusTooManyRequests && until.IsZero() {
+			if seconds := operation_setting.GetUpstreamRateLimitSetting().DefaultCooldownSeconds; seconds > 0 {
+				extend(now.Add(time.Duration(seconds)*time.Second), fmt.Sprintf("status code %d", statusCode))
+			}
+		}
+	}
+	return until, reason
+}
+
+// RecordUpstreamRateLimit 上游告知额度耗尽或返回 Retry-After 时冷却渠道（多 Key 渠道冷却当前 Key）
+func RecordUpstreamRateLimit(channelId int, isMultiKey bool, keyIndex int, statusCode int, header http.Header) {
+	setting := operation_setting.GetUpstreamRateLimitSetting()
+	if !setting.Enabled || channelId == 0 || header == nil {
+		return
+	}
+	now := time.Now()
+	until, reason := upstreamCooldownUntil(statusCode, header, now)
+	if until.IsZero() {
+		return
+	}
+	if maxCooldown := time.Duration(setting.MaxCooldownSeconds) * time.Second; maxCooldown > 0 && until.Sub(now) > maxCooldown {
+		until = now.Add(maxCooldown)
+	}
+	if !isMultiKey {
+		keyIndex = model.ChannelRateLimitChannelLevel
+	}
+	model.CoolDownChannel(channelId, keyIndex, until, reason)
+	if common.DebugEnabled {
+		common.SysLog(fmt.Sprintf("channel #%d key #%d cooling down until %s: %s", channelId, keyIndex, until.Format(time.RFC3339), reason))
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/QuantumNous/new-api/common"
@@ -31,6 +32,27 @@ func generateSignature(secret string, payload []byte) string {
 	return hex.EncodeToString(h.Sum(nil))
 }
 
+// generateTimestampSignature 对 "时间戳.请求体" 签名，接收方校验时间戳是否在允许范围内以防止重放
+func generateTimestampSignature(secret string, timestamp int64, payload []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	h.Write([]byte("."))
+	h.Write(payload)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// webhookNotifyHeaders webhook 通知的签名头，只对请求体签名
+func webhookNotifyHeaders(secret string, payloadBytes []byte) map[string]string {
+	headers := map[string]string{}
+	if secret != "" {
+		headers["X-Webhook-Signature"] = generateSignature(secret, payloadBytes)
+		if system_setting.EnableWorker() {
+			headers["Authorization"] = "Bearer " + secret
+		}
+	}
+	return headers
+}
+
 // SendWebhookNotify 发送 webhook 通知
 func SendWebhookNotify(webhookURL string, secret string, data dto.Notify) error {
 	// 处理占位符
@@ -54,9 +76,16 @@ func SendWebhookNotify(webhookURL string, secret string, data dto.Notify) error
 		return fmt.Errorf("failed to marshal webhook payload: %v", err)
 	}
 
+	_, err = postWebhook(webhookURL, webhookNotifyHeaders(secret, payloadBytes), payloadBytes)
+	return err
+}
+
+// postWebhook 发送签名的 webhook 请求，返回上游响应的状态码
+func postWebhook(webhookURL string, headers map[string]string, payloadBytes []byte) (int, error) {
 	// 创建 HTTP 请求
 	var req *http.Request
 	var resp *http.Response
+	var err error
 
 	if system_setting.EnableWorker() {
 		// 构建worker请求数据
@@ -69,58 +98,52 @@ func SendWebhookNotify(webhookURL string, secret string, data dto.Notify) error
 			},
 			Body: payloadBytes,
 		}
-
-		// 如果有secret，添加签名到headers
-		if secret != "" {
-			signature := generateSignature(secret, payloadBytes)
-			workerReq.Headers["X-Webhook-Signature"] = signature
-			workerReq.Headers["Authorization"] = "Bearer " + secret
+		for key, value := range headers {
+			workerReq.Headers[key] = value
 		}
 
 		resp, err = DoWorkerRequest(workerReq)
 		if err != nil {
-			return fmt.Errorf("failed to send webhook request through worker: %v", err)
+			return 0, fmt.Errorf("failed to send webhook request through worker: %v", err)
 		}
 		defer resp.Body.Close()
 
 		// 检查响应状态
 		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-			return fmt.Errorf("webhook request failed with status code: %d", resp.StatusCode)
+			return resp.StatusCode, fmt.Errorf("webhook request failed with status code: %d", resp.StatusCode)
 		}
 	} else {
 		// SSRF防护：验证Webhook URL（非Worker模式）
 		fetchSetting := system_setting.GetFetchSetting()
 		if err := common.ValidateURLWithFetchSetting(webhookURL, fetchSetting.EnableSSRFProtection, fetchSetting.AllowPrivateIp, fetchSetting.DomainFilterMode, fetchSetting.IpFilterMode, fetchSetting.DomainList, fetchSetting.IpList, fetchSetting.AllowedPorts, fetchSetting.ApplyIPFilterForDomain); err != nil {
-			return fmt.Errorf("request reject: %v", err)
+			return 0, fmt.Errorf("request reject: %v", err)
 		}
 
 		req, err = http.NewRequest(http.MethodPost, webhookURL, bytes.NewBuffer(payloadBytes))
 		if err != nil {
-			return fmt.Errorf("failed to create webhook request: %v", err)
+			return 0, fmt.Errorf("failed to create webhook request: %v", err)
 		}
 
 		// 设置请求头
 		req.Header.Set("Content-Type", "application/json")
 
-		// 如果有 secret，生成签名
-		if secret != "" {
-			signature := generateSignature(secret, payloadBytes)
-			req.Header.Set("X-Webhook-Signature", signature)
+		for key, value := range headers {
+			req.Header.Set(key, value)
 		}
 
 		// 发送请求
 		client := GetHttpClient()
 		resp, err = client.Do(req)
 		if err != nil {
-			return fmt.Errorf("failed to send webhook request: %v", err)
+			return 0, fmt.Errorf("failed to send webhook request: %v", err)
 		}
 		defer resp.Body.Close()
 
 		// 检查响应状态
 		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-			return fmt.Errorf("webhook request failed with status code: %d", resp.StatusCode)
+			return resp.StatusCode, fmt.Errorf("webhook request failed with status code: %d", resp.StatusCode)
 		}
 	}
 
-	return nil
+	return resp.StatusCode, nil
 }
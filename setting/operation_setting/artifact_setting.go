@@ -0,0 +1,28 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+type ArtifactSetting struct {
+	// Enabled 视频、图片任务成功后把结果下载到网关存储，任务中的结果地址替换为网关的固定地址
+	Enabled bool `json:"enabled"`
+	// MaxSizeMB 单个结果的最大体积，超过时不保存，仍使用上游地址
+	MaxSizeMB int `json:"max_size_mb"`
+	// RetentionDays 结果保存的天数，到期后删除，0 表示永久保存
+	RetentionDays int `json:"retention_days"`
+}
+
+// 默认配置
+var artifactSetting = ArtifactSetting{
+	Enabled:       false,
+	MaxSizeMB:     512,
+	RetentionDays: 30,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("artifact_setting", &artifactSetting)
+}
+
+func GetArtifactSetting() *ArtifactSetting {
+	return &artifactSetting
+}
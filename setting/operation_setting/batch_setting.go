@@ -0,0 +1,33 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+type BatchSetting struct {
+	Enabled bool `json:"enabled"`
+	// Concurrency 单个批处理任务同时执行的请求数
+	Concurrency int `json:"concurrency"`
+	// MaxRunningBatches 同时执行的批处理任务数
+	MaxRunningBatches int `json:"max_running_batches"`
+	// DiscountRatio 批处理请求的计费折扣，乘在分组倍率上，0.5 即半价
+	DiscountRatio float64 `json:"discount_ratio"`
+	// MaxRequests 单个批处理文件允许的最大请求数
+	MaxRequests int `json:"max_requests"`
+}
+
+// 默认配置
+var batchSetting = BatchSetting{
+	Enabled:           true,
+	Concurrency:       4,
+	MaxRunningBatches: 2,
+	DiscountRatio:     0.5,
+	MaxRequests:       50000,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("batch_setting", &batchSetting)
+}
+
+func GetBatchSetting() *BatchSetting {
+	return &batchSetting
+}
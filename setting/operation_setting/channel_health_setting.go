@@ -0,0 +1,68 @@
+package operation_setting
+
+import (
+	"slices"
+
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+type ChannelHealthSetting struct {
+	// Enabled 开启健康感知的渠道选择：根据近期成功率和延迟调整权重，并临时剔除异常渠道
+	Enabled bool `json:"enabled"`
+	// Groups / Models 仅对指定分组、模型生效，为空表示全部
+	Groups []string `json:"groups"`
+	Models []string `json:"models"`
+	// WindowSeconds 统计滑动窗口长度
+	WindowSeconds int `json:"window_seconds"`
+	// MinRequests 窗口内请求数达到该值后才根据成功率和延迟调整
+	MinRequests int `json:"min_requests"`
+	// EjectSuccessRate 窗口内成功率低于该值时剔除渠道
+	EjectSuccessRate float64 `json:"eject_success_rate"`
+	// ConsecutiveFailures 连续失败次数达到该值时剔除渠道
+	ConsecutiveFailures int `json:"consecutive_failures"`
+	// LatencyOutlierRatio 延迟超过同优先级渠道中位数的倍数时剔除渠道，0 表示只降权不剔除
+	LatencyOutlierRatio float64 `json:"latency_outlier_ratio"`
+	// EjectSeconds 首次剔除时长，连续剔除时翻倍，最长 MaxEjectSeconds
+	EjectSeconds    int `json:"eject_seconds"`
+	MaxEjectSeconds int `json:"max_eject_seconds"`
+	// MaxEjectPercent 同优先级渠道中最多剔除的比例，避免全部渠道被剔除
+	MaxEjectPercent int `json:"max_eject_percent"`
+}
+
+// 默认配置
+var channelHealthSetting = ChannelHealthSetting{
+	Enabled:             false,
+	Groups:              []string{},
+	Models:              []string{},
+	WindowSeconds:       60,
+	MinRequests:         10,
+	EjectSuccessRate:    0.5,
+	ConsecutiveFailures: 5,
+	LatencyOutlierRatio: 3,
+	EjectSeconds:        30,
+	MaxEjectSeconds:     300,
+	MaxEjectPercent:     50,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("channel_health_setting", &channelHealthSetting)
+}
+
+func GetChannelHealthSetting() *ChannelHealthSetting {
+	return &channelHealthSetting
+}
+
+// IsAdaptiveSelectionEnabled 指定分组和模型是否使用健康感知的渠道选择
+func IsAdaptiveSelectionEnabled(group string, model string) bool {
+	if !channelHealthSetting.Enabled {
+		return false
+	}
+	if len(channelHealthSetting.Groups) > 0 && !slices.Contains(channelHealthSetting.Groups, group) {
+		return false
+	}
+	if len(channelHealthSetting.Models) > 0 && !slices.Contains(channelHealthSetting.Models, model) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,31 @@
+package operation_setting
+
+import (
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+// ChannelRateLimitSetting 渠道 RPM/TPM/并发限制的排队配置，限制本身在渠道设置中配置
+type ChannelRateLimitSetting struct {
+	// QueueEnabled 模型的所有渠道都达到限制时排队等待，而不是直接返回错误
+	QueueEnabled bool `json:"queue_enabled"`
+	// QueueTimeoutSeconds 最长等待时间
+	QueueTimeoutSeconds int `json:"queue_timeout_seconds"`
+	// QueueMaxSize 单个节点同时排队的请求数上限，0 表示不限制
+	QueueMaxSize int `json:"queue_max_size"`
+}
+
+// 默认配置
+var channelRateLimitSetting = ChannelRateLimitSetting{
+	QueueEnabled:        false,
+	QueueTimeoutSeconds: 5,
+	QueueMaxSize:        100,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("channel_rate_limit_setting", &channelRateLimitSetting)
+}
+
+func GetChannelRateLimitSetting() *ChannelRateLimitSetting {
+	return &channelRateLimitSetting
+}
@@ -0,0 +1,37 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+type CircuitBreakerSetting struct {
+	// Enabled 开启渠道熔断：自动禁用的渠道（或多 Key 渠道中的 Key）在熔断时间结束后进入半开状态，
+	// 放行少量真实请求或主动测试，成功后自动启用
+	Enabled bool `json:"enabled"`
+	// OpenSeconds 首次熔断时长，半开状态下再次失败时翻倍，最长 MaxOpenSeconds
+	OpenSeconds    int `json:"open_seconds"`
+	MaxOpenSeconds int `json:"max_open_seconds"`
+	// HalfOpenTrafficPercent 半开状态下放行到该渠道的真实请求比例（百分比），0 表示只通过主动测试恢复
+	HalfOpenTrafficPercent float64 `json:"half_open_traffic_percent"`
+	// RecoverySuccesses 半开状态下连续成功多少次后关闭熔断并启用渠道
+	RecoverySuccesses int `json:"recovery_successes"`
+	// ProbeEnabled 半开状态下主动测试渠道
+	ProbeEnabled bool `json:"probe_enabled"`
+}
+
+// 默认配置
+var circuitBreakerSetting = CircuitBreakerSetting{
+	Enabled:                false,
+	OpenSeconds:            60,
+	MaxOpenSeconds:         3600,
+	HalfOpenTrafficPercent: 1,
+	RecoverySuccesses:      1,
+	ProbeEnabled:           true,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("circuit_breaker_setting", &circuitBreakerSetting)
+}
+
+func GetCircuitBreakerSetting() *CircuitBreakerSetting {
+	return &circuitBreakerSetting
+}
@@ -0,0 +1,40 @@
+package operation_setting
+
+import (
+	"slices"
+
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+type HedgeRequestSetting struct {
+	// Enabled 开启对冲请求：首次请求在 DelayMs 内没有返回首个字节时，向同一优先级的另一个渠道再发起一次请求，
+	// 先返回的一方胜出并计费，另一方被取消
+	Enabled bool `json:"enabled"`
+	// Models 只对这些模型开启，为空表示所有模型
+	Models []string `json:"models"`
+	// DelayMs 等待首个字节的时间，超过后发起对冲请求
+	DelayMs int `json:"delay_ms"`
+}
+
+// 默认配置
+var hedgeRequestSetting = HedgeRequestSetting{
+	Enabled: false,
+	Models:  []string{},
+	DelayMs: 2000,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("hedge_request_setting", &hedgeRequestSetting)
+}
+
+func GetHedgeRequestSetting() *HedgeRequestSetting {
+	return &hedgeRequestSetting
+}
+
+func IsHedgeRequestEnabled(model string) bool {
+	if !hedgeRequestSetting.Enabled || hedgeRequestSetting.DelayMs <= 0 {
+		return false
+	}
+	return len(hedgeRequestSetting.Models) == 0 || slices.Contains(hedgeRequestSetting.Models, model)
+}
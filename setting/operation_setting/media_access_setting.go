@@ -0,0 +1,32 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+type MediaAccessSetting struct {
+	// VerifyOwnership 访问 /v1/videos/:task_id/content 和 /mj/image/:id 时要求令牌鉴权，且只能访问自己的任务，带有效签名的地址除外
+	VerifyOwnership bool `json:"verify_ownership"`
+	// SignedUrlEnabled 返回给用户的结果地址附带限时签名，可以直接在浏览器中打开或分享
+	SignedUrlEnabled bool `json:"signed_url_enabled"`
+	// SignedUrlTTL 签名地址的默认有效期，单位秒
+	SignedUrlTTL int `json:"signed_url_ttl"`
+	// MaxSignedUrlTTL 主动申请签名地址时允许的最长有效期，单位秒
+	MaxSignedUrlTTL int `json:"max_signed_url_ttl"`
+}
+
+// 默认配置
+var mediaAccessSetting = MediaAccessSetting{
+	// 默认关闭，避免升级后已发出的不带签名的结果地址失效
+	VerifyOwnership:  false,
+	SignedUrlEnabled: true,
+	SignedUrlTTL:     86400,
+	MaxSignedUrlTTL:  7 * 86400,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("media_access_setting", &mediaAccessSetting)
+}
+
+func GetMediaAccessSetting() *MediaAccessSetting {
+	return &mediaAccessSetting
+}
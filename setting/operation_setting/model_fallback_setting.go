@@ -0,0 +1,60 @@
+package operation_setting
+
+import (
+	"slices"
+
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+type ModelFallbackRule struct {
+	// Model 请求的模型
+	Model string `json:"model"`
+	// Groups 规则适用的分组，为空表示所有分组
+	Groups []string `json:"groups"`
+	// Fallbacks 请求的模型没有可用渠道或所有渠道都失败时依次尝试的备用模型，按实际使用的模型计费
+	Fallbacks []string `json:"fallbacks"`
+}
+
+type ModelFallbackSetting struct {
+	Enabled bool                `json:"enabled"`
+	Rules   []ModelFallbackRule `json:"rules"`
+	// StatusCodes 返回这些状态码时不再重试当前模型的其他渠道，直接切换到备用模型
+	StatusCodes []int `json:"status_codes"`
+}
+
+// 默认配置
+var modelFallbackSetting = ModelFallbackSetting{
+	Enabled:     false,
+	Rules:       []ModelFallbackRule{},
+	StatusCodes: []int{},
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("model_fallback_setting", &modelFallbackSetting)
+}
+
+func GetModelFallbackSetting() *ModelFallbackSetting {
+	return &modelFallbackSetting
+}
+
+// GetModelFallbackChain 返回分组下模型的备用模型，按规则顺序取第一条匹配的规则
+func GetModelFallbackChain(group string, model string) []string {
+	if !modelFallbackSetting.Enabled {
+		return nil
+	}
+	for _, rule := range modelFallbackSetting.Rules {
+		if rule.Model != model {
+			continue
+		}
+		if len(rule.Groups) > 0 && !slices.Contains(rule.Groups, group) {
+			continue
+		}
+		return rule.Fallbacks
+	}
+	return nil
+}
+
+func IsModelFallbackStatusCode(statusCode int) bool {
+	return modelFallbackSetting.Enabled && slices.Contains(modelFallbackSetting.StatusCodes, statusCode)
+}
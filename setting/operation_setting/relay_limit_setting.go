@@ -0,0 +1,52 @@
+package operation_setting
+
+import (
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+// RelayLimit 每分钟 token 数（TPM）和同时进行的请求数限制，0 表示不限制
+type RelayLimit struct {
+	TPM         int `json:"tpm"`
+	Concurrency int `json:"concurrency"`
+}
+
+type RelayLimitSetting struct {
+	// Enabled 开启 TPM 和并发限制。TPM 在请求前按预估的输入 token 扣减，请求结束后按实际用量修正
+	Enabled bool `json:"enabled"`
+	// Token 每个令牌的限制，令牌上设置的限制更小时以令牌为准
+	Token RelayLimit `json:"token"`
+	// User 每个用户的限制
+	User RelayLimit `json:"user"`
+	// GroupUser 按用户分组覆盖 User
+	GroupUser map[string]RelayLimit `json:"group_user"`
+	// Models 每个用户对指定模型的限制
+	Models map[string]RelayLimit `json:"models"`
+}
+
+// 默认配置
+var relayLimitSetting = RelayLimitSetting{
+	Enabled:   false,
+	GroupUser: map[string]RelayLimit{},
+	Models:    map[string]RelayLimit{},
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("relay_limit_setting", &relayLimitSetting)
+}
+
+func GetRelayLimitSetting() *RelayLimitSetting {
+	return &relayLimitSetting
+}
+
+// GetUserRelayLimit 用户分组有单独配置时使用分组的限制
+func GetUserRelayLimit(group string) RelayLimit {
+	if limit, ok := relayLimitSetting.GroupUser[group]; ok {
+		return limit
+	}
+	return relayLimitSetting.User
+}
+
+func GetModelRelayLimit(model string) RelayLimit {
+	return relayLimitSetting.Models[model]
+}
@@ -0,0 +1,76 @@
+package operation_setting
+
+import (
+	"slices"
+
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+const (
+	ResponseCacheBillingFull     = "full"
+	ResponseCacheBillingDiscount = "discount"
+	ResponseCacheBillingFree     = "free"
+
+	ResponseCacheScopeUser   = "user"
+	ResponseCacheScopeGroup  = "group"
+	ResponseCacheScopeGlobal = "global"
+)
+
+type ResponseCacheSetting struct {
+	// Enabled 开启响应缓存（chat completions 和 embeddings），还需要令牌开启响应缓存或分组在 Groups 中
+	Enabled bool `json:"enabled"`
+	// Groups 对这些分组的所有请求开启响应缓存
+	Groups []string `json:"groups"`
+	// TTLSeconds 缓存有效期
+	TTLSeconds int `json:"ttl_seconds"`
+	// BillingMode 命中缓存时的计费方式：full 按原价，discount 按 DiscountRatio 折扣，free 免费
+	BillingMode   string  `json:"billing_mode"`
+	DiscountRatio float64 `json:"discount_ratio"`
+	// Scope 缓存共享范围：user 同一用户，group 同一分组，global 所有用户
+	Scope string `json:"scope"`
+	// MaxBodyKB 超过该大小的响应不缓存
+	MaxBodyKB int `json:"max_body_kb"`
+	// MaxMemoryEntries 未启用 Redis 时内存中最多缓存的响应数
+	MaxMemoryEntries int `json:"max_memory_entries"`
+}
+
+// 默认配置
+var responseCacheSetting = ResponseCacheSetting{
+	Enabled:          false,
+	Groups:           []string{},
+	TTLSeconds:       3600,
+	BillingMode:      ResponseCacheBillingDiscount,
+	DiscountRatio:    0.1,
+	Scope:            ResponseCacheScopeUser,
+	MaxBodyKB:        4096,
+	MaxMemoryEntries: 1000,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("response_cache_setting", &responseCacheSetting)
+}
+
+func GetResponseCacheSetting() *ResponseCacheSetting {
+	return &responseCacheSetting
+}
+
+// IsResponseCacheEnabled 令牌开启了响应缓存或分组在配置中时返回 true
+func IsResponseCacheEnabled(tokenEnabled bool, group string) bool {
+	if !responseCacheSetting.Enabled {
+		return false
+	}
+	return tokenEnabled || slices.Contains(responseCacheSetting.Groups, group)
+}
+
+// GetResponseCacheBillingRatio 命中缓存时的计费比例
+func GetResponseCacheBillingRatio() float64 {
+	switch responseCacheSetting.BillingMode {
+	case ResponseCacheBillingFree:
+		return 0
+	case ResponseCacheBillingDiscount:
+		return min(max(responseCacheSetting.DiscountRatio, 0), 1)
+	default:
+		return 1
+	}
+}
@@ -0,0 +1,52 @@
+package operation_setting
+
+import (
+	"slices"
+
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+type StickySessionSetting struct {
+	// Enabled 开启会话粘性：同一会话在 TTL 内固定使用同一个渠道和 Key，提高上游提示词缓存命中率，
+	// 渠道不可用或请求失败时按正常方式选择并重新绑定
+	Enabled bool `json:"enabled"`
+	// Groups 只对这些分组开启，为空表示所有分组
+	Groups []string `json:"groups"`
+	// Header 从该请求头读取会话 ID，为空表示不使用请求头
+	Header string `json:"header"`
+	// UseUserField 没有会话请求头时使用请求体中的 user 字段
+	UseUserField bool `json:"use_user_field"`
+	// PrefixMessages 以上都没有时，使用系统提示词和前 N 条消息的哈希作为会话 ID，0 表示不使用
+	PrefixMessages int `json:"prefix_messages"`
+	// TTLSeconds 绑定有效期，每次请求成功后刷新
+	TTLSeconds int `json:"ttl_seconds"`
+	// MaxMemoryEntries 未启用 Redis 时内存中最多保存的绑定数
+	MaxMemoryEntries int `json:"max_memory_entries"`
+}
+
+// 默认配置
+var stickySessionSetting = StickySessionSetting{
+	Enabled:          false,
+	Groups:           []string{},
+	Header:           "X-Session-Id",
+	UseUserField:     true,
+	PrefixMessages:   2,
+	TTLSeconds:       3600,
+	MaxMemoryEntries: 100000,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("sticky_session_setting", &stickySessionSetting)
+}
+
+func GetStickySessionSetting() *StickySessionSetting {
+	return &stickySessionSetting
+}
+
+func IsStickySessionEnabled(group string) bool {
+	if !stickySessionSetting.Enabled || stickySessionSetting.TTLSeconds <= 0 {
+		return false
+	}
+	return len(stickySessionSetting.Groups) == 0 || slices.Contains(stickySessionSetting.Groups, group)
+}
@@ -0,0 +1,41 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+const (
+	StreamFailoverModeError  = "error"
+	StreamFailoverModeResume = "resume"
+)
+
+type StreamFailoverSetting struct {
+	// Enabled 检测上游流式响应提前中断（没有 [DONE] 或结束原因、读取出错、流式超时）
+	Enabled bool `json:"enabled"`
+	// Mode error：按客户端请求的格式发送错误事件；resume：把已输出的内容作为 assistant 消息追加到请求中，
+	// 在其他渠道上继续输出，仅支持 OpenAI Chat Completions 格式，其他格式按 error 处理
+	Mode string `json:"mode"`
+	// MaxResumes 一次请求最多续写的次数，同时受重试次数限制
+	MaxResumes int `json:"max_resumes"`
+	// MaxBufferKB 续写需要保留已输出的流式内容，超过该大小（KB）后丢弃已保留的内容并不再续写
+	MaxBufferKB int `json:"max_buffer_kb"`
+}
+
+// 默认配置
+var streamFailoverSetting = StreamFailoverSetting{
+	Enabled:     false,
+	Mode:        StreamFailoverModeError,
+	MaxResumes:  1,
+	MaxBufferKB: 1024,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("stream_failover_setting", &streamFailoverSetting)
+}
+
+func GetStreamFailoverSetting() *StreamFailoverSetting {
+	return &streamFailoverSetting
+}
+
+func IsStreamResumeEnabled() bool {
+	return streamFailoverSetting.Enabled && streamFailoverSetting.Mode == StreamFailoverModeResume && streamFailoverSetting.MaxResumes > 0
+}
@@ -0,0 +1,31 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+type TaskCallbackSetting struct {
+	// Enabled 异步任务（视频、Suno、Midjourney）进入成功或失败状态时，向请求中的 callback_url 或令牌配置的回调地址推送通知
+	Enabled bool `json:"enabled"`
+	// MaxAttempts 单次回调最多投递的次数，包括首次投递
+	MaxAttempts int `json:"max_attempts"`
+	// RetryInterval 首次重试前等待的秒数，之后每次翻倍，最长 1 小时
+	RetryInterval int `json:"retry_interval"`
+	// RetentionDays 投递记录保留的天数，0 表示不清理
+	RetentionDays int `json:"retention_days"`
+}
+
+// 默认配置
+var taskCallbackSetting = TaskCallbackSetting{
+	Enabled:       true,
+	MaxAttempts:   5,
+	RetryInterval: 30,
+	RetentionDays: 30,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("task_callback_setting", &taskCallbackSetting)
+}
+
+func GetTaskCallbackSetting() *TaskCallbackSetting {
+	return &taskCallbackSetting
+}
@@ -0,0 +1,82 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+type TaskPollSetting struct {
+	// TickInterval 调度器检查到期任务的间隔，单位秒
+	TickInterval int `json:"tick_interval"`
+	// BatchSize 每次最多取出的到期任务数
+	BatchSize int `json:"batch_size"`
+	// Concurrency 同时查询上游的最大并发数
+	Concurrency int `json:"concurrency"`
+	// BaseInterval 任务状态变化后再次查询的间隔，单位秒，排队中的任务按两倍间隔查询
+	BaseInterval int `json:"base_interval"`
+	// MaxInterval 状态长时间未变化时退避的最大查询间隔，单位秒
+	MaxInterval int `json:"max_interval"`
+	// PlatformIntervals 按任务平台覆盖 BaseInterval，键为 suno、mj 或渠道类型编号
+	PlatformIntervals map[string]int `json:"platform_intervals"`
+	// PushEnabled 提交任务时让支持推送的上游主动推送任务状态，收到推送后立即更新任务
+	PushEnabled bool `json:"push_enabled"`
+	// PushPollInterval 已开启推送的任务兜底查询的间隔，单位秒
+	PushPollInterval int `json:"push_poll_interval"`
+	// MaxDuration 任务从提交起的最长时间，超过后标记失败并退还额度，单位秒，0 表示不限制
+	MaxDuration int `json:"max_duration"`
+	// PlatformMaxDurations 按任务平台覆盖 MaxDuration
+	PlatformMaxDurations map[string]int `json:"platform_max_durations"`
+}
+
+// 默认配置
+var taskPollSetting = TaskPollSetting{
+	TickInterval:      5,
+	BatchSize:         500,
+	Concurrency:       8,
+	BaseInterval:      15,
+	MaxInterval:       120,
+	PlatformIntervals: map[string]int{},
+	PushEnabled:       true,
+	PushPollInterval:  300,
+	// 默认不限制，避免升级后已在运行的长任务被判定超时
+	MaxDuration:          0,
+	PlatformMaxDurations: map[string]int{},
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("task_poll_setting", &taskPollSetting)
+}
+
+func GetTaskPollSetting() *TaskPollSetting {
+	return &taskPollSetting
+}
+
+// GetPollInterval 计算任务下次查询的间隔，状态未变化时按连续查询次数指数退避
+func (s *TaskPollSetting) GetPollInterval(platform string, queued bool, attempts int, push bool) int64 {
+	interval := s.BaseInterval
+	if v, ok := s.PlatformIntervals[platform]; ok && v > 0 {
+		interval = v
+	}
+	if interval <= 0 {
+		interval = 15
+	}
+	if queued {
+		interval *= 2
+	}
+	for i := 0; i < attempts && interval < s.MaxInterval; i++ {
+		interval *= 2
+	}
+	if s.MaxInterval > 0 && interval > s.MaxInterval {
+		interval = s.MaxInterval
+	}
+	if push && interval < s.PushPollInterval {
+		interval = s.PushPollInterval
+	}
+	return int64(interval)
+}
+
+// GetMaxDuration 获取平台任务的最长时间，单位秒，0 表示不限制
+func (s *TaskPollSetting) GetMaxDuration(platform string) int64 {
+	if v, ok := s.PlatformMaxDurations[platform]; ok {
+		return int64(max(v, 0))
+	}
+	return int64(max(s.MaxDuration, 0))
+}
@@ -0,0 +1,29 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+type UpstreamRateLimitSetting struct {
+	// Enabled 根据上游返回的 x-ratelimit-*、anthropic-ratelimit-* 和 Retry-After 响应头冷却渠道（或多 Key 渠道中的 Key），
+	// 冷却期间选择渠道和 Key 时跳过，到期后自动恢复
+	Enabled bool `json:"enabled"`
+	// DefaultCooldownSeconds 上游返回 429 但没有给出重置时间时的冷却时长，0 表示不冷却
+	DefaultCooldownSeconds int `json:"default_cooldown_seconds"`
+	// MaxCooldownSeconds 单次冷却的最长时间，避免上游返回异常的重置时间
+	MaxCooldownSeconds int `json:"max_cooldown_seconds"`
+}
+
+// 默认配置
+var upstreamRateLimitSetting = UpstreamRateLimitSetting{
+	Enabled:                false,
+	DefaultCooldownSeconds: 10,
+	MaxCooldownSeconds:     300,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("upstream_rate_limit_setting", &upstreamRateLimitSetting)
+}
+
+func GetUpstreamRateLimitSetting() *UpstreamRateLimitSetting {
+	return &upstreamRateLimitSetting
+}
@@ -1,15 +1,31 @@
 package setting
 
-import "strings"
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/QuantumNous/new-api/common"
+)
 
 var CheckSensitiveEnabled = true
 var CheckSensitiveOnPromptEnabled = true
 
-//var CheckSensitiveOnCompletionEnabled = true
+var CheckSensitiveOnCompletionEnabled = false
 
 // StopOnSensitiveEnabled 如果检测到敏感词，是否立刻停止生成，否则替换敏感词
 var StopOnSensitiveEnabled = true
 
+const (
+	SensitivePolicyReplace = "replace" // 替换敏感词
+	SensitivePolicyStop    = "stop"    // 停止生成
+	SensitivePolicyLog     = "log"     // 只记录日志
+)
+
+// completionSensitiveGroupPolicy 各分组对输出中敏感词的处理方式，未配置的分组按 StopOnSensitiveEnabled 停止或替换
+var completionSensitiveGroupPolicy = map[string]string{}
+var completionSensitiveGroupPolicyMutex sync.RWMutex
+
 // StreamCacheQueueLength 流模式缓存队列长度，0表示无缓存
 var StreamCacheQueueLength = 0
 
@@ -38,6 +54,43 @@ func ShouldCheckPromptSensitive() bool {
 	return CheckSensitiveEnabled && CheckSensitiveOnPromptEnabled
 }
 
-//func ShouldCheckCompletionSensitive() bool {
-//	return CheckSensitiveEnabled && CheckSensitiveOnCompletionEnabled
-//}
+func ShouldCheckCompletionSensitive() bool {
+	return CheckSensitiveEnabled && CheckSensitiveOnCompletionEnabled
+}
+
+func CompletionSensitiveGroupPolicy2JSONString() string {
+	completionSensitiveGroupPolicyMutex.RLock()
+	defer completionSensitiveGroupPolicyMutex.RUnlock()
+
+	jsonBytes, err := json.Marshal(completionSensitiveGroupPolicy)
+	if err != nil {
+		common.SysLog("error marshalling completion sensitive group policy: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateCompletionSensitiveGroupPolicyByJSONString(jsonStr string) error {
+	groupPolicy := make(map[string]string)
+	if err := json.Unmarshal([]byte(jsonStr), &groupPolicy); err != nil {
+		return err
+	}
+	completionSensitiveGroupPolicyMutex.Lock()
+	defer completionSensitiveGroupPolicyMutex.Unlock()
+	completionSensitiveGroupPolicy = groupPolicy
+	return nil
+}
+
+// GetCompletionSensitivePolicy 返回分组对输出中敏感词的处理方式
+func GetCompletionSensitivePolicy(group string) string {
+	completionSensitiveGroupPolicyMutex.RLock()
+	policy := completionSensitiveGroupPolicy[group]
+	completionSensitiveGroupPolicyMutex.RUnlock()
+	switch policy {
+	case SensitivePolicyReplace, SensitivePolicyStop, SensitivePolicyLog:
+		return policy
+	}
+	if StopOnSensitiveEnabled {
+		return SensitivePolicyStop
+	}
+	return SensitivePolicyReplace
+}
@@ -74,6 +74,7 @@ const (
 	ErrorCodeAwsInvokeError         ErrorCode = "aws_invoke_error"
 	ErrorCodeModelNotFound          ErrorCode = "model_not_found"
 	ErrorCodePromptBlocked          ErrorCode = "prompt_blocked"
+	ErrorCodeStreamInterrupted      ErrorCode = "stream_interrupted"
 
 	// sql error
 	ErrorCodeQueryDataError  ErrorCode = "query_data_error"
@@ -82,6 +83,11 @@ const (
 	// quota error
 	ErrorCodeInsufficientUserQuota      ErrorCode = "insufficient_user_quota"
 	ErrorCodePreConsumeTokenQuotaFailed ErrorCode = "pre_consume_token_quota_failed"
+
+	// rate limit error
+	ErrorCodeRateLimitExceeded    ErrorCode = "rate_limit_exceeded"
+	ErrorCodeRateLimitCheckFailed ErrorCode = "rate_limit_check_failed"
+	ErrorCodeRequestExceedsTPM    ErrorCode = "request_exceeds_tpm_limit"
 )
 
 type NewAPIError struct {
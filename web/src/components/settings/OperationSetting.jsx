@@ -58,6 +58,9 @@ const OperationSetting = () => {
     /* 敏感词设置 */
     CheckSensitiveEnabled: false,
     CheckSensitiveOnPromptEnabled: false,
+    CheckSensitiveOnCompletionEnabled: false,
+    StopOnSensitiveEnabled: false,
+    CompletionSensitiveGroupPolicy: '',
     SensitiveWords: '',
 
     /* 日志设置 */
@@ -1854,6 +1854,10 @@ const EditChannelModal = (props) => {
                           optionList={[
                             { label: t('随机'), value: 'random' },
                             { label: t('轮询'), value: 'polling' },
+                            { label: t('按权重'), value: 'weighted' },
+                            { label: t('最久未使用'), value: 'lru' },
+                            { label: t('最少进行中请求'), value: 'least_outstanding' },
+                            { label: t('用户粘性'), value: 'sticky' },
                           ]}
                           style={{ width: '100%' }}
                           value={inputs.multi_key_mode || 'random'}
@@ -399,6 +399,12 @@ export const useLogsData = () => {
             value: other.upstream_model_name,
           });
         }
+        if (other?.is_model_fallback && other?.fallback_from_model) {
+          expandDataLocal.push({
+            key: t('原请求模型'),
+            value: other.fallback_from_model,
+          });
+        }
         let content = '';
         if (other?.ws || other?.audio) {
           content = renderAudioModelPrice(
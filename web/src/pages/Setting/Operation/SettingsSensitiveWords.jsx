@@ -25,6 +25,7 @@ import {
   showError,
   showSuccess,
   showWarning,
+  verifyJSON,
 } from '../../../helpers';
 import { useTranslation } from 'react-i18next';
 
@@ -34,6 +35,9 @@ export default function SettingsSensitiveWords(props) {
   const [inputs, setInputs] = useState({
     CheckSensitiveEnabled: false,
     CheckSensitiveOnPromptEnabled: false,
+    CheckSensitiveOnCompletionEnabled: false,
+    StopOnSensitiveEnabled: false,
+    CompletionSensitiveGroupPolicy: '',
     SensitiveWords: '',
   });
   const refForm = useRef();
@@ -125,6 +129,40 @@ export default function SettingsSensitiveWords(props) {
                   }
                 />
               </Col>
+              <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                <Form.Switch
+                  field={'CheckSensitiveOnCompletionEnabled'}
+                  label={t('启用输出检查')}
+                  extraText={t('检查模型输出（包括流式输出）中的屏蔽词')}
+                  size='default'
+                  checkedText='｜'
+                  uncheckedText='〇'
+                  onChange={(value) =>
+                    setInputs({
+                      ...inputs,
+                      CheckSensitiveOnCompletionEnabled: value,
+                    })
+                  }
+                />
+              </Col>
+            </Row>
+            <Row gutter={16}>
+              <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                <Form.Switch
+                  field={'StopOnSensitiveEnabled'}
+                  label={t('输出包含屏蔽词时停止生成')}
+                  extraText={t('关闭时替换屏蔽词，分组单独配置时以分组配置为准')}
+                  size='default'
+                  checkedText='｜'
+                  uncheckedText='〇'
+                  onChange={(value) =>
+                    setInputs({
+                      ...inputs,
+                      StopOnSensitiveEnabled: value,
+                    })
+                  }
+                />
+              </Col>
             </Row>
             <Row>
               <Col xs={24} sm={12} md={8} lg={8} xl={8}>
@@ -143,7 +181,32 @@ export default function SettingsSensitiveWords(props) {
                   autosize={{ minRows: 6, maxRows: 12 }}
                 />
               </Col>
-            </Row>
+              <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                <Form.TextArea
+                  label={t('分组输出屏蔽词处理方式')}
+                  extraText={t(
+                    '分组名到处理方式的 JSON，可选 replace（替换）、stop（停止生成）、log（只记录日志）',
+                  )}
+                  placeholder={'{\n  "default": "replace",\n  "vip": "log"\n}'}
+                  field={'CompletionSensitiveGroupPolicy'}
+                  trigger='blur'
+                  stopValidateWithError
+                  rules={[
+                    {
+                      validator: (rule, value) => verifyJSON(value),
+                      message: t('不是合法的 JSON 字符串'),
+                    },
+                  ]}
+                  onChange={(value) =>
+                    setInputs({
+                      ...inputs,
+                      CompletionSensitiveGroupPolicy: value,
+                    })
+                  }
+                  style={{ fontFamily: 'JetBrains Mono, Consolas' }}
+                  autosize={{ minRows: 6, maxRows: 12 }}
+                />
+              </Col>
             <Row>
               <Button size='default' onClick={onSubmit}>
                 {t('保存屏蔽词过滤设置')}